@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// airQuality is the air quality at a location. AQI uses the 1 (good) to 5
+// (very poor) scale shared by OpenWeather and the European AQI bands.
+type airQuality struct {
+	AQI        int                `json:"aqi"`
+	Components map[string]float64 `json:"components,omitempty"`
+	// Pollen is in grains/m³ by plant, when the provider has it
+	Pollen map[string]float64 `json:"pollen,omitempty"`
+}
+
+// weatherThresholds are the levels above which the weather briefing comments
+// on air quality, UV and pollen
+type weatherThresholds struct {
+	aqi    float64
+	uv     float64
+	pollen float64
+}
+
+var aqiLabels = map[int]string{
+	1: "good",
+	2: "fair",
+	3: "moderate",
+	4: "poor",
+	5: "very poor",
+}
+
+const (
+	defaultAQIThreshold    = 3
+	defaultUVThreshold     = 6
+	defaultPollenThreshold = 50
+)
+
+func newWeatherThresholds() (weatherThresholds, error) {
+	aqi, err := envFloat("WEATHER_AQI_THRESHOLD", defaultAQIThreshold)
+	if err != nil {
+		return weatherThresholds{}, err
+	}
+
+	uv, err := envFloat("WEATHER_UV_THRESHOLD", defaultUVThreshold)
+	if err != nil {
+		return weatherThresholds{}, err
+	}
+
+	pollen, err := envFloat("WEATHER_POLLEN_THRESHOLD", defaultPollenThreshold)
+	if err != nil {
+		return weatherThresholds{}, err
+	}
+
+	return weatherThresholds{aqi: aqi, uv: uv, pollen: pollen}, nil
+}
+
+// europeanAQIToIndex converts a European AQI value (0-100+) to the 1-5 scale
+func europeanAQIToIndex(value float64) int {
+	switch {
+	case value < 20:
+		return 1
+	case value < 40:
+		return 2
+	case value < 60:
+		return 3
+	case value < 80:
+		return 4
+	}
+	return 5
+}
+
+// weatherNotices lists the air quality, UV and pollen readings that crossed their thresholds
+func weatherNotices(result weatherResult, thresholds weatherThresholds) []string {
+	var notices []string
+
+	if result.AirQuality != nil && result.AirQuality.AQI > 0 && float64(result.AirQuality.AQI) >= thresholds.aqi {
+		notices = append(notices, fmt.Sprintf("the air quality index is %d of 5 (%s)", result.AirQuality.AQI, aqiLabels[result.AirQuality.AQI]))
+	}
+
+	if result.UVIndex >= thresholds.uv {
+		notices = append(notices, fmt.Sprintf("the UV index is %.1f", result.UVIndex))
+	}
+
+	if result.AirQuality != nil {
+		plants := make([]string, 0, len(result.AirQuality.Pollen))
+		for plant := range result.AirQuality.Pollen {
+			plants = append(plants, plant)
+		}
+		sort.Strings(plants)
+		for _, plant := range plants {
+			if level := result.AirQuality.Pollen[plant]; level >= thresholds.pollen {
+				notices = append(notices, fmt.Sprintf("%s pollen is %.0f grains/m³", plant, level))
+			}
+		}
+	}
+
+	return notices
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWeatherNotices_BelowThresholds(t *testing.T) {
+	result := weatherResult{
+		UVIndex: 2,
+		AirQuality: &airQuality{
+			AQI:    1,
+			Pollen: map[string]float64{"grass": 10},
+		},
+	}
+
+	notices := weatherNotices(result, weatherThresholds{aqi: 3, uv: 6, pollen: 50})
+	require.Empty(t, notices)
+}
+
+func TestWeatherNotices_ConfiguredThresholds(t *testing.T) {
+	os.Setenv("WEATHER_UV_THRESHOLD", "1.5")
+	defer os.Unsetenv("WEATHER_UV_THRESHOLD")
+
+	thresholds, err := newWeatherThresholds()
+	require.NoError(t, err)
+
+	notices := weatherNotices(weatherResult{UVIndex: 2}, thresholds)
+	require.Equal(t, []string{"the UV index is 2.0"}, notices)
+}
+
+func TestEuropeanAQIToIndex(t *testing.T) {
+	require.Equal(t, 1, europeanAQIToIndex(5))
+	require.Equal(t, 3, europeanAQIToIndex(45))
+	require.Equal(t, 5, europeanAQIToIndex(120))
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// articleNews wraps a news source, fetching each story from an allowed
+// domain and adding the main text of the article to it
+type articleNews struct {
+	source   newsClient
+	domains  []string
+	maxBytes int
+	maxChars int
+	client   *http.Client
+
+	cache *ttlCache[string]
+}
+
+const (
+	defaultArticleMaxBytes = 2 << 20
+	defaultArticleMaxChars = 2000
+	articleCacheDuration   = 24 * time.Hour
+	articleRequestTimeout  = 10 * time.Second
+	articleMaxRedirects    = 5
+)
+
+// newArticleNewsClient returns the source unchanged unless NEWS_EXTRACT_DOMAINS lists domains to fetch articles from
+func newArticleNewsClient(source newsClient) (newsClient, error) {
+	domains := lowerAll(envList("NEWS_EXTRACT_DOMAINS"))
+	if len(domains) == 0 {
+		return source, nil
+	}
+
+	maxBytes, err := envInt("NEWS_EXTRACT_MAX_BYTES", defaultArticleMaxBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	maxChars, err := envInt("NEWS_EXTRACT_MAX_CHARS", defaultArticleMaxChars)
+	if err != nil {
+		return nil, err
+	}
+
+	cache, err := newSourceCache[string]("NEWS_EXTRACT", articleCacheDuration, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &articleNews{
+		source:   source,
+		domains:  domains,
+		maxBytes: maxBytes,
+		maxChars: maxChars,
+		cache:    cache,
+	}
+	client.client = &http.Client{Timeout: articleRequestTimeout, CheckRedirect: client.checkRedirect}
+	return client, nil
+}
+
+// checkRedirect only follows a few redirects, and only to allowed domains, so an
+// article can't point the request at another host
+func (a *articleNews) checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= articleMaxRedirects {
+		return fmt.Errorf("stopped after %d redirects", articleMaxRedirects)
+	}
+	if !a.allowed(req.URL.String()) {
+		return fmt.Errorf("redirect to %s is not allowed", req.URL.Host)
+	}
+	return nil
+}
+
+func (a *articleNews) get() ([]newsResult, error) {
+	results, err := a.source.get()
+	if err != nil {
+		return nil, err
+	}
+
+	// concurrently extract each allowed article, a failure only loses the extra text
+	extracted := make([]newsResult, len(results))
+	copy(extracted, results)
+	var wg sync.WaitGroup
+	for i := range extracted {
+		if !a.allowed(extracted[i].URL) {
+			continue
+		}
+		wg.Add(1)
+		go func(result *newsResult) {
+			defer wg.Done()
+			content, err := a.cache.get(result.URL, func() (string, error) {
+				return a.extract(result.URL)
+			})
+			if err != nil {
+				fmt.Println(fmt.Errorf("cannot extract article %s: %w", result.URL, err))
+				return
+			}
+			result.Content = content
+		}(&extracted[i])
+	}
+	wg.Wait()
+
+	return extracted, nil
+}
+
+// allowed reports whether the article's host is one of the allowed domains or a subdomain of one
+func (a *articleNews) allowed(articleURL string) bool {
+	parsed, err := url.Parse(articleURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		return false
+	}
+
+	host := strings.ToLower(parsed.Hostname())
+	for _, domain := range a.domains {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// extract downloads an article, up to the size limit, and returns its main text
+func (a *articleNews) extract(articleURL string) (string, error) {
+	resp, err := a.client.Get(articleURL)
+	if err != nil {
+		return "", fmt.Errorf("cannot get article: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// validate response code
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected response code: %d", resp.StatusCode)
+	}
+
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || mediaType != "text/html" {
+		return "", fmt.Errorf("unexpected content type: %s", resp.Header.Get("Content-Type"))
+	}
+
+	// read response, pages past the size limit are cut short rather than rejected
+	body, err := io.ReadAll(io.LimitReader(resp.Body, int64(a.maxBytes)))
+	if err != nil {
+		return "", fmt.Errorf("cannot read article: %w", err)
+	}
+
+	return truncateText(extractArticleText(string(body)), a.maxChars), nil
+}
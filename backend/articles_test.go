@@ -0,0 +1,123 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const articlePage = `<html>
+<head><style>p { color: red; }</style><script>var p = "<p>not text</p>";</script></head>
+<body>
+	<nav><p>Home | World | Politics | Sport | Weather | Culture and more</p></nav>
+	<article>
+		<h1>Storm hits the coast</h1>
+		<p>By a reporter</p>
+		<p>A powerful storm hit the coast overnight, leaving thousands without power.</p>
+		<p>Read more: <a href="/a">Related story about storms on the coast</a></p>
+		<p>Crews are working to restore electricity &amp; clear roads by the weekend.</p>
+	</article>
+	<footer><p>Copyright, all rights reserved, do not reproduce this page anywhere.</p></footer>
+</body>
+</html>`
+
+func setupArticleServer() (*httptest.Server, *int32) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if r.URL.Path == "/image.png" {
+			w.Header().Set("Content-Type", "image/png")
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(articlePage))
+	}))
+
+	// httptest servers listen on 127.0.0.1
+	os.Setenv("NEWS_EXTRACT_DOMAINS", "127.0.0.1")
+
+	return server, &requests
+}
+
+func TestArticleNews_ExtractsAllowedArticles(t *testing.T) {
+	server, requests := setupArticleServer()
+	defer server.Close()
+	defer os.Unsetenv("NEWS_EXTRACT_DOMAINS")
+
+	source := &mockNewsClient{getReturns: []newsResult{
+		{Title: "Storm", URL: server.URL + "/storm"},
+		{Title: "Elsewhere", URL: "https://not-allowed.test/story"},
+		{Title: "Image", URL: server.URL + "/image.png"},
+	}}
+	client, err := newArticleNewsClient(source)
+	require.NoError(t, err)
+
+	results, err := client.get()
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+	require.Equal(t, "A powerful storm hit the coast overnight, leaving thousands without power.\n\nCrews are working to restore electricity & clear roads by the weekend.", results[0].Content)
+	require.Empty(t, results[1].Content)
+	require.Empty(t, results[2].Content)
+
+	// extracted text is cached, failures are retried
+	_, err = client.get()
+	require.NoError(t, err)
+	require.Equal(t, int32(3), atomic.LoadInt32(requests))
+}
+
+func TestArticleNews_Redirects(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/moved":
+			http.Redirect(w, r, "/storm", http.StatusFound)
+		case "/elsewhere":
+			// the same server under a host that isn't allowed
+			http.Redirect(w, r, strings.Replace("http://"+r.Host, "127.0.0.1", "localhost", 1)+"/storm", http.StatusFound)
+		case "/loop":
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		default:
+			w.Header().Set("Content-Type", "text/html")
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(articlePage))
+		}
+	}))
+	defer server.Close()
+	t.Setenv("NEWS_EXTRACT_DOMAINS", "127.0.0.1")
+
+	client, err := newArticleNewsClient(&mockNewsClient{})
+	require.NoError(t, err)
+	articles := client.(*articleNews)
+
+	content, err := articles.extract(server.URL + "/moved")
+	require.NoError(t, err)
+	require.NotEmpty(t, content)
+
+	_, err = articles.extract(server.URL + "/elsewhere")
+	require.ErrorContains(t, err, "is not allowed")
+
+	_, err = articles.extract(server.URL + "/loop")
+	require.ErrorContains(t, err, "redirects")
+}
+
+func TestArticleNews_DisabledWithoutDomains(t *testing.T) {
+	source := &mockNewsClient{}
+	client, err := newArticleNewsClient(source)
+	require.NoError(t, err)
+	require.Same(t, source, client)
+}
+
+func TestTruncateText(t *testing.T) {
+	require.Equal(t, "short", truncateText("short", 10))
+	require.Equal(t, "a few…", truncateText("a few words here", 8))
+	require.False(t, strings.ContainsRune(truncateText("ééééé", 3), '�'))
+	// the limit is in characters, not bytes
+	require.Equal(t, "ééé", truncateText("ééé", 3))
+	require.Equal(t, "ééé…", truncateText("éééé", 3))
+}
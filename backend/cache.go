@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// sourceRequestTimeout bounds each request to a cached source, so a stuck
+// fetch doesn't hold up everyone waiting on it
+const sourceRequestTimeout = 30 * time.Second
+
+var sourceHTTPClient = &http.Client{Timeout: sourceRequestTimeout}
+
+// ttlCache is a concurrency-safe cache for remote source data. Values are
+// fresh for ttl; after that they are still served for up to maxStale while a
+// background refresh runs, and also when a refresh fails. Concurrent fetches
+// for the same key are de-duplicated.
+type ttlCache[T any] struct {
+	ttl      time.Duration
+	maxStale time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*cacheEntry[T]
+	calls   map[string]*cacheCall[T]
+}
+
+type cacheEntry[T any] struct {
+	value     T
+	fetchedAt time.Time
+}
+
+// cacheCall is an in-flight fetch that callers for the same key wait on
+type cacheCall[T any] struct {
+	done  chan struct{}
+	value T
+	err   error
+}
+
+func newTTLCache[T any](ttl, maxStale time.Duration) *ttlCache[T] {
+	return &ttlCache[T]{
+		ttl:      ttl,
+		maxStale: maxStale,
+		entries:  map[string]*cacheEntry[T]{},
+		calls:    map[string]*cacheCall[T]{},
+	}
+}
+
+// newSourceCache creates a cache configured from <prefix>_CACHE_TTL and
+// <prefix>_CACHE_MAX_STALE, falling back to the given defaults
+func newSourceCache[T any](prefix string, ttl, maxStale time.Duration) (*ttlCache[T], error) {
+	ttl, err := envDuration(prefix+"_CACHE_TTL", ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	if ttl < 0 {
+		return nil, fmt.Errorf("%s_CACHE_TTL must not be negative", prefix)
+	}
+
+	maxStale, err = envDuration(prefix+"_CACHE_MAX_STALE", maxStale)
+	if err != nil {
+		return nil, err
+	}
+	if maxStale < 0 {
+		return nil, fmt.Errorf("%s_CACHE_MAX_STALE must not be negative", prefix)
+	}
+
+	return newTTLCache[T](ttl, maxStale), nil
+}
+
+// get returns the cached value for key, calling fetch when it is missing or expired
+func (c *ttlCache[T]) get(key string, fetch func() (T, error)) (T, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	if ok {
+		age := time.Since(entry.fetchedAt)
+		// if cache is fresh, return cached value
+		if age < c.ttl {
+			c.mu.Unlock()
+			return entry.value, nil
+		}
+		// if cache is stale but usable, return it and refresh in the background
+		if age < c.ttl+c.maxStale {
+			c.startFetch(key, fetch, true)
+			c.mu.Unlock()
+			return entry.value, nil
+		}
+	}
+	call := c.startFetch(key, fetch, false)
+	c.mu.Unlock()
+
+	<-call.done
+	return call.value, call.err
+}
+
+// startFetch starts fetching key unless a fetch is already in flight; c.mu must be held.
+// Nobody waits on a background refresh, so it logs its own failure.
+func (c *ttlCache[T]) startFetch(key string, fetch func() (T, error), background bool) *cacheCall[T] {
+	if call, ok := c.calls[key]; ok {
+		return call
+	}
+
+	call := &cacheCall[T]{done: make(chan struct{})}
+	c.calls[key] = call
+	go func() {
+		call.value, call.err = fetch()
+		if call.err != nil && background {
+			fmt.Println(fmt.Errorf("cannot refresh %s, serving the stale value: %w", key, call.err))
+		}
+
+		c.mu.Lock()
+		if call.err == nil {
+			c.entries[key] = &cacheEntry[T]{value: call.value, fetchedAt: time.Now()}
+		}
+		c.prune()
+		delete(c.calls, key)
+		c.mu.Unlock()
+
+		close(call.done)
+	}()
+	return call
+}
+
+// prune drops entries too old to ever be served again, for caches with
+// an open-ended set of keys; c.mu must be held
+func (c *ttlCache[T]) prune() {
+	for key, entry := range c.entries {
+		if time.Since(entry.fetchedAt) >= c.ttl+c.maxStale {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// invalidate drops the cached value for key, e.g. after writing to the source
+func (c *ttlCache[T]) invalidate(key string) {
+	c.mu.Lock()
+	delete(c.entries, key)
+	c.mu.Unlock()
+}
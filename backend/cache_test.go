@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTTLCache_FreshValueIsReused(t *testing.T) {
+	cache := newTTLCache[string](time.Minute, time.Hour)
+	var calls int32
+	fetch := func() (string, error) {
+		atomic.AddInt32(&calls, 1)
+		return "value", nil
+	}
+
+	for i := 0; i < 3; i++ {
+		value, err := cache.get("key", fetch)
+		require.NoError(t, err)
+		require.Equal(t, "value", value)
+	}
+	require.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestTTLCache_ConcurrentFetchesAreDeduplicated(t *testing.T) {
+	cache := newTTLCache[string](time.Minute, time.Hour)
+	var calls int32
+	release := make(chan struct{})
+	fetch := func() (string, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return "value", nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			value, err := cache.get("key", fetch)
+			require.NoError(t, err)
+			require.Equal(t, "value", value)
+		}()
+	}
+
+	// give every caller a chance to join the in-flight fetch
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	require.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestTTLCache_ServesStaleOnError(t *testing.T) {
+	cache := newTTLCache[string](time.Minute, time.Hour)
+	_, err := cache.get("key", func() (string, error) { return "old", nil })
+	require.NoError(t, err)
+
+	// expire the value but keep it within the max staleness
+	cache.entries["key"].fetchedAt = time.Now().Add(-2 * time.Minute)
+	value, err := cache.get("key", func() (string, error) { return "", errors.New("source down") })
+	require.NoError(t, err)
+	require.Equal(t, "old", value)
+}
+
+func TestTTLCache_ErrorBeyondMaxStale(t *testing.T) {
+	cache := newTTLCache[string](time.Minute, time.Hour)
+	_, err := cache.get("key", func() (string, error) { return "old", nil })
+	require.NoError(t, err)
+
+	// too old to serve
+	cache.entries["key"].fetchedAt = time.Now().Add(-2 * time.Hour)
+	value, err := cache.get("key", func() (string, error) { return "", errors.New("source down") })
+	require.Error(t, err)
+	require.Empty(t, value)
+}
+
+func TestTTLCache_StaleWhileRevalidate(t *testing.T) {
+	cache := newTTLCache[string](time.Minute, time.Hour)
+	_, err := cache.get("key", func() (string, error) { return "old", nil })
+	require.NoError(t, err)
+
+	cache.entries["key"].fetchedAt = time.Now().Add(-2 * time.Minute)
+	refreshed := make(chan struct{})
+	value, err := cache.get("key", func() (string, error) {
+		defer close(refreshed)
+		return "new", nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, "old", value)
+
+	// the background refresh replaces the stale value
+	<-refreshed
+	require.Eventually(t, func() bool {
+		value, _ := cache.get("key", func() (string, error) { return "unexpected", nil })
+		return value == "new"
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestTTLCache_Invalidate(t *testing.T) {
+	cache := newTTLCache[string](time.Minute, time.Hour)
+	var calls int32
+	fetch := func() (string, error) {
+		atomic.AddInt32(&calls, 1)
+		return "value", nil
+	}
+
+	_, err := cache.get("key", fetch)
+	require.NoError(t, err)
+	cache.invalidate("key")
+	_, err = cache.get("key", fetch)
+	require.NoError(t, err)
+	require.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestNewSourceCache_RejectsNegativeDurations(t *testing.T) {
+	t.Setenv("TEST_CACHE_TTL", "-1m")
+	_, err := newSourceCache[string]("TEST", time.Minute, time.Hour)
+	require.ErrorContains(t, err, "TEST_CACHE_TTL must not be negative")
+
+	t.Setenv("TEST_CACHE_TTL", "")
+	t.Setenv("TEST_CACHE_MAX_STALE", "-1h")
+	_, err = newSourceCache[string]("TEST", time.Minute, time.Hour)
+	require.ErrorContains(t, err, "TEST_CACHE_MAX_STALE must not be negative")
+}
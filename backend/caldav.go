@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// caldavCalendar reads events from a CalDAV calendar collection, such as a Nextcloud calendar
+type caldavCalendar struct {
+	url      string
+	username string
+	password string
+	settings calendarSettings
+
+	cache *ttlCache[[]calendarEvent]
+}
+
+// caldavMultistatus is the subset of a REPORT response that holds calendar data
+type caldavMultistatus struct {
+	Responses []struct {
+		Href     string `xml:"href"`
+		Propstat []struct {
+			Status string `xml:"status"`
+			Prop   struct {
+				ETag         string `xml:"getetag"`
+				CalendarData string `xml:"calendar-data"`
+			} `xml:"prop"`
+		} `xml:"propstat"`
+	} `xml:"response"`
+}
+
+const caldavCalendarQuery = `<?xml version="1.0" encoding="utf-8" ?>
+<c:calendar-query xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav">
+	<d:prop>
+		<d:getetag/>
+		<c:calendar-data/>
+	</d:prop>
+	<c:filter>
+		<c:comp-filter name="VCALENDAR">
+			<c:comp-filter name="VEVENT">
+				<c:time-range start="%s" end="%s"/>
+			</c:comp-filter>
+		</c:comp-filter>
+	</c:filter>
+</c:calendar-query>`
+
+func newCalDAVCalendarClient(prefix string) (calendarClient, error) {
+	if os.Getenv(prefix+"_URL") == "" {
+		return nil, fmt.Errorf("%s_URL is not set", prefix)
+	}
+
+	settings, err := newCalendarSettings()
+	if err != nil {
+		return nil, err
+	}
+
+	cache, err := newSourceCache[[]calendarEvent]("CALENDAR", calendarCacheDuration, calendarCacheMaxStale)
+	if err != nil {
+		return nil, err
+	}
+
+	return &caldavCalendar{
+		url:      os.Getenv(prefix + "_URL"),
+		username: os.Getenv(prefix + "_USERNAME"),
+		password: os.Getenv(prefix + "_PASSWORD"),
+		settings: settings,
+		cache:    cache,
+	}, nil
+}
+
+func (c *caldavCalendar) getEvents() ([]calendarEvent, error) {
+	return c.cache.get("events", c.fetch)
+}
+
+func (c *caldavCalendar) fetch() ([]calendarEvent, error) {
+	now := time.Now()
+	from, to := now, now.Add(c.settings.lookahead)
+
+	// payload for calendar-query REPORT over the time range
+	payload := fmt.Sprintf(caldavCalendarQuery, from.UTC().Format(icsUTCDateTimeLayout), to.UTC().Format(icsUTCDateTimeLayout))
+
+	// create request
+	req, err := http.NewRequest("REPORT", c.url, bytes.NewBufferString(payload))
+	if err != nil {
+		return nil, fmt.Errorf("cannot create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+	req.Header.Set("Depth", "1")
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+
+	// send request
+	resp, err := sourceHTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("cannot send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// validate response code
+	if resp.StatusCode != http.StatusMultiStatus {
+		return nil, fmt.Errorf("unexpected response code: %d", resp.StatusCode)
+	}
+
+	// read response
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read events: %w", err)
+	}
+
+	var multistatus caldavMultistatus
+	err = xml.Unmarshal(body, &multistatus)
+	if err != nil {
+		return nil, fmt.Errorf("cannot unmarshal events: %w", err)
+	}
+
+	// each resource holds one event, possibly with overridden instances
+	var events []icsEvent
+	for _, response := range multistatus.Responses {
+		for _, propstat := range response.Propstat {
+			if propstat.Prop.CalendarData == "" {
+				continue
+			}
+			// a bad event is left out rather than failing the calendar
+			parsed, err := parseICS(propstat.Prop.CalendarData, c.settings.location)
+			if err != nil {
+				fmt.Println(fmt.Errorf("skipping %s: %w", response.Href, err))
+				continue
+			}
+			events = append(events, parsed...)
+		}
+	}
+
+	return icsEventsBetween(events, from, to, c.settings.location), nil
+}
+
+func (c *caldavCalendar) writable() bool {
+	return true
+}
+
+// createEvent stores the event as a new resource in the calendar collection
+func (c *caldavCalendar) createEvent(event calendarEvent) error {
+	payload := formatICSCalendar(formatICSEvent(event, time.Now()))
+
+	// create request
+	req, err := http.NewRequest("PUT", strings.TrimSuffix(c.url, "/")+"/"+url.PathEscape(event.UID)+".ics", bytes.NewBufferString(payload))
+	if err != nil {
+		return fmt.Errorf("cannot create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "text/calendar; charset=utf-8")
+	// never overwrite an existing event
+	req.Header.Set("If-None-Match", "*")
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+
+	// send request
+	resp, err := sourceHTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("cannot send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// validate response code
+	if resp.StatusCode == http.StatusPreconditionFailed {
+		return errCalendarEventExists
+	}
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("unexpected response code: %d", resp.StatusCode)
+	}
+
+	c.cache.invalidate("events")
+	return nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func setupCalDAVServer(t *testing.T) *httptest.Server {
+	start := time.Now().Add(2 * time.Hour).Truncate(time.Second).UTC().Format(icsUTCDateTimeLayout)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		if r.Method != "REPORT" || r.Header.Get("Depth") != "1" || !ok || username != "test" || password != "secret" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		require.Contains(t, string(body), "<c:time-range start=")
+
+		w.WriteHeader(http.StatusMultiStatus)
+		w.Write([]byte(fmt.Sprintf(`<?xml version="1.0"?>
+<d:multistatus xmlns:d="DAV:" xmlns:cal="urn:ietf:params:xml:ns:caldav">
+	<d:response>
+		<d:href>/remote.php/dav/calendars/test/personal/lunch.ics</d:href>
+		<d:propstat>
+			<d:prop>
+				<d:getetag>"1"</d:getetag>
+				<cal:calendar-data>BEGIN:VCALENDAR
+BEGIN:VEVENT
+UID:lunch@test
+SUMMARY:Lunch
+LOCATION:Cafe
+DTSTART:%s
+END:VEVENT
+END:VCALENDAR
+</cal:calendar-data>
+			</d:prop>
+			<d:status>HTTP/1.1 200 OK</d:status>
+		</d:propstat>
+	</d:response>
+</d:multistatus>`, start)))
+	}))
+
+	os.Setenv("CALENDAR_PROVIDER", "caldav")
+	os.Setenv("CALENDAR_URL", server.URL+"/remote.php/dav/calendars/test/personal/")
+	os.Setenv("CALENDAR_USERNAME", "test")
+	os.Setenv("CALENDAR_PASSWORD", "secret")
+
+	return server
+}
+
+func teardownCalDAVEnvVars() {
+	for _, name := range []string{"CALENDAR_PROVIDER", "CALENDAR_URL", "CALENDAR_USERNAME", "CALENDAR_PASSWORD"} {
+		os.Unsetenv(name)
+	}
+}
+
+func TestCalDAVCalendarClient_GetEvents(t *testing.T) {
+	server := setupCalDAVServer(t)
+	defer server.Close()
+	defer teardownCalDAVEnvVars()
+
+	calendarClient, err := newCalendarClient()
+	require.NoError(t, err)
+
+	events, err := calendarClient.getEvents()
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	require.Equal(t, "Lunch", events[0].Title)
+	require.Equal(t, "Cafe", events[0].Location)
+	require.WithinDuration(t, time.Now().Add(2*time.Hour), events[0].Start, time.Minute)
+}
+
+func TestCalDAVCalendarClient_GetEventsUnauthorized(t *testing.T) {
+	server := setupCalDAVServer(t)
+	defer server.Close()
+	defer teardownCalDAVEnvVars()
+	os.Setenv("CALENDAR_PASSWORD", "wrong")
+
+	calendarClient, err := newCalendarClient()
+	require.NoError(t, err)
+
+	events, err := calendarClient.getEvents()
+	require.Error(t, err)
+	require.Nil(t, events)
+}
+
+func TestCalDAVCalendarClient_CreateEvent(t *testing.T) {
+	var path, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PUT" || r.Header.Get("If-None-Match") != "*" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		path = r.URL.Path
+		data, _ := io.ReadAll(r.Body)
+		body = string(data)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+	os.Setenv("CALENDAR_PROVIDER", "caldav")
+	os.Setenv("CALENDAR_URL", server.URL+"/calendars/test/personal/")
+	defer teardownCalDAVEnvVars()
+
+	calendarClient, err := newCalendarClient()
+	require.NoError(t, err)
+
+	start := time.Date(2024, 1, 5, 12, 0, 0, 0, time.UTC)
+	err = calendarClient.(calendarWriter).createEvent(calendarEvent{UID: "lunch@test", Title: "Lunch", Start: start, End: start.Add(time.Hour)})
+	require.NoError(t, err)
+	require.Equal(t, "/calendars/test/personal/lunch@test.ics", path)
+	require.Contains(t, body, "DTSTART:20240105T120000Z\r\n")
+	require.Contains(t, body, "SUMMARY:Lunch\r\n")
+}
+
+func TestCalDAVCalendarClient_CreateEventExists(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusPreconditionFailed)
+	}))
+	defer server.Close()
+	os.Setenv("CALENDAR_PROVIDER", "caldav")
+	os.Setenv("CALENDAR_URL", server.URL+"/calendars/test/personal/")
+	defer teardownCalDAVEnvVars()
+
+	calendarClient, err := newCalendarClient()
+	require.NoError(t, err)
+
+	start := time.Date(2024, 1, 5, 12, 0, 0, 0, time.UTC)
+	err = calendarClient.(calendarWriter).createEvent(calendarEvent{UID: "lunch@test", Title: "Lunch", Start: start, End: start.Add(time.Hour)})
+	require.ErrorIs(t, err, errCalendarEventExists)
+}
@@ -2,47 +2,173 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"net/http"
 	"os"
+	"time"
 )
 
 type calendarClient interface {
 	getEvents() ([]calendarEvent, error)
 }
 
+// calendarWriter is implemented by calendar providers that events can be added to
+type calendarWriter interface {
+	createEvent(event calendarEvent) error
+	// writable is false when the provider can only be read as configured
+	writable() bool
+}
+
+// errCalendarReadOnly is returned when adding an event to a calendar that can only be read
+var errCalendarReadOnly = errors.New("calendar is read-only")
+
+// errCalendarEventExists is returned when adding an event whose UID is already in the calendar
+var errCalendarEventExists = errors.New("event already exists")
+
 type calendar struct {
-	apiKey string
-	baseURL string
+	apiKey   string
+	baseURL  string
+	location *time.Location
+
+	cache *ttlCache[[]calendarEvent]
 }
 
 type calendarEvent struct {
-	Title string `json:"title"`
+	UID         string    `json:"uid,omitempty"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	Location    string    `json:"location,omitempty"`
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	// AllDay events start and end at midnight
+	AllDay    bool `json:"all_day,omitempty"`
+	Recurring bool `json:"recurring,omitempty"`
+	// Calendar, Color and Kind describe the calendar the event is from, when several are merged
+	Calendar string `json:"calendar,omitempty"`
+	Color    string `json:"color,omitempty"`
+	Kind     string `json:"kind,omitempty"`
+}
+
+// calendarAPIEvent is an event as returned by the calendar API, with times
+// given either as dates or as RFC 3339 date-times
+type calendarAPIEvent struct {
+	Title       string `json:"title"`
 	Description string `json:"description"`
-	Start string `json:"start"`
-	End string `json:"end"`
+	Start       string `json:"start"`
+	End         string `json:"end"`
 }
 
+const (
+	calendarCacheDuration    = 5 * time.Minute
+	calendarCacheMaxStale    = time.Hour
+	defaultCalendarLookahead = 7 * 24 * time.Hour
+)
+
+// newCalendarClient merges the calendars listed in CALENDARS, each configured
+// by CALENDAR_<NAME>_* env vars, or creates a single calendar configured by CALENDAR_*
 func newCalendarClient() (calendarClient, error) {
-	if os.Getenv("CALENDAR_API_KEY") == "" {
-		return nil, fmt.Errorf("CALENDAR_API_KEY is not set")
+	names := envList("CALENDARS")
+	if len(names) == 0 {
+		client, err := newCalendarProvider("CALENDAR")
+		if err != nil {
+			return nil, err
+		}
+		// a single calendar gets the new events
+		if writer, ok := client.(calendarWriter); ok && writer.writable() {
+			markEventTarget(writer)
+		}
+		return client, nil
+	}
+	return newMergedCalendarClient(names)
+}
+
+// newCalendarProvider creates the calendar client selected by <prefix>_PROVIDER,
+// either "api" (the default), "ics" for iCalendar URLs and files, or "caldav"
+func newCalendarProvider(prefix string) (calendarClient, error) {
+	switch os.Getenv(prefix + "_PROVIDER") {
+	case "", "api":
+		return newCalendarAPIClient(prefix)
+	case "ics":
+		return newICSCalendarClient(prefix)
+	case "caldav":
+		return newCalDAVCalendarClient(prefix)
+	default:
+		return nil, fmt.Errorf("unknown %s_PROVIDER %q", prefix, os.Getenv(prefix+"_PROVIDER"))
+	}
+}
+
+// calendarSettings are shared by the iCalendar based providers
+type calendarSettings struct {
+	// lookahead is how far ahead of now events are fetched
+	lookahead time.Duration
+	// location is used for times that have no timezone of their own
+	location *time.Location
+	// window is which of the fetched events are shown, see eventsInWindow
+	window string
+}
+
+func newCalendarSettings() (calendarSettings, error) {
+	lookahead, err := envDuration("CALENDAR_LOOKAHEAD", defaultCalendarLookahead)
+	if err != nil {
+		return calendarSettings{}, err
+	}
+
+	location := time.Local
+	if name := os.Getenv("CALENDAR_TIMEZONE"); name != "" {
+		location, err = time.LoadLocation(name)
+		if err != nil {
+			return calendarSettings{}, fmt.Errorf("CALENDAR_TIMEZONE is not a valid timezone: %w", err)
+		}
+	}
+
+	window := os.Getenv("CALENDAR_WINDOW")
+	switch window {
+	case "":
+		window = calendarWindowWeek
+	case calendarWindowToday, calendarWindow24h, calendarWindowWeek:
+	default:
+		return calendarSettings{}, fmt.Errorf("unknown CALENDAR_WINDOW %q", window)
 	}
 
-	if os.Getenv("CALENDAR_BASE_URL") == "" {
-		return nil, fmt.Errorf("CALENDAR_BASE_URL is not set")
+	return calendarSettings{lookahead: lookahead, location: location, window: window}, nil
+}
+
+func newCalendarAPIClient(prefix string) (calendarClient, error) {
+	if os.Getenv(prefix+"_API_KEY") == "" {
+		return nil, fmt.Errorf("%s_API_KEY is not set", prefix)
+	}
+
+	if os.Getenv(prefix+"_BASE_URL") == "" {
+		return nil, fmt.Errorf("%s_BASE_URL is not set", prefix)
+	}
+
+	settings, err := newCalendarSettings()
+	if err != nil {
+		return nil, err
+	}
+
+	cache, err := newSourceCache[[]calendarEvent]("CALENDAR", calendarCacheDuration, calendarCacheMaxStale)
+	if err != nil {
+		return nil, err
 	}
 
 	return &calendar{
-		apiKey: os.Getenv("CALENDAR_API_KEY"),
-		baseURL: os.Getenv("CALENDAR_BASE_URL"),
+		apiKey:   os.Getenv(prefix + "_API_KEY"),
+		baseURL:  os.Getenv(prefix + "_BASE_URL"),
+		location: settings.location,
+		cache:    cache,
 	}, nil
 }
 
 func (c *calendar) getEvents() ([]calendarEvent, error) {
+	return c.cache.get("events", c.fetch)
+}
+
+func (c *calendar) fetch() ([]calendarEvent, error) {
 	url := fmt.Sprintf("%s/events?apiKey=%s", c.baseURL, c.apiKey)
 
-	resp, err := http.Get(url)
+	resp, err := sourceHTTPClient.Get(url)
 	if err != nil {
 		return nil, fmt.Errorf("cannot get events: %w", err)
 	}
@@ -54,11 +180,50 @@ func (c *calendar) getEvents() ([]calendarEvent, error) {
 		return nil, fmt.Errorf("cannot read events: %w", err)
 	}
 
-	var events []calendarEvent
-	err = json.Unmarshal(body, &events)
+	var apiEvents []calendarAPIEvent
+	err = json.Unmarshal(body, &apiEvents)
 	if err != nil {
 		return nil, fmt.Errorf("cannot unmarshal events: %w", err)
 	}
 
+	events := make([]calendarEvent, len(apiEvents))
+	for i, apiEvent := range apiEvents {
+		start, allDay, err := parseCalendarTime(apiEvent.Start, c.location)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse start of %q: %w", apiEvent.Title, err)
+		}
+
+		end := start
+		if apiEvent.End != "" {
+			end, _, err = parseCalendarTime(apiEvent.End, c.location)
+			if err != nil {
+				return nil, fmt.Errorf("cannot parse end of %q: %w", apiEvent.Title, err)
+			}
+		}
+
+		events[i] = calendarEvent{
+			Title:       apiEvent.Title,
+			Description: apiEvent.Description,
+			Start:       start,
+			End:         end,
+			AllDay:      allDay,
+		}
+	}
+
 	return events, nil
 }
+
+// parseCalendarTime parses a date, which is reported as all-day, or a date-time with or without an offset
+func parseCalendarTime(value string, loc *time.Location) (time.Time, bool, error) {
+	if t, err := time.ParseInLocation(calendarDateLayout, value, loc); err == nil {
+		return t, true, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.In(loc), false, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02T15:04:05", value, loc)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("unknown time format %q", value)
+	}
+	return t, false, nil
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// calendarEventRequest is the body of POST /calendar/events. Text is parsed into an
+// event that is returned as a preview; posting the previewed event back with
+// its token and Confirm set adds it to the calendar.
+type calendarEventRequest struct {
+	Text    string         `json:"text,omitempty"`
+	Event   *calendarEvent `json:"event,omitempty"`
+	Token   string         `json:"token,omitempty"`
+	Confirm bool           `json:"confirm,omitempty"`
+}
+
+// calendarEventResponse is the previewed or created event. Token comes with a
+// preview and must be sent back to confirm it.
+type calendarEventResponse struct {
+	Event   calendarEvent `json:"event"`
+	Token   string        `json:"token,omitempty"`
+	Created bool          `json:"created"`
+}
+
+// calendarPreviewKey signs previews, so only events the server previewed can be confirmed.
+// Previews from before a restart have to be made again.
+var calendarPreviewKey = newCalendarPreviewKey()
+
+// extractedCalendarEvent is the structured output the model fills in from text
+type extractedCalendarEvent struct {
+	Title    string `json:"title"`
+	Start    string `json:"start"`
+	End      string `json:"end"`
+	Location string `json:"location"`
+	AllDay   bool   `json:"all_day"`
+}
+
+var extractedCalendarEventSchema = map[string]any{
+	"type": "object",
+	"properties": map[string]any{
+		"title":    map[string]any{"type": "string"},
+		"start":    map[string]any{"type": "string", "description": "YYYY-MM-DDTHH:MM:SS local time, or YYYY-MM-DD for all-day events"},
+		"end":      map[string]any{"type": "string", "description": "same format as start, empty if not mentioned"},
+		"location": map[string]any{"type": "string"},
+		"all_day":  map[string]any{"type": "boolean"},
+	},
+	"required":             []string{"title", "start", "end", "location", "all_day"},
+	"additionalProperties": false,
+}
+
+// defaultEventLength is used when the text doesn't say when an event ends
+const defaultEventLength = time.Hour
+
+// getCalendarFree responds with the free working time in the calendar window, along with
+// conflicting and back-to-back events. The optional min query parameter, like "30m", drops shorter free slots.
+func getCalendarFree(w http.ResponseWriter, r *http.Request, calendar calendarClient, settings scheduleSettings) error {
+	var minDuration time.Duration
+	if value := r.URL.Query().Get("min"); value != "" {
+		var err error
+		minDuration, err = time.ParseDuration(value)
+		if err != nil {
+			writeHttpError(w, http.StatusBadRequest, "min is not a valid duration", err)
+			return nil
+		}
+	}
+
+	events, err := calendar.getEvents()
+	if err != nil {
+		return fmt.Errorf("cannot get calendar events: %w", err)
+	}
+
+	report := analyzeSchedule(events, settings, time.Now().In(settings.calendar.location))
+	free := []freeSlot{}
+	for _, slot := range report.Free {
+		if slot.End.Sub(slot.Start) >= minDuration {
+			free = append(free, slot)
+		}
+	}
+	report.Free = free
+
+	reportJson, err := json.Marshal(report)
+	if err != nil {
+		return fmt.Errorf("cannot marshal schedule to json: %w", err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(reportJson)
+
+	return nil
+}
+
+// postCalendarEvent previews an event described in natural language, like "lunch with Sam
+// Friday at noon for an hour", or adds a previewed event to the calendar once it is confirmed
+func postCalendarEvent(w http.ResponseWriter, r *http.Request, o openWebUIClient, writer calendarWriter, settings calendarSettings) error {
+	var request calendarEventRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		writeHttpError(w, http.StatusBadRequest, "cannot parse request", err)
+		return nil
+	}
+
+	if request.Confirm {
+		if request.Event == nil {
+			writeHttpError(w, http.StatusBadRequest, "confirm needs the previewed event", fmt.Errorf("event is missing"))
+			return nil
+		}
+		event := *request.Event
+		token, err := calendarPreviewToken(event)
+		if err != nil {
+			return err
+		}
+		if !hmac.Equal([]byte(token), []byte(request.Token)) {
+			writeHttpError(w, http.StatusBadRequest, "confirm needs the token of the preview", fmt.Errorf("token doesn't match the event"))
+			return nil
+		}
+		err = validateCalendarEvent(event)
+		if err != nil {
+			writeHttpError(w, http.StatusBadRequest, "event is not valid", err)
+			return nil
+		}
+		if writer == nil {
+			writeHttpError(w, http.StatusNotImplemented, "calendar is read-only", errCalendarReadOnly)
+			return nil
+		}
+
+		// the UID comes with the preview, so confirming it again doesn't add it twice
+		err = writer.createEvent(event)
+		if errors.Is(err, errCalendarReadOnly) {
+			writeHttpError(w, http.StatusNotImplemented, "calendar is read-only", err)
+			return nil
+		}
+		if errors.Is(err, errCalendarEventExists) {
+			writeHttpError(w, http.StatusConflict, "event was already added", err)
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("cannot create event: %w", err)
+		}
+		return writeCalendarEventResponse(w, http.StatusCreated, calendarEventResponse{Event: event, Created: true})
+	}
+
+	if strings.TrimSpace(request.Text) == "" {
+		writeHttpError(w, http.StatusBadRequest, "text is required", fmt.Errorf("text is empty"))
+		return nil
+	}
+
+	event, err := extractCalendarEvent(o, request.Text, time.Now().In(settings.location))
+	if err != nil {
+		writeHttpError(w, http.StatusUnprocessableEntity, "cannot understand the event", err)
+		return nil
+	}
+	event.UID, err = newEventUID()
+	if err != nil {
+		return err
+	}
+	token, err := calendarPreviewToken(event)
+	if err != nil {
+		return err
+	}
+	return writeCalendarEventResponse(w, http.StatusOK, calendarEventResponse{Event: event, Token: token})
+}
+
+// extractCalendarEvent asks the model for the event described by text, relative to now
+func extractCalendarEvent(o openWebUIClient, text string, now time.Time) (calendarEvent, error) {
+	result, err := o.generateJSON(fmt.Sprintf("You are a calendar assistant. It is %s (%s). Extract the calendar event described below, with times in the same timezone.\n \n %s", now.Format("Monday, 2006-01-02 15:04"), now.Location(), text), extractedCalendarEventSchema)
+	if err != nil {
+		return calendarEvent{}, fmt.Errorf("cannot extract event: %w", err)
+	}
+
+	var extracted extractedCalendarEvent
+	err = json.Unmarshal([]byte(result), &extracted)
+	if err != nil {
+		return calendarEvent{}, fmt.Errorf("cannot unmarshal event: %w", err)
+	}
+
+	start, allDay, err := parseCalendarTime(extracted.Start, now.Location())
+	if err != nil {
+		return calendarEvent{}, fmt.Errorf("cannot parse start: %w", err)
+	}
+	allDay = allDay || extracted.AllDay
+	if allDay {
+		start = time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, now.Location())
+	}
+
+	end := start.Add(defaultEventLength)
+	if allDay {
+		end = start.AddDate(0, 0, 1)
+	}
+	if extracted.End != "" {
+		end, _, err = parseCalendarTime(extracted.End, now.Location())
+		if err != nil {
+			return calendarEvent{}, fmt.Errorf("cannot parse end: %w", err)
+		}
+		// all-day events end at the start of the day after
+		if allDay {
+			end = time.Date(end.Year(), end.Month(), end.Day()+1, 0, 0, 0, 0, now.Location())
+		}
+	}
+
+	event := calendarEvent{
+		Title:    strings.TrimSpace(extracted.Title),
+		Location: strings.TrimSpace(extracted.Location),
+		Start:    start,
+		End:      end,
+		AllDay:   allDay,
+	}
+	return event, validateCalendarEvent(event)
+}
+
+func validateCalendarEvent(event calendarEvent) error {
+	if event.Title == "" {
+		return fmt.Errorf("event has no title")
+	}
+	if event.Start.IsZero() {
+		return fmt.Errorf("event has no start")
+	}
+	if !event.End.After(event.Start) {
+		return fmt.Errorf("event must end after it starts")
+	}
+	return nil
+}
+
+// newCalendarPreviewKey generates the key previews are signed with when the server starts
+func newCalendarPreviewKey() []byte {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	if err != nil {
+		panic(fmt.Errorf("cannot generate preview key: %w", err))
+	}
+	return key
+}
+
+// calendarPreviewToken signs an event as it is sent to the client, with its UID, any change to it changes the token
+func calendarPreviewToken(event calendarEvent) (string, error) {
+	eventJson, err := json.Marshal(event)
+	if err != nil {
+		return "", fmt.Errorf("cannot marshal event to json: %w", err)
+	}
+	mac := hmac.New(sha256.New, calendarPreviewKey)
+	mac.Write(eventJson)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// newEventUID generates a globally unique identifier for a new event
+func newEventUID() (string, error) {
+	id := make([]byte, 16)
+	_, err := rand.Read(id)
+	if err != nil {
+		return "", fmt.Errorf("cannot generate event id: %w", err)
+	}
+	return hex.EncodeToString(id) + "@assistant", nil
+}
+
+func writeCalendarEventResponse(w http.ResponseWriter, status int, response calendarEventResponse) error {
+	responseJson, err := json.Marshal(response)
+	if err != nil {
+		return fmt.Errorf("cannot marshal event to json: %w", err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(responseJson)
+
+	return nil
+}
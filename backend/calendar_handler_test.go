@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetCalendarFree(t *testing.T) {
+	settings := testScheduleSettings()
+	// work around the clock, so there is always free time in the next 24 hours
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		settings.workDays[day] = true
+	}
+	settings.workStart, settings.workEnd = 0, 24*time.Hour-time.Minute
+	settings.calendar.window = calendarWindow24h
+
+	start := time.Now().Add(time.Hour)
+	mockCalendarClient := &mockCalendarClient{
+		getEventsReturns: []calendarEvent{
+			{Title: "A", Start: start, End: start.Add(time.Hour)},
+			{Title: "B", Start: start.Add(time.Hour + time.Minute), End: start.Add(2 * time.Hour)},
+		},
+	}
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/calendar/free?min=2m", nil)
+	err := getCalendarFree(recorder, req, mockCalendarClient, settings)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, recorder.Code)
+
+	var report scheduleReport
+	err = json.NewDecoder(recorder.Body).Decode(&report)
+	require.NoError(t, err)
+	require.Empty(t, report.Conflicts)
+	require.Len(t, report.BackToBack, 1)
+	require.NotEmpty(t, report.Free)
+	for _, slot := range report.Free {
+		// the minute between A and B is too short
+		require.GreaterOrEqual(t, slot.End.Sub(slot.Start), 2*time.Minute)
+		require.False(t, slot.Start.Before(start.Add(time.Hour)) && slot.End.After(start))
+	}
+}
+
+func TestGetCalendarFree_Errors(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/calendar/free?min=soon", nil)
+	err := getCalendarFree(recorder, req, &mockCalendarClient{}, testScheduleSettings())
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, recorder.Code)
+
+	mockCalendarClient := &mockCalendarClient{getEventsErrors: []error{errors.New("unavailable")}}
+	err = getCalendarFree(httptest.NewRecorder(), httptest.NewRequest("GET", "/calendar/free", nil), mockCalendarClient, testScheduleSettings())
+	require.Error(t, err)
+}
+
+type mockCalendarWriter struct {
+	createEventArgs   []calendarEvent
+	createEventErrors []error
+}
+
+func (m *mockCalendarWriter) createEvent(event calendarEvent) error {
+	m.createEventArgs = append(m.createEventArgs, event)
+	if len(m.createEventErrors) > 0 {
+		return m.createEventErrors[len(m.createEventArgs)-1]
+	}
+	return nil
+}
+
+func (m *mockCalendarWriter) writable() bool {
+	return true
+}
+
+func TestPostCalendarEvent_PreviewAndConfirm(t *testing.T) {
+	settings := testScheduleSettings().calendar
+	mockOpenWebUIClient := &mockOpenWebUIClient{
+		generateJSONReturns: `{"title": "Lunch with Sam", "start": "2030-01-04T12:00:00", "end": "", "location": "Cafe", "all_day": false}`,
+	}
+	mockCalendarWriter := &mockCalendarWriter{}
+
+	// preview
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/calendar/events", strings.NewReader(`{"text": "lunch with Sam Friday at noon at the cafe"}`))
+	err := postCalendarEvent(recorder, req, mockOpenWebUIClient, mockCalendarWriter, settings)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, recorder.Code)
+	require.Empty(t, mockCalendarWriter.createEventArgs)
+	require.Contains(t, mockOpenWebUIClient.generateJSONArgs[0], "lunch with Sam Friday at noon at the cafe")
+
+	var preview calendarEventResponse
+	err = json.NewDecoder(recorder.Body).Decode(&preview)
+	require.NoError(t, err)
+	require.False(t, preview.Created)
+	require.Equal(t, "Lunch with Sam", preview.Event.Title)
+	require.Equal(t, "Cafe", preview.Event.Location)
+	require.True(t, time.Date(2030, 1, 4, 12, 0, 0, 0, time.UTC).Equal(preview.Event.Start))
+	// an hour long unless the text says otherwise
+	require.True(t, time.Date(2030, 1, 4, 13, 0, 0, 0, time.UTC).Equal(preview.Event.End))
+	require.NotEmpty(t, preview.Token)
+	require.NotEmpty(t, preview.Event.UID)
+
+	// a changed event can't be confirmed with the token of the preview
+	changed := preview.Event
+	changed.Title = "Lunch with someone else"
+	body, err := json.Marshal(calendarEventRequest{Event: &changed, Token: preview.Token, Confirm: true})
+	require.NoError(t, err)
+	recorder = httptest.NewRecorder()
+	req = httptest.NewRequest("POST", "/calendar/events", bytes.NewReader(body))
+	err = postCalendarEvent(recorder, req, mockOpenWebUIClient, mockCalendarWriter, settings)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, recorder.Code)
+	require.Empty(t, mockCalendarWriter.createEventArgs)
+
+	// confirm
+	body, err = json.Marshal(calendarEventRequest{Event: &preview.Event, Token: preview.Token, Confirm: true})
+	require.NoError(t, err)
+	recorder = httptest.NewRecorder()
+	req = httptest.NewRequest("POST", "/calendar/events", bytes.NewReader(body))
+	err = postCalendarEvent(recorder, req, mockOpenWebUIClient, mockCalendarWriter, settings)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, recorder.Code)
+
+	require.Len(t, mockCalendarWriter.createEventArgs, 1)
+	created := mockCalendarWriter.createEventArgs[0]
+	require.Equal(t, "Lunch with Sam", created.Title)
+	require.Equal(t, preview.Event.UID, created.UID)
+	require.Len(t, mockOpenWebUIClient.generateJSONArgs, 1)
+
+	// confirming again, like a double click, adds the same event
+	mockCalendarWriter.createEventErrors = []error{nil, errCalendarEventExists}
+	recorder = httptest.NewRecorder()
+	req = httptest.NewRequest("POST", "/calendar/events", bytes.NewReader(body))
+	err = postCalendarEvent(recorder, req, mockOpenWebUIClient, mockCalendarWriter, settings)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusConflict, recorder.Code)
+	require.Equal(t, preview.Event.UID, mockCalendarWriter.createEventArgs[1].UID)
+}
+
+func TestExtractCalendarEvent_AllDay(t *testing.T) {
+	now := time.Date(2030, 1, 1, 9, 0, 0, 0, time.UTC)
+	mockOpenWebUIClient := &mockOpenWebUIClient{
+		generateJSONReturns: `{"title": "Offsite", "start": "2030-01-07", "end": "2030-01-08", "location": "", "all_day": true}`,
+	}
+
+	event, err := extractCalendarEvent(mockOpenWebUIClient, "offsite monday and tuesday", now)
+	require.NoError(t, err)
+	require.True(t, event.AllDay)
+	require.Equal(t, time.Date(2030, 1, 7, 0, 0, 0, 0, time.UTC), event.Start)
+	require.Equal(t, time.Date(2030, 1, 9, 0, 0, 0, 0, time.UTC), event.End)
+}
+
+func TestPostCalendarEvent_Errors(t *testing.T) {
+	settings := testScheduleSettings().calendar
+	start := time.Date(2030, 1, 4, 12, 0, 0, 0, time.UTC)
+	event := calendarEvent{UID: "lunch@test", Title: "Lunch", Start: start, End: start.Add(time.Hour)}
+	token, err := calendarPreviewToken(event)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		body   any
+		model  string
+		writer calendarWriter
+		status int
+	}{
+		{"no text", calendarEventRequest{}, "", &mockCalendarWriter{}, http.StatusBadRequest},
+		{"confirm without event", calendarEventRequest{Text: "lunch", Confirm: true}, "", &mockCalendarWriter{}, http.StatusBadRequest},
+		{"event ends before it starts", calendarEventRequest{Event: &calendarEvent{Title: "Lunch", Start: start, End: start}, Confirm: true}, "", &mockCalendarWriter{}, http.StatusBadRequest},
+		{"model output is not an event", calendarEventRequest{Text: "lunch"}, `{"title": "", "start": "soon"}`, &mockCalendarWriter{}, http.StatusUnprocessableEntity},
+		{"confirm without token", calendarEventRequest{Event: &event, Confirm: true}, "", &mockCalendarWriter{}, http.StatusBadRequest},
+		{"no writable calendar", calendarEventRequest{Event: &event, Token: token, Confirm: true}, "", nil, http.StatusNotImplemented},
+		{"read-only calendar", calendarEventRequest{Event: &event, Token: token, Confirm: true}, "", &mockCalendarWriter{createEventErrors: []error{errCalendarReadOnly}}, http.StatusNotImplemented},
+		{"already added", calendarEventRequest{Event: &event, Token: token, Confirm: true}, "", &mockCalendarWriter{createEventErrors: []error{errCalendarEventExists}}, http.StatusConflict},
+	}
+	for _, test := range tests {
+		body, err := json.Marshal(test.body)
+		require.NoError(t, err)
+
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/calendar/events", bytes.NewReader(body))
+		err = postCalendarEvent(recorder, req, &mockOpenWebUIClient{generateJSONReturns: test.model}, test.writer, settings)
+		require.NoError(t, err, test.name)
+		require.Equal(t, test.status, recorder.Code, test.name)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -47,8 +48,9 @@ func TestCalendarClient_GetEvents(t *testing.T) {
 	require.Equal(t, 1, len(events))
 	require.Equal(t, "Test Title", events[0].Title)
 	require.Equal(t, "Test Description", events[0].Description)
-	require.Equal(t, "2024-01-01", events[0].Start)
-	require.Equal(t, "2024-01-02", events[0].End)
+	require.Equal(t, time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local), events[0].Start)
+	require.Equal(t, time.Date(2024, 1, 2, 0, 0, 0, 0, time.Local), events[0].End)
+	require.True(t, events[0].AllDay)
 }
 
 func TestCalendarClient_GetEventsInternalError(t *testing.T) {
@@ -63,3 +65,25 @@ func TestCalendarClient_GetEventsInternalError(t *testing.T) {
 	require.Nil(t, events)
 }
 
+
+func TestParseCalendarTime(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+
+	start, allDay, err := parseCalendarTime("2024-01-01", newYork)
+	require.NoError(t, err)
+	require.True(t, allDay)
+	require.Equal(t, time.Date(2024, 1, 1, 0, 0, 0, 0, newYork), start)
+
+	start, allDay, err = parseCalendarTime("2024-01-01T14:00:00Z", newYork)
+	require.NoError(t, err)
+	require.False(t, allDay)
+	require.True(t, time.Date(2024, 1, 1, 9, 0, 0, 0, newYork).Equal(start))
+
+	start, _, err = parseCalendarTime("2024-01-01T09:00:00", newYork)
+	require.NoError(t, err)
+	require.Equal(t, time.Date(2024, 1, 1, 9, 0, 0, 0, newYork), start)
+
+	_, _, err = parseCalendarTime("tomorrow", newYork)
+	require.Error(t, err)
+}
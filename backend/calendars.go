@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// calendar kinds, which prompts treat differently
+const (
+	calendarKindWork     = "work"
+	calendarKindPersonal = "personal"
+)
+
+// calendarSource is one of several merged calendars
+type calendarSource struct {
+	name   string
+	color  string
+	kind   string
+	client calendarClient
+}
+
+// mergedCalendar combines several calendars, tagging each event with the
+// calendar it is from and dropping events that are in more than one
+type mergedCalendar struct {
+	sources []calendarSource
+	// writer is where new events are added, nil if no calendar is writable
+	writer calendarWriter
+}
+
+// newMergedCalendarClient creates each named calendar from CALENDAR_<NAME>_PROVIDER and the
+// provider's other settings, labelled with CALENDAR_<NAME>_COLOR and CALENDAR_<NAME>_KIND
+// ("work" or "personal"). New events go to CALENDAR_DEFAULT, or the first writable calendar.
+func newMergedCalendarClient(names []string) (calendarClient, error) {
+	merged := &mergedCalendar{}
+	for _, name := range names {
+		prefix := "CALENDAR_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+
+		client, err := newCalendarProvider(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("cannot create calendar %q: %w", name, err)
+		}
+
+		kind := os.Getenv(prefix + "_KIND")
+		switch kind {
+		case "":
+			kind = calendarKindPersonal
+			if strings.EqualFold(name, calendarKindWork) {
+				kind = calendarKindWork
+			}
+		case calendarKindWork, calendarKindPersonal:
+		default:
+			return nil, fmt.Errorf("unknown %s_KIND %q", prefix, kind)
+		}
+
+		merged.sources = append(merged.sources, calendarSource{
+			name:   name,
+			color:  os.Getenv(prefix + "_COLOR"),
+			kind:   kind,
+			client: client,
+		})
+	}
+
+	defaultName := os.Getenv("CALENDAR_DEFAULT")
+	for _, source := range merged.sources {
+		writer, ok := source.client.(calendarWriter)
+		ok = ok && writer.writable()
+		if defaultName != "" && source.name == defaultName {
+			if !ok {
+				return nil, fmt.Errorf("CALENDAR_DEFAULT calendar %q is read-only", defaultName)
+			}
+			merged.writer = writer
+			break
+		}
+		if defaultName == "" && ok && merged.writer == nil {
+			merged.writer = writer
+		}
+	}
+	if defaultName != "" && merged.writer == nil {
+		return nil, fmt.Errorf("CALENDAR_DEFAULT calendar %q is not in CALENDARS", defaultName)
+	}
+	if merged.writer != nil {
+		markEventTarget(merged.writer)
+	}
+
+	return merged, nil
+}
+
+// getEvents concurrently gets the events of every calendar. A calendar that fails is
+// left out, so one being down doesn't hide the others, unless they all fail.
+func (c *mergedCalendar) getEvents() ([]calendarEvent, error) {
+	results := make([][]calendarEvent, len(c.sources))
+	errs := make([]error, len(c.sources))
+	var wg sync.WaitGroup
+	for i, source := range c.sources {
+		wg.Add(1)
+		go func(i int, source calendarSource) {
+			defer wg.Done()
+			events, err := source.client.getEvents()
+			if err != nil {
+				errs[i] = fmt.Errorf("cannot get calendar %q: %w", source.name, err)
+				return
+			}
+			for _, event := range events {
+				event.Calendar = source.name
+				event.Color = source.color
+				event.Kind = source.kind
+				results[i] = append(results[i], event)
+			}
+		}(i, source)
+	}
+	wg.Wait()
+
+	failed := 0
+	for _, err := range errs {
+		if err != nil {
+			fmt.Println(err)
+			failed++
+		}
+	}
+	if failed == len(c.sources) {
+		return nil, errs[0]
+	}
+
+	// the first calendar an event is in wins, in configured order
+	var events []calendarEvent
+	seenUIDs := map[string]bool{}
+	seenTimes := map[string]bool{}
+	for _, result := range results {
+		for _, event := range result {
+			uid := event.UID
+			if uid != "" && event.Recurring {
+				// occurrences of a recurring event share the UID
+				uid += "|" + strconv.FormatInt(event.Start.Unix(), 10)
+			}
+			timeKey := strings.ToLower(strings.TrimSpace(event.Title)) + "|" + strconv.FormatInt(event.Start.Unix(), 10) + "|" + strconv.FormatInt(event.End.Unix(), 10)
+			if (uid != "" && seenUIDs[uid]) || seenTimes[timeKey] {
+				continue
+			}
+			if uid != "" {
+				seenUIDs[uid] = true
+			}
+			seenTimes[timeKey] = true
+			events = append(events, event)
+		}
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Start.Before(events[j].Start)
+	})
+	return events, nil
+}
+
+func (c *mergedCalendar) writable() bool {
+	return c.writer != nil
+}
+
+// createEvent adds the event to the default calendar
+func (c *mergedCalendar) createEvent(event calendarEvent) error {
+	if c.writer == nil {
+		return errCalendarReadOnly
+	}
+	return c.writer.createEvent(event)
+}
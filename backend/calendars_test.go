@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type failingCalendar struct{}
+
+func (failingCalendar) getEvents() ([]calendarEvent, error) {
+	return nil, errors.New("unavailable")
+}
+
+func TestMergedCalendar_GetEvents(t *testing.T) {
+	start := time.Date(2024, 1, 5, 12, 0, 0, 0, time.UTC)
+	standup := calendarEvent{UID: "standup@test", Title: "Standup", Start: start.Add(-3 * time.Hour), End: start.Add(-170 * time.Minute)}
+	dinner := calendarEvent{UID: "dinner@family", Title: "Dinner", Start: start.Add(7 * time.Hour), End: start.Add(9 * time.Hour)}
+
+	calendarClient := &mergedCalendar{sources: []calendarSource{
+		{name: "work", color: "#0000ff", kind: calendarKindWork, client: staticCalendar{standup}},
+		{name: "personal", color: "#00ff00", kind: calendarKindPersonal, client: staticCalendar{
+			{UID: "lunch@test", Title: "Lunch", Start: start, End: start.Add(time.Hour)},
+			// the same invitation with another UID
+			{UID: "dinner@personal", Title: "dinner ", Start: dinner.Start, End: dinner.End},
+		}},
+		{name: "family", kind: calendarKindPersonal, client: staticCalendar{dinner, standup}},
+		{name: "broken", client: failingCalendar{}},
+	}}
+
+	events, err := calendarClient.getEvents()
+	require.NoError(t, err)
+	require.Equal(t, []string{"Standup", "Lunch", "dinner "}, eventTitles(events))
+	require.Equal(t, "work", events[0].Calendar)
+	require.Equal(t, "#0000ff", events[0].Color)
+	require.Equal(t, calendarKindWork, events[0].Kind)
+	require.Equal(t, "personal", events[2].Calendar)
+}
+
+func TestMergedCalendar_KeepsRecurringOccurrences(t *testing.T) {
+	start := time.Date(2024, 1, 5, 9, 0, 0, 0, time.UTC)
+	calendarClient := &mergedCalendar{sources: []calendarSource{
+		{name: "work", client: staticCalendar{
+			{UID: "standup@test", Title: "Standup", Start: start, End: start.Add(15 * time.Minute), Recurring: true},
+			{UID: "standup@test", Title: "Standup", Start: start.AddDate(0, 0, 1), End: start.AddDate(0, 0, 1).Add(15 * time.Minute), Recurring: true},
+		}},
+	}}
+
+	events, err := calendarClient.getEvents()
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+}
+
+func TestMergedCalendar_AllFail(t *testing.T) {
+	calendarClient := &mergedCalendar{sources: []calendarSource{{name: "broken", client: failingCalendar{}}}}
+
+	events, err := calendarClient.getEvents()
+	require.Error(t, err)
+	require.Nil(t, events)
+}
+
+func TestNewCalendarClient_Multiple(t *testing.T) {
+	t.Setenv("CALENDARS", "work,family")
+	t.Setenv("CALENDAR_WORK_PROVIDER", "ics")
+	t.Setenv("CALENDAR_WORK_URL", "https://example.com/work.ics")
+	t.Setenv("CALENDAR_FAMILY_PROVIDER", "ics")
+	t.Setenv("CALENDAR_FAMILY_URL", filepath.Join(t.TempDir(), "family.ics"))
+	t.Setenv("CALENDAR_FAMILY_COLOR", "#ff0000")
+
+	calendarClient, err := newCalendarClient()
+	require.NoError(t, err)
+	merged := calendarClient.(*mergedCalendar)
+	require.Len(t, merged.sources, 2)
+	require.Equal(t, calendarKindWork, merged.sources[0].kind)
+	require.Equal(t, calendarKindPersonal, merged.sources[1].kind)
+	require.Equal(t, "#ff0000", merged.sources[1].color)
+
+	// new events go to the family calendar file, the work calendar is read-only
+	start := time.Now().Add(time.Hour).Truncate(time.Second)
+	err = merged.createEvent(calendarEvent{UID: "a@test", Title: "Swimming", Start: start, End: start.Add(time.Hour)})
+	require.NoError(t, err)
+
+	events, err := merged.sources[1].client.getEvents()
+	require.NoError(t, err)
+	require.Equal(t, []string{"Swimming"}, eventTitles(events))
+
+	t.Setenv("CALENDAR_DEFAULT", "work")
+	_, err = newCalendarClient()
+	require.Error(t, err)
+
+	t.Setenv("CALENDAR_DEFAULT", "")
+	t.Setenv("CALENDAR_FAMILY_KIND", "school")
+	_, err = newCalendarClient()
+	require.Error(t, err)
+}
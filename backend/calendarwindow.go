@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// calendar windows, selected by CALENDAR_WINDOW
+const (
+	// calendarWindowToday is the rest of today
+	calendarWindowToday = "today"
+	// calendarWindow24h is the next 24 hours
+	calendarWindow24h = "24h"
+	// calendarWindowWeek is the rest of the week, which starts on Monday
+	calendarWindowWeek = "week"
+)
+
+// windowedCalendar only returns the events of another calendar that are in
+// the configured window, ordered by start
+type windowedCalendar struct {
+	source   calendarClient
+	settings calendarSettings
+}
+
+func newWindowedCalendarClient(source calendarClient) (calendarClient, error) {
+	settings, err := newCalendarSettings()
+	if err != nil {
+		return nil, err
+	}
+
+	return &windowedCalendar{source: source, settings: settings}, nil
+}
+
+func (c *windowedCalendar) getEvents() ([]calendarEvent, error) {
+	events, err := c.source.getEvents()
+	if err != nil {
+		return nil, err
+	}
+
+	return eventsInWindow(events, c.settings.window, time.Now().In(c.settings.location)), nil
+}
+
+// windowEnd is when the window starting at now ends, in now's location
+func windowEnd(window string, now time.Time) time.Time {
+	year, month, day := now.Date()
+	switch window {
+	case calendarWindowToday:
+		return time.Date(year, month, day+1, 0, 0, 0, 0, now.Location())
+	case calendarWindow24h:
+		return now.Add(24 * time.Hour)
+	}
+	daysToMonday := (8 - int(now.Weekday())) % 7
+	if daysToMonday == 0 {
+		daysToMonday = 7
+	}
+	return time.Date(year, month, day+daysToMonday, 0, 0, 0, 0, now.Location())
+}
+
+// eventsInWindow returns the events that are ongoing at now or start before the window ends,
+// ordered by start with all-day events first
+func eventsInWindow(events []calendarEvent, window string, now time.Time) []calendarEvent {
+	end := windowEnd(window, now)
+
+	var matching []calendarEvent
+	for _, event := range events {
+		eventEnd := event.End
+		if eventEnd.Before(event.Start) {
+			eventEnd = event.Start
+		}
+		if (eventEnd.After(now) && event.Start.Before(end)) || event.Start.Equal(now) {
+			matching = append(matching, event)
+		}
+	}
+
+	sort.SliceStable(matching, func(i, j int) bool {
+		if !matching[i].Start.Equal(matching[j].Start) {
+			return matching[i].Start.Before(matching[j].Start)
+		}
+		return matching[i].AllDay && !matching[j].AllDay
+	})
+	return matching
+}
+
+// relativeTime describes when an event starts relative to now, e.g. "in 45 minutes" or
+// "tomorrow at 9:00 AM", or how long ago it started if it is ongoing
+func relativeTime(event calendarEvent, now time.Time) string {
+	start := event.Start.In(now.Location())
+	days := calendarDaysBetween(now, start)
+
+	if event.AllDay {
+		if !start.After(now) {
+			return "all day today"
+		}
+		return "all day " + relativeDay(days, start)
+	}
+
+	until := start.Sub(now)
+	switch {
+	case until < -time.Minute:
+		return fmt.Sprintf("started %s ago", roundedDuration(-until))
+	case until < time.Minute:
+		return "now"
+	case until < 12*time.Hour && days == 0:
+		return "in " + roundedDuration(until)
+	}
+	return relativeDay(days, start) + " at " + start.Format("3:04 PM")
+}
+
+// relativeDay names the day that is days after today
+func relativeDay(days int, day time.Time) string {
+	switch {
+	case days == 0:
+		return "today"
+	case days == 1:
+		return "tomorrow"
+	case days < 7:
+		return "on " + day.Format("Monday")
+	}
+	return "on " + day.Format("Monday, January 2")
+}
+
+// calendarDaysBetween counts the midnights between from and to
+func calendarDaysBetween(from, to time.Time) int {
+	fromDay := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.UTC)
+	toDay := time.Date(to.Year(), to.Month(), to.Day(), 0, 0, 0, 0, time.UTC)
+	return int(toDay.Sub(fromDay).Hours() / 24)
+}
+
+// roundedDuration formats a duration in minutes or, past an hour and a half, in hours
+func roundedDuration(d time.Duration) string {
+	minutes := int(d.Round(time.Minute).Minutes())
+	if minutes < 90 {
+		if minutes == 1 {
+			return "1 minute"
+		}
+		return fmt.Sprintf("%d minutes", minutes)
+	}
+	return fmt.Sprintf("%d hours", int(d.Round(time.Hour).Hours()))
+}
+
+// describeCalendarEvent formats an event for prompts
+func describeCalendarEvent(event calendarEvent, now time.Time) string {
+	description := fmt.Sprintf("%s, %s", event.Title, relativeTime(event, now))
+	if !event.AllDay {
+		description += fmt.Sprintf(" (%s - %s)", event.Start.In(now.Location()).Format("Monday 3:04 PM"), event.End.In(now.Location()).Format("3:04 PM"))
+	}
+	if event.Location != "" {
+		description += ", at " + event.Location
+	}
+	if event.Calendar != "" {
+		description += fmt.Sprintf(", from the %s calendar", event.Calendar)
+	}
+	if event.Description != "" {
+		description += ". Details: " + event.Description
+	}
+	return description
+}
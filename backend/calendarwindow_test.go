@@ -0,0 +1,99 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type staticCalendar []calendarEvent
+
+func (c staticCalendar) getEvents() ([]calendarEvent, error) {
+	return c, nil
+}
+
+func windowTestEvent(title string, start time.Time, duration time.Duration) calendarEvent {
+	return calendarEvent{Title: title, Start: start, End: start.Add(duration)}
+}
+
+func eventTitles(events []calendarEvent) []string {
+	titles := []string{}
+	for _, event := range events {
+		titles = append(titles, event.Title)
+	}
+	return titles
+}
+
+func TestEventsInWindow(t *testing.T) {
+	// a Wednesday
+	now := time.Date(2024, 1, 3, 10, 0, 0, 0, time.UTC)
+	events := []calendarEvent{
+		windowTestEvent("Sunday brunch", time.Date(2024, 1, 7, 11, 0, 0, 0, time.UTC), time.Hour),
+		windowTestEvent("Tomorrow morning", time.Date(2024, 1, 4, 9, 0, 0, 0, time.UTC), time.Hour),
+		windowTestEvent("Ongoing", time.Date(2024, 1, 3, 9, 30, 0, 0, time.UTC), time.Hour),
+		windowTestEvent("Ended", time.Date(2024, 1, 3, 8, 0, 0, 0, time.UTC), time.Hour),
+		windowTestEvent("Next week", time.Date(2024, 1, 8, 9, 0, 0, 0, time.UTC), time.Hour),
+		windowTestEvent("Afternoon", time.Date(2024, 1, 3, 15, 0, 0, 0, time.UTC), time.Hour),
+		{Title: "Holiday", Start: time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC), End: time.Date(2024, 1, 4, 0, 0, 0, 0, time.UTC), AllDay: true},
+	}
+
+	require.Equal(t, []string{"Holiday", "Ongoing", "Afternoon"}, eventTitles(eventsInWindow(events, calendarWindowToday, now)))
+	require.Equal(t, []string{"Holiday", "Ongoing", "Afternoon", "Tomorrow morning"}, eventTitles(eventsInWindow(events, calendarWindow24h, now)))
+	require.Equal(t, []string{"Holiday", "Ongoing", "Afternoon", "Tomorrow morning", "Sunday brunch"}, eventTitles(eventsInWindow(events, calendarWindowWeek, now)))
+}
+
+func TestWindowEnd_Week(t *testing.T) {
+	sunday := time.Date(2024, 1, 7, 10, 0, 0, 0, time.UTC)
+	require.Equal(t, time.Date(2024, 1, 8, 0, 0, 0, 0, time.UTC), windowEnd(calendarWindowWeek, sunday))
+
+	monday := time.Date(2024, 1, 8, 10, 0, 0, 0, time.UTC)
+	require.Equal(t, time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC), windowEnd(calendarWindowWeek, monday))
+}
+
+func TestRelativeTime(t *testing.T) {
+	now := time.Date(2024, 1, 3, 10, 0, 0, 0, time.UTC)
+
+	tests := map[string]calendarEvent{
+		"in 45 minutes":                    windowTestEvent("", now.Add(45*time.Minute), time.Hour),
+		"in 3 hours":                       windowTestEvent("", now.Add(3*time.Hour), time.Hour),
+		"now":                              windowTestEvent("", now, time.Hour),
+		"started 20 minutes ago":           windowTestEvent("", now.Add(-20*time.Minute), time.Hour),
+		"tomorrow at 9:00 AM":              windowTestEvent("", time.Date(2024, 1, 4, 9, 0, 0, 0, time.UTC), time.Hour),
+		"on Friday at 2:30 PM":             windowTestEvent("", time.Date(2024, 1, 5, 14, 30, 0, 0, time.UTC), time.Hour),
+		"on Monday, January 15 at 9:00 AM": windowTestEvent("", time.Date(2024, 1, 15, 9, 0, 0, 0, time.UTC), time.Hour),
+		"all day today":                    {Start: time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC), AllDay: true},
+		"all day tomorrow":                 {Start: time.Date(2024, 1, 4, 0, 0, 0, 0, time.UTC), AllDay: true},
+	}
+	for expected, event := range tests {
+		require.Equal(t, expected, relativeTime(event, now))
+	}
+}
+
+func TestDescribeCalendarEvent(t *testing.T) {
+	now := time.Date(2024, 1, 3, 10, 0, 0, 0, time.UTC)
+	event := windowTestEvent("1:1 with Sam", now.Add(45*time.Minute), 30*time.Minute)
+	event.Location = "Room 2"
+
+	require.Equal(t, "1:1 with Sam, in 45 minutes (Wednesday 10:45 AM - 11:15 AM), at Room 2", describeCalendarEvent(event, now))
+}
+
+func TestWindowedCalendarClient(t *testing.T) {
+	t.Setenv("CALENDAR_WINDOW", calendarWindow24h)
+
+	now := time.Now()
+	calendarClient, err := newWindowedCalendarClient(staticCalendar{
+		windowTestEvent("Later", now.Add(3*time.Hour), time.Hour),
+		windowTestEvent("Soon", now.Add(time.Hour), time.Hour),
+		windowTestEvent("Too late", now.Add(48*time.Hour), time.Hour),
+	})
+	require.NoError(t, err)
+
+	events, err := calendarClient.getEvents()
+	require.NoError(t, err)
+	require.Equal(t, []string{"Soon", "Later"}, eventTitles(events))
+
+	t.Setenv("CALENDAR_WINDOW", "month")
+	_, err = newWindowedCalendarClient(staticCalendar{})
+	require.Error(t, err)
+}
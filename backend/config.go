@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// envDuration parses a duration like "10m" from an env var, returning def if it is unset
+func envDuration(name string, def time.Duration) (time.Duration, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return def, nil
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s is not a valid duration: %w", name, err)
+	}
+	return duration, nil
+}
+
+// envInt parses a whole number from an env var, returning def if it is unset
+func envInt(name string, def int) (int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return def, nil
+	}
+
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s is not a valid number: %w", name, err)
+	}
+	return number, nil
+}
+
+// envList splits a comma-separated env var into its trimmed, non-empty values
+func envList(name string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(name), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// envFloat parses a number from an env var, returning def if it is unset
+func envFloat(name string, def float64) (float64, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return def, nil
+	}
+
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s is not a valid number: %w", name, err)
+	}
+	return number, nil
+}
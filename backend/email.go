@@ -0,0 +1,737 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+type emailClient interface {
+	getUnread() ([]emailMessage, error)
+}
+
+// emailWriter changes messages in the mailbox
+type emailWriter interface {
+	// setSeen marks messages as read, or unread again
+	setSeen(refs []emailRef, seen bool) error
+	// move moves messages to a folder, returning where they ended up
+	move(refs []emailRef, folder string) ([]emailRef, error)
+	archiveFolder() string
+}
+
+// emailLink is a link in the body of a message, with the text it is shown as
+type emailLink struct {
+	Text string
+	URL  string
+}
+
+// emailThreader finds the earlier messages of a thread, including read and sent ones
+type emailThreader interface {
+	// thread returns the earlier messages that a message replies to, oldest first
+	thread(message emailMessage) ([]emailMessage, error)
+}
+
+// emailDrafter saves reply drafts, with the earlier messages of a thread as context
+type emailDrafter interface {
+	emailThreader
+	saveDraft(draft []byte) error
+}
+
+// emailRef identifies a message in the mailbox. UIDs change when a message is
+// moved, so the Message-ID is used to find it again.
+type emailRef struct {
+	Folder    string `json:"folder"`
+	UID       uint32 `json:"uid"`
+	MessageID string `json:"message_id,omitempty"`
+}
+
+// emailMessage is an unread message, with its text cleaned up for summaries
+type emailMessage struct {
+	UID         uint32
+	Folder      string
+	MessageID   string
+	From        string
+	FromName    string
+	FromAddress string
+	To          []string
+	Cc          []string
+	ReplyTo     string
+	Subject     string
+	Date        time.Time
+	Text        string
+	// InReplyTo and References are the Message-IDs of the earlier messages in the thread
+	InReplyTo  string
+	References []string
+	// FromMe is set for messages found in the sent folder
+	FromMe bool
+	// AuthenticationResults is the SPF, DKIM and DMARC verdict added by the receiving server
+	AuthenticationResults string
+	Links                 []emailLink
+	// StructuredData are the schema.org JSON-LD blocks of an HTML message, like reservations
+	StructuredData []string
+	// Bulk is set for mailing lists and newsletters
+	Bulk bool
+	// Automated is set for messages sent by a machine, like receipts and alerts
+	Automated bool
+
+	// Classification is set once the message has been classified
+	Classification *emailClassification
+	// Risk is set once the message has been scored for phishing
+	Risk *emailRisk
+	// Items are the flights, hotel stays and parcels found in the message
+	Items []emailItem
+}
+
+// imapEmail reads unread messages from IMAP folders
+type imapEmail struct {
+	address     string
+	username    string
+	password    string
+	useTLS      bool
+	folders     []string
+	maxMessages int
+	maxChars    int
+	archive     string
+	drafts      string
+	sent        string
+
+	cache *ttlCache[[]emailMessage]
+}
+
+// noEmail is used when no email provider is configured
+type noEmail struct{}
+
+const (
+	emailCacheDuration   = 5 * time.Minute
+	emailCacheMaxStale   = time.Hour
+	defaultEmailMaxCount = 10
+	defaultEmailMaxChars = 2000
+	imapTimeout          = 30 * time.Second
+	imapMaxMessageBytes  = 256 * 1024
+	defaultIMAPPort      = "993"
+	defaultIMAPFolder    = "INBOX"
+	defaultIMAPArchive   = "Archive"
+	defaultIMAPDrafts    = "Drafts"
+	defaultIMAPSent      = "Sent"
+	// emailThreadMaxMessages is how many earlier messages are fetched for a thread's context
+	emailThreadMaxMessages = 5
+	// emailThreadConnections is how many threads fetch their context at once, each over its
+	// own IMAP connection, well below the 10 connections a user gets on Dovecot by default
+	emailThreadConnections = 3
+)
+
+var (
+	// jsonLDPattern matches the schema.org markup that flight, hotel and shipping emails carry
+	jsonLDPattern = regexp.MustCompile(`(?is)<script\b[^>]*\btype\s*=\s*["']application/ld\+json["'][^>]*>(.*?)</script>`)
+	// htmlAnchorPattern matches a link in an HTML body, with its address and text
+	htmlAnchorPattern = regexp.MustCompile(`(?is)<a\b[^>]*\bhref\s*=\s*["']([^"']+)["'][^>]*>(.*?)</a>`)
+	// plainURLPattern matches a bare web address in plain text
+	plainURLPattern = regexp.MustCompile(`https?://[^\s<>"')\]]+`)
+	// emailMessageIDPattern matches the <id> in Message-ID, In-Reply-To and References headers
+	emailMessageIDPattern = regexp.MustCompile(`<([^<>\s]+)>`)
+	// emailReplyHeaderPattern matches the line that introduces a quoted reply, like "On Mon, Jan 1, 2024, Sam wrote:"
+	emailReplyHeaderPattern = regexp.MustCompile(`(?m)^\s*(On .{1,200}wrote:|-{2,} ?Original Message ?-{2,}|_{10,})\s*$`)
+	// emailForwardedHeaderPattern matches the header block Outlook puts above quoted messages
+	emailForwardedHeaderPattern = regexp.MustCompile(`(?m)^\s*From: .+\n\s*(Sent|Date): `)
+	// htmlBlockquotePattern matches quoted messages in HTML replies, which come after the new text
+	htmlBlockquotePattern = regexp.MustCompile(`(?is)<blockquote\b.*</blockquote>`)
+	htmlLineBreakPattern  = regexp.MustCompile(`(?i)<br\s*/?>|</p>|</div>`)
+	// emailNoReplyPattern matches sender addresses that can't be replied to
+	emailNoReplyPattern = regexp.MustCompile(`^(no-?reply|do-?not-?reply|notifications?|mailer-daemon|postmaster)[@+.-]`)
+)
+
+// newEmailClient creates the email client selected by EMAIL_PROVIDER, either "imap"
+// or "none" (the default) when there is no mailbox to read
+func newEmailClient() (emailClient, error) {
+	switch os.Getenv("EMAIL_PROVIDER") {
+	case "", "none":
+		return &noEmail{}, nil
+	case "imap":
+		return newIMAPEmailClient()
+	default:
+		return nil, fmt.Errorf("unknown EMAIL_PROVIDER %q", os.Getenv("EMAIL_PROVIDER"))
+	}
+}
+
+// emailOwnAddresses are the user's addresses from EMAIL_ADDRESSES, or the IMAP username when it is an address
+func emailOwnAddresses() []string {
+	addresses := lowerAll(envList("EMAIL_ADDRESSES"))
+	if len(addresses) == 0 && strings.Contains(os.Getenv("IMAP_USERNAME"), "@") {
+		addresses = []string{strings.ToLower(os.Getenv("IMAP_USERNAME"))}
+	}
+	return addresses
+}
+
+func (e *noEmail) getUnread() ([]emailMessage, error) {
+	return nil, nil
+}
+
+func newIMAPEmailClient() (emailClient, error) {
+	if os.Getenv("IMAP_HOST") == "" {
+		return nil, fmt.Errorf("IMAP_HOST is not set")
+	}
+	if os.Getenv("IMAP_USERNAME") == "" {
+		return nil, fmt.Errorf("IMAP_USERNAME is not set")
+	}
+	if os.Getenv("IMAP_PASSWORD") == "" {
+		return nil, fmt.Errorf("IMAP_PASSWORD is not set")
+	}
+
+	port := os.Getenv("IMAP_PORT")
+	if port == "" {
+		port = defaultIMAPPort
+	}
+
+	// without TLS the connection is upgraded with STARTTLS, unless the server is on the same host
+	useTLS := os.Getenv("IMAP_TLS") != "false"
+
+	folders := envList("IMAP_FOLDERS")
+	if len(folders) == 0 {
+		folders = []string{defaultIMAPFolder}
+	}
+
+	maxMessages, err := envInt("EMAIL_MAX_MESSAGES", defaultEmailMaxCount)
+	if err != nil {
+		return nil, err
+	}
+	if maxMessages <= 0 {
+		return nil, fmt.Errorf("EMAIL_MAX_MESSAGES must be greater than 0")
+	}
+
+	maxChars, err := envInt("EMAIL_MAX_CHARS", defaultEmailMaxChars)
+	if err != nil {
+		return nil, err
+	}
+	if maxChars <= 0 {
+		return nil, fmt.Errorf("EMAIL_MAX_CHARS must be greater than 0")
+	}
+
+	archive := os.Getenv("IMAP_ARCHIVE_FOLDER")
+	if archive == "" {
+		archive = defaultIMAPArchive
+	}
+
+	drafts := os.Getenv("IMAP_DRAFTS_FOLDER")
+	if drafts == "" {
+		drafts = defaultIMAPDrafts
+	}
+
+	sent := os.Getenv("IMAP_SENT_FOLDER")
+	if sent == "" {
+		sent = defaultIMAPSent
+	}
+
+	cache, err := newSourceCache[[]emailMessage]("EMAIL", emailCacheDuration, emailCacheMaxStale)
+	if err != nil {
+		return nil, err
+	}
+
+	return &imapEmail{
+		address:     net.JoinHostPort(os.Getenv("IMAP_HOST"), port),
+		username:    os.Getenv("IMAP_USERNAME"),
+		password:    os.Getenv("IMAP_PASSWORD"),
+		useTLS:      useTLS,
+		folders:     folders,
+		maxMessages: maxMessages,
+		maxChars:    maxChars,
+		archive:     archive,
+		drafts:      drafts,
+		sent:        sent,
+		cache:       cache,
+	}, nil
+}
+
+func (e *imapEmail) getUnread() ([]emailMessage, error) {
+	return e.cache.get("unread", e.fetch)
+}
+
+// connect opens an authenticated IMAP session
+func (e *imapEmail) connect() (*imapConn, error) {
+	conn, err := dialIMAP(e.address, e.useTLS, imapTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	err = conn.login(e.username, e.password)
+	if err != nil {
+		conn.close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// fetch gets the most recent unread messages across all folders
+func (e *imapEmail) fetch() ([]emailMessage, error) {
+	conn, err := e.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.close()
+
+	var messages []emailMessage
+	for _, folder := range e.folders {
+		err = conn.selectFolder(folder, true)
+		if err != nil {
+			return nil, err
+		}
+
+		uids, err := conn.searchUIDs("UNSEEN")
+		if err != nil {
+			return nil, err
+		}
+		// UIDs grow with arrival, so the last ones are the newest
+		if len(uids) > e.maxMessages {
+			uids = uids[len(uids)-e.maxMessages:]
+		}
+
+		raw, err := conn.fetchMessages(uids, imapMaxMessageBytes)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, uid := range uids {
+			data, ok := raw[uid]
+			if !ok {
+				continue
+			}
+			message, err := parseEmail(data, e.maxChars)
+			if err != nil {
+				fmt.Println(fmt.Errorf("cannot parse message %d in %s: %w", uid, folder, err))
+				continue
+			}
+			message.UID = uid
+			message.Folder = folder
+			messages = append(messages, message)
+		}
+	}
+	conn.logout()
+
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].Date.After(messages[j].Date)
+	})
+	if len(messages) > e.maxMessages {
+		messages = messages[:e.maxMessages]
+	}
+	return messages, nil
+}
+
+func (e *imapEmail) archiveFolder() string {
+	return e.archive
+}
+
+// setSeen adds or removes the \Seen flag, folder by folder
+func (e *imapEmail) setSeen(refs []emailRef, seen bool) error {
+	conn, err := e.connect()
+	if err != nil {
+		return err
+	}
+	defer conn.close()
+	// changed messages shouldn't linger in the unread list
+	defer e.cache.invalidate("unread")
+
+	for _, folder := range emailRefFolders(refs) {
+		uids := emailRefUIDs(refs, folder)
+		if len(uids) == 0 {
+			continue
+		}
+		err = conn.selectFolder(folder, false)
+		if err != nil {
+			return err
+		}
+		err = conn.storeFlags(uids, seen, `\Seen`)
+		if err != nil {
+			return err
+		}
+	}
+	conn.logout()
+	return nil
+}
+
+// move moves messages to another folder, then looks them up by Message-ID to
+// find their new UIDs. Messages without a Message-ID are returned with no UID.
+func (e *imapEmail) move(refs []emailRef, folder string) ([]emailRef, error) {
+	conn, err := e.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.close()
+	defer e.cache.invalidate("unread")
+
+	for _, from := range emailRefFolders(refs) {
+		uids := emailRefUIDs(refs, from)
+		if len(uids) == 0 {
+			continue
+		}
+		err = conn.selectFolder(from, false)
+		if err != nil {
+			return nil, err
+		}
+		err = conn.moveMessages(uids, folder)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = conn.selectFolder(folder, true)
+	if err != nil {
+		return nil, err
+	}
+	moved := make([]emailRef, len(refs))
+	for i, ref := range refs {
+		moved[i] = emailRef{Folder: folder, MessageID: ref.MessageID}
+		if ref.MessageID == "" {
+			continue
+		}
+		uids, err := conn.searchUIDs("HEADER Message-ID " + imapQuote(ref.MessageID))
+		if err != nil {
+			return nil, err
+		}
+		if len(uids) > 0 {
+			moved[i].UID = uids[len(uids)-1]
+		}
+	}
+	conn.logout()
+	return moved, nil
+}
+
+// thread searches the mail folders and the sent folder for the messages this one
+// references, so replies can see the conversation and not just the last message
+func (e *imapEmail) thread(message emailMessage) ([]emailMessage, error) {
+	ids := message.References
+	if len(ids) == 0 && message.InReplyTo != "" {
+		ids = []string{message.InReplyTo}
+	}
+	if len(ids) > emailThreadMaxMessages {
+		ids = ids[len(ids)-emailThreadMaxMessages:]
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	conn, err := e.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.close()
+
+	folders := e.folders
+	if !slices.Contains(folders, e.sent) {
+		folders = append(slices.Clone(folders), e.sent)
+	}
+
+	var thread []emailMessage
+	found := map[string]bool{}
+	for _, folder := range folders {
+		err = conn.selectFolder(folder, true)
+		if err != nil {
+			// not every server has a sent folder
+			fmt.Println(fmt.Errorf("cannot open %s for thread: %w", folder, err))
+			continue
+		}
+
+		var uids []uint32
+		for _, id := range ids {
+			if found[id] {
+				continue
+			}
+			matches, err := conn.searchUIDs("HEADER Message-ID " + imapQuote(id))
+			if err != nil {
+				return nil, err
+			}
+			uids = append(uids, matches...)
+		}
+		if len(uids) == 0 {
+			continue
+		}
+
+		raw, err := conn.fetchMessages(uids, imapMaxMessageBytes)
+		if err != nil {
+			return nil, err
+		}
+		for _, uid := range uids {
+			data, ok := raw[uid]
+			if !ok {
+				continue
+			}
+			earlier, err := parseEmail(data, e.maxChars)
+			if err != nil || found[earlier.MessageID] {
+				continue
+			}
+			earlier.UID = uid
+			earlier.Folder = folder
+			earlier.FromMe = folder == e.sent
+			found[earlier.MessageID] = true
+			thread = append(thread, earlier)
+		}
+	}
+	conn.logout()
+
+	sort.SliceStable(thread, func(i, j int) bool {
+		return thread[i].Date.Before(thread[j].Date)
+	})
+	return thread, nil
+}
+
+// saveDraft adds a message to the drafts folder, where the mail client can review and send it
+func (e *imapEmail) saveDraft(draft []byte) error {
+	conn, err := e.connect()
+	if err != nil {
+		return err
+	}
+	defer conn.close()
+
+	err = conn.appendMessage(e.drafts, draft, `\Draft`, `\Seen`)
+	if err != nil {
+		return err
+	}
+	conn.logout()
+	return nil
+}
+
+// emailRefFolders lists the folders of the messages, in order of first appearance
+func emailRefFolders(refs []emailRef) []string {
+	var folders []string
+	for _, ref := range refs {
+		if !slices.Contains(folders, ref.Folder) {
+			folders = append(folders, ref.Folder)
+		}
+	}
+	return folders
+}
+
+// emailRefUIDs lists the UIDs of the messages in a folder
+func emailRefUIDs(refs []emailRef, folder string) []uint32 {
+	var uids []uint32
+	for _, ref := range refs {
+		if ref.Folder == folder && ref.UID != 0 {
+			uids = append(uids, ref.UID)
+		}
+	}
+	return uids
+}
+
+// parseEmail reads the headers and the text of a message, preferring the
+// plain text part, and drops quoted replies and signatures
+func parseEmail(data []byte, maxChars int) (emailMessage, error) {
+	message, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		return emailMessage{}, fmt.Errorf("cannot read message: %w", err)
+	}
+
+	decoder := &mime.WordDecoder{CharsetReader: charsetReader}
+	decodeHeader := func(name string) string {
+		value, err := decoder.DecodeHeader(message.Header.Get(name))
+		if err != nil {
+			return message.Header.Get(name)
+		}
+		return strings.TrimSpace(value)
+	}
+
+	addressParser := &mail.AddressParser{WordDecoder: decoder}
+	from, fromName, fromAddress := decodeHeader("From"), "", ""
+	if address, err := addressParser.Parse(message.Header.Get("From")); err == nil {
+		from, fromName, fromAddress = address.Address, address.Name, strings.ToLower(address.Address)
+		if address.Name != "" {
+			from = address.Name + " <" + address.Address + ">"
+		}
+	}
+	addresses := func(name string) []string {
+		list, _ := addressParser.ParseList(message.Header.Get(name))
+		var parsed []string
+		for _, address := range list {
+			parsed = append(parsed, strings.ToLower(address.Address))
+		}
+		return parsed
+	}
+
+	precedence := strings.ToLower(message.Header.Get("Precedence"))
+	bulk := message.Header.Get("List-Id") != "" || message.Header.Get("List-Unsubscribe") != "" || precedence == "bulk" || precedence == "list"
+	autoSubmitted := strings.ToLower(message.Header.Get("Auto-Submitted"))
+	automated := (autoSubmitted != "" && autoSubmitted != "no") || emailNoReplyPattern.MatchString(fromAddress)
+
+	date, _ := message.Header.Date()
+
+	replyTo := ""
+	if address, err := addressParser.Parse(message.Header.Get("Reply-To")); err == nil {
+		replyTo = strings.ToLower(address.Address)
+	}
+	inReplyTo := emailMessageIDs(message.Header.Get("In-Reply-To"))
+
+	plain, htmlText := emailBodies(message.Header.Get("Content-Type"), message.Header.Get("Content-Transfer-Encoding"), message.Body)
+	text := cleanEmailText(plain)
+	if text == "" && htmlText != "" {
+		text = cleanEmailText(htmlToEmailText(htmlText))
+	}
+
+	return emailMessage{
+		MessageID:   strings.Trim(strings.TrimSpace(message.Header.Get("Message-Id")), "<>"),
+		From:        from,
+		FromName:    fromName,
+		FromAddress: fromAddress,
+		To:          addresses("To"),
+		Cc:          addresses("Cc"),
+		ReplyTo:     replyTo,
+		Subject:     decodeHeader("Subject"),
+		Date:        date,
+		Text:        truncateText(text, maxChars),
+		InReplyTo:   strings.Join(inReplyTo, " "),
+		References:  emailMessageIDs(message.Header.Get("References")),
+		// servers add their verdict on top, so the first header is the one to trust
+		AuthenticationResults: message.Header.Get("Authentication-Results"),
+		Links:                 emailLinks(plain, htmlText),
+		StructuredData:        emailStructuredData(htmlText),
+		Bulk:                  bulk,
+		Automated:             automated,
+	}, nil
+}
+
+// emailMessageIDs lists the Message-IDs in a header, without their angle brackets
+func emailMessageIDs(header string) []string {
+	var ids []string
+	for _, match := range emailMessageIDPattern.FindAllStringSubmatch(header, -1) {
+		ids = append(ids, match[1])
+	}
+	return ids
+}
+
+// emailLinks lists the links in the HTML body, with their text, and the bare URLs in the plain text
+func emailLinks(plain, htmlText string) []emailLink {
+	var links []emailLink
+	for _, match := range htmlAnchorPattern.FindAllStringSubmatch(htmlText, -1) {
+		links = append(links, emailLink{Text: stripHTML(match[2]), URL: html.UnescapeString(match[1])})
+	}
+	if len(links) == 0 {
+		for _, url := range plainURLPattern.FindAllString(plain, -1) {
+			// punctuation after a URL ends the sentence
+			url = strings.TrimRight(url, ".,;:!?")
+			links = append(links, emailLink{Text: url, URL: url})
+		}
+	}
+	return links
+}
+
+// emailStructuredData returns the JSON-LD blocks in the HTML body
+func emailStructuredData(htmlText string) []string {
+	var blocks []string
+	for _, match := range jsonLDPattern.FindAllStringSubmatch(htmlText, -1) {
+		if block := strings.TrimSpace(match[1]); block != "" {
+			blocks = append(blocks, block)
+		}
+	}
+	return blocks
+}
+
+// emailBodies finds the first text/plain and text/html parts of a message
+// body, descending into multipart bodies. Truncated messages give what was read.
+func emailBodies(contentType, encoding string, body io.Reader) (string, string) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = "text/plain"
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		var plain, htmlText string
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextRawPart()
+			if err != nil {
+				break
+			}
+			// attachments are not part of the text
+			if disposition, _, _ := mime.ParseMediaType(part.Header.Get("Content-Disposition")); disposition == "attachment" {
+				continue
+			}
+			partPlain, partHTML := emailBodies(part.Header.Get("Content-Type"), part.Header.Get("Content-Transfer-Encoding"), part)
+			if plain == "" {
+				plain = partPlain
+			}
+			if htmlText == "" {
+				htmlText = partHTML
+			}
+		}
+		return plain, htmlText
+	}
+
+	if mediaType != "text/plain" && mediaType != "text/html" {
+		return "", ""
+	}
+
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	}
+	data, _ := io.ReadAll(body)
+
+	text := decodeCharset(data, params["charset"])
+	if mediaType == "text/html" {
+		return "", text
+	}
+	return text, ""
+}
+
+// htmlToEmailText turns an HTML body into plain text, dropping quoted messages
+func htmlToEmailText(body string) string {
+	body = htmlCommentPattern.ReplaceAllString(body, "")
+	body = htmlBlockquotePattern.ReplaceAllString(body, "")
+	for _, pattern := range htmlBoilerplatePatterns {
+		body = pattern.ReplaceAllString(body, "")
+	}
+	// keep line breaks, so replies can still be told apart from the new text
+	body = htmlLineBreakPattern.ReplaceAllString(body, "\n")
+
+	var lines []string
+	for _, line := range strings.Split(body, "\n") {
+		lines = append(lines, stripHTML(line))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// cutQuotedEmail drops the text from the first match of pattern on, unless there is nothing but
+// separator lines above it, like in a forwarded message, where the quoted message is the content
+func cutQuotedEmail(text string, pattern *regexp.Regexp) string {
+	loc := pattern.FindStringIndex(text)
+	if loc == nil || strings.Trim(text[:loc[0]], " \t\n-_") == "" {
+		return text
+	}
+	return text[:loc[0]]
+}
+
+// cleanEmailText drops quoted replies, forwarded headers and the signature, and collapses blank lines
+func cleanEmailText(text string) string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+
+	text = cutQuotedEmail(text, emailReplyHeaderPattern)
+	text = cutQuotedEmail(text, emailForwardedHeaderPattern)
+
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		// the signature delimiter is "-- " on its own line
+		if line == "-- " || line == "--" {
+			break
+		}
+		if strings.HasPrefix(strings.TrimSpace(line), ">") {
+			continue
+		}
+		line = strings.TrimSpace(line)
+		if line == "" && (len(lines) == 0 || lines[len(lines)-1] == "") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
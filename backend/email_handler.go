@@ -0,0 +1,274 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// emailActionRequest is the body of POST /emails/actions
+type emailActionRequest struct {
+	Action   string     `json:"action"`
+	Messages []emailRef `json:"messages"`
+}
+
+// emailDismissRequest is the optional body of POST /emails/dismiss
+type emailDismissRequest struct {
+	// Action is mark_read (the default) or archive
+	Action string `json:"action,omitempty"`
+}
+
+// postEmailAction marks the given messages as read or archives them
+func postEmailAction(w http.ResponseWriter, r *http.Request, email emailClient, writer emailWriter, log *emailActionLog) error {
+	var request emailActionRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		writeHttpError(w, http.StatusBadRequest, "cannot parse request", err)
+		return nil
+	}
+	if request.Action != emailActionMarkRead && request.Action != emailActionArchive {
+		writeHttpError(w, http.StatusBadRequest, "action must be mark_read or archive", fmt.Errorf("unknown action %q", request.Action))
+		return nil
+	}
+	if len(request.Messages) == 0 {
+		writeHttpError(w, http.StatusBadRequest, "messages are required", fmt.Errorf("no messages"))
+		return nil
+	}
+	if writer == nil {
+		writeHttpError(w, http.StatusNotImplemented, "email is read-only", fmt.Errorf("no email provider can change messages"))
+		return nil
+	}
+
+	// unread messages have the Message-ID needed to find archived messages again,
+	// and the sender and subject for the audit log
+	unread, err := email.getUnread()
+	if err != nil {
+		return fmt.Errorf("cannot get unread emails: %w", err)
+	}
+	messages := make([]emailActionMessage, len(request.Messages))
+	for i, ref := range request.Messages {
+		messages[i] = emailActionMessage{emailRef: ref}
+		for _, message := range unread {
+			if message.Folder == ref.Folder && message.UID == ref.UID {
+				messages[i] = newEmailActionMessage(message)
+				break
+			}
+		}
+	}
+
+	return performEmailAction(w, writer, log, request.Action, messages, false)
+}
+
+// postEmailDismiss marks every unread message that doesn't need a response as read, or archives them
+func postEmailDismiss(w http.ResponseWriter, r *http.Request, email emailClient, writer emailWriter, log *emailActionLog) error {
+	request := emailDismissRequest{Action: emailActionMarkRead}
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil && !errors.Is(err, io.EOF) {
+		writeHttpError(w, http.StatusBadRequest, "cannot parse request", err)
+		return nil
+	}
+	if request.Action != emailActionMarkRead && request.Action != emailActionArchive {
+		writeHttpError(w, http.StatusBadRequest, "action must be mark_read or archive", fmt.Errorf("unknown action %q", request.Action))
+		return nil
+	}
+	if writer == nil {
+		writeHttpError(w, http.StatusNotImplemented, "email is read-only", fmt.Errorf("no email provider can change messages"))
+		return nil
+	}
+
+	unread, err := email.getUnread()
+	if err != nil {
+		return fmt.Errorf("cannot get unread emails: %w", err)
+	}
+	// unclassified messages are left alone, they might need a response
+	messages := []emailActionMessage{}
+	for _, message := range unread {
+		if message.Classification != nil && !message.Classification.NeedsResponse {
+			messages = append(messages, newEmailActionMessage(message))
+		}
+	}
+	if len(messages) == 0 {
+		return writeEmailActionResponse(w, http.StatusOK, emailAction{Action: request.Action, Bulk: true, Messages: messages})
+	}
+
+	return performEmailAction(w, writer, log, request.Action, messages, true)
+}
+
+// postEmailUndo reverts an action while its undo window is open. When some archived
+// messages can't be moved back, it responds with a conflict listing them.
+func postEmailUndo(w http.ResponseWriter, r *http.Request, writer emailWriter, log *emailActionLog) error {
+	if writer == nil {
+		writeHttpError(w, http.StatusNotImplemented, "email is read-only", fmt.Errorf("no email provider can change messages"))
+		return nil
+	}
+
+	now := time.Now()
+	action, err := log.takeUndoable(r.PathValue("id"), now)
+	if errors.Is(err, errEmailActionNotFound) {
+		writeHttpError(w, http.StatusNotFound, "action not found", err)
+		return nil
+	}
+	if errors.Is(err, errEmailUndoExpired) {
+		writeHttpError(w, http.StatusGone, "action can no longer be undone", err)
+		return nil
+	}
+
+	unrestored, err := undoEmailAction(writer, action)
+	if err != nil {
+		log.restore(action)
+		return err
+	}
+
+	id, err := newEmailActionID()
+	if err != nil {
+		return err
+	}
+	undo := emailAction{
+		ID:         id,
+		Action:     emailActionUndo,
+		Bulk:       action.Bulk,
+		Messages:   action.Messages,
+		Time:       now,
+		Undoes:     action.ID,
+		Unrestored: unrestored,
+	}
+	err = log.record(undo)
+	if err != nil {
+		fmt.Println(fmt.Errorf("cannot record email undo: %w", err))
+	}
+	if len(unrestored) > 0 {
+		return writeEmailActionResponse(w, http.StatusConflict, undo)
+	}
+	return writeEmailActionResponse(w, http.StatusOK, undo)
+}
+
+// performEmailAction changes the messages and records the action, responding with it
+func performEmailAction(w http.ResponseWriter, writer emailWriter, log *emailActionLog, action string, messages []emailActionMessage, bulk bool) error {
+	result, err := applyEmailAction(writer, action, messages)
+	if err != nil {
+		return err
+	}
+
+	result.ID, err = newEmailActionID()
+	if err != nil {
+		return err
+	}
+	result.Bulk = bulk
+	result.Time = time.Now()
+	result.UndoUntil = result.Time.Add(log.undoWindow)
+
+	// the mailbox has already changed, so a failure to record it is only logged
+	err = log.record(result)
+	if err != nil {
+		fmt.Println(fmt.Errorf("cannot record email action: %w", err))
+	}
+	return writeEmailActionResponse(w, http.StatusOK, result)
+}
+
+func newEmailActionMessage(message emailMessage) emailActionMessage {
+	return emailActionMessage{
+		emailRef: emailRef{Folder: message.Folder, UID: message.UID, MessageID: message.MessageID},
+		From:     message.From,
+		Subject:  message.Subject,
+	}
+}
+
+func writeEmailActionResponse(w http.ResponseWriter, status int, action emailAction) error {
+	actionJson, err := json.Marshal(action)
+	if err != nil {
+		return fmt.Errorf("cannot marshal action to json: %w", err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(actionJson)
+
+	return nil
+}
+
+// emailDraftResponse is the reply saved to the drafts folder
+type emailDraftResponse struct {
+	Draft emailDraft `json:"draft"`
+	Saved bool       `json:"saved"`
+}
+
+// postEmailDraft writes a reply to an unread message that needs a response with the local
+// model and saves it to the drafts folder, so it can be reviewed and sent from the mail client
+func postEmailDraft(w http.ResponseWriter, r *http.Request, o openWebUIClient, email emailClient, drafter emailDrafter, settings emailDraftSettings, log *emailActionLog) error {
+	var ref emailRef
+	err := json.NewDecoder(r.Body).Decode(&ref)
+	if err != nil {
+		writeHttpError(w, http.StatusBadRequest, "cannot parse request", err)
+		return nil
+	}
+	if drafter == nil {
+		writeHttpError(w, http.StatusNotImplemented, "email is read-only", fmt.Errorf("no email provider can save drafts"))
+		return nil
+	}
+
+	unread, err := email.getUnread()
+	if err != nil {
+		return fmt.Errorf("cannot get unread emails: %w", err)
+	}
+	index := slices.IndexFunc(unread, func(message emailMessage) bool {
+		return message.Folder == ref.Folder && message.UID == ref.UID
+	})
+	if index < 0 {
+		writeHttpError(w, http.StatusNotFound, "message not found", fmt.Errorf("no unread message %d in %s", ref.UID, ref.Folder))
+		return nil
+	}
+	message := unread[index]
+	if message.Risk != nil && message.Risk.Risky {
+		writeHttpError(w, http.StatusConflict, "message looks like phishing", fmt.Errorf("%s", strings.Join(message.Risk.Reasons, "; ")))
+		return nil
+	}
+	if message.Classification == nil || !message.Classification.NeedsResponse {
+		writeHttpError(w, http.StatusConflict, "message doesn't need a response", fmt.Errorf("%q isn't classified as needing a response", message.Subject))
+		return nil
+	}
+
+	// the reply can still be written from the message alone
+	thread, err := drafter.thread(message)
+	if err != nil {
+		fmt.Println(fmt.Errorf("cannot get thread of %q: %w", message.Subject, err))
+	}
+
+	draft, err := draftEmailReply(o, message, thread, settings)
+	if err != nil {
+		writeHttpError(w, http.StatusBadGateway, "cannot write a reply", err)
+		return nil
+	}
+
+	id, err := newEmailActionID()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	data, err := formatEmailDraft(draft, message, id+"@assistant", now)
+	if err != nil {
+		return err
+	}
+	err = drafter.saveDraft(data)
+	if err != nil {
+		return fmt.Errorf("cannot save draft: %w", err)
+	}
+
+	err = log.record(emailAction{ID: id, Action: emailActionDraft, Messages: []emailActionMessage{newEmailActionMessage(message)}, Time: now})
+	if err != nil {
+		fmt.Println(fmt.Errorf("cannot record email draft: %w", err))
+	}
+
+	responseJson, err := json.Marshal(emailDraftResponse{Draft: draft, Saved: true})
+	if err != nil {
+		return fmt.Errorf("cannot marshal draft to json: %w", err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(responseJson)
+
+	return nil
+}
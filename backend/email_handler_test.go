@@ -0,0 +1,282 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type mockEmailWriter struct {
+	setSeenArgs  [][]emailRef
+	setSeenFlags []bool
+	moveArgs     [][]emailRef
+	moveFolders  []string
+}
+
+func (m *mockEmailWriter) setSeen(refs []emailRef, seen bool) error {
+	m.setSeenArgs = append(m.setSeenArgs, refs)
+	m.setSeenFlags = append(m.setSeenFlags, seen)
+	return nil
+}
+
+// move pretends the messages got new UIDs in the destination folder, messages
+// without a Message-ID can't be found there
+func (m *mockEmailWriter) move(refs []emailRef, folder string) ([]emailRef, error) {
+	m.moveArgs = append(m.moveArgs, refs)
+	m.moveFolders = append(m.moveFolders, folder)
+	moved := make([]emailRef, len(refs))
+	for i, ref := range refs {
+		moved[i] = emailRef{Folder: folder, MessageID: ref.MessageID}
+		if ref.MessageID != "" {
+			moved[i].UID = ref.UID + 100
+		}
+	}
+	return moved, nil
+}
+
+func (m *mockEmailWriter) archiveFolder() string {
+	return "Archive"
+}
+
+func newTestEmailActionLog(t *testing.T) *emailActionLog {
+	t.Setenv("EMAIL_AUDIT_PATH", filepath.Join(t.TempDir(), "audit.jsonl"))
+	log, err := newEmailActionLog()
+	require.NoError(t, err)
+	return log
+}
+
+func testUnreadEmails() staticEmail {
+	return staticEmail{
+		{UID: 1, Folder: "INBOX", MessageID: "question@test", Subject: "Question", From: "Sam <sam@example.com>", Classification: &emailClassification{Category: emailCategoryNeedsReply, NeedsResponse: true}},
+		{UID: 2, Folder: "INBOX", MessageID: "news@test", Subject: "Weekly news", Classification: &emailClassification{Category: emailCategoryNewsletter}},
+		{UID: 4, Folder: "Work", MessageID: "build@test", Subject: "Build passed", Classification: &emailClassification{Category: emailCategoryNotification}},
+		{UID: 5, Folder: "INBOX", MessageID: "unknown@test", Subject: "Unclassified"},
+	}
+}
+
+func decodeEmailAction(t *testing.T, recorder *httptest.ResponseRecorder) emailAction {
+	var action emailAction
+	err := json.NewDecoder(recorder.Body).Decode(&action)
+	require.NoError(t, err)
+	return action
+}
+
+func TestPostEmailAction_MarkReadAndUndo(t *testing.T) {
+	writer := &mockEmailWriter{}
+	log := newTestEmailActionLog(t)
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/emails/actions", strings.NewReader(`{"action":"mark_read","messages":[{"folder":"INBOX","uid":1}]}`))
+	err := postEmailAction(recorder, req, testUnreadEmails(), writer, log)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, recorder.Code)
+
+	action := decodeEmailAction(t, recorder)
+	require.NotEmpty(t, action.ID)
+	require.Equal(t, emailActionMarkRead, action.Action)
+	require.True(t, action.UndoUntil.After(action.Time))
+	require.Equal(t, []emailRef{{Folder: "INBOX", UID: 1, MessageID: "question@test"}}, writer.setSeenArgs[0])
+	require.Equal(t, []bool{true}, writer.setSeenFlags)
+	// the audit log has the subject so the message can be recognized
+	require.Equal(t, "Question", action.Messages[0].Subject)
+
+	recorder = httptest.NewRecorder()
+	req = httptest.NewRequest("POST", "/emails/actions/"+action.ID+"/undo", nil)
+	req.SetPathValue("id", action.ID)
+	err = postEmailUndo(recorder, req, writer, log)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, recorder.Code)
+	undo := decodeEmailAction(t, recorder)
+	require.Equal(t, emailActionUndo, undo.Action)
+	require.Equal(t, action.ID, undo.Undoes)
+	require.Equal(t, []bool{true, false}, writer.setSeenFlags)
+
+	// an action can only be undone once
+	recorder = httptest.NewRecorder()
+	err = postEmailUndo(recorder, req, writer, log)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNotFound, recorder.Code)
+
+	audit, err := os.ReadFile(log.path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(audit)), "\n")
+	require.Len(t, lines, 2)
+	require.Contains(t, lines[0], `"action":"mark_read"`)
+	require.Contains(t, lines[1], `"undoes":"`+action.ID+`"`)
+}
+
+func TestPostEmailDismiss_ArchiveAndUndo(t *testing.T) {
+	writer := &mockEmailWriter{}
+	log := newTestEmailActionLog(t)
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/emails/dismiss", strings.NewReader(`{"action":"archive"}`))
+	err := postEmailDismiss(recorder, req, testUnreadEmails(), writer, log)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, recorder.Code)
+
+	// only classified messages that don't need a response are archived
+	action := decodeEmailAction(t, recorder)
+	require.True(t, action.Bulk)
+	require.Len(t, action.Messages, 2)
+	require.Equal(t, "Weekly news", action.Messages[0].Subject)
+	require.Equal(t, "Build passed", action.Messages[1].Subject)
+	require.Equal(t, []string{"Archive"}, writer.moveFolders)
+	require.Equal(t, uint32(102), action.Archived[0].UID)
+
+	req = httptest.NewRequest("POST", "/emails/actions/"+action.ID+"/undo", nil)
+	req.SetPathValue("id", action.ID)
+	err = postEmailUndo(httptest.NewRecorder(), req, writer, log)
+	require.NoError(t, err)
+
+	// archived messages go back to the folders they came from
+	require.Equal(t, []string{"Archive", "INBOX", "Work"}, writer.moveFolders)
+	require.Equal(t, []emailRef{{Folder: "Archive", UID: 102, MessageID: "news@test"}}, writer.moveArgs[1])
+	require.Equal(t, []emailRef{{Folder: "Archive", UID: 104, MessageID: "build@test"}}, writer.moveArgs[2])
+}
+
+func TestPostEmailUndo_Unrestored(t *testing.T) {
+	writer := &mockEmailWriter{}
+	log := newTestEmailActionLog(t)
+
+	// the second message isn't unread, so its Message-ID is unknown
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/emails/actions", strings.NewReader(`{"action":"archive","messages":[{"folder":"INBOX","uid":1},{"folder":"INBOX","uid":9}]}`))
+	err := postEmailAction(recorder, req, testUnreadEmails(), writer, log)
+	require.NoError(t, err)
+	action := decodeEmailAction(t, recorder)
+
+	recorder = httptest.NewRecorder()
+	req = httptest.NewRequest("POST", "/emails/actions/"+action.ID+"/undo", nil)
+	req.SetPathValue("id", action.ID)
+	err = postEmailUndo(recorder, req, writer, log)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusConflict, recorder.Code)
+	undo := decodeEmailAction(t, recorder)
+	require.Equal(t, []emailRef{{Folder: "INBOX", UID: 9}}, undo.Unrestored)
+	require.Equal(t, []emailRef{{Folder: "Archive", UID: 101, MessageID: "question@test"}}, writer.moveArgs[1])
+
+	// the action can't be undone twice
+	recorder = httptest.NewRecorder()
+	err = postEmailUndo(recorder, req, writer, log)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNotFound, recorder.Code)
+}
+
+func TestPostEmailDismiss_DefaultsToMarkRead(t *testing.T) {
+	writer := &mockEmailWriter{}
+	recorder := httptest.NewRecorder()
+	err := postEmailDismiss(recorder, httptest.NewRequest("POST", "/emails/dismiss", nil), testUnreadEmails(), writer, newTestEmailActionLog(t))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, recorder.Code)
+	require.Len(t, writer.setSeenArgs, 1)
+	require.Len(t, writer.setSeenArgs[0], 2)
+}
+
+func TestEmailActions_Errors(t *testing.T) {
+	log := newTestEmailActionLog(t)
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/emails/actions", strings.NewReader(`{"action":"delete","messages":[{"folder":"INBOX","uid":1}]}`))
+	err := postEmailAction(recorder, req, testUnreadEmails(), &mockEmailWriter{}, log)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	req = httptest.NewRequest("POST", "/emails/actions", strings.NewReader(`{"action":"archive","messages":[]}`))
+	err = postEmailAction(recorder, req, testUnreadEmails(), &mockEmailWriter{}, log)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	err = postEmailDismiss(recorder, httptest.NewRequest("POST", "/emails/dismiss", nil), testUnreadEmails(), nil, log)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNotImplemented, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	req = httptest.NewRequest("POST", "/emails/actions/missing/undo", nil)
+	req.SetPathValue("id", "missing")
+	err = postEmailUndo(recorder, req, &mockEmailWriter{}, log)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNotFound, recorder.Code)
+}
+
+type mockEmailDrafter struct {
+	threadReturns []emailMessage
+	saveDraftArgs [][]byte
+}
+
+func (m *mockEmailDrafter) thread(message emailMessage) ([]emailMessage, error) {
+	return m.threadReturns, nil
+}
+
+func (m *mockEmailDrafter) saveDraft(draft []byte) error {
+	m.saveDraftArgs = append(m.saveDraftArgs, draft)
+	return nil
+}
+
+func TestPostEmailDraft(t *testing.T) {
+	mockOpenWebUIClient := &mockOpenWebUIClient{generateReturns: "Happy to help, [details]."}
+	drafter := &mockEmailDrafter{}
+	log := newTestEmailActionLog(t)
+	settings := emailDraftSettings{style: "brief", from: "me@example.com"}
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/emails/drafts", strings.NewReader(`{"folder":"INBOX","uid":1}`))
+	err := postEmailDraft(recorder, req, mockOpenWebUIClient, testUnreadEmails(), drafter, settings, log)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, recorder.Code)
+
+	var response emailDraftResponse
+	err = json.NewDecoder(recorder.Body).Decode(&response)
+	require.NoError(t, err)
+	require.True(t, response.Saved)
+	require.Equal(t, "Re: Question", response.Draft.Subject)
+	require.Equal(t, "question@test", response.Draft.InReplyTo)
+	require.Len(t, drafter.saveDraftArgs, 1)
+	require.Contains(t, string(drafter.saveDraftArgs[0]), "Happy to help, [details].")
+
+	// drafts are recorded in the audit log
+	audit, err := os.ReadFile(log.path)
+	require.NoError(t, err)
+	require.Contains(t, string(audit), `"action":"draft"`)
+
+	// only unread messages can be replied to
+	recorder = httptest.NewRecorder()
+	req = httptest.NewRequest("POST", "/emails/drafts", strings.NewReader(`{"folder":"INBOX","uid":9}`))
+	err = postEmailDraft(recorder, req, mockOpenWebUIClient, testUnreadEmails(), drafter, settings, log)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNotFound, recorder.Code)
+
+	// only messages that need a response get a reply
+	for _, uid := range []string{"2", "5"} {
+		recorder = httptest.NewRecorder()
+		req = httptest.NewRequest("POST", "/emails/drafts", strings.NewReader(`{"folder":"INBOX","uid":`+uid+`}`))
+		err = postEmailDraft(recorder, req, mockOpenWebUIClient, testUnreadEmails(), drafter, settings, log)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusConflict, recorder.Code, uid)
+	}
+	require.Len(t, drafter.saveDraftArgs, 1)
+
+	// no replies to phishing
+	risky := testUnreadEmails()
+	risky[0].Risk = &emailRisk{Risky: true, Reasons: []string{"the DMARC check failed"}}
+	recorder = httptest.NewRecorder()
+	req = httptest.NewRequest("POST", "/emails/drafts", strings.NewReader(`{"folder":"INBOX","uid":1}`))
+	err = postEmailDraft(recorder, req, mockOpenWebUIClient, risky, drafter, settings, log)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusConflict, recorder.Code)
+	require.Len(t, drafter.saveDraftArgs, 1)
+
+	recorder = httptest.NewRecorder()
+	req = httptest.NewRequest("POST", "/emails/drafts", strings.NewReader(`{"folder":"INBOX","uid":1}`))
+	err = postEmailDraft(recorder, req, mockOpenWebUIClient, testUnreadEmails(), nil, settings, log)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNotImplemented, recorder.Code)
+}
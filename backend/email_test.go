@@ -0,0 +1,154 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseEmail_MultipartPrefersPlainText(t *testing.T) {
+	raw := "Message-ID: <abc@test>\r\n" +
+		"From: =?UTF-8?Q?Ren=C3=A9e?= <renee@example.com>\r\n" +
+		"Subject: =?UTF-8?B?Q2Fmw6kgbWVldGluZw==?=\r\n" +
+		"Date: Mon, 1 Jan 2024 10:00:00 +0100\r\n" +
+		"Content-Type: multipart/alternative; boundary=\"b1\"\r\n" +
+		"\r\n" +
+		"--b1\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"Content-Transfer-Encoding: quoted-printable\r\n" +
+		"\r\n" +
+		"Shall we meet at the caf=C3=A9 at 3?\r\n" +
+		"\r\n" +
+		"-- \r\n" +
+		"Ren=C3=A9e\r\n" +
+		"--b1\r\n" +
+		"Content-Type: text/html; charset=utf-8\r\n" +
+		"\r\n" +
+		"<p>Shall we meet at the caf&eacute; at 3?</p>\r\n" +
+		"--b1--\r\n"
+
+	message, err := parseEmail([]byte(raw), 2000)
+	require.NoError(t, err)
+	require.Equal(t, "abc@test", message.MessageID)
+	require.Equal(t, "Renée <renee@example.com>", message.From)
+	require.Equal(t, "Café meeting", message.Subject)
+	require.Equal(t, 2024, message.Date.Year())
+	require.Equal(t, "Shall we meet at the café at 3?", message.Text)
+}
+
+func TestParseEmail_HTMLOnly(t *testing.T) {
+	raw := "Subject: Newsletter\r\n" +
+		"Content-Type: text/html; charset=iso-8859-1\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"\r\n" +
+		// "<style>p{}</style><p>Caf\xe9 news</p><blockquote>old</blockquote>"
+		"PHN0eWxlPnB7fTwvc3R5bGU+PHA+Q2Fm6SBuZXdzPC9wPjxibG9ja3F1b3RlPm9sZDwvYmxv\r\n" +
+		"Y2txdW90ZT4=\r\n"
+
+	message, err := parseEmail([]byte(raw), 2000)
+	require.NoError(t, err)
+	require.Equal(t, "Café news", message.Text)
+}
+
+func TestParseEmail_SkipsAttachmentsAndTruncates(t *testing.T) {
+	raw := "Subject: Report\r\n" +
+		"Content-Type: multipart/mixed; boundary=b2\r\n" +
+		"\r\n" +
+		"--b2\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"Content-Disposition: attachment; filename=notes.txt\r\n" +
+		"\r\n" +
+		"attached notes\r\n" +
+		"--b2\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"The quarterly report is attached, please review it before Friday.\r\n" +
+		"--b2--\r\n"
+
+	message, err := parseEmail([]byte(raw), 20)
+	require.NoError(t, err)
+	require.Equal(t, truncateText("The quarterly report is attached, please review it before Friday.", 20), message.Text)
+}
+
+func TestCleanEmailText(t *testing.T) {
+	tests := map[string]string{
+		"Sounds good.\n\nOn Tue, Jan 2, 2024 at 10:00 AM Sam <sam@example.com> wrote:\n> Lunch?":   "Sounds good.",
+		"Thanks!\n\n-----Original Message-----\nFrom: Sam":                                         "Thanks!",
+		"See below.\n\nFrom: Sam Smith\nSent: Tuesday, January 2, 2024\nSubject: Lunch":            "See below.",
+		"From: Sam Smith\nSent: Tuesday, January 2, 2024\nSubject: Lunch\n\nNoon works.":           "From: Sam Smith\nSent: Tuesday, January 2, 2024\nSubject: Lunch\n\nNoon works.",
+		"________________________________\nFrom: Sam Smith\nSent: Tuesday\n\nNoon works.":          "________________________________\nFrom: Sam Smith\nSent: Tuesday\n\nNoon works.",
+		"Inline reply:\n> your question\nmy answer\n\n\n\nBest,\nAlex\n-- \nAlex Smith\nACME Corp": "Inline reply:\nmy answer\n\nBest,\nAlex",
+	}
+	for text, expected := range tests {
+		require.Equal(t, expected, cleanEmailText(text))
+	}
+}
+
+func TestParseEmail_RecipientsAndBulkHeaders(t *testing.T) {
+	raw := "From: Shop <No-Reply@Shop.example>\r\n" +
+		"To: Me <me@example.com>, team@example.com\r\n" +
+		"Cc: boss@example.com\r\n" +
+		"List-Unsubscribe: <mailto:unsubscribe@shop.example>\r\n" +
+		"Subject: Sale\r\n" +
+		"\r\n" +
+		"Everything must go.\r\n"
+
+	message, err := parseEmail([]byte(raw), 2000)
+	require.NoError(t, err)
+	require.Equal(t, "no-reply@shop.example", message.FromAddress)
+	require.Equal(t, []string{"me@example.com", "team@example.com"}, message.To)
+	require.Equal(t, []string{"boss@example.com"}, message.Cc)
+	require.True(t, message.Bulk)
+	require.True(t, message.Automated)
+}
+
+func TestParseEmail_ThreadHeaders(t *testing.T) {
+	raw := "From: Sam <sam@example.com>\r\n" +
+		"Reply-To: Team <Team@example.com>\r\n" +
+		"Subject: Re: Plans\r\n" +
+		"Message-ID: <reply@test>\r\n" +
+		"In-Reply-To: <mine@test>\r\n" +
+		"References: <start@test>\r\n <mine@test>\r\n" +
+		"\r\n" +
+		"Friday?\r\n"
+
+	message, err := parseEmail([]byte(raw), 2000)
+	require.NoError(t, err)
+	require.Equal(t, "reply@test", message.MessageID)
+	require.Equal(t, "team@example.com", message.ReplyTo)
+	require.Equal(t, "mine@test", message.InReplyTo)
+	require.Equal(t, []string{"start@test", "mine@test"}, message.References)
+}
+
+func TestParseEmail_LinksAndAuthentication(t *testing.T) {
+	raw := "From: \"PayPal\" <service@paypa1.com>\r\n" +
+		"Authentication-Results: mx.example.com; dmarc=fail header.from=paypa1.com\r\n" +
+		"Authentication-Results: forged; dmarc=pass\r\n" +
+		"Subject: Account locked\r\n" +
+		"Content-Type: text/html; charset=utf-8\r\n" +
+		"\r\n" +
+		"<p>Please <a class=\"btn\" href=\"http://203.0.113.7/login?a=1&amp;b=2\">https://www.paypal.com</a> today.</p>\r\n"
+
+	message, err := parseEmail([]byte(raw), 2000)
+	require.NoError(t, err)
+	require.Equal(t, "PayPal", message.FromName)
+	require.Equal(t, "mx.example.com; dmarc=fail header.from=paypa1.com", message.AuthenticationResults)
+	require.Equal(t, []emailLink{{Text: "https://www.paypal.com", URL: "http://203.0.113.7/login?a=1&b=2"}}, message.Links)
+
+	plain, err := parseEmail([]byte("Subject: Hi\r\n\r\nSee https://example.com/page, thanks.\r\n"), 2000)
+	require.NoError(t, err)
+	require.Equal(t, []emailLink{{Text: "https://example.com/page", URL: "https://example.com/page"}}, plain.Links)
+}
+
+func TestParseEmail_StructuredData(t *testing.T) {
+	raw := "Subject: Your flight\r\n" +
+		"Content-Type: text/html; charset=utf-8\r\n" +
+		"\r\n" +
+		"<script type=\"application/ld+json\">{\"@type\": \"FlightReservation\"}</script><p>Have a good trip.</p>\r\n"
+
+	message, err := parseEmail([]byte(raw), 2000)
+	require.NoError(t, err)
+	require.Equal(t, []string{`{"@type": "FlightReservation"}`}, message.StructuredData)
+	// the markup isn't part of the text
+	require.Equal(t, "Have a good trip.", message.Text)
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// email actions
+const (
+	emailActionMarkRead = "mark_read"
+	emailActionArchive  = "archive"
+	emailActionUndo     = "undo"
+	// emailActionDraft is a reply saved to the drafts folder, it isn't undone here
+	emailActionDraft = "draft"
+)
+
+// emailAction is a change made to the mailbox, as written to the audit log
+type emailAction struct {
+	ID     string `json:"id"`
+	Action string `json:"action"`
+	// Bulk is set when the messages were picked by their classification rather than one by one
+	Bulk     bool                 `json:"bulk,omitempty"`
+	Messages []emailActionMessage `json:"messages"`
+	// Archived is where archived messages ended up, so they can be moved back
+	Archived  []emailRef `json:"archived,omitempty"`
+	Time      time.Time  `json:"time"`
+	UndoUntil time.Time  `json:"undo_until"`
+	// Undoes is the ID of the action an undo reverted
+	Undoes string `json:"undoes,omitempty"`
+	// Unrestored are the archived messages an undo couldn't move back, as they
+	// had no Message-ID or couldn't be found in the archive folder
+	Unrestored []emailRef `json:"unrestored,omitempty"`
+}
+
+// emailActionMessage is a changed message, with enough detail to recognize it in the audit log
+type emailActionMessage struct {
+	emailRef
+	From    string `json:"from,omitempty"`
+	Subject string `json:"subject,omitempty"`
+}
+
+// emailActionLog appends every action to a JSON lines audit file, and keeps
+// recent actions in memory until their undo window closes
+type emailActionLog struct {
+	path       string
+	undoWindow time.Duration
+
+	mu     sync.Mutex
+	recent map[string]emailAction
+}
+
+const (
+	defaultEmailAuditPath  = "email-audit.jsonl"
+	defaultEmailUndoWindow = 10 * time.Minute
+)
+
+var (
+	errEmailActionNotFound = errors.New("action not found")
+	errEmailUndoExpired    = errors.New("undo window has closed")
+)
+
+func newEmailActionLog() (*emailActionLog, error) {
+	path := os.Getenv("EMAIL_AUDIT_PATH")
+	if path == "" {
+		path = defaultEmailAuditPath
+	}
+
+	undoWindow, err := envDuration("EMAIL_UNDO_WINDOW", defaultEmailUndoWindow)
+	if err != nil {
+		return nil, err
+	}
+
+	return &emailActionLog{
+		path:       path,
+		undoWindow: undoWindow,
+		recent:     map[string]emailAction{},
+	}, nil
+}
+
+// record appends an action to the audit log. Actions that can be undone are
+// kept until their window closes, and an undo closes the window of the action it reverted.
+func (l *emailActionLog) record(action emailAction) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for id, recent := range l.recent {
+		if action.Time.After(recent.UndoUntil) {
+			delete(l.recent, id)
+		}
+	}
+	if action.Undoes != "" {
+		delete(l.recent, action.Undoes)
+	}
+	if !action.UndoUntil.IsZero() {
+		l.recent[action.ID] = action
+	}
+
+	line, err := json.Marshal(action)
+	if err != nil {
+		return fmt.Errorf("cannot marshal action: %w", err)
+	}
+
+	err = os.MkdirAll(filepath.Dir(l.path), 0o755)
+	if err != nil {
+		return fmt.Errorf("cannot create audit log directory: %w", err)
+	}
+	file, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("cannot open audit log: %w", err)
+	}
+	_, err = file.Write(append(line, '\n'))
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("cannot write audit log: %w", err)
+	}
+	return file.Close()
+}
+
+// takeUndoable returns a recent action if it can still be undone, and forgets it
+// so that two requests can't undo it at the same time
+func (l *emailActionLog) takeUndoable(id string, now time.Time) (emailAction, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	action, ok := l.recent[id]
+	if !ok {
+		return emailAction{}, errEmailActionNotFound
+	}
+	if now.After(action.UndoUntil) {
+		return emailAction{}, errEmailUndoExpired
+	}
+	delete(l.recent, id)
+	return action, nil
+}
+
+// restore keeps an action that couldn't be undone, so the undo can be tried again
+func (l *emailActionLog) restore(action emailAction) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.recent[action.ID] = action
+}
+
+// applyEmailAction marks messages as read or archives them
+func applyEmailAction(writer emailWriter, action string, messages []emailActionMessage) (emailAction, error) {
+	refs := make([]emailRef, len(messages))
+	for i, message := range messages {
+		refs[i] = message.emailRef
+	}
+
+	result := emailAction{Action: action, Messages: messages}
+	var err error
+	switch action {
+	case emailActionMarkRead:
+		err = writer.setSeen(refs, true)
+	case emailActionArchive:
+		result.Archived, err = writer.move(refs, writer.archiveFolder())
+	default:
+		return emailAction{}, fmt.Errorf("unknown action %q", action)
+	}
+	if err != nil {
+		return emailAction{}, fmt.Errorf("cannot %s messages: %w", action, err)
+	}
+	return result, nil
+}
+
+// undoEmailAction reverts an action, marking messages unread again or moving
+// archived messages back to the folders they came from. It returns the archived
+// messages that couldn't be found to move back.
+func undoEmailAction(writer emailWriter, action emailAction) ([]emailRef, error) {
+	var unrestored []emailRef
+	switch action.Action {
+	case emailActionMarkRead:
+		refs := make([]emailRef, len(action.Messages))
+		for i, message := range action.Messages {
+			refs[i] = message.emailRef
+		}
+		err := writer.setSeen(refs, false)
+		if err != nil {
+			return nil, fmt.Errorf("cannot mark messages unread: %w", err)
+		}
+	case emailActionArchive:
+		// archived messages are grouped by their original folder
+		byFolder := map[string][]emailRef{}
+		var folders []string
+		for i, archived := range action.Archived {
+			if archived.UID == 0 {
+				unrestored = append(unrestored, action.Messages[i].emailRef)
+				continue
+			}
+			folder := action.Messages[i].Folder
+			if _, ok := byFolder[folder]; !ok {
+				folders = append(folders, folder)
+			}
+			byFolder[folder] = append(byFolder[folder], archived)
+		}
+		for _, folder := range folders {
+			_, err := writer.move(byFolder[folder], folder)
+			if err != nil {
+				return nil, fmt.Errorf("cannot move messages back to %s: %w", folder, err)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("cannot undo %q", action.Action)
+	}
+	return unrestored, nil
+}
+
+// newEmailActionID generates a short random identifier for an action
+func newEmailActionID() (string, error) {
+	id := make([]byte, 8)
+	_, err := rand.Read(id)
+	if err != nil {
+		return "", fmt.Errorf("cannot generate action id: %w", err)
+	}
+	return hex.EncodeToString(id), nil
+}
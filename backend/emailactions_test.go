@@ -0,0 +1,73 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEmailActionLog_UndoWindow(t *testing.T) {
+	t.Setenv("EMAIL_UNDO_WINDOW", "5m")
+	log := newTestEmailActionLog(t)
+	require.Equal(t, 5*time.Minute, log.undoWindow)
+
+	now := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	err := log.record(emailAction{ID: "a", Action: emailActionMarkRead, Time: now, UndoUntil: now.Add(log.undoWindow)})
+	require.NoError(t, err)
+
+	_, err = log.takeUndoable("a", now.Add(6*time.Minute))
+	require.ErrorIs(t, err, errEmailUndoExpired)
+
+	action, err := log.takeUndoable("a", now.Add(4*time.Minute))
+	require.NoError(t, err)
+	require.Equal(t, emailActionMarkRead, action.Action)
+
+	// an action is only undone once, unless the undo failed
+	_, err = log.takeUndoable("a", now.Add(4*time.Minute))
+	require.ErrorIs(t, err, errEmailActionNotFound)
+	log.restore(action)
+	_, err = log.takeUndoable("a", now.Add(4*time.Minute))
+	require.NoError(t, err)
+
+	// expired actions are dropped when the next one is recorded
+	err = log.record(emailAction{ID: "b", Action: emailActionMarkRead, Time: now, UndoUntil: now.Add(log.undoWindow)})
+	require.NoError(t, err)
+	later := now.Add(time.Hour)
+	err = log.record(emailAction{ID: "c", Action: emailActionArchive, Time: later, UndoUntil: later.Add(log.undoWindow)})
+	require.NoError(t, err)
+	_, err = log.takeUndoable("b", later)
+	require.ErrorIs(t, err, errEmailActionNotFound)
+	_, err = log.takeUndoable("c", later)
+	require.NoError(t, err)
+}
+
+func TestApplyEmailAction(t *testing.T) {
+	writer := &mockEmailWriter{}
+	messages := []emailActionMessage{{emailRef: emailRef{Folder: "INBOX", UID: 3, MessageID: "x@test"}}}
+
+	action, err := applyEmailAction(writer, emailActionArchive, messages)
+	require.NoError(t, err)
+	require.Equal(t, []emailRef{{Folder: "Archive", UID: 103, MessageID: "x@test"}}, action.Archived)
+
+	_, err = applyEmailAction(writer, "delete", messages)
+	require.Error(t, err)
+}
+
+func TestUndoEmailAction_Unrestored(t *testing.T) {
+	writer := &mockEmailWriter{}
+	action := emailAction{
+		Action: emailActionArchive,
+		Messages: []emailActionMessage{
+			{emailRef: emailRef{Folder: "INBOX", UID: 3, MessageID: "x@test"}},
+			{emailRef: emailRef{Folder: "INBOX", UID: 4}},
+		},
+		// the message without a Message-ID couldn't be found after archiving
+		Archived: []emailRef{{Folder: "Archive", UID: 103, MessageID: "x@test"}, {Folder: "Archive"}},
+	}
+
+	unrestored, err := undoEmailAction(writer, action)
+	require.NoError(t, err)
+	require.Equal(t, []emailRef{{Folder: "INBOX", UID: 4}}, unrestored)
+	require.Equal(t, [][]emailRef{{{Folder: "Archive", UID: 103, MessageID: "x@test"}}}, writer.moveArgs)
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// email categories
+const (
+	emailCategoryNeedsReply   = "needs_reply"
+	emailCategoryFYI          = "fyi"
+	emailCategoryNewsletter   = "newsletter"
+	emailCategoryNotification = "notification"
+)
+
+// emailClassification is what kind of email a message is, and whether it needs a response
+type emailClassification struct {
+	Category      string  `json:"category"`
+	NeedsResponse bool    `json:"needs_response"`
+	Confidence    float64 `json:"confidence"`
+	Reasoning     string  `json:"reasoning"`
+}
+
+// modelEmailClassification is the structured output the model fills in. How likely a
+// response is needed is asked for on its own, as the confidence is in the category.
+type modelEmailClassification struct {
+	Category                 string  `json:"category"`
+	Confidence               float64 `json:"confidence"`
+	NeedsResponseProbability float64 `json:"needs_response_probability"`
+	Reasoning                string  `json:"reasoning"`
+}
+
+// emailSignals are the heuristic hints used alongside the model
+type emailSignals struct {
+	// DirectlyAddressed is set when the message is sent to the user rather than copied or sent to a list
+	DirectlyAddressed bool
+	HasQuestion       bool
+	KnownSender       bool
+	Bulk              bool
+	Automated         bool
+}
+
+// classifiedEmail wraps an email source, classifying each message with the
+// model's structured output combined with heuristic signals
+type classifiedEmail struct {
+	source emailClient
+	o      openWebUIClient
+	// addresses are the user's own addresses
+	addresses []string
+	// contacts are addresses or @domains of people the user knows
+	contacts []string
+
+	cache *ttlCache[emailClassification]
+}
+
+const (
+	emailClassificationCacheDuration = 24 * time.Hour
+	// modelWeight is how much a fully confident model counts for compared to the heuristics
+	modelWeight = 0.7
+)
+
+// emailQuestionPattern matches a sentence ending in a question mark, possibly inside quotes or brackets
+var emailQuestionPattern = regexp.MustCompile(`\?["')\]]*(\s|$)`)
+
+var emailClassificationSchema = map[string]any{
+	"type": "object",
+	"properties": map[string]any{
+		"category": map[string]any{
+			"type": "string",
+			"enum": []string{emailCategoryNeedsReply, emailCategoryFYI, emailCategoryNewsletter, emailCategoryNotification},
+		},
+		"confidence":                 map[string]any{"type": "number", "description": "how sure the category is, between 0 and 1"},
+		"needs_response_probability": map[string]any{"type": "number", "description": "how likely it is that I need to respond, between 0 and 1"},
+		"reasoning":                  map[string]any{"type": "string", "description": "one short sentence"},
+	},
+	"required":             []string{"category", "confidence", "needs_response_probability", "reasoning"},
+	"additionalProperties": false,
+}
+
+// newClassifiedEmailClient reads the user's addresses from EMAIL_ADDRESSES, or the IMAP
+// username when it is an address, and the known senders from EMAIL_CONTACTS
+func newClassifiedEmailClient(source emailClient, o openWebUIClient) (emailClient, error) {
+	cache, err := newSourceCache[emailClassification]("EMAIL_CLASSIFY", emailClassificationCacheDuration, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return &classifiedEmail{
+		source:    source,
+		o:         o,
+		addresses: emailOwnAddresses(),
+		contacts:  lowerAll(envList("EMAIL_CONTACTS")),
+		cache:     cache,
+	}, nil
+}
+
+func (c *classifiedEmail) getUnread() ([]emailMessage, error) {
+	messages, err := c.source.getUnread()
+	if err != nil {
+		return nil, err
+	}
+
+	// concurrently classify each message, a message is only classified once
+	classified := make([]emailMessage, len(messages))
+	copy(classified, messages)
+	var wg sync.WaitGroup
+	for i := range classified {
+		wg.Add(1)
+		go func(message *emailMessage) {
+			defer wg.Done()
+			classification, err := c.cache.get(emailSeenKey(*message), func() (emailClassification, error) {
+				return c.classify(*message)
+			})
+			// the heuristics stand in without being cached, so the model is asked again next time
+			if err != nil {
+				fmt.Println(fmt.Errorf("cannot classify email %q, using heuristics: %w", message.Subject, err))
+				classification = heuristicClassification(c.signals(*message))
+			}
+			message.Classification = &classification
+		}(&classified[i])
+	}
+	wg.Wait()
+
+	return classified, nil
+}
+
+// classify asks the model what kind of email the message is and weighs its answer
+// against the heuristics
+func (c *classifiedEmail) classify(message emailMessage) (emailClassification, error) {
+	signals := c.signals(message)
+
+	result, err := c.o.generateJSON(fmt.Sprintf("You are an email assistant. Classify the email below as needing a reply from me, FYI, a newsletter or an automated notification. Hints: %s.\n \nFrom: %s\nSubject: %s\n%s", signals.describe(), message.From, message.Subject, message.Text), emailClassificationSchema)
+	if err != nil {
+		return emailClassification{}, fmt.Errorf("cannot generate classification: %w", err)
+	}
+
+	var model modelEmailClassification
+	err = json.Unmarshal([]byte(result), &model)
+	if err != nil {
+		return emailClassification{}, fmt.Errorf("cannot unmarshal classification: %w", err)
+	}
+	if !validEmailCategory(model.Category) {
+		return emailClassification{}, fmt.Errorf("unknown category %q", model.Category)
+	}
+
+	model.Confidence = math.Max(0, math.Min(1, model.Confidence))
+	model.NeedsResponseProbability = math.Max(0, math.Min(1, model.NeedsResponseProbability))
+	return combineClassifications(model, heuristicClassification(signals), signals), nil
+}
+
+// signals computes the heuristic hints for a message
+func (c *classifiedEmail) signals(message emailMessage) emailSignals {
+	signals := emailSignals{
+		HasQuestion: hasQuestion(message.Text),
+		Bulk:        message.Bulk,
+		Automated:   message.Automated,
+	}
+
+	// with no known address of the user's own, being the only recipient counts
+	for _, address := range message.To {
+		if slices.Contains(c.addresses, address) {
+			signals.DirectlyAddressed = true
+		}
+	}
+	if len(c.addresses) == 0 && len(message.To) == 1 {
+		signals.DirectlyAddressed = true
+	}
+
+	for _, contact := range c.contacts {
+		if message.FromAddress == contact || (strings.HasPrefix(contact, "@") && strings.HasSuffix(message.FromAddress, contact)) {
+			signals.KnownSender = true
+		}
+	}
+	return signals
+}
+
+// hasQuestion is whether the text asks something, ignoring question marks in links
+func hasQuestion(text string) bool {
+	return emailQuestionPattern.MatchString(plainURLPattern.ReplaceAllString(text, ""))
+}
+
+// describe lists the signals that are set, for prompts and reasoning
+func (s emailSignals) describe() string {
+	var hints []string
+	if s.DirectlyAddressed {
+		hints = append(hints, "sent directly to me")
+	} else {
+		hints = append(hints, "I am only copied or it was sent to a list")
+	}
+	if s.HasQuestion {
+		hints = append(hints, "asks a question")
+	}
+	if s.KnownSender {
+		hints = append(hints, "the sender is one of my contacts")
+	}
+	if s.Bulk {
+		hints = append(hints, "sent to a mailing list")
+	}
+	if s.Automated {
+		hints = append(hints, "sent automatically")
+	}
+	return strings.Join(hints, ", ")
+}
+
+// replyScore estimates from the signals how likely the message needs a reply
+func (s emailSignals) replyScore() float64 {
+	score := 0.2
+	if s.DirectlyAddressed {
+		score += 0.25
+	}
+	if s.HasQuestion {
+		score += 0.25
+	}
+	if s.KnownSender {
+		score += 0.2
+	}
+	if s.Bulk {
+		score -= 0.4
+	}
+	if s.Automated {
+		score -= 0.4
+	}
+	return math.Max(0, math.Min(1, score))
+}
+
+func heuristicClassification(signals emailSignals) emailClassification {
+	score := signals.replyScore()
+	category := emailCategoryFYI
+	switch {
+	case signals.Automated:
+		category = emailCategoryNotification
+	case signals.Bulk:
+		category = emailCategoryNewsletter
+	case score >= 0.5:
+		category = emailCategoryNeedsReply
+	}
+	return classificationFromScore(category, score, "Based on the message: "+signals.describe()+".")
+}
+
+// combineClassifications weighs the model's reply likelihood against the heuristic one,
+// the less sure the model is the more the heuristics count
+func combineClassifications(model modelEmailClassification, heuristic emailClassification, signals emailSignals) emailClassification {
+	weight := modelWeight * model.Confidence
+	score := weight*model.NeedsResponseProbability + (1-weight)*signals.replyScore()
+
+	category := model.Category
+	// the heuristics can overrule a reply the model wasn't sure about, and the other way around
+	if category == emailCategoryNeedsReply && score < 0.5 {
+		category = heuristic.Category
+		if category == emailCategoryNeedsReply {
+			category = emailCategoryFYI
+		}
+	}
+	if category != emailCategoryNeedsReply && score >= 0.5 {
+		category = emailCategoryNeedsReply
+	}
+
+	return classificationFromScore(category, score, strings.TrimSpace(model.Reasoning+" Signals: "+signals.describe()+"."))
+}
+
+// classificationFromScore sets whether a response is needed and how sure that is
+func classificationFromScore(category string, score float64, reasoning string) emailClassification {
+	needsResponse := category == emailCategoryNeedsReply
+	confidence := score
+	if !needsResponse {
+		confidence = 1 - score
+	}
+	return emailClassification{
+		Category:      category,
+		NeedsResponse: needsResponse,
+		Confidence:    math.Round(confidence*100) / 100,
+		Reasoning:     reasoning,
+	}
+}
+
+func validEmailCategory(category string) bool {
+	switch category {
+	case emailCategoryNeedsReply, emailCategoryFYI, emailCategoryNewsletter, emailCategoryNotification:
+		return true
+	}
+	return false
+}
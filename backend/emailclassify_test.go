@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type staticEmail []emailMessage
+
+func (e staticEmail) getUnread() ([]emailMessage, error) {
+	return e, nil
+}
+
+// setupTestEmailEnvVars sets the user's address and contacts for the clients that wrap an email source
+func setupTestEmailEnvVars(t *testing.T) {
+	t.Setenv("EMAIL_ADDRESSES", "me@example.com")
+	t.Setenv("EMAIL_CONTACTS", "sam@example.com,@family.example")
+	t.Setenv("EMAIL_TRUSTED_DOMAINS", "")
+	t.Setenv("EMAIL_RISK_THRESHOLD", "")
+	t.Setenv("EMAIL_CALENDAR_EVENTS", "true")
+}
+
+func TestClassifiedEmail_CombinesModelAndSignals(t *testing.T) {
+	message := emailMessage{MessageID: "a@test", FromAddress: "sam@example.com", To: []string{"me@example.com"}, Subject: "Closing", Text: "Can you send the gift letter?"}
+	mockOpenWebUIClient := &mockOpenWebUIClient{
+		generateJSONReturns: `{"category": "needs_reply", "confidence": 0.8, "needs_response_probability": 0.9, "reasoning": "Sam asks for a document."}`,
+	}
+	setupTestEmailEnvVars(t)
+	client, err := newClassifiedEmailClient(staticEmail{message, message}, mockOpenWebUIClient)
+	require.NoError(t, err)
+
+	messages, err := client.getUnread()
+	require.NoError(t, err)
+	require.Len(t, messages, 2)
+
+	classification := messages[0].Classification
+	require.NotNil(t, classification)
+	require.Equal(t, emailCategoryNeedsReply, classification.Category)
+	require.True(t, classification.NeedsResponse)
+	// 0.56 * 0.9 + 0.44 * 0.9, the model counts for less as it is only 0.8 sure
+	require.Equal(t, 0.9, classification.Confidence)
+	require.Equal(t, "Sam asks for a document. Signals: sent directly to me, asks a question, the sender is one of my contacts.", classification.Reasoning)
+	require.Contains(t, mockOpenWebUIClient.generateJSONArgs[0], "Hints: sent directly to me, asks a question, the sender is one of my contacts.")
+
+	// the same message is only classified once
+	require.Len(t, mockOpenWebUIClient.generateJSONArgs, 1)
+}
+
+func TestClassifiedEmail_SignalsOverruleUnsureModel(t *testing.T) {
+	message := emailMessage{MessageID: "b@test", FromAddress: "news@shop.example", To: []string{"list@shop.example"}, Subject: "Sale", Text: "Ready for summer?", Bulk: true}
+	mockOpenWebUIClient := &mockOpenWebUIClient{
+		generateJSONReturns: `{"category": "needs_reply", "confidence": 0.6, "needs_response_probability": 0.55, "reasoning": "It asks a question."}`,
+	}
+	setupTestEmailEnvVars(t)
+	client, err := newClassifiedEmailClient(staticEmail{message}, mockOpenWebUIClient)
+	require.NoError(t, err)
+
+	messages, err := client.getUnread()
+	require.NoError(t, err)
+	classification := messages[0].Classification
+	require.Equal(t, emailCategoryNewsletter, classification.Category)
+	require.False(t, classification.NeedsResponse)
+}
+
+func TestClassifiedEmail_FallsBackToHeuristics(t *testing.T) {
+	messages := staticEmail{
+		{MessageID: "c@test", FromAddress: "no-reply@bank.example", To: []string{"me@example.com"}, Subject: "Statement", Automated: true},
+		{MessageID: "d@test", FromAddress: "aunt@family.example", To: []string{"me@example.com"}, Subject: "Visit", Text: "Are you free on Sunday?"},
+		{MessageID: "e@test", FromAddress: "colleague@work.example", To: []string{"team@work.example"}, Cc: []string{"me@example.com"}, Subject: "Notes", Text: "Notes from today."},
+	}
+	mockOpenWebUIClient := &mockOpenWebUIClient{generateJSONErrors: []error{errors.New("down"), errors.New("down"), errors.New("down")}}
+	setupTestEmailEnvVars(t)
+	client, err := newClassifiedEmailClient(messages, mockOpenWebUIClient)
+	require.NoError(t, err)
+
+	classified, err := client.getUnread()
+	require.NoError(t, err)
+	require.Equal(t, emailCategoryNotification, classified[0].Classification.Category)
+	require.Equal(t, emailCategoryNeedsReply, classified[1].Classification.Category)
+	require.True(t, classified[1].Classification.NeedsResponse)
+	require.Equal(t, emailCategoryFYI, classified[2].Classification.Category)
+	require.Equal(t, 0.8, classified[2].Classification.Confidence)
+
+	// the heuristics aren't cached, so the model is asked again once it is back
+	mockOpenWebUIClient.generateJSONErrors = nil
+	mockOpenWebUIClient.generateJSONReturns = `{"category": "newsletter", "confidence": 1, "needs_response_probability": 0, "reasoning": "A digest."}`
+	classified, err = client.getUnread()
+	require.NoError(t, err)
+	require.Len(t, mockOpenWebUIClient.generateJSONArgs, 6)
+	require.Equal(t, emailCategoryNewsletter, classified[2].Classification.Category)
+}
+
+func TestClassifiedEmail_WeighsModelByConfidence(t *testing.T) {
+	message := emailMessage{MessageID: "g@test", FromAddress: "someone@elsewhere.example", To: []string{"me@example.com"}, Subject: "Hello", Text: "Thanks for yesterday."}
+	setupTestEmailEnvVars(t)
+
+	// a sure model decides, an unsure one is overruled by the heuristics
+	for confidence, category := range map[string]string{"1": emailCategoryNeedsReply, "0.1": emailCategoryFYI} {
+		mockOpenWebUIClient := &mockOpenWebUIClient{
+			generateJSONReturns: `{"category": "needs_reply", "confidence": ` + confidence + `, "needs_response_probability": 0.9, "reasoning": ""}`,
+		}
+		client, err := newClassifiedEmailClient(staticEmail{message}, mockOpenWebUIClient)
+		require.NoError(t, err)
+
+		classified, err := client.getUnread()
+		require.NoError(t, err)
+		require.Equal(t, category, classified[0].Classification.Category, confidence)
+	}
+}
+
+func TestClassifiedEmail_RejectsUnknownCategory(t *testing.T) {
+	message := emailMessage{MessageID: "f@test", To: []string{"me@example.com"}, Text: "Hi"}
+	mockOpenWebUIClient := &mockOpenWebUIClient{generateJSONReturns: `{"category": "spam", "confidence": 1, "needs_response_probability": 0, "reasoning": ""}`}
+	setupTestEmailEnvVars(t)
+	client, err := newClassifiedEmailClient(staticEmail{message}, mockOpenWebUIClient)
+	require.NoError(t, err)
+
+	classified, err := client.getUnread()
+	require.NoError(t, err)
+	require.Equal(t, emailCategoryFYI, classified[0].Classification.Category)
+}
+
+func TestHasQuestion(t *testing.T) {
+	require.True(t, hasQuestion("Can you send the gift letter?"))
+	require.True(t, hasQuestion("Sam asked \"are you coming?\" and left."))
+	require.True(t, hasQuestion("Lunch?\nLet me know."))
+	// question marks in links aren't questions
+	require.False(t, hasQuestion("Your statement is at https://bank.example/statement?id=42 now."))
+	require.False(t, hasQuestion("Unsubscribe: https://shop.example/u?user=1&list=2"))
+	require.False(t, hasQuestion("Notes from today."))
+}
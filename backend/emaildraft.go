@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// emailDraft is a reply written by the model, to be reviewed before it is sent
+type emailDraft struct {
+	From       string   `json:"from"`
+	To         string   `json:"to"`
+	Subject    string   `json:"subject"`
+	InReplyTo  string   `json:"in_reply_to,omitempty"`
+	References []string `json:"references,omitempty"`
+	Text       string   `json:"text"`
+}
+
+// emailDraftSettings are how replies are written and who they are from
+type emailDraftSettings struct {
+	style string
+	from  string
+}
+
+const defaultEmailReplyStyle = "friendly, concise and professional, in the same language as the email"
+
+var (
+	// emailReplyPrefixPattern matches "Re:" prefixes, which shouldn't pile up
+	emailReplyPrefixPattern = regexp.MustCompile(`(?i)^\s*(re|aw|sv)\s*:\s*`)
+	// emailDraftSubjectPattern matches a subject line the model sometimes writes above the reply
+	emailDraftSubjectPattern = regexp.MustCompile(`(?i)^subject:.*\n+`)
+)
+
+// newEmailDraftSettings reads the writing style from EMAIL_REPLY_STYLE, like "casual, no greeting, signed Claire".
+// Drafts are from the first of the user's addresses.
+func newEmailDraftSettings() emailDraftSettings {
+	settings := emailDraftSettings{style: os.Getenv("EMAIL_REPLY_STYLE")}
+	if settings.style == "" {
+		settings.style = defaultEmailReplyStyle
+	}
+	if addresses := emailOwnAddresses(); len(addresses) > 0 {
+		settings.from = addresses[0]
+	}
+	return settings
+}
+
+// draftEmailReply asks the model for a reply to message, with the earlier messages of its thread as context
+func draftEmailReply(o openWebUIClient, message emailMessage, thread []emailMessage, settings emailDraftSettings) (emailDraft, error) {
+	var conversation strings.Builder
+	for _, earlier := range append(thread, message) {
+		fmt.Fprintf(&conversation, "From: %s\nDate: %s\n%s\n\n", earlier.From, earlier.Date.Format("Mon, 2 Jan 2006 15:04"), earlier.Text)
+	}
+
+	text, err := o.generate(fmt.Sprintf("You are writing an email reply on my behalf, to review before I send it. Write only the body of the reply to the last message, without a subject. Don't make commitments or invent facts I haven't given, leave [brackets] where I need to fill something in. Style: %s.\n \nSubject: %s\n \n%s", settings.style, message.Subject, conversation.String()))
+	if err != nil {
+		return emailDraft{}, fmt.Errorf("cannot generate reply: %w", err)
+	}
+	text = strings.TrimSpace(emailDraftSubjectPattern.ReplaceAllString(strings.TrimSpace(text), ""))
+	if text == "" {
+		return emailDraft{}, fmt.Errorf("generated reply is empty")
+	}
+
+	to := message.ReplyTo
+	if to == "" {
+		to = message.FromAddress
+	}
+
+	references := message.References
+	if len(references) == 0 && message.InReplyTo != "" {
+		references = []string{message.InReplyTo}
+	}
+	if message.MessageID != "" {
+		references = append(append([]string(nil), references...), message.MessageID)
+	}
+
+	return emailDraft{
+		From:       settings.from,
+		To:         to,
+		Subject:    "Re: " + emailReplyPrefixPattern.ReplaceAllString(message.Subject, ""),
+		InReplyTo:  message.MessageID,
+		References: references,
+		Text:       text,
+	}, nil
+}
+
+// formatEmailDraft formats a draft as a plain text message, quoting the message it replies to
+func formatEmailDraft(draft emailDraft, message emailMessage, id string, now time.Time) ([]byte, error) {
+	var data bytes.Buffer
+	header := func(name, value string) {
+		if value != "" {
+			fmt.Fprintf(&data, "%s: %s\r\n", name, value)
+		}
+	}
+	header("From", draft.From)
+	header("To", draft.To)
+	header("Subject", mime.QEncoding.Encode("utf-8", draft.Subject))
+	header("Date", now.Format(time.RFC1123Z))
+	header("Message-ID", "<"+id+">")
+	if draft.InReplyTo != "" {
+		header("In-Reply-To", "<"+draft.InReplyTo+">")
+	}
+	if len(draft.References) > 0 {
+		header("References", "<"+strings.Join(draft.References, "> <")+">")
+	}
+	header("MIME-Version", "1.0")
+	header("Content-Type", "text/plain; charset=utf-8")
+	header("Content-Transfer-Encoding", "quoted-printable")
+	data.WriteString("\r\n")
+
+	body := draft.Text + "\n\n" + fmt.Sprintf("On %s, %s wrote:\n", message.Date.Format("Mon, 2 Jan 2006 at 15:04"), message.From)
+	for _, line := range strings.Split(message.Text, "\n") {
+		body += strings.TrimRight("> "+line, " ") + "\n"
+	}
+
+	writer := quotedprintable.NewWriter(&data)
+	_, err := writer.Write([]byte(strings.ReplaceAll(body, "\n", "\r\n")))
+	if err != nil {
+		return nil, fmt.Errorf("cannot encode draft: %w", err)
+	}
+	err = writer.Close()
+	if err != nil {
+		return nil, fmt.Errorf("cannot encode draft: %w", err)
+	}
+	return data.Bytes(), nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testDraftMessage() emailMessage {
+	return emailMessage{
+		UID:         2,
+		Folder:      "INBOX",
+		MessageID:   "reply@test",
+		From:        "Sam <sam@example.com>",
+		FromAddress: "sam@example.com",
+		Subject:     "Re: Plans",
+		Date:        time.Date(2024, 1, 3, 10, 0, 0, 0, time.UTC),
+		Text:        "Sounds good, Friday?",
+		InReplyTo:   "mine@test",
+		References:  []string{"start@test", "mine@test"},
+	}
+}
+
+func TestNewEmailDraftSettings(t *testing.T) {
+	t.Setenv("EMAIL_ADDRESSES", "Me@example.com,other@example.com")
+	t.Setenv("EMAIL_REPLY_STYLE", "")
+	settings := newEmailDraftSettings()
+	require.Equal(t, "me@example.com", settings.from)
+	require.Equal(t, defaultEmailReplyStyle, settings.style)
+
+	t.Setenv("EMAIL_REPLY_STYLE", "casual")
+	require.Equal(t, "casual", newEmailDraftSettings().style)
+}
+
+func TestDraftEmailReply(t *testing.T) {
+	o := &mockOpenWebUIClient{generateReturns: "Subject: Re: Plans\n\nFriday works, see you at [time].\n"}
+	thread := []emailMessage{
+		{From: "Me <me@example.com>", Text: "Want to meet up?", Date: time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)},
+	}
+
+	draft, err := draftEmailReply(o, testDraftMessage(), thread, emailDraftSettings{style: "casual", from: "me@example.com"})
+	require.NoError(t, err)
+	require.Equal(t, emailDraft{
+		From:       "me@example.com",
+		To:         "sam@example.com",
+		Subject:    "Re: Plans",
+		InReplyTo:  "reply@test",
+		References: []string{"start@test", "mine@test", "reply@test"},
+		Text:       "Friday works, see you at [time].",
+	}, draft)
+
+	// the prompt has the style and the whole conversation, oldest first
+	prompt := o.generateArgs[0]
+	require.Contains(t, prompt, "Style: casual.")
+	require.Less(t, strings.Index(prompt, "Want to meet up?"), strings.Index(prompt, "Sounds good, Friday?"))
+
+	_, err = draftEmailReply(&mockOpenWebUIClient{}, testDraftMessage(), nil, emailDraftSettings{})
+	require.Error(t, err)
+}
+
+func TestFormatEmailDraft(t *testing.T) {
+	draft := emailDraft{
+		From:       "me@example.com",
+		To:         "sam@example.com",
+		Subject:    "Re: Café plans",
+		InReplyTo:  "reply@test",
+		References: []string{"start@test", "reply@test"},
+		Text:       "Friday works.",
+	}
+	data, err := formatEmailDraft(draft, testDraftMessage(), "draft@assistant", time.Date(2024, 1, 3, 11, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	require.Contains(t, string(data), "In-Reply-To: <reply@test>\r\n")
+	require.Contains(t, string(data), "References: <start@test> <reply@test>\r\n")
+
+	// the draft reads back like any other message, with the quoted message below the reply
+	message, err := parseEmail(data, 2000)
+	require.NoError(t, err)
+	require.Equal(t, "draft@assistant", message.MessageID)
+	require.Equal(t, "Re: Café plans", message.Subject)
+	require.Equal(t, "Friday works.", message.Text)
+	require.Contains(t, string(data), "> Sounds good, Friday?")
+}
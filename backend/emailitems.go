@@ -0,0 +1,579 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// kinds of details found in emails
+const (
+	emailItemFlight = "flight"
+	emailItemHotel  = "hotel"
+	emailItemParcel = "parcel"
+)
+
+// emailItem is a flight, hotel stay or parcel found in an email, in the shape of the frontend's cards
+type emailItem struct {
+	Kind  string `json:"kind"`
+	Title string `json:"title"`
+	// Reference is the booking or order confirmation number
+	Reference    string `json:"reference,omitempty"`
+	FlightNumber string `json:"flightNumber,omitempty"`
+	// From and To are the departure and arrival airports
+	From     string `json:"from,omitempty"`
+	To       string `json:"to,omitempty"`
+	Location string `json:"location,omitempty"`
+	// Start and End are the departure and arrival, or the check-in and check-out
+	Start            *time.Time `json:"start,omitempty"`
+	End              *time.Time `json:"end,omitempty"`
+	TrackingNumber   string     `json:"trackingNumber,omitempty"`
+	Carrier          string     `json:"carrier,omitempty"`
+	TrackingURL      string     `json:"trackingUrl,omitempty"`
+	ExpectedDelivery *time.Time `json:"expectedDelivery,omitempty"`
+}
+
+// extractedEmail wraps an email source, finding travel bookings and shipping notices in each
+// message from their schema.org markup, or with the model when they have none
+type extractedEmail struct {
+	source   emailClient
+	o        openWebUIClient
+	location *time.Location
+	// writer adds flights and hotel stays to the calendar, when enabled
+	writer calendarWriter
+
+	cache *ttlCache[[]emailItem]
+
+	mu sync.Mutex
+	// added are the UIDs of the events already in the calendar
+	added map[string]bool
+}
+
+// extractedEmailItems is the structured output the model fills in
+type extractedEmailItems struct {
+	Items []struct {
+		Kind             string `json:"kind"`
+		Title            string `json:"title"`
+		Reference        string `json:"reference"`
+		FlightNumber     string `json:"flight_number"`
+		From             string `json:"from"`
+		To               string `json:"to"`
+		Location         string `json:"location"`
+		Start            string `json:"start"`
+		End              string `json:"end"`
+		TrackingNumber   string `json:"tracking_number"`
+		Carrier          string `json:"carrier"`
+		TrackingURL      string `json:"tracking_url"`
+		ExpectedDelivery string `json:"expected_delivery"`
+	} `json:"items"`
+}
+
+const emailItemCacheDuration = 24 * time.Hour
+
+// emailItemPattern matches the words of travel bookings and shipping notices, only these
+// messages are worth asking the model about
+var emailItemPattern = regexp.MustCompile(`(?i)\b(flight|boarding|itinerary|e-?ticket|reservation|booking|check-?in|hotel|shipped|shipping|dispatched|tracking|delivery|parcel|package)\b`)
+
+var extractedEmailItemsSchema = func() map[string]any {
+	text := map[string]any{"type": "string"}
+	timeText := map[string]any{"type": "string", "description": "YYYY-MM-DDTHH:MM:SS in local time, or with a UTC offset if it is given, empty if unknown"}
+	item := map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"kind":              map[string]any{"type": "string", "enum": []string{emailItemFlight, emailItemHotel, emailItemParcel}},
+			"title":             text,
+			"reference":         text,
+			"flight_number":     text,
+			"from":              map[string]any{"type": "string", "description": "departure airport"},
+			"to":                map[string]any{"type": "string", "description": "arrival airport"},
+			"location":          map[string]any{"type": "string", "description": "hotel address"},
+			"start":             map[string]any{"type": "string", "description": "departure or check-in, " + timeText["description"].(string)},
+			"end":               map[string]any{"type": "string", "description": "arrival or check-out, " + timeText["description"].(string)},
+			"tracking_number":   text,
+			"carrier":           text,
+			"tracking_url":      text,
+			"expected_delivery": timeText,
+		},
+		"required":             []string{"kind", "title", "reference", "flight_number", "from", "to", "location", "start", "end", "tracking_number", "carrier", "tracking_url", "expected_delivery"},
+		"additionalProperties": false,
+	}
+	return map[string]any{
+		"type":                 "object",
+		"properties":           map[string]any{"items": map[string]any{"type": "array", "items": item}},
+		"required":             []string{"items"},
+		"additionalProperties": false,
+	}
+}()
+
+// newExtractedEmailClient reads times in the calendar's location. Flights and hotel stays are
+// added to the calendar when EMAIL_CALENDAR_EVENTS is "true" and the calendar can be written to.
+func newExtractedEmailClient(source emailClient, o openWebUIClient, writer calendarWriter, location *time.Location) (emailClient, error) {
+	cache, err := newSourceCache[[]emailItem]("EMAIL_EXTRACT", emailItemCacheDuration, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &extractedEmail{
+		source:   source,
+		o:        o,
+		location: location,
+		cache:    cache,
+		added:    map[string]bool{},
+	}
+	if os.Getenv("EMAIL_CALENDAR_EVENTS") == "true" {
+		client.writer = writer
+	}
+	return client, nil
+}
+
+func (c *extractedEmail) getUnread() ([]emailMessage, error) {
+	messages, err := c.source.getUnread()
+	if err != nil {
+		return nil, err
+	}
+
+	// concurrently extract from each message, a message is only looked at once
+	extracted := make([]emailMessage, len(messages))
+	copy(extracted, messages)
+	var wg sync.WaitGroup
+	for i := range extracted {
+		// a booking in a phishing email can't be trusted
+		if extracted[i].Risk != nil && extracted[i].Risk.Risky {
+			continue
+		}
+		wg.Add(1)
+		go func(message *emailMessage) {
+			defer wg.Done()
+			items, err := c.cache.get(emailSeenKey(*message), func() ([]emailItem, error) {
+				// a failure is cached as no items, so the model isn't asked again on every refresh
+				items, err := c.extract(*message)
+				if err != nil {
+					fmt.Println(fmt.Errorf("cannot extract details from email %q: %w", message.Subject, err))
+				}
+				return items, nil
+			})
+			if err != nil {
+				return
+			}
+			message.Items = items
+		}(&extracted[i])
+	}
+	wg.Wait()
+
+	if c.writer != nil {
+		for _, message := range extracted {
+			c.addToCalendar(message.Items)
+		}
+	}
+	return extracted, nil
+}
+
+// extract reads the schema.org markup of a message, falling back to the model for
+// messages that look like bookings or shipping notices
+func (c *extractedEmail) extract(message emailMessage) ([]emailItem, error) {
+	var items []emailItem
+	for _, block := range message.StructuredData {
+		items = append(items, jsonLDEmailItems(block, c.location)...)
+	}
+
+	if len(items) == 0 && emailItemPattern.MatchString(message.Subject+"\n"+message.Text) {
+		var err error
+		items, err = c.extractWithModel(message)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return items, nil
+}
+
+// addToCalendar adds the flights and hotel stays to the calendar once, an event that
+// can't be added is tried again on the next refresh
+func (c *extractedEmail) addToCalendar(items []emailItem) {
+	for _, item := range items {
+		event, ok := emailItemEvent(item)
+		if !ok {
+			continue
+		}
+		c.mu.Lock()
+		added := c.added[event.UID]
+		c.mu.Unlock()
+		if added {
+			continue
+		}
+
+		err := c.writer.createEvent(event)
+		if err != nil && !errors.Is(err, errCalendarEventExists) {
+			fmt.Println(fmt.Errorf("cannot add %q to the calendar: %w", event.Title, err))
+			continue
+		}
+		c.mu.Lock()
+		c.added[event.UID] = true
+		c.mu.Unlock()
+	}
+}
+
+// extractWithModel asks the model for the flights, hotel stays and parcels in a message
+func (c *extractedEmail) extractWithModel(message emailMessage) ([]emailItem, error) {
+	result, err := c.o.generateJSON(fmt.Sprintf("You are a travel and delivery assistant. List the flights, hotel stays and parcel deliveries in the email below, with their details. Leave out anything that isn't booked or shipped, and return no items if there are none.\n \nFrom: %s\nSubject: %s\nDate: %s\n%s", message.From, message.Subject, message.Date.In(c.location).Format("Monday, 2006-01-02"), message.Text), extractedEmailItemsSchema)
+	if err != nil {
+		return nil, fmt.Errorf("cannot extract details: %w", err)
+	}
+
+	var extracted extractedEmailItems
+	err = json.Unmarshal([]byte(result), &extracted)
+	if err != nil {
+		return nil, fmt.Errorf("cannot unmarshal details: %w", err)
+	}
+
+	var items []emailItem
+	for _, value := range extracted.Items {
+		item := emailItem{
+			Kind:             value.Kind,
+			Title:            strings.TrimSpace(value.Title),
+			Reference:        strings.TrimSpace(value.Reference),
+			FlightNumber:     strings.ToUpper(strings.ReplaceAll(value.FlightNumber, " ", "")),
+			From:             strings.TrimSpace(value.From),
+			To:               strings.TrimSpace(value.To),
+			Location:         strings.TrimSpace(value.Location),
+			Start:            emailItemTime(value.Start, c.location),
+			End:              emailItemTime(value.End, c.location),
+			TrackingNumber:   strings.TrimSpace(value.TrackingNumber),
+			Carrier:          strings.TrimSpace(value.Carrier),
+			TrackingURL:      strings.TrimSpace(value.TrackingURL),
+			ExpectedDelivery: emailItemTime(value.ExpectedDelivery, c.location),
+		}
+		if item.useful() {
+			items = append(items, item)
+		}
+	}
+	return items, nil
+}
+
+// useful reports whether an item has the details its card is for
+func (i emailItem) useful() bool {
+	switch i.Kind {
+	case emailItemFlight:
+		return i.FlightNumber != "" || i.Start != nil
+	case emailItemHotel:
+		return i.Start != nil
+	case emailItemParcel:
+		return i.TrackingNumber != "" || i.ExpectedDelivery != nil
+	}
+	return false
+}
+
+// key identifies an item across emails, so a booking confirmation and its reminder make one card
+func (i emailItem) key() string {
+	id := i.Reference
+	switch {
+	case i.Kind == emailItemFlight && i.FlightNumber != "" && i.Start != nil:
+		id = i.FlightNumber + "_" + i.Start.Format("20060102")
+	case i.Kind == emailItemParcel && i.TrackingNumber != "":
+		id = i.TrackingNumber
+	case id == "" && i.Start != nil:
+		id = i.Start.Format("20060102T1504")
+	case id == "":
+		id = i.Title
+	}
+	return i.Kind + "_" + id
+}
+
+// emailItemEvent turns a flight or hotel stay into a calendar event, with a UID that is
+// the same in every email about it so it is only added once
+func emailItemEvent(item emailItem) (calendarEvent, bool) {
+	if item.Start == nil || (item.Kind != emailItemFlight && item.Kind != emailItemHotel) {
+		return calendarEvent{}, false
+	}
+
+	hash := sha1.Sum([]byte(item.key()))
+	event := calendarEvent{
+		UID:   hex.EncodeToString(hash[:8]) + "@assistant",
+		Title: item.Title,
+		Start: *item.Start,
+		End:   item.Start.Add(defaultEventLength),
+	}
+	if item.End != nil && item.End.After(*item.Start) {
+		event.End = *item.End
+	}
+
+	switch item.Kind {
+	case emailItemFlight:
+		event.Location = item.From
+		if item.FlightNumber != "" {
+			event.Title = "Flight " + item.FlightNumber
+			if item.From != "" && item.To != "" {
+				event.Title += fmt.Sprintf(" %s to %s", item.From, item.To)
+			}
+		}
+	case emailItemHotel:
+		event.Location = item.Location
+		if !strings.HasPrefix(strings.ToLower(event.Title), "hotel") {
+			event.Title = "Hotel: " + event.Title
+		}
+	}
+	if item.Reference != "" {
+		event.Description = "Booking reference " + item.Reference
+	}
+	return event, true
+}
+
+// describeEmailItem describes an item for prompts
+func describeEmailItem(item emailItem, now time.Time) string {
+	at := func(t *time.Time) string {
+		return t.In(now.Location()).Format("Monday, January 2 at 3:04 PM")
+	}
+
+	var parts []string
+	switch item.Kind {
+	case emailItemFlight:
+		flight := "Flight " + item.FlightNumber
+		if item.From != "" && item.To != "" {
+			flight += fmt.Sprintf(" from %s to %s", item.From, item.To)
+		}
+		parts = append(parts, flight)
+		if item.Start != nil {
+			parts = append(parts, "departing "+at(item.Start))
+		}
+		if item.End != nil {
+			parts = append(parts, "arriving "+at(item.End))
+		}
+	case emailItemHotel:
+		parts = append(parts, "Hotel stay at "+item.Title)
+		if item.Location != "" {
+			parts = append(parts, item.Location)
+		}
+		if item.Start != nil {
+			parts = append(parts, "checking in "+at(item.Start))
+		}
+		if item.End != nil {
+			parts = append(parts, "checking out "+at(item.End))
+		}
+	case emailItemParcel:
+		parts = append(parts, "Parcel: "+item.Title)
+		if item.Carrier != "" {
+			parts = append(parts, "shipped with "+item.Carrier)
+		}
+		if item.TrackingNumber != "" {
+			parts = append(parts, "tracking number "+item.TrackingNumber)
+		}
+		if item.ExpectedDelivery != nil {
+			parts = append(parts, "expected "+item.ExpectedDelivery.In(now.Location()).Format("Monday, January 2"))
+		}
+	}
+	if item.Reference != "" {
+		parts = append(parts, "booking reference "+item.Reference)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// current reports whether an item is still worth a card: flights and stays that haven't
+// ended, and parcels that aren't expected before today
+func (i emailItem) current(now time.Time) bool {
+	switch {
+	case i.End != nil:
+		return i.End.After(now)
+	case i.Start != nil:
+		return i.Start.After(now.Add(-defaultEventLength))
+	case i.ExpectedDelivery != nil:
+		today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+		return !i.ExpectedDelivery.Before(today)
+	}
+	return true
+}
+
+// jsonLDEmailItems reads the flight and hotel reservations and parcel deliveries in a JSON-LD block
+func jsonLDEmailItems(block string, loc *time.Location) []emailItem {
+	var data any
+	err := json.Unmarshal([]byte(block), &data)
+	if err != nil {
+		return nil
+	}
+
+	var items []emailItem
+	var walk func(value any)
+	walk = func(value any) {
+		switch value := value.(type) {
+		case []any:
+			for _, node := range value {
+				walk(node)
+			}
+		case map[string]any:
+			if graph, ok := value["@graph"]; ok {
+				walk(graph)
+				return
+			}
+			items = append(items, jsonLDNodeItems(value, loc)...)
+		}
+	}
+	walk(data)
+	return items
+}
+
+// jsonLDNodeItems reads a single schema.org node, a reservation can be for several flights
+func jsonLDNodeItems(node map[string]any, loc *time.Location) []emailItem {
+	var items []emailItem
+	reference := jsonLDText(node["reservationNumber"])
+	for _, reserved := range jsonLDNodes(node["reservationFor"]) {
+		switch jsonLDType(node) {
+		case "FlightReservation":
+			item := emailItem{
+				Kind:         emailItemFlight,
+				Reference:    reference,
+				FlightNumber: jsonLDText(reserved["flightNumber"]),
+				From:         jsonLDAirport(reserved["departureAirport"]),
+				To:           jsonLDAirport(reserved["arrivalAirport"]),
+				Start:        emailItemTime(jsonLDText(reserved["departureTime"]), loc),
+				End:          emailItemTime(jsonLDText(reserved["arrivalTime"]), loc),
+			}
+			// the number is often given without the airline
+			if airline := jsonLDText(jsonLDNodes(reserved["airline"])[0]["iataCode"]); airline != "" && !strings.HasPrefix(item.FlightNumber, airline) {
+				item.FlightNumber = airline + item.FlightNumber
+			}
+			item.Title = strings.TrimSpace("Flight " + item.FlightNumber)
+			items = append(items, item)
+		case "LodgingReservation":
+			start := node["checkinTime"]
+			if start == nil {
+				start = node["checkinDate"]
+			}
+			end := node["checkoutTime"]
+			if end == nil {
+				end = node["checkoutDate"]
+			}
+			items = append(items, emailItem{
+				Kind:      emailItemHotel,
+				Title:     jsonLDText(reserved["name"]),
+				Reference: reference,
+				Location:  jsonLDAddress(reserved["address"]),
+				Start:     emailItemTime(jsonLDText(start), loc),
+				End:       emailItemTime(jsonLDText(end), loc),
+			})
+		}
+	}
+
+	if jsonLDType(node) == "ParcelDelivery" {
+		carrier := jsonLDText(node["carrier"])
+		if carrier == "" {
+			carrier = jsonLDText(node["provider"])
+		}
+		expected := jsonLDText(node["expectedArrivalUntil"])
+		if expected == "" {
+			expected = jsonLDText(node["expectedArrivalFrom"])
+		}
+		title := jsonLDText(node["itemShipped"])
+		if title == "" {
+			title = "Parcel"
+		}
+		items = append(items, emailItem{
+			Kind:             emailItemParcel,
+			Title:            title,
+			Reference:        jsonLDText(jsonLDNodes(node["partOfOrder"])[0]["orderNumber"]),
+			TrackingNumber:   jsonLDText(node["trackingNumber"]),
+			Carrier:          carrier,
+			TrackingURL:      jsonLDText(node["trackingUrl"]),
+			ExpectedDelivery: emailItemTime(expected, loc),
+		})
+	}
+
+	var useful []emailItem
+	for _, item := range items {
+		if item.useful() {
+			useful = append(useful, item)
+		}
+	}
+	return useful
+}
+
+// jsonLDType is the schema.org type of a node, without the vocabulary
+func jsonLDType(node map[string]any) string {
+	value := node["@type"]
+	if types, ok := value.([]any); ok && len(types) > 0 {
+		value = types[0]
+	}
+	name, _ := value.(string)
+	return name[strings.LastIndex(name, "/")+1:]
+}
+
+// jsonLDNodes returns a value as a list of nodes, always with at least one so fields can be read from it
+func jsonLDNodes(value any) []map[string]any {
+	var nodes []map[string]any
+	switch value := value.(type) {
+	case map[string]any:
+		nodes = append(nodes, value)
+	case []any:
+		for _, item := range value {
+			if node, ok := item.(map[string]any); ok {
+				nodes = append(nodes, node)
+			}
+		}
+	}
+	if len(nodes) == 0 {
+		nodes = append(nodes, map[string]any{})
+	}
+	return nodes
+}
+
+// jsonLDText reads a value as text, using the name of a node
+func jsonLDText(value any) string {
+	switch value := value.(type) {
+	case string:
+		return strings.TrimSpace(value)
+	case float64:
+		return fmt.Sprint(value)
+	case map[string]any:
+		return jsonLDText(value["name"])
+	case []any:
+		if len(value) > 0 {
+			return jsonLDText(value[0])
+		}
+	}
+	return ""
+}
+
+// jsonLDAirport prefers the IATA code of an airport to its name
+func jsonLDAirport(value any) string {
+	airport := jsonLDNodes(value)[0]
+	if code := jsonLDText(airport["iataCode"]); code != "" {
+		return code
+	}
+	return jsonLDText(value)
+}
+
+// jsonLDAddress reads a text address or a PostalAddress
+func jsonLDAddress(value any) string {
+	if text, ok := value.(string); ok {
+		return strings.TrimSpace(text)
+	}
+	address := jsonLDNodes(value)[0]
+	var parts []string
+	for _, field := range []string{"streetAddress", "addressLocality", "postalCode", "addressCountry"} {
+		if part := jsonLDText(address[field]); part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// emailItemTime parses a date or time, in loc unless it has an offset
+func emailItemTime(value string, loc *time.Location) *time.Time {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil
+	}
+	t, _, err := parseCalendarTime(value, loc)
+	if err != nil {
+		// times are often given without seconds
+		t, err = time.ParseInLocation("2006-01-02T15:04", value, loc)
+		if err != nil {
+			return nil
+		}
+	}
+	return &t
+}
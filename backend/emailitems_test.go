@@ -0,0 +1,186 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testFlightJSONLD = `{
+	"@context": "http://schema.org",
+	"@type": "FlightReservation",
+	"reservationNumber": "RXJ34P",
+	"reservationFor": {
+		"@type": "Flight",
+		"flightNumber": "110",
+		"airline": {"@type": "Airline", "name": "United", "iataCode": "UA"},
+		"departureAirport": {"@type": "Airport", "name": "San Francisco Airport", "iataCode": "SFO"},
+		"departureTime": "2030-03-04T20:15:00-08:00",
+		"arrivalAirport": {"@type": "Airport", "name": "John F. Kennedy International Airport", "iataCode": "JFK"},
+		"arrivalTime": "2030-03-05T06:30:00-05:00"
+	}
+}`
+
+func TestJSONLDEmailItems(t *testing.T) {
+	items := jsonLDEmailItems(testFlightJSONLD, time.UTC)
+	require.Len(t, items, 1)
+	require.Equal(t, emailItemFlight, items[0].Kind)
+	require.Equal(t, "UA110", items[0].FlightNumber)
+	require.Equal(t, "RXJ34P", items[0].Reference)
+	require.Equal(t, "SFO", items[0].From)
+	require.Equal(t, "JFK", items[0].To)
+	require.Equal(t, time.Date(2030, 3, 5, 4, 15, 0, 0, time.UTC), *items[0].Start)
+
+	// hotels with dates, in a graph with other nodes
+	items = jsonLDEmailItems(`{"@context": "https://schema.org", "@graph": [
+		{"@type": "Organization", "name": "Hotels"},
+		{"@type": ["LodgingReservation"], "reservationNumber": "H-1", "checkinDate": "2030-03-05", "checkoutDate": "2030-03-07",
+		 "reservationFor": {"@type": "LodgingBusiness", "name": "Grand Hotel", "address": {"streetAddress": "1 Main St", "addressLocality": "New York"}}}
+	]}`, time.UTC)
+	require.Equal(t, []emailItem{{
+		Kind:      emailItemHotel,
+		Title:     "Grand Hotel",
+		Reference: "H-1",
+		Location:  "1 Main St, New York",
+		Start:     emailItemTime("2030-03-05", time.UTC),
+		End:       emailItemTime("2030-03-07", time.UTC),
+	}}, items)
+
+	// parcels, as a list
+	items = jsonLDEmailItems(`[{"@type": "http://schema.org/ParcelDelivery", "trackingNumber": "1Z999", "carrier": {"name": "UPS"},
+		"trackingUrl": "https://ups.example/track/1Z999", "expectedArrivalUntil": "2030-03-02T18:00:00Z",
+		"itemShipped": {"@type": "Product", "name": "Headphones"}, "partOfOrder": {"orderNumber": "123-456"}}]`, time.UTC)
+	require.Equal(t, []emailItem{{
+		Kind:             emailItemParcel,
+		Title:            "Headphones",
+		Reference:        "123-456",
+		TrackingNumber:   "1Z999",
+		Carrier:          "UPS",
+		TrackingURL:      "https://ups.example/track/1Z999",
+		ExpectedDelivery: emailItemTime("2030-03-02T18:00:00Z", time.UTC),
+	}}, items)
+
+	// other markup and broken blocks are ignored
+	require.Empty(t, jsonLDEmailItems(`{"@type": "EmailMessage", "potentialAction": {"@type": "ViewAction"}}`, time.UTC))
+	require.Empty(t, jsonLDEmailItems(`{"@type": `, time.UTC))
+}
+
+func TestExtractedEmail_StructuredDataAndCalendar(t *testing.T) {
+	writer := &mockCalendarWriter{createEventErrors: []error{errors.New("calendar is down"), errCalendarEventExists}}
+	o := &mockOpenWebUIClient{}
+	messages := staticEmail{
+		{UID: 1, Folder: "INBOX", MessageID: "flight@test", Subject: "Your flight", StructuredData: []string{testFlightJSONLD}},
+		{UID: 2, Folder: "INBOX", MessageID: "phish@test", Subject: "Your flight", StructuredData: []string{testFlightJSONLD}, Risk: &emailRisk{Risky: true}},
+	}
+	setupTestEmailEnvVars(t)
+	client, err := newExtractedEmailClient(messages, o, writer, time.UTC)
+	require.NoError(t, err)
+
+	unread, err := client.getUnread()
+	require.NoError(t, err)
+	require.Len(t, unread[0].Items, 1)
+	require.Equal(t, "UA110", unread[0].Items[0].FlightNumber)
+	// nothing is taken from phishing
+	require.Empty(t, unread[1].Items)
+	// the markup is enough, the model isn't asked
+	require.Empty(t, o.generateJSONArgs)
+
+	require.Len(t, writer.createEventArgs, 1)
+	event := writer.createEventArgs[0]
+	require.Equal(t, "Flight UA110 SFO to JFK", event.Title)
+	require.Equal(t, "SFO", event.Location)
+	require.Equal(t, "Booking reference RXJ34P", event.Description)
+	require.Equal(t, time.Date(2030, 3, 5, 11, 30, 0, 0, time.UTC), event.End)
+
+	// an event that couldn't be added is tried again on the next refresh, and a duplicate isn't an error
+	unread, err = client.getUnread()
+	require.NoError(t, err)
+	require.Len(t, unread[0].Items, 1)
+	require.Len(t, writer.createEventArgs, 2)
+	require.Equal(t, event, writer.createEventArgs[1])
+
+	// once it is in the calendar it isn't added again
+	_, err = client.getUnread()
+	require.NoError(t, err)
+	require.Len(t, writer.createEventArgs, 2)
+
+	// a reminder about the same flight gets the same UID, so it is only added once
+	reminder := messages[0]
+	reminder.UID, reminder.MessageID, reminder.Subject = 3, "reminder@test", "Your flight is tomorrow"
+	writer = &mockCalendarWriter{}
+	client, err = newExtractedEmailClient(staticEmail{messages[0], reminder}, o, writer, time.UTC)
+	require.NoError(t, err)
+	unread, err = client.getUnread()
+	require.NoError(t, err)
+	require.Len(t, unread[1].Items, 1)
+	require.Len(t, writer.createEventArgs, 1)
+	require.Equal(t, event.UID, writer.createEventArgs[0].UID)
+}
+
+func TestExtractedEmail_ModelFallback(t *testing.T) {
+	o := &mockOpenWebUIClient{generateJSONReturns: `{"items": [
+		{"kind": "parcel", "title": "Running shoes", "reference": "", "flight_number": "", "from": "", "to": "", "location": "", "start": "", "end": "",
+		 "tracking_number": "JD0002", "carrier": "DHL", "tracking_url": "", "expected_delivery": "2030-03-02"},
+		{"kind": "hotel", "title": "Somewhere", "reference": "", "flight_number": "", "from": "", "to": "", "location": "", "start": "", "end": "",
+		 "tracking_number": "", "carrier": "", "tracking_url": "", "expected_delivery": ""}
+	]}`}
+	messages := staticEmail{
+		{UID: 1, Folder: "INBOX", MessageID: "shipped@test", Subject: "Your order has shipped", Text: "Tracking number JD0002 with DHL."},
+		{UID: 2, Folder: "INBOX", MessageID: "lunch@test", Subject: "Lunch", Text: "Friday?"},
+	}
+	// without EMAIL_CALENDAR_EVENTS nothing is added to the calendar
+	writer := &mockCalendarWriter{}
+	setupTestEmailEnvVars(t)
+	t.Setenv("EMAIL_CALENDAR_EVENTS", "")
+	client, err := newExtractedEmailClient(messages, o, writer, time.UTC)
+	require.NoError(t, err)
+
+	unread, err := client.getUnread()
+	require.NoError(t, err)
+	// items without the details their card needs are dropped
+	require.Equal(t, []emailItem{{
+		Kind:             emailItemParcel,
+		Title:            "Running shoes",
+		TrackingNumber:   "JD0002",
+		Carrier:          "DHL",
+		ExpectedDelivery: emailItemTime("2030-03-02", time.UTC),
+	}}, unread[0].Items)
+	// only messages that look like bookings or shipping notices are sent to the model
+	require.Len(t, o.generateJSONArgs, 1)
+	require.Contains(t, o.generateJSONArgs[0], "Tracking number JD0002")
+	require.Empty(t, writer.createEventArgs)
+
+	// a model failure is cached as no items, so the model isn't asked again on every refresh
+	o = &mockOpenWebUIClient{generateJSONErrors: []error{errors.New("model is down")}}
+	failing, err := newExtractedEmailClient(messages[:1], o, writer, time.UTC)
+	require.NoError(t, err)
+	unread, err = failing.getUnread()
+	require.NoError(t, err)
+	require.Empty(t, unread[0].Items)
+	_, err = failing.getUnread()
+	require.NoError(t, err)
+	require.Len(t, o.generateJSONArgs, 1)
+}
+
+func TestEmailItemPrompts(t *testing.T) {
+	now := time.Date(2030, 3, 4, 12, 0, 0, 0, time.UTC)
+	at := func(value string) *time.Time { return emailItemTime(value, time.UTC) }
+	flight := emailItem{Kind: emailItemFlight, Title: "Flight UA110", FlightNumber: "UA110", From: "SFO", To: "JFK", Start: at("2030-03-05T04:15:00"), End: at("2030-03-05T11:30:00")}
+	messages := []emailMessage{
+		{Items: []emailItem{flight, {Kind: emailItemParcel, Title: "Headphones", TrackingNumber: "1Z999", ExpectedDelivery: at("2030-03-01")}}},
+		// the reminder for the same flight makes no second card
+		{Items: []emailItem{flight, {Kind: emailItemHotel, Title: "Grand Hotel", Reference: "H-1", Start: at("2030-03-10"), End: at("2030-03-12")}}},
+	}
+
+	prompts := emailItemPrompts(messages, now)
+	require.Len(t, prompts, 2)
+	require.Equal(t, "email_item_flight_UA110_20300305", prompts[0].key)
+	require.Equal(t, "email-item:flight_UA110_20300305|in 16 hours", prompts[0].seenKey)
+	require.Equal(t, priorityHigh, prompts[0].priority)
+	require.Contains(t, prompts[0].prompt, "Flight UA110 from SFO to JFK, departing Tuesday, March 5 at 4:15 AM")
+	require.Equal(t, "email_item_hotel_H-1", prompts[1].key)
+	require.Empty(t, prompts[1].priority)
+	require.Equal(t, "Grand Hotel", prompts[1].item.Title)
+}
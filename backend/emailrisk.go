@@ -0,0 +1,474 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// emailRisk is how likely a message is phishing or spam, and why
+type emailRisk struct {
+	Score float64 `json:"score"`
+	// Risky messages are flagged, and their content is kept out of summaries
+	Risky   bool     `json:"risky"`
+	Reasons []string `json:"reasons"`
+}
+
+// riskScoredEmail wraps an email source, scoring each message for phishing with
+// header and link heuristics combined with the model's judgment
+type riskScoredEmail struct {
+	source emailClient
+	o      openWebUIClient
+	// trusted are the domains whose lookalikes are suspicious
+	trusted   []string
+	threshold float64
+
+	cache *ttlCache[emailRisk]
+}
+
+// emailModelRisk is the structured output the model fills in
+type emailModelRisk struct {
+	Probability float64 `json:"phishing_probability"`
+	Reasoning   string  `json:"reasoning"`
+}
+
+const (
+	emailRiskCacheDuration    = 24 * time.Hour
+	defaultEmailRiskThreshold = 0.5
+	// riskModelWeight is how much the model counts for compared to the heuristics,
+	// which are harder to talk around than the model
+	riskModelWeight = 0.4
+	// riskModelDecisive is how sure the model has to be to flag a message on its own,
+	// as its weight alone can't reach the threshold
+	riskModelDecisive = 0.9
+)
+
+// defaultTrustedDomains are commonly impersonated, when EMAIL_TRUSTED_DOMAINS is not set
+var defaultTrustedDomains = []string{"paypal.com", "apple.com", "google.com", "microsoft.com", "amazon.com", "netflix.com"}
+
+var (
+	authenticationResultPattern = regexp.MustCompile(`(?i)\b(spf|dkim|dmarc)\s*=\s*([a-z]+)`)
+	displayNameAddressPattern   = regexp.MustCompile(`[\w.+-]+@([\w-]+(\.[\w-]+)+)`)
+	// secondLevelSuffixes are used under country domains, like co.uk
+	secondLevelSuffixes = []string{"co", "com", "org", "net", "ac", "gov", "edu"}
+	// lookalikeReplacer undoes the usual character swaps in lookalike domains
+	lookalikeReplacer = strings.NewReplacer("rn", "m", "vv", "w", "0", "o", "1", "l", "3", "e", "5", "s", "i", "l")
+	// confusableReplacer turns Cyrillic, Greek and Latin letters that look like plain letters
+	// into them, so pаypal with a Cyrillic а reads as paypal
+	confusableReplacer = strings.NewReplacer(
+		"а", "a", "е", "e", "һ", "h", "і", "i", "ј", "j", "к", "k", "ӏ", "l", "о", "o", "р", "p", "с", "c",
+		"ԁ", "d", "ԛ", "q", "ѕ", "s", "ԝ", "w", "х", "x", "у", "y",
+		"α", "a", "ι", "i", "κ", "k", "ν", "v", "ο", "o", "ρ", "p", "υ", "u", "χ", "x",
+		"ı", "i", "ɑ", "a", "ɡ", "g",
+	)
+)
+
+var emailRiskSchema = map[string]any{
+	"type": "object",
+	"properties": map[string]any{
+		"phishing_probability": map[string]any{"type": "number", "description": "between 0 and 1"},
+		"reasoning":            map[string]any{"type": "string", "description": "one short sentence"},
+	},
+	"required":             []string{"phishing_probability", "reasoning"},
+	"additionalProperties": false,
+}
+
+// newRiskScoredEmailClient reads the trusted domains from EMAIL_TRUSTED_DOMAINS, adding the domains
+// of the user's own addresses and contacts, and the score messages are flagged at from EMAIL_RISK_THRESHOLD
+func newRiskScoredEmailClient(source emailClient, o openWebUIClient) (emailClient, error) {
+	trusted := lowerAll(envList("EMAIL_TRUSTED_DOMAINS"))
+	if len(trusted) == 0 {
+		trusted = slices.Clone(defaultTrustedDomains)
+	}
+	for _, address := range append(emailOwnAddresses(), lowerAll(envList("EMAIL_CONTACTS"))...) {
+		if _, domain, ok := strings.Cut(address, "@"); ok && domain != "" && !slices.Contains(trusted, domain) {
+			trusted = append(trusted, domain)
+		}
+	}
+
+	threshold, err := envFloat("EMAIL_RISK_THRESHOLD", defaultEmailRiskThreshold)
+	if err != nil {
+		return nil, err
+	}
+
+	cache, err := newSourceCache[emailRisk]("EMAIL_RISK", emailRiskCacheDuration, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return &riskScoredEmail{
+		source:    source,
+		o:         o,
+		trusted:   trusted,
+		threshold: threshold,
+		cache:     cache,
+	}, nil
+}
+
+func (c *riskScoredEmail) getUnread() ([]emailMessage, error) {
+	messages, err := c.source.getUnread()
+	if err != nil {
+		return nil, err
+	}
+
+	// concurrently score each message, a message is only scored once
+	scored := make([]emailMessage, len(messages))
+	copy(scored, messages)
+	var wg sync.WaitGroup
+	for i := range scored {
+		wg.Add(1)
+		go func(message *emailMessage) {
+			defer wg.Done()
+			risk, err := c.cache.get(emailSeenKey(*message), func() (emailRisk, error) {
+				return c.score(*message), nil
+			})
+			if err != nil {
+				return
+			}
+			message.Risk = &risk
+		}(&scored[i])
+	}
+	wg.Wait()
+
+	return scored, nil
+}
+
+// score weighs the model's judgment against the heuristics, falling back to the heuristics
+// alone if the model fails. A decisive heuristic, like a failed DMARC check, or a model that is
+// nearly sure flags the message on its own.
+func (c *riskScoredEmail) score(message emailMessage) emailRisk {
+	heuristic, reasons, decisive := c.heuristicRisk(message)
+
+	hints := "none"
+	if len(reasons) > 0 {
+		hints = strings.Join(reasons, "; ")
+	}
+	var links []string
+	for _, link := range message.Links {
+		links = append(links, fmt.Sprintf("%q -> %s", link.Text, link.URL))
+	}
+
+	result, err := c.o.generateJSON(fmt.Sprintf("You are an email security assistant. How likely is the email below to be phishing or a scam? Warning signs found so far: %s.\n \nFrom: %s\nSubject: %s\nLinks: %s\n%s", hints, message.From, message.Subject, strings.Join(links, ", "), message.Text), emailRiskSchema)
+	var model emailModelRisk
+	if err == nil {
+		err = json.Unmarshal([]byte(result), &model)
+	}
+	if err != nil {
+		fmt.Println(fmt.Errorf("cannot score email %q, using heuristics: %w", message.Subject, err))
+		return c.riskFromScore(heuristic, reasons, decisive)
+	}
+
+	probability := math.Max(0, math.Min(1, model.Probability))
+	score := riskModelWeight*probability + (1-riskModelWeight)*heuristic
+	if model.Reasoning != "" && probability >= 0.5 {
+		reasons = append(reasons, model.Reasoning)
+	}
+	return c.riskFromScore(score, reasons, decisive || probability >= riskModelDecisive)
+}
+
+func (c *riskScoredEmail) riskFromScore(score float64, reasons []string, decisive bool) emailRisk {
+	score = math.Round(score*100) / 100
+	return emailRisk{
+		Score:   score,
+		Risky:   decisive || score >= c.threshold,
+		Reasons: reasons,
+	}
+}
+
+// heuristicRisk scores the authentication results, sender and links of a message
+func (c *riskScoredEmail) heuristicRisk(message emailMessage) (float64, []string, bool) {
+	score := 0.0
+	var reasons []string
+	decisive := false
+	add := func(weight float64, reason string) {
+		if !slices.Contains(reasons, reason) {
+			score += weight
+			reasons = append(reasons, reason)
+		}
+	}
+
+	results := authenticationResults(message.AuthenticationResults)
+	if results["dmarc"] == "fail" {
+		add(0.6, "the DMARC check failed")
+		decisive = true
+	}
+	if results["spf"] == "fail" || results["spf"] == "softfail" {
+		add(0.25, "the SPF check failed")
+	}
+	if results["dkim"] == "fail" {
+		add(0.25, "the DKIM signature is not valid")
+	}
+
+	_, domain, _ := strings.Cut(message.FromAddress, "@")
+	if trusted, ok := c.lookalike(domain); ok {
+		add(0.6, fmt.Sprintf("the sender domain %s looks like %s", domain, trusted))
+		decisive = true
+	}
+	if match := displayNameAddressPattern.FindStringSubmatch(message.FromName); match != nil && !hostInDomain(domain, strings.ToLower(match[1])) {
+		add(0.4, fmt.Sprintf("the sender name shows %s but it was sent from %s", match[0], domain))
+	}
+	for _, trusted := range c.trusted {
+		name := domainLabel(trusted)
+		if len(name) >= 4 && strings.Contains(strings.ToLower(message.FromName), name) && domainLabel(domain) != name {
+			add(0.4, fmt.Sprintf("the sender name mentions %s but it was sent from %s", trusted, domain))
+		}
+	}
+	if _, replyDomain, ok := strings.Cut(message.ReplyTo, "@"); ok && replyDomain != domain {
+		add(0.15, "replies go to another domain, "+replyDomain)
+	}
+
+	for _, link := range message.Links {
+		parsed, err := url.Parse(link.URL)
+		if err != nil || parsed.Hostname() == "" {
+			continue
+		}
+		host := strings.ToLower(parsed.Hostname())
+		if net.ParseIP(host) != nil {
+			add(0.3, "a link points to an IP address")
+		}
+		if strings.Contains(host, "xn--") {
+			add(0.2, "a link uses an internationalized domain")
+		}
+		if trusted, ok := c.lookalike(host); ok {
+			add(0.4, fmt.Sprintf("a link goes to %s, which looks like %s", host, trusted))
+		}
+		// a link that shows one address but goes to another
+		shownURL, ok := linkTextURL(link.Text)
+		if !ok {
+			continue
+		}
+		if shown, err := url.Parse(shownURL); err == nil && shown.Hostname() != "" {
+			shownHost := strings.ToLower(shown.Hostname())
+			if !hostInDomain(host, shownHost) && !hostInDomain(shownHost, host) {
+				add(0.3, fmt.Sprintf("a link shows %s but goes to %s", shownHost, host))
+			}
+		}
+	}
+
+	return math.Min(1, score), reasons, decisive
+}
+
+// lookalike reports whether a domain imitates one of the trusted domains without being it
+func (c *riskScoredEmail) lookalike(domain string) (string, bool) {
+	if domain == "" {
+		return "", false
+	}
+	for _, trusted := range c.trusted {
+		if hostInDomain(domain, trusted) {
+			return "", false
+		}
+	}
+
+	// internationalized domains are compared by the letters they look like, other
+	// internationalized domains, like münchen.de, are left alone
+	internationalized := strings.Contains(domain, "xn--")
+	if internationalized {
+		decoded, err := decodeIDN(domain)
+		if err != nil {
+			return "", false
+		}
+		domain = confusableReplacer.Replace(decoded)
+	}
+
+	label := domainLabel(domain)
+	for _, trusted := range c.trusted {
+		trustedLabel := domainLabel(trusted)
+		if internationalized && label == trustedLabel {
+			return trusted, true
+		}
+		// the same name under another suffix, like amazon.co.uk, is most likely the real one
+		if len(trustedLabel) < 5 || label == trustedLabel {
+			continue
+		}
+		if lookalikeReplacer.Replace(label) == lookalikeReplacer.Replace(trustedLabel) {
+			return trusted, true
+		}
+		// a character or two off, like paypa1 or gooogle
+		distance := editDistance(label, trustedLabel)
+		if distance == 1 || (distance == 2 && len(trustedLabel) >= 8) {
+			return trusted, true
+		}
+		// the name as part of another domain, like paypal-secure.com or paypal.com.example.net,
+		// leaving out the top-level domain
+		if slices.Contains(strings.FieldsFunc(domain[:strings.LastIndex(domain, ".")+1], func(r rune) bool { return r == '-' || r == '.' }), trustedLabel) {
+			return trusted, true
+		}
+	}
+	return "", false
+}
+
+// authenticationResults reads the spf, dkim and dmarc results from an Authentication-Results header
+func authenticationResults(header string) map[string]string {
+	results := map[string]string{}
+	for _, match := range authenticationResultPattern.FindAllStringSubmatch(header, -1) {
+		method := strings.ToLower(match[1])
+		if _, ok := results[method]; !ok {
+			results[method] = strings.ToLower(match[2])
+		}
+	}
+	return results
+}
+
+// hostInDomain reports whether host is domain or one of its subdomains
+func hostInDomain(host, domain string) bool {
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+// domainLabel is the name part of a domain, like "example" for mail.example.com or example.co.uk
+func domainLabel(domain string) string {
+	labels := strings.Split(strings.TrimSuffix(domain, "."), ".")
+	if len(labels) < 2 {
+		return domain
+	}
+	if len(labels) >= 3 && len(labels[len(labels)-1]) == 2 && slices.Contains(secondLevelSuffixes, labels[len(labels)-2]) {
+		return labels[len(labels)-3]
+	}
+	return labels[len(labels)-2]
+}
+
+// linkTextURL returns the link text as a URL when the text is an address, like www.example.com
+func linkTextURL(text string) (string, bool) {
+	text = strings.TrimSpace(text)
+	if strings.ContainsAny(text, " \t\n") {
+		return "", false
+	}
+	if strings.HasPrefix(text, "http://") || strings.HasPrefix(text, "https://") {
+		return text, true
+	}
+	if strings.HasPrefix(text, "www.") {
+		return "https://" + text, true
+	}
+	return "", false
+}
+
+// editDistance is the Levenshtein distance between two strings
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
+
+// punycode parameters from RFC 3492
+const (
+	punycodeBase        = 36
+	punycodeTMin        = 1
+	punycodeTMax        = 26
+	punycodeSkew        = 38
+	punycodeDamp        = 700
+	punycodeInitialBias = 72
+	punycodeInitialN    = 128
+)
+
+// decodeIDN turns the xn-- labels of a domain back into Unicode
+func decodeIDN(domain string) (string, error) {
+	labels := strings.Split(domain, ".")
+	for i, label := range labels {
+		if !strings.HasPrefix(label, "xn--") {
+			continue
+		}
+		decoded, err := decodePunycode(label[len("xn--"):])
+		if err != nil {
+			return "", fmt.Errorf("cannot decode %s: %w", label, err)
+		}
+		labels[i] = decoded
+	}
+	return strings.Join(labels, "."), nil
+}
+
+// decodePunycode decodes a punycode label without its xn-- prefix, as in RFC 3492
+func decodePunycode(label string) (string, error) {
+	var output []rune
+	input := label
+	// the plain letters come first, before the last dash
+	if i := strings.LastIndex(label, "-"); i >= 0 {
+		for _, r := range label[:i] {
+			if r >= 0x80 {
+				return "", fmt.Errorf("label is not ASCII")
+			}
+			output = append(output, r)
+		}
+		input = label[i+1:]
+	}
+
+	n, bias, i := punycodeInitialN, punycodeInitialBias, 0
+	for pos := 0; pos < len(input); {
+		oldI, weight := i, 1
+		for k := punycodeBase; ; k += punycodeBase {
+			if pos == len(input) {
+				return "", fmt.Errorf("label ends in the middle of a code point")
+			}
+			digit, ok := punycodeDigit(input[pos])
+			if !ok {
+				return "", fmt.Errorf("invalid character %q", input[pos])
+			}
+			pos++
+			i += digit * weight
+			t := min(max(k-bias, punycodeTMin), punycodeTMax)
+			if digit < t {
+				break
+			}
+			weight *= punycodeBase - t
+			if i > utf8.MaxRune || weight > utf8.MaxRune {
+				return "", fmt.Errorf("code point is out of range")
+			}
+		}
+		bias = punycodeAdapt(i-oldI, len(output)+1, oldI == 0)
+		n += i / (len(output) + 1)
+		i %= len(output) + 1
+		if n > utf8.MaxRune {
+			return "", fmt.Errorf("code point is out of range")
+		}
+		output = slices.Insert(output, i, rune(n))
+		i++
+	}
+	return string(output), nil
+}
+
+func punycodeDigit(c byte) (int, bool) {
+	switch {
+	case c >= 'a' && c <= 'z':
+		return int(c - 'a'), true
+	case c >= 'A' && c <= 'Z':
+		return int(c - 'A'), true
+	case c >= '0' && c <= '9':
+		return int(c-'0') + 26, true
+	}
+	return 0, false
+}
+
+func punycodeAdapt(delta, points int, first bool) int {
+	if first {
+		delta /= punycodeDamp
+	} else {
+		delta /= 2
+	}
+	delta += delta / points
+	k := 0
+	for delta > (punycodeBase-punycodeTMin)*punycodeTMax/2 {
+		delta /= punycodeBase - punycodeTMin
+		k += punycodeBase
+	}
+	return k + (punycodeBase-punycodeTMin+1)*delta/(delta+punycodeSkew)
+}
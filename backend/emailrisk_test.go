@@ -0,0 +1,169 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewRiskScoredEmailClient(t *testing.T) {
+	setupTestEmailEnvVars(t)
+	client, err := newRiskScoredEmailClient(staticEmail{}, &mockOpenWebUIClient{})
+	require.NoError(t, err)
+	risk := client.(*riskScoredEmail)
+	require.Contains(t, risk.trusted, "paypal.com")
+	require.Contains(t, risk.trusted, "example.com")
+	require.Contains(t, risk.trusted, "family.example")
+	require.Equal(t, defaultEmailRiskThreshold, risk.threshold)
+
+	t.Setenv("EMAIL_RISK_THRESHOLD", "high")
+	_, err = newRiskScoredEmailClient(staticEmail{}, &mockOpenWebUIClient{})
+	require.Error(t, err)
+}
+
+func TestRiskScoredEmail_HeuristicRisk(t *testing.T) {
+	setupTestEmailEnvVars(t)
+	client, err := newRiskScoredEmailClient(staticEmail{}, &mockOpenWebUIClient{})
+	require.NoError(t, err)
+	risk := client.(*riskScoredEmail)
+
+	tests := []struct {
+		name     string
+		message  emailMessage
+		reason   string
+		decisive bool
+	}{
+		{
+			name:     "failed dmarc",
+			message:  emailMessage{FromAddress: "billing@shop.example", AuthenticationResults: "mx.example.com; spf=pass smtp.mailfrom=shop.example; dkim=none; dmarc=fail header.from=shop.example"},
+			reason:   "the DMARC check failed",
+			decisive: true,
+		},
+		{
+			name:     "lookalike sender",
+			message:  emailMessage{FromAddress: "service@paypa1.com"},
+			reason:   "the sender domain paypa1.com looks like paypal.com",
+			decisive: true,
+		},
+		{
+			name:     "brand in another domain",
+			message:  emailMessage{FromAddress: "alerts@paypal-secure.net"},
+			reason:   "the sender domain paypal-secure.net looks like paypal.com",
+			decisive: true,
+		},
+		{
+			name:     "confusable internationalized sender",
+			message:  emailMessage{FromAddress: "id@xn--80ak6aa92e.com"},
+			reason:   "the sender domain xn--80ak6aa92e.com looks like apple.com",
+			decisive: true,
+		},
+		{
+			name:    "spoofed display name",
+			message: emailMessage{FromName: "sam@family.example", FromAddress: "sam@mailer.example"},
+			reason:  "the sender name shows sam@family.example but it was sent from mailer.example",
+		},
+		{
+			name:    "brand in display name",
+			message: emailMessage{FromName: "PayPal Support", FromAddress: "support@helpdesk.example"},
+			reason:  "the sender name mentions paypal.com but it was sent from helpdesk.example",
+		},
+		{
+			name:    "mismatched link",
+			message: emailMessage{FromAddress: "news@shop.example", Links: []emailLink{{Text: "https://www.paypal.com/login", URL: "http://203.0.113.7/login"}}},
+			reason:  "a link shows www.paypal.com but goes to 203.0.113.7",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			score, reasons, decisive := risk.heuristicRisk(test.message)
+			require.Greater(t, score, 0.0)
+			require.Contains(t, reasons, test.reason)
+			require.Equal(t, test.decisive, decisive)
+		})
+	}
+
+	// the real domains and their subdomains are fine
+	for _, address := range []string{"service@paypal.com", "alerts@mail.google.com", "sam@family.example", "orders@amazon.co.uk", "info@xn--mnchen-3ya.de"} {
+		score, reasons, _ := risk.heuristicRisk(emailMessage{FromAddress: address, AuthenticationResults: "mx; spf=pass; dkim=pass; dmarc=pass"})
+		require.Zero(t, score, address)
+		require.Empty(t, reasons, address)
+	}
+}
+
+func TestRiskScoredEmail_GetUnread(t *testing.T) {
+	source := staticEmail{
+		{MessageID: "fine@test", FromAddress: "sam@family.example", Subject: "Dinner", Text: "Are you free Friday?"},
+		{MessageID: "phish@test", FromAddress: "security@rnicrosoft.com", Subject: "Password expires", Text: "Log in now."},
+		{MessageID: "odd@test", FromAddress: "deals@shop.example", Subject: "Urgent", Text: "Send gift cards."},
+	}
+	o := &mockOpenWebUIClient{generateJSONReturns: `{"phishing_probability":0.7,"reasoning":"Asks for gift cards."}`}
+	setupTestEmailEnvVars(t)
+	client, err := newRiskScoredEmailClient(source, o)
+	require.NoError(t, err)
+
+	messages, err := client.getUnread()
+	require.NoError(t, err)
+	require.Len(t, messages, 3)
+
+	// the model alone isn't enough to flag a message unless it is nearly sure
+	require.False(t, messages[0].Risk.Risky)
+	require.Equal(t, 0.28, messages[0].Risk.Score)
+
+	require.True(t, messages[1].Risk.Risky)
+	require.Contains(t, messages[1].Risk.Reasons, "the sender domain rnicrosoft.com looks like microsoft.com")
+	require.Contains(t, messages[1].Risk.Reasons, "Asks for gift cards.")
+
+	// scores are cached by message
+	_, err = client.getUnread()
+	require.NoError(t, err)
+	require.Len(t, o.generateJSONArgs, 3)
+}
+
+func TestRiskScoredEmail_ModelSure(t *testing.T) {
+	source := staticEmail{{MessageID: "odd@test", FromAddress: "deals@shop.example", Subject: "Urgent", Text: "Send gift cards."}}
+	o := &mockOpenWebUIClient{generateJSONReturns: `{"phishing_probability":0.95,"reasoning":"Asks for gift cards."}`}
+	setupTestEmailEnvVars(t)
+	client, err := newRiskScoredEmailClient(source, o)
+	require.NoError(t, err)
+
+	messages, err := client.getUnread()
+	require.NoError(t, err)
+	require.True(t, messages[0].Risk.Risky)
+	require.Equal(t, 0.38, messages[0].Risk.Score)
+	require.Equal(t, []string{"Asks for gift cards."}, messages[0].Risk.Reasons)
+}
+
+func TestRiskScoredEmail_ModelFailure(t *testing.T) {
+	source := staticEmail{{MessageID: "phish@test", FromAddress: "it@example.com", AuthenticationResults: "mx; dmarc=fail"}}
+	o := &mockOpenWebUIClient{generateJSONErrors: []error{errors.New("unavailable")}}
+	setupTestEmailEnvVars(t)
+	client, err := newRiskScoredEmailClient(source, o)
+	require.NoError(t, err)
+
+	messages, err := client.getUnread()
+	require.NoError(t, err)
+	require.True(t, messages[0].Risk.Risky)
+	require.Equal(t, []string{"the DMARC check failed"}, messages[0].Risk.Reasons)
+}
+
+func TestDecodeIDN(t *testing.T) {
+	domain, err := decodeIDN("www.xn--mnchen-3ya.de")
+	require.NoError(t, err)
+	require.Equal(t, "www.münchen.de", domain)
+
+	domain, err = decodeIDN("xn--pypal-4ve.com")
+	require.NoError(t, err)
+	require.Equal(t, "pаypal.com", domain)
+	require.Equal(t, "paypal.com", confusableReplacer.Replace(domain))
+
+	_, err = decodeIDN("xn--mnchen-3y!.de")
+	require.Error(t, err)
+}
+
+func TestEditDistance(t *testing.T) {
+	require.Equal(t, 0, editDistance("paypal", "paypal"))
+	require.Equal(t, 1, editDistance("paypa1", "paypal"))
+	require.Equal(t, 1, editDistance("gooogle", "google"))
+	require.Equal(t, 3, editDistance("", "abc"))
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// emailThread is a conversation, reconstructed from the Message-ID, In-Reply-To
+// and References headers of its messages
+type emailThread struct {
+	// ID is the Message-ID of the first message in the thread
+	ID      string
+	Subject string
+	// Messages are oldest first, and can include earlier read and sent messages
+	Messages []emailMessage
+	// Unread are the thread's unread messages, oldest first
+	Unread []emailMessage
+}
+
+// emailThreadSummary is the structured summary of a conversation
+type emailThreadSummary struct {
+	Summary       string   `json:"summary"`
+	Decisions     []string `json:"decisions"`
+	OpenQuestions []string `json:"open_questions"`
+	// WaitingOnUs are the people waiting for an answer from the user, and what for
+	WaitingOnUs []string `json:"waiting_on_us"`
+}
+
+var emailThreadSummarySchema = map[string]any{
+	"type": "object",
+	"properties": map[string]any{
+		"summary":        map[string]any{"type": "string", "description": "one or two sentences"},
+		"decisions":      map[string]any{"type": "array", "items": map[string]any{"type": "string"}},
+		"open_questions": map[string]any{"type": "array", "items": map[string]any{"type": "string"}},
+		"waiting_on_us":  map[string]any{"type": "array", "items": map[string]any{"type": "string"}, "description": "who is waiting on me and for what"},
+	},
+	"required":             []string{"summary", "decisions", "open_questions", "waiting_on_us"},
+	"additionalProperties": false,
+}
+
+// groupEmailThreads groups messages that reference each other, directly or through
+// a shared ancestor, into threads. Threads with the newest messages come first.
+func groupEmailThreads(messages []emailMessage) []emailThread {
+	// union-find over Message-IDs, so a reply links its whole chain of references
+	parent := map[string]string{}
+	var find func(id string) string
+	find = func(id string) string {
+		if _, ok := parent[id]; !ok {
+			parent[id] = id
+		}
+		if parent[id] != id {
+			parent[id] = find(parent[id])
+		}
+		return parent[id]
+	}
+	union := func(a, b string) {
+		parent[find(b)] = find(a)
+	}
+
+	keys := make([]string, len(messages))
+	for i, message := range messages {
+		keys[i] = emailSeenKey(message)
+		for _, id := range emailMessageAncestors(message) {
+			union("email:"+id, keys[i])
+		}
+	}
+
+	byRoot := map[string]*emailThread{}
+	var threads []*emailThread
+	for i, message := range messages {
+		root := find(keys[i])
+		thread, ok := byRoot[root]
+		if !ok {
+			thread = &emailThread{}
+			byRoot[root] = thread
+			threads = append(threads, thread)
+		}
+		thread.Unread = append(thread.Unread, message)
+	}
+
+	grouped := make([]emailThread, len(threads))
+	for i, thread := range threads {
+		sortEmailsByDate(thread.Unread)
+		thread.Messages = thread.Unread
+		thread.ID, thread.Subject = emailThreadStart(thread.Messages)
+		grouped[i] = *thread
+	}
+	sort.SliceStable(grouped, func(i, j int) bool {
+		return grouped[i].latest().Date.After(grouped[j].latest().Date)
+	})
+	return grouped
+}
+
+// emailMessageAncestors are the Message-IDs a message replies to, directly or not
+func emailMessageAncestors(message emailMessage) []string {
+	ancestors := slices.Clone(message.References)
+	if message.InReplyTo != "" && !slices.Contains(ancestors, message.InReplyTo) {
+		ancestors = append(ancestors, message.InReplyTo)
+	}
+	return ancestors
+}
+
+// emailThreadStart returns the ID and subject of a thread from its oldest message
+func emailThreadStart(messages []emailMessage) (string, string) {
+	first := messages[0]
+	id := first.MessageID
+	if references := emailMessageAncestors(first); len(references) > 0 {
+		id = references[0]
+	}
+	return id, emailReplyPrefixPattern.ReplaceAllString(first.Subject, "")
+}
+
+func sortEmailsByDate(messages []emailMessage) {
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].Date.Before(messages[j].Date)
+	})
+}
+
+// latest is the newest unread message of the thread
+func (t emailThread) latest() emailMessage {
+	return t.Unread[len(t.Unread)-1]
+}
+
+// needsResponse returns the newest unread message that needs a response, if any
+func (t emailThread) needsResponse() (emailMessage, bool) {
+	for i := len(t.Unread) - 1; i >= 0; i-- {
+		if t.Unread[i].Classification != nil && t.Unread[i].Classification.NeedsResponse {
+			return t.Unread[i], true
+		}
+	}
+	return emailMessage{}, false
+}
+
+// withContext adds the earlier messages of the thread that aren't unread, like the
+// user's own replies, so the summary covers the whole conversation
+func (t emailThread) withContext(threader emailThreader) emailThread {
+	if threader == nil || len(emailMessageAncestors(t.latest())) == 0 {
+		return t
+	}
+	earlier, err := threader.thread(t.latest())
+	if err != nil {
+		fmt.Println(fmt.Errorf("cannot get earlier messages of %q: %w", t.Subject, err))
+		return t
+	}
+
+	messages := slices.Clone(t.Unread)
+	for _, message := range earlier {
+		if !slices.ContainsFunc(messages, func(other emailMessage) bool { return other.MessageID == message.MessageID }) {
+			messages = append(messages, message)
+		}
+	}
+	sortEmailsByDate(messages)
+	t.Messages = messages
+	t.ID, t.Subject = emailThreadStart(messages)
+	return t
+}
+
+// seenKey identifies the thread as of its newest unread message, so it is summarized again when a reply arrives
+func (t emailThread) seenKey() string {
+	return "email-thread:" + t.ID + "|" + emailSeenKey(t.latest())
+}
+
+// summarizeEmailThread asks the model for the decisions, open questions and people waiting on the user in a conversation
+func summarizeEmailThread(o openWebUIClient, thread emailThread) (emailThreadSummary, error) {
+	var conversation strings.Builder
+	for _, message := range thread.Messages {
+		from := message.From
+		if message.FromMe {
+			from += " (me)"
+		}
+		status := "read"
+		if slices.ContainsFunc(thread.Unread, func(unread emailMessage) bool { return emailSeenKey(unread) == emailSeenKey(message) }) {
+			status = "unread"
+		}
+		fmt.Fprintf(&conversation, "From: %s\nDate: %s (%s)\n%s\n\n", from, message.Date.Format("Mon, 2 Jan 2006 15:04"), status, message.Text)
+	}
+
+	result, err := o.generateJSON(fmt.Sprintf("You are an email assistant. Summarize the email conversation below for me: what it is about, the decisions made, the questions still open, and who is waiting on me for what.\n \nSubject: %s\n \n%s", thread.Subject, conversation.String()), emailThreadSummarySchema)
+	if err != nil {
+		return emailThreadSummary{}, fmt.Errorf("cannot summarize thread: %w", err)
+	}
+
+	var summary emailThreadSummary
+	err = json.Unmarshal([]byte(result), &summary)
+	if err != nil {
+		return emailThreadSummary{}, fmt.Errorf("cannot unmarshal thread summary: %w", err)
+	}
+	if strings.TrimSpace(summary.Summary) == "" {
+		return emailThreadSummary{}, fmt.Errorf("thread summary is empty")
+	}
+	return summary, nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type mockEmailThreader struct {
+	mu            sync.Mutex
+	threadArgs    []emailMessage
+	threadReturns []emailMessage
+	threadErrors  []error
+	// active and maxActive count the calls in progress, which last delay
+	active    int
+	maxActive int
+	delay     time.Duration
+}
+
+func (m *mockEmailThreader) thread(message emailMessage) ([]emailMessage, error) {
+	m.mu.Lock()
+	m.threadArgs = append(m.threadArgs, message)
+	calls := len(m.threadArgs)
+	m.active++
+	m.maxActive = max(m.maxActive, m.active)
+	m.mu.Unlock()
+
+	time.Sleep(m.delay)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.active--
+	if len(m.threadErrors) > 0 {
+		return nil, m.threadErrors[calls-1]
+	}
+	return m.threadReturns, nil
+}
+
+func testThreadMessages() []emailMessage {
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 10, 0, 0, 0, time.UTC) }
+	return []emailMessage{
+		{MessageID: "c@test", Subject: "Re: Offsite", Date: day(4), InReplyTo: "b@test", References: []string{"a@test", "b@test"}},
+		{MessageID: "lunch@test", Subject: "Lunch?", Date: day(3)},
+		// only references its parent, but still joins the thread through it
+		{MessageID: "d@test", Subject: "Re: Offsite", Date: day(5), InReplyTo: "c@test"},
+		{MessageID: "b@test", Subject: "RE: Offsite", Date: day(2), InReplyTo: "a@test", References: []string{"a@test"}},
+		{Folder: "INBOX", UID: 9, Subject: "No ID", Date: day(1)},
+	}
+}
+
+func TestGroupEmailThreads(t *testing.T) {
+	threads := groupEmailThreads(testThreadMessages())
+	require.Len(t, threads, 3)
+
+	// newest thread first, its messages oldest first
+	require.Equal(t, "a@test", threads[0].ID)
+	require.Equal(t, "Offsite", threads[0].Subject)
+	require.Len(t, threads[0].Unread, 3)
+	require.Equal(t, "b@test", threads[0].Unread[0].MessageID)
+	require.Equal(t, "d@test", threads[0].latest().MessageID)
+
+	require.Equal(t, "lunch@test", threads[1].ID)
+	require.Equal(t, "Lunch?", threads[1].Subject)
+	require.Equal(t, "No ID", threads[2].Subject)
+}
+
+func TestEmailThread_NeedsResponse(t *testing.T) {
+	messages := testThreadMessages()
+	messages[3].Classification = &emailClassification{NeedsResponse: true}
+	thread := groupEmailThreads(messages)[0]
+
+	message, ok := thread.needsResponse()
+	require.True(t, ok)
+	require.Equal(t, "b@test", message.MessageID)
+
+	_, ok = groupEmailThreads(messages)[1].needsResponse()
+	require.False(t, ok)
+}
+
+func TestEmailThread_WithContext(t *testing.T) {
+	thread := groupEmailThreads(testThreadMessages())[0]
+	threader := &mockEmailThreader{threadReturns: []emailMessage{
+		{MessageID: "a@test", Subject: "Offsite", Date: time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC), FromMe: true},
+		{MessageID: "b@test", Subject: "RE: Offsite", Date: time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)},
+	}}
+
+	withContext := thread.withContext(threader)
+	require.Equal(t, "d@test", threader.threadArgs[0].MessageID)
+	require.Len(t, withContext.Messages, 4)
+	require.True(t, withContext.Messages[0].FromMe)
+	require.Len(t, withContext.Unread, 3)
+	require.Equal(t, thread.seenKey(), withContext.seenKey())
+
+	// without earlier messages the thread is summarized from the unread ones
+	failing := &mockEmailThreader{threadErrors: []error{errors.New("unavailable")}}
+	require.Len(t, thread.withContext(failing).Messages, 3)
+	require.Len(t, thread.withContext(nil).Messages, 3)
+}
+
+func TestSummarizeEmailThread(t *testing.T) {
+	thread := groupEmailThreads(testThreadMessages())[0]
+	thread.Messages = append([]emailMessage{{MessageID: "a@test", From: "Me <me@example.com>", Text: "Offsite in March?", FromMe: true}}, thread.Messages...)
+	o := &mockOpenWebUIClient{generateJSONReturns: `{"summary":"Planning the offsite.","decisions":["March"],"open_questions":["Which venue?"],"waiting_on_us":["Sam, for the budget"]}`}
+
+	summary, err := summarizeEmailThread(o, thread)
+	require.NoError(t, err)
+	require.Equal(t, emailThreadSummary{
+		Summary:       "Planning the offsite.",
+		Decisions:     []string{"March"},
+		OpenQuestions: []string{"Which venue?"},
+		WaitingOnUs:   []string{"Sam, for the budget"},
+	}, summary)
+	require.Contains(t, o.generateJSONArgs[0], "From: Me <me@example.com> (me)")
+	require.Contains(t, o.generateJSONArgs[0], "(read)")
+	require.Contains(t, o.generateJSONArgs[0], "(unread)")
+
+	_, err = summarizeEmailThread(&mockOpenWebUIClient{generateJSONReturns: `{"summary":""}`}, thread)
+	require.Error(t, err)
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// feedNews is a news source that merges RSS 2.0 and Atom feeds by recency
+type feedNews struct {
+	feedURLs []string
+	maxItems int
+
+	cache *ttlCache[[]newsResult]
+
+	// conditional request state and last items, per feed URL
+	mu    sync.Mutex
+	feeds map[string]*feedState
+}
+
+type feedState struct {
+	etag         string
+	lastModified string
+	items        []newsResult
+}
+
+// rssFeed is the subset of RSS 2.0 that is mapped to newsResult
+type rssFeed struct {
+	Channel struct {
+		Title string `xml:"title"`
+		Items []struct {
+			Title       string `xml:"title"`
+			Link        string `xml:"link"`
+			Description string `xml:"description"`
+			PubDate     string `xml:"pubDate"`
+		} `xml:"item"`
+	} `xml:"channel"`
+}
+
+// atomFeed is the subset of Atom that is mapped to newsResult
+type atomFeed struct {
+	Title   string `xml:"title"`
+	Entries []struct {
+		Title string `xml:"title"`
+		Links []struct {
+			Href string `xml:"href,attr"`
+			Rel  string `xml:"rel,attr"`
+		} `xml:"link"`
+		Summary   string `xml:"summary"`
+		Content   string `xml:"content"`
+		Published string `xml:"published"`
+		Updated   string `xml:"updated"`
+	} `xml:"entry"`
+}
+
+const defaultFeedMaxItems = 30
+
+// feedDateLayouts are the date formats seen in the wild in RSS and Atom feeds
+var feedDateLayouts = []string{
+	time.RFC1123Z,
+	time.RFC1123,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"2 Jan 2006 15:04:05 -0700",
+	time.RFC3339,
+}
+
+func newFeedNewsClient() (newsClient, error) {
+	feedURLs := envList("NEWS_FEED_URLS")
+	if len(feedURLs) == 0 {
+		return nil, fmt.Errorf("NEWS_FEED_URLS is not set")
+	}
+
+	maxItems, err := envInt("NEWS_FEED_MAX_ITEMS", defaultFeedMaxItems)
+	if err != nil {
+		return nil, err
+	}
+	if maxItems <= 0 {
+		return nil, fmt.Errorf("NEWS_FEED_MAX_ITEMS must be greater than 0")
+	}
+
+	cache, err := newSourceCache[[]newsResult]("NEWS", newsCacheDuration, newsCacheMaxStale)
+	if err != nil {
+		return nil, err
+	}
+
+	return &feedNews{
+		feedURLs: feedURLs,
+		maxItems: maxItems,
+		cache:    cache,
+		feeds:    map[string]*feedState{},
+	}, nil
+}
+
+func (f *feedNews) get() ([]newsResult, error) {
+	return f.cache.get("feeds", f.fetch)
+}
+
+func (f *feedNews) fetch() ([]newsResult, error) {
+	var results []newsResult
+	var errs []error
+	for _, feedURL := range f.feedURLs {
+		items, err := f.fetchFeed(feedURL)
+		if err != nil {
+			// one broken feed shouldn't hide the others
+			errs = append(errs, fmt.Errorf("cannot get feed %s: %w", feedURL, err))
+			continue
+		}
+		results = append(results, items...)
+	}
+	if len(errs) == len(f.feedURLs) {
+		return nil, errs[0]
+	}
+	for _, err := range errs {
+		fmt.Println(err)
+	}
+
+	// merge feeds by recency
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Published.After(results[j].Published)
+	})
+	if len(results) > f.maxItems {
+		results = results[:f.maxItems]
+	}
+
+	return results, nil
+}
+
+// fetchFeed gets the items of a single feed, reusing the previous items when it hasn't changed
+func (f *feedNews) fetchFeed(feedURL string) ([]newsResult, error) {
+	f.mu.Lock()
+	state, ok := f.feeds[feedURL]
+	if !ok {
+		state = &feedState{}
+		f.feeds[feedURL] = state
+	}
+	etag, lastModified := state.etag, state.lastModified
+	f.mu.Unlock()
+
+	// create request
+	req, err := http.NewRequest("GET", feedURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create request: %w", err)
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if lastModified != "" {
+		req.Header.Set("If-Modified-Since", lastModified)
+	}
+
+	// send request
+	resp, err := sourceHTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("cannot send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		return state.items, nil
+	}
+
+	// validate response code
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected response code: %d", resp.StatusCode)
+	}
+
+	// read response
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read feed: %w", err)
+	}
+
+	items, err := parseFeed(body, feedURL)
+	if err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	state.etag = resp.Header.Get("ETag")
+	state.lastModified = resp.Header.Get("Last-Modified")
+	state.items = items
+	f.mu.Unlock()
+
+	return items, nil
+}
+
+// parseFeed parses an RSS 2.0 or Atom document into news results
+func parseFeed(body []byte, feedURL string) ([]newsResult, error) {
+	root, err := feedRootElement(body)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []newsResult
+	switch root {
+	case "rss":
+		var feed rssFeed
+		err = newFeedDecoder(body).Decode(&feed)
+		if err != nil {
+			return nil, fmt.Errorf("cannot unmarshal rss feed: %w", err)
+		}
+		source := feedSourceName(feed.Channel.Title, feedURL)
+		for _, item := range feed.Channel.Items {
+			results = append(results, newsResult{
+				Title:       strings.TrimSpace(item.Title),
+				Description: stripHTML(item.Description),
+				URL:         strings.TrimSpace(item.Link),
+				Published:   parseFeedDate(item.PubDate),
+				Source:      source,
+			})
+		}
+	case "feed":
+		var feed atomFeed
+		err = newFeedDecoder(body).Decode(&feed)
+		if err != nil {
+			return nil, fmt.Errorf("cannot unmarshal atom feed: %w", err)
+		}
+		source := feedSourceName(feed.Title, feedURL)
+		for _, entry := range feed.Entries {
+			result := newsResult{
+				Title:       strings.TrimSpace(entry.Title),
+				Description: stripHTML(entry.Summary),
+				Published:   parseFeedDate(entry.Published),
+				Source:      source,
+			}
+			if result.Description == "" {
+				result.Description = stripHTML(entry.Content)
+			}
+			if result.Published.IsZero() {
+				result.Published = parseFeedDate(entry.Updated)
+			}
+			for _, link := range entry.Links {
+				if link.Rel == "" || link.Rel == "alternate" {
+					result.URL = link.Href
+					break
+				}
+			}
+			results = append(results, result)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported feed format: %s", root)
+	}
+
+	return results, nil
+}
+
+// newFeedDecoder reads feeds that declare a Latin-1 or Windows-1252 encoding as well as UTF-8
+func newFeedDecoder(body []byte) *xml.Decoder {
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	decoder.CharsetReader = charsetReader
+	return decoder
+}
+
+// feedRootElement returns the name of the document's root element
+func feedRootElement(body []byte) (string, error) {
+	decoder := newFeedDecoder(body)
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return "", fmt.Errorf("cannot parse feed: %w", err)
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			return start.Name.Local, nil
+		}
+	}
+}
+
+// feedSourceName names a feed by its title, or its host when it has none
+func feedSourceName(title, feedURL string) string {
+	if title = strings.TrimSpace(title); title != "" {
+		return title
+	}
+	if parsed, err := url.Parse(feedURL); err == nil && parsed.Host != "" {
+		return parsed.Host
+	}
+	return feedURL
+}
+
+// parseFeedDate parses a feed date, returning the zero time when it isn't recognized
+func parseFeedDate(value string) time.Time {
+	value = strings.TrimSpace(value)
+	for _, layout := range feedDateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const rssFeedResponse = `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0">
+	<channel>
+		<title>Test RSS</title>
+		<item>
+			<title>Older RSS Story</title>
+			<link>https://rss.test/older</link>
+			<description>&lt;p&gt;Older &amp;amp; wiser&lt;/p&gt;</description>
+			<pubDate>Mon, 01 Jan 2024 08:00:00 +0000</pubDate>
+		</item>
+		<item>
+			<title>Newest RSS Story</title>
+			<link>https://rss.test/newest</link>
+			<description>Newest</description>
+			<pubDate>Mon, 01 Jan 2024 12:00:00 GMT</pubDate>
+		</item>
+	</channel>
+</rss>`
+
+const atomFeedResponse = `<?xml version="1.0" encoding="utf-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+	<title>Test Atom</title>
+	<entry>
+		<title>Atom Story</title>
+		<link rel="alternate" href="https://atom.test/story"/>
+		<summary>Atom summary</summary>
+		<updated>2024-01-01T10:00:00Z</updated>
+	</entry>
+</feed>`
+
+func setupFeedServer() (*httptest.Server, *int) {
+	notModified := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/rss":
+			if r.Header.Get("If-None-Match") == `"rss-v1"` {
+				notModified++
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("ETag", `"rss-v1"`)
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(rssFeedResponse))
+		case "/atom":
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(atomFeedResponse))
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+
+	os.Setenv("NEWS_PROVIDER", "feeds")
+	os.Setenv("NEWS_FEED_URLS", server.URL+"/rss, "+server.URL+"/atom, "+server.URL+"/broken")
+
+	return server, &notModified
+}
+
+func TestFeedNewsClient_GetMergedByRecency(t *testing.T) {
+	server, _ := setupFeedServer()
+	defer server.Close()
+	defer os.Unsetenv("NEWS_PROVIDER")
+
+	client, err := newNewsClient()
+	require.NoError(t, err)
+
+	results, err := client.get()
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+
+	require.Equal(t, "Newest RSS Story", results[0].Title)
+	require.Equal(t, "Test RSS", results[0].Source)
+	require.Equal(t, time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC), results[0].Published.UTC())
+
+	require.Equal(t, "Atom Story", results[1].Title)
+	require.Equal(t, "https://atom.test/story", results[1].URL)
+	require.Equal(t, "Atom summary", results[1].Description)
+	require.Equal(t, "Test Atom", results[1].Source)
+
+	require.Equal(t, "Older RSS Story", results[2].Title)
+	require.Equal(t, "Older & wiser", results[2].Description)
+}
+
+func TestFeedNewsClient_HonorsETag(t *testing.T) {
+	server, notModified := setupFeedServer()
+	defer server.Close()
+	defer os.Unsetenv("NEWS_PROVIDER")
+
+	client, err := newNewsClient()
+	require.NoError(t, err)
+
+	first, err := client.(*feedNews).fetch()
+	require.NoError(t, err)
+
+	// the second fetch is answered with 304 and reuses the previous items
+	second, err := client.(*feedNews).fetch()
+	require.NoError(t, err)
+	require.Equal(t, 1, *notModified)
+	require.Equal(t, first, second)
+}
+
+func TestFeedNewsClient_AllFeedsFail(t *testing.T) {
+	server, _ := setupFeedServer()
+	defer server.Close()
+	defer os.Unsetenv("NEWS_PROVIDER")
+	os.Setenv("NEWS_FEED_URLS", server.URL+"/broken")
+
+	client, err := newNewsClient()
+	require.NoError(t, err)
+
+	results, err := client.get()
+	require.Error(t, err)
+	require.Nil(t, results)
+}
+
+func TestParseFeed_Latin1(t *testing.T) {
+	body := []byte("<?xml version=\"1.0\" encoding=\"ISO-8859-1\"?>\n<rss version=\"2.0\"><channel><title>Caf\xe9</title>" +
+		"<item><title>\x93Quoted\x94 news</title><link>https://rss.test/story</link></item></channel></rss>")
+
+	results, err := parseFeed(body, "https://rss.test/feed")
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	require.Equal(t, "Café", results[0].Source)
+	// Windows-1252 quotes declared as Latin-1
+	require.Equal(t, "“Quoted” news", results[0].Title)
+}
+
+func TestNewFeedNewsClient_MaxItems(t *testing.T) {
+	t.Setenv("NEWS_FEED_URLS", "https://rss.test/feed")
+	t.Setenv("NEWS_FEED_MAX_ITEMS", "0")
+	_, err := newFeedNewsClient()
+	require.Error(t, err)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"html"
+	"io"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	htmlTagPattern        = regexp.MustCompile(`(?s)<[^>]*>`)
+	htmlWhitespacePattern = regexp.MustCompile(`\s+`)
+	htmlCommentPattern    = regexp.MustCompile(`(?s)<!--.*?-->`)
+	htmlArticlePattern    = regexp.MustCompile(`(?is)<article\b[^>]*>(.*?)</article>`)
+	htmlParagraphPattern  = regexp.MustCompile(`(?is)<p\b[^>]*>(.*?)</p>`)
+	htmlLinkPattern       = regexp.MustCompile(`(?is)<a\b[^>]*>(.*?)</a>`)
+
+	// htmlBoilerplatePatterns match elements that never hold the main text of a page
+	htmlBoilerplatePatterns = boilerplatePatterns("script", "style", "noscript", "nav", "header", "footer", "aside", "form", "iframe", "svg")
+)
+
+const (
+	// paragraphs shorter than this are usually captions, bylines or buttons
+	minArticleParagraphLength = 40
+	// paragraphs that are mostly links are usually navigation or related stories
+	maxArticleLinkDensity = 0.5
+)
+
+func boilerplatePatterns(tags ...string) []*regexp.Regexp {
+	patterns := make([]*regexp.Regexp, len(tags))
+	for i, tag := range tags {
+		patterns[i] = regexp.MustCompile(`(?is)<` + tag + `\b[^>]*>.*?</` + tag + `>`)
+	}
+	return patterns
+}
+
+// stripHTML removes tags from an HTML fragment and unescapes entities,
+// collapsing whitespace into single spaces
+func stripHTML(fragment string) string {
+	text := htmlTagPattern.ReplaceAllString(fragment, " ")
+	text = html.UnescapeString(text)
+	return strings.TrimSpace(htmlWhitespacePattern.ReplaceAllString(text, " "))
+}
+
+// extractArticleText pulls the main readable text out of an HTML page: it
+// drops boilerplate elements, prefers the largest <article>, and keeps the
+// paragraphs that are long enough and not mostly links
+func extractArticleText(page string) string {
+	page = htmlCommentPattern.ReplaceAllString(page, "")
+	for _, pattern := range htmlBoilerplatePatterns {
+		page = pattern.ReplaceAllString(page, "")
+	}
+
+	longest := ""
+	for _, match := range htmlArticlePattern.FindAllStringSubmatch(page, -1) {
+		if len(match[1]) > len(longest) {
+			longest = match[1]
+		}
+	}
+	if longest != "" {
+		page = longest
+	}
+
+	var paragraphs []string
+	for _, match := range htmlParagraphPattern.FindAllStringSubmatch(page, -1) {
+		text := stripHTML(match[1])
+		if len(text) < minArticleParagraphLength {
+			continue
+		}
+
+		linkText := 0
+		for _, link := range htmlLinkPattern.FindAllStringSubmatch(match[1], -1) {
+			linkText += len(stripHTML(link[1]))
+		}
+		if float64(linkText)/float64(len(text)) > maxArticleLinkDensity {
+			continue
+		}
+
+		paragraphs = append(paragraphs, text)
+	}
+
+	return strings.Join(paragraphs, "\n\n")
+}
+
+// truncateText shortens text to at most limit characters, cutting at a word boundary
+func truncateText(text string, limit int) string {
+	if utf8.RuneCountInString(text) <= limit {
+		return text
+	}
+
+	// the byte offset of the first character past the limit
+	end, count := 0, 0
+	for end = range text {
+		if count == limit {
+			break
+		}
+		count++
+	}
+
+	cut := strings.LastIndexAny(text[:end], " \n")
+	if cut <= 0 {
+		cut = end
+	}
+	return strings.TrimSpace(text[:cut]) + "…"
+}
+
+// charsetReader decodes the single-byte charsets that are still common in mail
+// and feeds; anything else is read as is
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	data, err := io.ReadAll(input)
+	if err != nil {
+		return nil, err
+	}
+	return strings.NewReader(decodeCharset(data, charset)), nil
+}
+
+// windows1252 are the characters Windows-1252 has in place of the C1 controls of Latin-1
+var windows1252 = [32]rune{
+	'€', 0x81, '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', 0x8d, 'Ž', 0x8f,
+	0x90, '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', 0x9d, 'ž', 'Ÿ',
+}
+
+func decodeCharset(data []byte, charset string) string {
+	switch strings.ToLower(charset) {
+	case "iso-8859-1", "latin1", "windows-1252", "cp1252":
+		runes := make([]rune, len(data))
+		for i, b := range data {
+			runes[i] = rune(b)
+			// Latin-1 is often declared for text written in Windows-1252
+			if b >= 0x80 && b < 0xa0 {
+				runes[i] = windows1252[b-0x80]
+			}
+		}
+		return string(runes)
+	}
+	return string(data)
+}
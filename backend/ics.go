@@ -0,0 +1,439 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	// embed the timezone database, the container image has none
+	_ "time/tzdata"
+)
+
+// icsEvent is a VEVENT as parsed from an iCalendar document
+type icsEvent struct {
+	uid         string
+	summary     string
+	description string
+	location    string
+	start       time.Time
+	end         time.Time
+	allDay      bool
+
+	// recurrence
+	rrule        string
+	rdates       []time.Time
+	exdates      []time.Time
+	recurrenceID time.Time
+	recurring    bool
+}
+
+// icsProperty is a single content line, e.g. DTSTART;TZID=Europe/Paris:20240101T090000
+type icsProperty struct {
+	name   string
+	params map[string]string
+	value  string
+}
+
+const (
+	icsDateLayout         = "20060102"
+	icsDateTimeLayout     = "20060102T150405"
+	icsUTCDateTimeLayout  = "20060102T150405Z"
+	calendarDateLayout    = "2006-01-02"
+	defaultICSEventLength = time.Hour
+)
+
+// parseICS parses the VEVENTs of an iCalendar document. Times without a
+// timezone ("floating" times) are read in loc. Malformed lines and events are
+// skipped, it fails only when there are no good events to return.
+func parseICS(data string, loc *time.Location) ([]icsEvent, error) {
+	var events []icsEvent
+	var current *icsEvent
+	var invalid error
+	var errs []error
+	var duration time.Duration
+	depth := 0
+
+	for _, line := range unfoldICS(data) {
+		property, err := parseICSProperty(line)
+		if err != nil {
+			fmt.Println(fmt.Errorf("skipping calendar line: %w", err))
+			continue
+		}
+
+		switch {
+		case property.name == "BEGIN" && property.value == "VEVENT":
+			current = &icsEvent{}
+			invalid = nil
+			duration = 0
+			depth = 0
+			continue
+		case current == nil:
+			continue
+		case property.name == "BEGIN":
+			// nested components such as VALARM
+			depth++
+			continue
+		case property.name == "END" && depth > 0:
+			depth--
+			continue
+		case depth > 0:
+			continue
+		case property.name == "END" && property.value == "VEVENT":
+			if invalid == nil && current.start.IsZero() {
+				invalid = fmt.Errorf("it has no start")
+			}
+			if invalid != nil {
+				errs = append(errs, fmt.Errorf("event %q: %w", current.uid, invalid))
+				current = nil
+				continue
+			}
+			if current.end.IsZero() {
+				current.end = icsDefaultEnd(current, duration)
+			}
+			events = append(events, *current)
+			current = nil
+			continue
+		}
+
+		switch property.name {
+		case "UID":
+			current.uid = property.value
+		case "SUMMARY":
+			current.summary = unescapeICSText(property.value)
+		case "DESCRIPTION":
+			current.description = unescapeICSText(property.value)
+		case "LOCATION":
+			current.location = unescapeICSText(property.value)
+		case "DTSTART":
+			current.start, current.allDay, err = parseICSTime(property, loc)
+		case "DTEND":
+			current.end, _, err = parseICSTime(property, loc)
+		case "DURATION":
+			duration, err = parseICSDuration(property.value)
+		case "RRULE":
+			current.rrule = property.value
+		case "RDATE":
+			var dates []time.Time
+			dates, err = parseICSTimeList(property, loc)
+			current.rdates = append(current.rdates, dates...)
+		case "EXDATE":
+			var dates []time.Time
+			dates, err = parseICSTimeList(property, loc)
+			current.exdates = append(current.exdates, dates...)
+		case "RECURRENCE-ID":
+			current.recurrenceID, _, err = parseICSTime(property, loc)
+		}
+		if err != nil && invalid == nil {
+			invalid = fmt.Errorf("cannot parse %s: %w", property.name, err)
+		}
+	}
+
+	// one bad event doesn't hide the rest, only a calendar of bad events fails
+	if len(events) == 0 && len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	for _, err := range errs {
+		fmt.Println(fmt.Errorf("skipping calendar event: %w", err))
+	}
+	return events, nil
+}
+
+// icsDefaultEnd works out the end of an event without DTEND, from its
+// DURATION or otherwise as RFC 5545 defaults it
+func icsDefaultEnd(event *icsEvent, duration time.Duration) time.Time {
+	switch {
+	case duration > 0:
+		return event.start.Add(duration)
+	case event.allDay:
+		return event.start.AddDate(0, 0, 1)
+	}
+	return event.start.Add(defaultICSEventLength)
+}
+
+// unfoldICS splits a document into content lines, joining lines that were folded onto the next one
+func unfoldICS(data string) []string {
+	var lines []string
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// parseICSProperty splits a content line into its name, parameters and value
+func parseICSProperty(line string) (icsProperty, error) {
+	// the value starts at the first colon outside of a quoted parameter value
+	quoted := false
+	colon := -1
+	for i, r := range line {
+		if r == '"' {
+			quoted = !quoted
+		}
+		if r == ':' && !quoted {
+			colon = i
+			break
+		}
+	}
+	if colon < 0 {
+		return icsProperty{}, fmt.Errorf("malformed content line %q", line)
+	}
+
+	parts := strings.Split(line[:colon], ";")
+	property := icsProperty{
+		name:   strings.ToUpper(parts[0]),
+		params: map[string]string{},
+		value:  line[colon+1:],
+	}
+	for _, param := range parts[1:] {
+		name, value, _ := strings.Cut(param, "=")
+		property.params[strings.ToUpper(name)] = strings.Trim(value, `"`)
+	}
+	return property, nil
+}
+
+// parseICSTime parses a DATE or DATE-TIME value, reporting whether it was a whole-day DATE
+func parseICSTime(property icsProperty, loc *time.Location) (time.Time, bool, error) {
+	return parseICSTimeValue(property.value, property.params["VALUE"] == "DATE", icsLocation(property, loc))
+}
+
+// parseICSTimeList parses a comma-separated list of DATE or DATE-TIME values
+func parseICSTimeList(property icsProperty, loc *time.Location) ([]time.Time, error) {
+	loc = icsLocation(property, loc)
+	var times []time.Time
+	for _, value := range strings.Split(property.value, ",") {
+		t, _, err := parseICSTimeValue(value, property.params["VALUE"] == "DATE", loc)
+		if err != nil {
+			return nil, err
+		}
+		times = append(times, t)
+	}
+	return times, nil
+}
+
+func parseICSTimeValue(value string, isDate bool, loc *time.Location) (time.Time, bool, error) {
+	switch {
+	case isDate || len(value) == len(icsDateLayout):
+		t, err := time.ParseInLocation(icsDateLayout, value, loc)
+		return t, true, err
+	case strings.HasSuffix(value, "Z"):
+		t, err := time.Parse(icsUTCDateTimeLayout, value)
+		return t, false, err
+	default:
+		t, err := time.ParseInLocation(icsDateTimeLayout, value, loc)
+		return t, false, err
+	}
+}
+
+// windowsTimezones maps the Windows zone names Outlook and Exchange use as TZIDs to IANA names
+var windowsTimezones = map[string]string{
+	"UTC":                            "UTC",
+	"Pacific Standard Time":          "America/Los_Angeles",
+	"Mountain Standard Time":         "America/Denver",
+	"US Mountain Standard Time":      "America/Phoenix",
+	"Central Standard Time":          "America/Chicago",
+	"Eastern Standard Time":          "America/New_York",
+	"Atlantic Standard Time":         "America/Halifax",
+	"GMT Standard Time":              "Europe/London",
+	"W. Europe Standard Time":        "Europe/Berlin",
+	"Romance Standard Time":          "Europe/Paris",
+	"Central Europe Standard Time":   "Europe/Budapest",
+	"Central European Standard Time": "Europe/Warsaw",
+	"E. Europe Standard Time":        "Europe/Chisinau",
+	"FLE Standard Time":              "Europe/Kiev",
+	"India Standard Time":            "Asia/Kolkata",
+	"China Standard Time":            "Asia/Shanghai",
+	"Tokyo Standard Time":            "Asia/Tokyo",
+	"AUS Eastern Standard Time":      "Australia/Sydney",
+}
+
+// icsLocation returns the location named by a TZID parameter, or loc when there is none or
+// it is unknown, so one event with a custom timezone doesn't break the calendar
+func icsLocation(property icsProperty, loc *time.Location) *time.Location {
+	tzid, ok := property.params["TZID"]
+	if !ok {
+		return loc
+	}
+
+	names := []string{tzid, windowsTimezones[tzid]}
+	// ids like /mozilla.org/20050126_1/Europe/Berlin end with an IANA name
+	if parts := strings.Split(tzid, "/"); len(parts) >= 2 {
+		names = append(names, strings.Join(parts[len(parts)-2:], "/"))
+	}
+	for _, name := range names {
+		if name == "" {
+			continue
+		}
+		if location, err := time.LoadLocation(name); err == nil {
+			return location
+		}
+	}
+	fmt.Println(fmt.Errorf("unknown timezone %q, using %s", tzid, loc))
+	return loc
+}
+
+// parseICSDuration parses a duration such as P1D, PT1H30M or P2W
+func parseICSDuration(value string) (time.Duration, error) {
+	sign := time.Duration(1)
+	switch {
+	case strings.HasPrefix(value, "-"):
+		sign = -1
+		value = value[1:]
+	case strings.HasPrefix(value, "+"):
+		value = value[1:]
+	}
+	if !strings.HasPrefix(value, "P") {
+		return 0, fmt.Errorf("malformed duration %q", value)
+	}
+
+	var duration time.Duration
+	number := ""
+	for _, r := range value[1:] {
+		if r >= '0' && r <= '9' {
+			number += string(r)
+			continue
+		}
+		if r == 'T' {
+			continue
+		}
+		n, err := strconv.Atoi(number)
+		if err != nil {
+			return 0, fmt.Errorf("malformed duration %q", value)
+		}
+		switch r {
+		case 'W':
+			duration += time.Duration(n) * 7 * 24 * time.Hour
+		case 'D':
+			duration += time.Duration(n) * 24 * time.Hour
+		case 'H':
+			duration += time.Duration(n) * time.Hour
+		case 'M':
+			duration += time.Duration(n) * time.Minute
+		case 'S':
+			duration += time.Duration(n) * time.Second
+		default:
+			return 0, fmt.Errorf("malformed duration %q", value)
+		}
+		number = ""
+	}
+	return sign * duration, nil
+}
+
+func unescapeICSText(value string) string {
+	replacer := strings.NewReplacer(`\n`, "\n", `\N`, "\n", `\,`, ",", `\;`, ";", `\\`, `\`)
+	return replacer.Replace(value)
+}
+
+// escapeICSText escapes a TEXT value, the reverse of unescapeICSText
+func escapeICSText(value string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, "\n", `\n`, ",", `\,`, ";", `\;`, "\r", "")
+	return replacer.Replace(value)
+}
+
+// foldICSLine splits a content line into lines of at most 75 bytes, without
+// splitting UTF-8 characters, and terminates it with CRLF
+func foldICSLine(line string) string {
+	var folded strings.Builder
+	// continuation lines start with a space, which counts towards their length
+	for limit := 75; len(line) > limit; limit = 74 {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		folded.WriteString(line[:cut] + "\r\n ")
+		line = line[cut:]
+	}
+	folded.WriteString(line + "\r\n")
+	return folded.String()
+}
+
+// formatICSEvent writes an event as a VEVENT, with times in UTC or as dates for all-day events
+func formatICSEvent(event calendarEvent, stamp time.Time) string {
+	lines := []string{
+		"BEGIN:VEVENT",
+		"UID:" + event.UID,
+		"DTSTAMP:" + stamp.UTC().Format(icsUTCDateTimeLayout),
+	}
+	if event.AllDay {
+		lines = append(lines,
+			"DTSTART;VALUE=DATE:"+event.Start.Format(icsDateLayout),
+			"DTEND;VALUE=DATE:"+event.End.Format(icsDateLayout),
+		)
+	} else {
+		lines = append(lines,
+			"DTSTART:"+event.Start.UTC().Format(icsUTCDateTimeLayout),
+			"DTEND:"+event.End.UTC().Format(icsUTCDateTimeLayout),
+		)
+	}
+	lines = append(lines, "SUMMARY:"+escapeICSText(event.Title))
+	if event.Location != "" {
+		lines = append(lines, "LOCATION:"+escapeICSText(event.Location))
+	}
+	if event.Description != "" {
+		lines = append(lines, "DESCRIPTION:"+escapeICSText(event.Description))
+	}
+	lines = append(lines, "END:VEVENT")
+
+	var formatted strings.Builder
+	for _, line := range lines {
+		formatted.WriteString(foldICSLine(line))
+	}
+	return formatted.String()
+}
+
+// formatICSCalendar wraps VEVENTs in a VCALENDAR
+func formatICSCalendar(events string) string {
+	return "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//assistant//calendar//EN\r\n" + events + "END:VCALENDAR\r\n"
+}
+
+// toCalendarEvent converts a parsed event to the calendar model, with times in loc
+func (e icsEvent) toCalendarEvent(loc *time.Location) calendarEvent {
+	return calendarEvent{
+		UID:         e.uid,
+		Title:       e.summary,
+		Description: e.description,
+		Location:    e.location,
+		Start:       e.start.In(loc),
+		End:         e.end.In(loc),
+		AllDay:      e.allDay,
+		Recurring:   e.recurring,
+	}
+}
+
+// icsEventsBetween expands recurring events and converts the occurrences that overlap [from, to)
+// to the calendar model in loc, ordered by start
+func icsEventsBetween(events []icsEvent, from, to time.Time, loc *time.Location) []calendarEvent {
+	expanded := expandRecurrences(events, from, to)
+
+	var matching []icsEvent
+	for _, event := range expanded {
+		if event.end.After(from) && event.start.Before(to) {
+			matching = append(matching, event)
+		}
+	}
+
+	sortICSEvents(matching)
+	calendarEvents := make([]calendarEvent, len(matching))
+	for i, event := range matching {
+		calendarEvents[i] = event.toCalendarEvent(loc)
+	}
+	return calendarEvents
+}
+
+func sortICSEvents(events []icsEvent) {
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].start.Before(events[j].start)
+	})
+}
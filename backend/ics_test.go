@@ -0,0 +1,158 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const icsTestCalendar = "BEGIN:VCALENDAR\r\n" +
+	"VERSION:2.0\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:standup@test\r\n" +
+	"SUMMARY:Team standup\\, daily\r\n" +
+	"DESCRIPTION:Line one\\nLine two that is folded\r\n" +
+	"  across lines\r\n" +
+	"LOCATION:Room 1\r\n" +
+	"DTSTART;TZID=America/New_York:20240102T090000\r\n" +
+	"DURATION:PT15M\r\n" +
+	"BEGIN:VALARM\r\n" +
+	"DESCRIPTION:Reminder\r\n" +
+	"END:VALARM\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:holiday@test\r\n" +
+	"SUMMARY:Holiday\r\n" +
+	"DTSTART;VALUE=DATE:20240101\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:utc@test\r\n" +
+	"SUMMARY:Call\r\n" +
+	"DTSTART:20240103T150000Z\r\n" +
+	"DTEND:20240103T153000Z\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func TestParseICS(t *testing.T) {
+	events, err := parseICS(icsTestCalendar, time.UTC)
+	require.NoError(t, err)
+	require.Len(t, events, 3)
+
+	newYork, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+
+	standup := events[0]
+	require.Equal(t, "standup@test", standup.uid)
+	require.Equal(t, "Team standup, daily", standup.summary)
+	require.Equal(t, "Line one\nLine two that is folded across lines", standup.description)
+	require.Equal(t, "Room 1", standup.location)
+	require.True(t, standup.start.Equal(time.Date(2024, 1, 2, 9, 0, 0, 0, newYork)))
+	require.Equal(t, 15*time.Minute, standup.end.Sub(standup.start))
+	require.False(t, standup.allDay)
+
+	holiday := events[1]
+	require.True(t, holiday.allDay)
+	require.Equal(t, time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), holiday.end)
+
+	call := events[2]
+	require.Equal(t, time.Date(2024, 1, 3, 15, 30, 0, 0, time.UTC), call.end)
+}
+
+func TestParseICS_Malformed(t *testing.T) {
+	events, err := parseICS("BEGIN:VEVENT\nSUMMARY:No start\nEND:VEVENT\n", time.UTC)
+	require.Error(t, err)
+	require.Nil(t, events)
+
+}
+
+func TestParseICS_SkipsBadEvents(t *testing.T) {
+	calendar := "BEGIN:VCALENDAR\n" +
+		"BEGIN:VEVENT\nUID:nostart@test\nSUMMARY:No start\nEND:VEVENT\n" +
+		"BEGIN:VEVENT\nUID:badstart@test\nDTSTART:tomorrow\nEND:VEVENT\n" +
+		"BEGIN:VEVENT\nUID:good@test\nthis line has no colon\nDTSTART:20240101T090000Z\nEND:VEVENT\n" +
+		"END:VCALENDAR\n"
+
+	events, err := parseICS(calendar, time.UTC)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	require.Equal(t, "good@test", events[0].uid)
+}
+
+func TestParseICS_Timezones(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+
+	tests := []struct {
+		tzid string
+		want time.Time
+	}{
+		{tzid: "Eastern Standard Time", want: time.Date(2024, 1, 1, 9, 0, 0, 0, newYork)},
+		{tzid: "/citadel.org/20190914_1/America/New_York", want: time.Date(2024, 1, 1, 9, 0, 0, 0, newYork)},
+		// unknown timezones fall back to the default location
+		{tzid: "Nowhere/Special", want: time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		events, err := parseICS("BEGIN:VEVENT\nDTSTART;TZID="+test.tzid+":20240101T090000\nEND:VEVENT\n", time.UTC)
+		require.NoError(t, err, test.tzid)
+		require.True(t, test.want.Equal(events[0].start), test.tzid)
+	}
+}
+
+func TestICSEventsBetween(t *testing.T) {
+	events, err := parseICS(icsTestCalendar, time.UTC)
+	require.NoError(t, err)
+
+	calendarEvents := icsEventsBetween(events, time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC), time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC), time.UTC)
+	require.Len(t, calendarEvents, 2)
+	require.Equal(t, "Holiday", calendarEvents[0].Title)
+	require.Equal(t, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), calendarEvents[0].Start)
+	require.True(t, calendarEvents[0].AllDay)
+	require.Equal(t, "Team standup, daily", calendarEvents[1].Title)
+	require.Equal(t, time.Date(2024, 1, 2, 14, 0, 0, 0, time.UTC), calendarEvents[1].Start)
+	require.False(t, calendarEvents[1].AllDay)
+}
+
+func TestParseICSDuration(t *testing.T) {
+	duration, err := parseICSDuration("P1DT2H30M")
+	require.NoError(t, err)
+	require.Equal(t, 26*time.Hour+30*time.Minute, duration)
+
+	duration, err = parseICSDuration("-P2W")
+	require.NoError(t, err)
+	require.Equal(t, -14*24*time.Hour, duration)
+
+	_, err = parseICSDuration("1H")
+	require.Error(t, err)
+}
+
+func TestFormatICSEvent(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+
+	start := time.Date(2024, 1, 5, 12, 0, 0, 0, newYork)
+	event := calendarEvent{
+		UID:         "lunch@test",
+		Title:       "Lunch, with Sam; maybe",
+		Location:    "Café",
+		Description: "A very long description that has to be folded across several lines because it is well over seventy-five bytes\nwith a second line",
+		Start:       start,
+		End:         start.Add(time.Hour),
+	}
+
+	formatted := formatICSCalendar(formatICSEvent(event, start))
+	for _, line := range strings.Split(formatted, "\r\n") {
+		require.LessOrEqual(t, len(line), 75)
+	}
+
+	// parsing it again gives back the same event
+	events, err := parseICS(formatted, time.UTC)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	parsed := events[0].toCalendarEvent(newYork)
+	require.Equal(t, event, parsed)
+
+	allDay := calendarEvent{UID: "holiday@test", Title: "Holiday", Start: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), End: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), AllDay: true}
+	require.Contains(t, formatICSEvent(allDay, start), "DTSTART;VALUE=DATE:20240101\r\nDTEND;VALUE=DATE:20240102\r\n")
+}
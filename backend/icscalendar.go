@@ -0,0 +1,172 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// icsCalendar reads events from an iCalendar (.ics) URL or file
+type icsCalendar struct {
+	source   string
+	settings calendarSettings
+
+	// writeMu serializes changes to a calendar file
+	writeMu sync.Mutex
+	// eventTarget is set on the calendar file new events are added to, which may not exist
+	// until the first one is. Any other missing file is an error, like a mistyped path.
+	eventTarget bool
+
+	cache *ttlCache[[]calendarEvent]
+}
+
+func newICSCalendarClient(prefix string) (calendarClient, error) {
+	if os.Getenv(prefix+"_URL") == "" {
+		return nil, fmt.Errorf("%s_URL is not set", prefix)
+	}
+
+	settings, err := newCalendarSettings()
+	if err != nil {
+		return nil, err
+	}
+
+	cache, err := newSourceCache[[]calendarEvent]("CALENDAR", calendarCacheDuration, calendarCacheMaxStale)
+	if err != nil {
+		return nil, err
+	}
+
+	// webcal:// is how calendar subscriptions are linked, they are fetched over https
+	source := os.Getenv(prefix + "_URL")
+	if strings.HasPrefix(source, "webcal://") {
+		source = "https://" + strings.TrimPrefix(source, "webcal://")
+	}
+
+	return &icsCalendar{
+		source:   source,
+		settings: settings,
+		cache:    cache,
+	}, nil
+}
+
+func (c *icsCalendar) getEvents() ([]calendarEvent, error) {
+	return c.cache.get("events", c.fetch)
+}
+
+func (c *icsCalendar) fetch() ([]calendarEvent, error) {
+	data, err := c.read()
+	if err != nil {
+		return nil, err
+	}
+
+	events, err := parseICS(data, c.settings.location)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse events: %w", err)
+	}
+
+	now := time.Now()
+	return icsEventsBetween(events, now, now.Add(c.settings.lookahead), c.settings.location), nil
+}
+
+// isFile is whether the calendar is read from disk rather than over http(s)
+func (c *icsCalendar) isFile() bool {
+	return !strings.HasPrefix(c.source, "http://") && !strings.HasPrefix(c.source, "https://")
+}
+
+func (c *icsCalendar) path() string {
+	return strings.TrimPrefix(c.source, "file://")
+}
+
+// read gets the calendar over http(s), or from disk for anything else
+func (c *icsCalendar) read() (string, error) {
+	if c.isFile() {
+		data, err := os.ReadFile(c.path())
+		// a calendar file that events are added to may not exist yet
+		if errors.Is(err, os.ErrNotExist) && c.eventTarget {
+			return "", nil
+		}
+		if err != nil {
+			return "", fmt.Errorf("cannot read events: %w", err)
+		}
+		return string(data), nil
+	}
+
+	resp, err := sourceHTTPClient.Get(c.source)
+	if err != nil {
+		return "", fmt.Errorf("cannot get events: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// validate response code
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected response code: %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("cannot read events: %w", err)
+	}
+	return string(body), nil
+}
+
+// writable is true for calendar files
+func (c *icsCalendar) writable() bool {
+	return c.isFile()
+}
+
+// createEvent adds the event to the calendar file, creating it if needed.
+// Calendars read over http(s) are read-only.
+func (c *icsCalendar) createEvent(event calendarEvent) error {
+	if !c.isFile() {
+		return errCalendarReadOnly
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	vevent := formatICSEvent(event, time.Now())
+	data, err := os.ReadFile(c.path())
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		data = []byte(formatICSCalendar(vevent))
+	case err != nil:
+		return fmt.Errorf("cannot read events: %w", err)
+	case icsHasUID(string(data), event.UID):
+		return errCalendarEventExists
+	default:
+		end := strings.LastIndex(string(data), "END:VCALENDAR")
+		if end < 0 {
+			return fmt.Errorf("cannot add event: calendar file has no END:VCALENDAR")
+		}
+		data = []byte(string(data[:end]) + vevent + string(data[end:]))
+	}
+
+	err = writeFileAtomic(c.path(), data)
+	if err != nil {
+		return fmt.Errorf("cannot write events: %w", err)
+	}
+
+	c.cache.invalidate("events")
+	return nil
+}
+
+// icsHasUID reports whether the calendar already has an event with the UID
+func icsHasUID(data, uid string) bool {
+	for _, line := range unfoldICS(data) {
+		if line == "UID:"+uid {
+			return true
+		}
+	}
+	return false
+}
+
+// markEventTarget lets the calendar file that new events are added to be missing until the first one is
+func markEventTarget(writer calendarWriter) {
+	if c, ok := writer.(*icsCalendar); ok {
+		c.eventTarget = true
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// upcomingICSCalendar has one event tomorrow and one long past
+func upcomingICSCalendar() string {
+	tomorrow := time.Now().Add(24 * time.Hour).UTC()
+	return fmt.Sprintf("BEGIN:VCALENDAR\n"+
+		"BEGIN:VEVENT\nUID:past@test\nSUMMARY:Past\nDTSTART:20200101T090000Z\nEND:VEVENT\n"+
+		"BEGIN:VEVENT\nUID:tomorrow@test\nSUMMARY:Tomorrow\nDTSTART:%s\nEND:VEVENT\n"+
+		"END:VCALENDAR\n", tomorrow.Format(icsUTCDateTimeLayout))
+}
+
+func setupICSCalendarEnvVars(source string) {
+	os.Setenv("CALENDAR_PROVIDER", "ics")
+	os.Setenv("CALENDAR_URL", source)
+}
+
+func teardownICSCalendarEnvVars() {
+	os.Unsetenv("CALENDAR_PROVIDER")
+	os.Unsetenv("CALENDAR_URL")
+}
+
+func TestICSCalendarClient_GetEventsFromURL(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/calendar")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(upcomingICSCalendar()))
+	}))
+	defer server.Close()
+	setupICSCalendarEnvVars(server.URL + "/calendar.ics")
+	defer teardownICSCalendarEnvVars()
+
+	calendarClient, err := newCalendarClient()
+	require.NoError(t, err)
+
+	events, err := calendarClient.getEvents()
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	require.Equal(t, "tomorrow@test", events[0].UID)
+	require.Equal(t, "Tomorrow", events[0].Title)
+}
+
+func TestICSCalendarClient_GetEventsFromFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "calendar.ics")
+	require.NoError(t, os.WriteFile(path, []byte(upcomingICSCalendar()), 0o600))
+	setupICSCalendarEnvVars("file://" + path)
+	defer teardownICSCalendarEnvVars()
+
+	calendarClient, err := newCalendarClient()
+	require.NoError(t, err)
+
+	events, err := calendarClient.getEvents()
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	require.Equal(t, "Tomorrow", events[0].Title)
+}
+
+func TestICSCalendarClient_GetEventsInternalError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	setupICSCalendarEnvVars(server.URL)
+	defer teardownICSCalendarEnvVars()
+
+	calendarClient, err := newCalendarClient()
+	require.NoError(t, err)
+
+	events, err := calendarClient.getEvents()
+	require.Error(t, err)
+	require.Nil(t, events)
+}
+
+func TestICSCalendarClient_CreateEvent(t *testing.T) {
+	// the calendar file is created with the first event
+	path := filepath.Join(t.TempDir(), "calendar.ics")
+	setupICSCalendarEnvVars(path)
+	defer teardownICSCalendarEnvVars()
+
+	calendarClient, err := newCalendarClient()
+	require.NoError(t, err)
+
+	events, err := calendarClient.getEvents()
+	require.NoError(t, err)
+	require.Empty(t, events)
+
+	start := time.Now().Add(time.Hour).Truncate(time.Second)
+	writer := calendarClient.(calendarWriter)
+	require.NoError(t, writer.createEvent(calendarEvent{UID: "a@test", Title: "First", Start: start, End: start.Add(time.Hour)}))
+	require.NoError(t, writer.createEvent(calendarEvent{UID: "b@test", Title: "Second", Start: start.Add(time.Hour), End: start.Add(2 * time.Hour)}))
+	// an event is only added once
+	require.ErrorIs(t, writer.createEvent(calendarEvent{UID: "a@test", Title: "First", Start: start, End: start.Add(time.Hour)}), errCalendarEventExists)
+
+	events, err = calendarClient.getEvents()
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	require.Equal(t, "First", events[0].Title)
+	require.True(t, start.Equal(events[0].Start))
+	require.Equal(t, "Second", events[1].Title)
+}
+
+func TestICSCalendarClient_MissingFile(t *testing.T) {
+	// only the file new events go to may be missing
+	setupICSCalendarEnvVars(filepath.Join(t.TempDir(), "mistyped.ics"))
+	defer teardownICSCalendarEnvVars()
+
+	calendarClient, err := newICSCalendarClient("CALENDAR")
+	require.NoError(t, err)
+
+	events, err := calendarClient.getEvents()
+	require.ErrorIs(t, err, os.ErrNotExist)
+	require.Nil(t, events)
+}
+
+func TestICSCalendarClient_Webcal(t *testing.T) {
+	setupICSCalendarEnvVars("webcal://example.com/calendar.ics")
+	defer teardownICSCalendarEnvVars()
+
+	calendarClient, err := newCalendarClient()
+	require.NoError(t, err)
+	require.Equal(t, "https://example.com/calendar.ics", calendarClient.(*icsCalendar).source)
+	require.False(t, calendarClient.(calendarWriter).writable())
+}
+
+func TestICSHasUID(t *testing.T) {
+	uid := "a-very-long-identifier-that-is-folded-across-two-lines-of-the-calendar@example.com"
+	calendar := formatICSCalendar(formatICSEvent(calendarEvent{UID: uid, Title: "Lunch", Start: time.Now(), End: time.Now().Add(time.Hour)}, time.Now()))
+	require.Contains(t, calendar, "\r\n ")
+	require.True(t, icsHasUID(calendar, uid))
+	require.False(t, icsHasUID(calendar, "other@example.com"))
+}
+
+func TestICSCalendarClient_CreateEventReadOnly(t *testing.T) {
+	setupICSCalendarEnvVars("https://example.com/calendar.ics")
+	defer teardownICSCalendarEnvVars()
+
+	calendarClient, err := newCalendarClient()
+	require.NoError(t, err)
+
+	err = calendarClient.(calendarWriter).createEvent(calendarEvent{Title: "Lunch"})
+	require.ErrorIs(t, err, errCalendarReadOnly)
+}
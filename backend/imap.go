@@ -0,0 +1,385 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// imapConn is a minimal IMAP4rev1 client connection (RFC 3501), covering the
+// few commands the email source needs
+type imapConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	tag    int
+}
+
+// imapResponse is an untagged response, with the literals it contains in order.
+// The text keeps the {size} markers where the literals were.
+type imapResponse struct {
+	text     string
+	literals [][]byte
+}
+
+var imapFetchUIDPattern = regexp.MustCompile(`\bUID (\d+)`)
+
+func dialIMAP(address string, useTLS bool, timeout time.Duration) (*imapConn, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+
+	var conn net.Conn
+	var err error
+	if useTLS {
+		host, _, _ := net.SplitHostPort(address)
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, &tls.Config{ServerName: host})
+	} else {
+		conn, err = dialer.Dial("tcp", address)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect: %w", err)
+	}
+
+	// bound the whole session, so a stuck server can't hang the updates
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	c := &imapConn{conn: conn, reader: bufio.NewReader(conn)}
+	greeting, err := c.readResponse()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("cannot read greeting: %w", err)
+	}
+	if !strings.HasPrefix(greeting.text, "* OK") && !strings.HasPrefix(greeting.text, "* PREAUTH") {
+		conn.Close()
+		return nil, fmt.Errorf("unexpected greeting: %s", greeting.text)
+	}
+
+	// without TLS the password would be sent in cleartext, which is only
+	// acceptable when the server is on the same host
+	if !useTLS {
+		host, _, _ := net.SplitHostPort(address)
+		capabilities, err := c.capabilities()
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		switch {
+		case capabilities["STARTTLS"]:
+			err = c.startTLS(host)
+			if err != nil {
+				conn.Close()
+				return nil, err
+			}
+		case !imapLoopback(host):
+			conn.Close()
+			return nil, fmt.Errorf("%s doesn't support STARTTLS, refusing to log in without TLS", host)
+		}
+	}
+	return c, nil
+}
+
+// imapLoopback is whether host is the machine itself
+func imapLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// capabilities lists what the server supports, in upper case
+func (c *imapConn) capabilities() (map[string]bool, error) {
+	responses, err := c.command("CAPABILITY")
+	if err != nil {
+		return nil, fmt.Errorf("cannot get capabilities: %w", err)
+	}
+
+	capabilities := map[string]bool{}
+	for _, response := range responses {
+		fields, ok := strings.CutPrefix(response.text, "* CAPABILITY ")
+		if !ok {
+			continue
+		}
+		for _, field := range strings.Fields(fields) {
+			capabilities[strings.ToUpper(field)] = true
+		}
+	}
+	return capabilities, nil
+}
+
+// startTLS upgrades the connection to TLS (RFC 3501 section 6.2.1)
+func (c *imapConn) startTLS(host string) error {
+	_, err := c.command("STARTTLS")
+	if err != nil {
+		return fmt.Errorf("cannot start TLS: %w", err)
+	}
+
+	conn := tls.Client(c.conn, &tls.Config{ServerName: host})
+	err = conn.Handshake()
+	if err != nil {
+		return fmt.Errorf("cannot start TLS: %w", err)
+	}
+	c.conn = conn
+	c.reader = bufio.NewReader(conn)
+	return nil
+}
+
+func (c *imapConn) close() error {
+	return c.conn.Close()
+}
+
+// readResponse reads one response line, including any literals it continues into
+func (c *imapConn) readResponse() (imapResponse, error) {
+	var response imapResponse
+	var text strings.Builder
+	for {
+		line, err := c.reader.ReadString('\n')
+		if err != nil {
+			return imapResponse{}, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		text.WriteString(line)
+
+		size, ok := imapLiteralSize(line)
+		if !ok {
+			response.text = text.String()
+			return response, nil
+		}
+		literal := make([]byte, size)
+		_, err = io.ReadFull(c.reader, literal)
+		if err != nil {
+			return imapResponse{}, err
+		}
+		response.literals = append(response.literals, literal)
+	}
+}
+
+// imapLiteralSize reads the size of the literal that follows a line ending in {size}
+func imapLiteralSize(line string) (int, bool) {
+	if !strings.HasSuffix(line, "}") {
+		return 0, false
+	}
+	open := strings.LastIndex(line, "{")
+	if open < 0 {
+		return 0, false
+	}
+	size, err := strconv.Atoi(strings.TrimSuffix(line[open+1:len(line)-1], "+"))
+	if err != nil || size < 0 {
+		return 0, false
+	}
+	return size, true
+}
+
+// command sends a command and returns its untagged responses, failing unless it completes with OK
+func (c *imapConn) command(command string) ([]imapResponse, error) {
+	c.tag++
+	tag := fmt.Sprintf("A%03d", c.tag)
+	_, err := fmt.Fprintf(c.conn, "%s %s\r\n", tag, command)
+	if err != nil {
+		return nil, fmt.Errorf("cannot send command: %w", err)
+	}
+	return c.readCompletion(tag)
+}
+
+// readCompletion reads responses until the one tagged with tag
+func (c *imapConn) readCompletion(tag string) ([]imapResponse, error) {
+	var responses []imapResponse
+	for {
+		response, err := c.readResponse()
+		if err != nil {
+			return nil, fmt.Errorf("cannot read response: %w", err)
+		}
+		if status, ok := strings.CutPrefix(response.text, tag+" "); ok {
+			if !strings.HasPrefix(strings.ToUpper(status), "OK") {
+				return nil, fmt.Errorf("command failed: %s", status)
+			}
+			return responses, nil
+		}
+		responses = append(responses, response)
+	}
+}
+
+// imapQuote quotes a string argument
+func imapQuote(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
+
+func (c *imapConn) login(username, password string) error {
+	capabilities, err := c.capabilities()
+	if err != nil {
+		return fmt.Errorf("cannot log in: %w", err)
+	}
+	if capabilities["LOGINDISABLED"] {
+		return fmt.Errorf("cannot log in: the server doesn't allow LOGIN on this connection")
+	}
+
+	_, err = c.command("LOGIN " + imapQuote(username) + " " + imapQuote(password))
+	if err != nil {
+		return fmt.Errorf("cannot log in: %w", err)
+	}
+	return nil
+}
+
+// selectFolder opens a folder, read-only unless messages are going to be changed
+func (c *imapConn) selectFolder(folder string, readOnly bool) error {
+	command := "SELECT "
+	if readOnly {
+		command = "EXAMINE "
+	}
+	_, err := c.command(command + imapQuote(folder))
+	if err != nil {
+		return fmt.Errorf("cannot open %s: %w", folder, err)
+	}
+	return nil
+}
+
+// searchUIDs returns the UIDs of the messages in the open folder matching the search criteria, in ascending order
+func (c *imapConn) searchUIDs(criteria string) ([]uint32, error) {
+	responses, err := c.command("UID SEARCH " + criteria)
+	if err != nil {
+		return nil, fmt.Errorf("cannot search: %w", err)
+	}
+
+	var uids []uint32
+	for _, response := range responses {
+		fields, ok := strings.CutPrefix(response.text, "* SEARCH")
+		if !ok {
+			continue
+		}
+		for _, field := range strings.Fields(fields) {
+			uid, err := strconv.ParseUint(field, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("cannot parse search result %q", field)
+			}
+			uids = append(uids, uint32(uid))
+		}
+	}
+
+	sort.Slice(uids, func(i, j int) bool { return uids[i] < uids[j] })
+	return uids, nil
+}
+
+// fetchMessages fetches the first maxBytes of the given messages, without marking them as read
+func (c *imapConn) fetchMessages(uids []uint32, maxBytes int) (map[uint32][]byte, error) {
+	messages := map[uint32][]byte{}
+	if len(uids) == 0 {
+		return messages, nil
+	}
+
+	responses, err := c.command(fmt.Sprintf("UID FETCH %s (UID BODY.PEEK[]<0.%d>)", imapSequenceSet(uids), maxBytes))
+	if err != nil {
+		return nil, fmt.Errorf("cannot fetch messages: %w", err)
+	}
+
+	for _, response := range responses {
+		if !strings.Contains(response.text, " FETCH ") || len(response.literals) == 0 {
+			continue
+		}
+		match := imapFetchUIDPattern.FindStringSubmatch(response.text)
+		if match == nil {
+			continue
+		}
+		uid, err := strconv.ParseUint(match[1], 10, 32)
+		if err != nil {
+			continue
+		}
+		messages[uint32(uid)] = response.literals[0]
+	}
+	return messages, nil
+}
+
+func (c *imapConn) logout() error {
+	_, err := c.command("LOGOUT")
+	return err
+}
+
+// imapSequenceSet lists UIDs as a comma-separated set
+func imapSequenceSet(uids []uint32) string {
+	set := make([]string, len(uids))
+	for i, uid := range uids {
+		set[i] = strconv.FormatUint(uint64(uid), 10)
+	}
+	return strings.Join(set, ",")
+}
+
+// storeFlags adds flags to, or removes them from, messages in the open folder
+func (c *imapConn) storeFlags(uids []uint32, add bool, flags ...string) error {
+	operation := "-FLAGS.SILENT"
+	if add {
+		operation = "+FLAGS.SILENT"
+	}
+	_, err := c.command(fmt.Sprintf("UID STORE %s %s (%s)", imapSequenceSet(uids), operation, strings.Join(flags, " ")))
+	if err != nil {
+		return fmt.Errorf("cannot change flags: %w", err)
+	}
+	return nil
+}
+
+// moveMessages moves messages from the open folder to another one. Servers without the
+// MOVE extension need UIDPLUS to copy and delete them, as a plain EXPUNGE would also
+// remove any other message marked as deleted.
+func (c *imapConn) moveMessages(uids []uint32, folder string) error {
+	capabilities, err := c.capabilities()
+	if err != nil {
+		return fmt.Errorf("cannot move messages: %w", err)
+	}
+
+	set := imapSequenceSet(uids)
+	switch {
+	case capabilities["MOVE"]:
+		_, err = c.command("UID MOVE " + set + " " + imapQuote(folder))
+		if err != nil {
+			return fmt.Errorf("cannot move messages: %w", err)
+		}
+		return nil
+	case !capabilities["UIDPLUS"]:
+		return fmt.Errorf("cannot move messages: the server supports neither MOVE nor UIDPLUS")
+	}
+
+	_, err = c.command("UID COPY " + set + " " + imapQuote(folder))
+	if err != nil {
+		return fmt.Errorf("cannot move messages: %w", err)
+	}
+	err = c.storeFlags(uids, true, `\Deleted`)
+	if err != nil {
+		return fmt.Errorf("cannot move messages: %w", err)
+	}
+	_, err = c.command("UID EXPUNGE " + set)
+	if err != nil {
+		return fmt.Errorf("cannot move messages: %w", err)
+	}
+	return nil
+}
+
+// appendMessage adds a message to a folder, sending it as a literal once the server is ready for it
+func (c *imapConn) appendMessage(folder string, message []byte, flags ...string) error {
+	c.tag++
+	tag := fmt.Sprintf("A%03d", c.tag)
+	_, err := fmt.Fprintf(c.conn, "%s APPEND %s (%s) {%d}\r\n", tag, imapQuote(folder), strings.Join(flags, " "), len(message))
+	if err != nil {
+		return fmt.Errorf("cannot send command: %w", err)
+	}
+
+	response, err := c.readResponse()
+	if err != nil {
+		return fmt.Errorf("cannot read response: %w", err)
+	}
+	if !strings.HasPrefix(response.text, "+") {
+		return fmt.Errorf("cannot append to %s: %s", folder, response.text)
+	}
+
+	_, err = c.conn.Write(append(message, "\r\n"...))
+	if err != nil {
+		return fmt.Errorf("cannot send message: %w", err)
+	}
+	_, err = c.readCompletion(tag)
+	if err != nil {
+		return fmt.Errorf("cannot append to %s: %w", folder, err)
+	}
+	return nil
+}
@@ -0,0 +1,493 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeIMAPServer speaks just enough IMAP for the email client, over plain TCP
+type fakeIMAPServer struct {
+	listener net.Listener
+
+	mu       sync.Mutex
+	commands []string
+	// folders holds the raw messages and their flags by UID
+	folders map[string]map[uint32]*fakeIMAPMessage
+	// noMove and noUIDPlus make the server act like one without the MOVE or UIDPLUS extension
+	noMove    bool
+	noUIDPlus bool
+	// capabilities are advertised in addition to IMAP4rev1
+	capabilities []string
+}
+
+type fakeIMAPMessage struct {
+	raw   string
+	flags []string
+}
+
+func newFakeIMAPServer(t *testing.T, folders map[string]map[uint32]*fakeIMAPMessage) *fakeIMAPServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	server := &fakeIMAPServer{listener: listener, folders: folders}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { listener.Close() })
+	return server
+}
+
+func (s *fakeIMAPServer) setupEnvVars(t *testing.T) {
+	host, port, _ := net.SplitHostPort(s.listener.Addr().String())
+	t.Setenv("EMAIL_PROVIDER", "imap")
+	t.Setenv("IMAP_HOST", host)
+	t.Setenv("IMAP_PORT", port)
+	t.Setenv("IMAP_TLS", "false")
+	t.Setenv("IMAP_USERNAME", "test")
+	t.Setenv("IMAP_PASSWORD", `se"cret`)
+}
+
+func (s *fakeIMAPServer) received() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.commands...)
+}
+
+func (s *fakeIMAPServer) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	fmt.Fprint(conn, "* OK fake IMAP ready\r\n")
+
+	selected := ""
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		tag, command, _ := strings.Cut(line, " ")
+
+		s.mu.Lock()
+		s.commands = append(s.commands, command)
+		upper := strings.ToUpper(command)
+		switch {
+		case upper == "CAPABILITY":
+			capabilities := append([]string{"IMAP4rev1"}, s.capabilities...)
+			if !s.noMove {
+				capabilities = append(capabilities, "MOVE")
+			}
+			if !s.noUIDPlus {
+				capabilities = append(capabilities, "UIDPLUS")
+			}
+			fmt.Fprintf(conn, "* CAPABILITY %s\r\n%s OK capability done\r\n", strings.Join(capabilities, " "), tag)
+		case upper == `LOGIN "TEST" "SE\"CRET"`:
+			fmt.Fprintf(conn, "%s OK logged in\r\n", tag)
+		case strings.HasPrefix(upper, "LOGIN"):
+			fmt.Fprintf(conn, "%s NO invalid credentials\r\n", tag)
+		case strings.HasPrefix(upper, "SELECT ") || strings.HasPrefix(upper, "EXAMINE "):
+			_, folder, _ := strings.Cut(command, " ")
+			folder = strings.Trim(folder, `"`)
+			if _, ok := s.folders[folder]; !ok {
+				fmt.Fprintf(conn, "%s NO no such folder\r\n", tag)
+				break
+			}
+			selected = folder
+			fmt.Fprintf(conn, "* %d EXISTS\r\n%s OK selected\r\n", len(s.folders[folder]), tag)
+		case upper == "UID SEARCH UNSEEN":
+			var uids []string
+			for uid, message := range s.folders[selected] {
+				if !slices.Contains(message.flags, `\Seen`) {
+					uids = append(uids, strconv.FormatUint(uint64(uid), 10))
+				}
+			}
+			fmt.Fprintf(conn, "* SEARCH %s\r\n%s OK search done\r\n", strings.Join(uids, " "), tag)
+		case strings.HasPrefix(upper, "UID SEARCH HEADER MESSAGE-ID "):
+			fields := strings.SplitN(command, " ", 5)
+			messageID := strings.Trim(fields[4], `"`)
+			var uids []string
+			for uid, message := range s.folders[selected] {
+				if strings.Contains(message.raw, "Message-ID: <"+messageID+">") {
+					uids = append(uids, strconv.FormatUint(uint64(uid), 10))
+				}
+			}
+			fmt.Fprintf(conn, "* SEARCH %s\r\n%s OK search done\r\n", strings.Join(uids, " "), tag)
+		case strings.HasPrefix(upper, "APPEND "):
+			fields := strings.SplitN(command, " ", 3)
+			folder := strings.Trim(fields[1], `"`)
+			size, _ := imapLiteralSize(command)
+			fmt.Fprint(conn, "+ ready\r\n")
+			literal := make([]byte, size+2)
+			_, err := io.ReadFull(reader, literal)
+			if err != nil {
+				s.mu.Unlock()
+				return
+			}
+			if _, ok := s.folders[folder]; !ok {
+				fmt.Fprintf(conn, "%s NO no such folder\r\n", tag)
+				break
+			}
+			flags := strings.Fields(command[strings.Index(command, "(")+1 : strings.Index(command, ")")])
+			s.folders[folder][uint32(len(s.folders[folder])+1)] = &fakeIMAPMessage{raw: string(literal[:size]), flags: flags}
+			fmt.Fprintf(conn, "%s OK appended\r\n", tag)
+		case strings.HasPrefix(upper, "UID STORE "):
+			fields := strings.SplitN(command, " ", 5)
+			flags := strings.Fields(strings.Trim(fields[4], "()"))
+			for _, uid := range fakeIMAPUIDs(fields[2]) {
+				message, ok := s.folders[selected][uid]
+				if !ok {
+					continue
+				}
+				message.flags = slices.DeleteFunc(message.flags, func(flag string) bool { return slices.Contains(flags, flag) })
+				if strings.HasPrefix(fields[3], "+") {
+					message.flags = append(message.flags, flags...)
+				}
+			}
+			fmt.Fprintf(conn, "%s OK store done\r\n", tag)
+		case (strings.HasPrefix(upper, "UID MOVE ") && !s.noMove) || strings.HasPrefix(upper, "UID COPY "):
+			fields := strings.SplitN(command, " ", 4)
+			folder := strings.Trim(fields[3], `"`)
+			if _, ok := s.folders[folder]; !ok {
+				fmt.Fprintf(conn, "%s NO no such folder\r\n", tag)
+				break
+			}
+			for _, uid := range fakeIMAPUIDs(fields[2]) {
+				message, ok := s.folders[selected][uid]
+				if !ok {
+					continue
+				}
+				next := uint32(1)
+				for existing := range s.folders[folder] {
+					next = max(next, existing+1)
+				}
+				s.folders[folder][next] = &fakeIMAPMessage{raw: message.raw, flags: slices.Clone(message.flags)}
+				if strings.HasPrefix(upper, "UID MOVE ") {
+					delete(s.folders[selected], uid)
+				}
+			}
+			fmt.Fprintf(conn, "%s OK done\r\n", tag)
+		case upper == "EXPUNGE" || (strings.HasPrefix(upper, "UID EXPUNGE ") && !s.noUIDPlus):
+			var uids []uint32
+			if strings.HasPrefix(upper, "UID EXPUNGE ") {
+				uids = fakeIMAPUIDs(strings.Fields(command)[2])
+			}
+			for uid, message := range s.folders[selected] {
+				if slices.Contains(message.flags, `\Deleted`) && (uids == nil || slices.Contains(uids, uid)) {
+					delete(s.folders[selected], uid)
+				}
+			}
+			fmt.Fprintf(conn, "%s OK expunged\r\n", tag)
+		case strings.HasPrefix(upper, "UID FETCH "):
+			set := strings.Fields(command)[2]
+			for i, field := range strings.Split(set, ",") {
+				uid, _ := strconv.ParseUint(field, 10, 32)
+				message, ok := s.folders[selected][uint32(uid)]
+				if !ok {
+					continue
+				}
+				fmt.Fprintf(conn, "* %d FETCH (UID %d BODY[]<0> {%d}\r\n%s)\r\n", i+1, uid, len(message.raw), message.raw)
+			}
+			fmt.Fprintf(conn, "%s OK fetch done\r\n", tag)
+		case upper == "LOGOUT":
+			fmt.Fprintf(conn, "* BYE\r\n%s OK bye\r\n", tag)
+			s.mu.Unlock()
+			return
+		default:
+			fmt.Fprintf(conn, "%s BAD unknown command\r\n", tag)
+		}
+		s.mu.Unlock()
+	}
+}
+
+func fakeIMAPUIDs(set string) []uint32 {
+	var uids []uint32
+	for _, field := range strings.Split(set, ",") {
+		uid, _ := strconv.ParseUint(field, 10, 32)
+		uids = append(uids, uint32(uid))
+	}
+	return uids
+}
+
+func testEmail(messageID, subject, date, body string) string {
+	return "Message-ID: <" + messageID + ">\r\n" +
+		"From: Sam <sam@example.com>\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"Date: " + date + "\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" + body + "\r\n"
+}
+
+func TestIMAPEmailClient_GetUnread(t *testing.T) {
+	server := newFakeIMAPServer(t, map[string]map[uint32]*fakeIMAPMessage{
+		"INBOX": {
+			1: {raw: testEmail("read@test", "Already read", "Mon, 1 Jan 2024 09:00:00 +0000", "Old news."), flags: []string{`\Seen`}},
+			2: {raw: testEmail("first@test", "First", "Mon, 1 Jan 2024 10:00:00 +0000", "Hello.")},
+			3: {raw: testEmail("second@test", "Second", "Tue, 2 Jan 2024 10:00:00 +0000", "Hi there.\r\n\r\nOn Mon, Jan 1, 2024 at 9:00 AM Alex wrote:\r\n> earlier")},
+		},
+		"Work": {
+			7: {raw: testEmail("work@test", "Work", "Mon, 1 Jan 2024 12:00:00 +0000", "Report attached.")},
+		},
+	})
+	server.setupEnvVars(t)
+	t.Setenv("IMAP_FOLDERS", "INBOX,Work")
+
+	emailClient, err := newEmailClient()
+	require.NoError(t, err)
+
+	messages, err := emailClient.getUnread()
+	require.NoError(t, err)
+	require.Len(t, messages, 3)
+
+	// newest first, across folders
+	require.Equal(t, "Second", messages[0].Subject)
+	require.Equal(t, uint32(3), messages[0].UID)
+	require.Equal(t, "INBOX", messages[0].Folder)
+	require.Equal(t, "second@test", messages[0].MessageID)
+	require.Equal(t, "Sam <sam@example.com>", messages[0].From)
+	require.Equal(t, "Hi there.", messages[0].Text)
+	require.Equal(t, "Work", messages[1].Subject)
+	require.Equal(t, "Work", messages[1].Folder)
+	require.Equal(t, "First", messages[2].Subject)
+
+	// folders are opened read-only and messages fetched without marking them read
+	commands := server.received()
+	require.Contains(t, commands, `EXAMINE "INBOX"`)
+	for _, command := range commands {
+		if strings.HasPrefix(command, "UID FETCH") {
+			require.Contains(t, command, "BODY.PEEK[]")
+		}
+	}
+}
+
+func TestIMAPEmailClient_LoginFailure(t *testing.T) {
+	server := newFakeIMAPServer(t, map[string]map[uint32]*fakeIMAPMessage{"INBOX": {}})
+	server.setupEnvVars(t)
+	t.Setenv("IMAP_PASSWORD", "wrong")
+
+	emailClient, err := newEmailClient()
+	require.NoError(t, err)
+
+	messages, err := emailClient.getUnread()
+	require.Error(t, err)
+	require.Nil(t, messages)
+}
+
+func TestIMAPEmailClient_LoginDisabled(t *testing.T) {
+	server := newFakeIMAPServer(t, map[string]map[uint32]*fakeIMAPMessage{"INBOX": {}})
+	server.capabilities = []string{"LOGINDISABLED"}
+	server.setupEnvVars(t)
+
+	emailClient, err := newEmailClient()
+	require.NoError(t, err)
+
+	// the password is never sent
+	_, err = emailClient.getUnread()
+	require.ErrorContains(t, err, "doesn't allow LOGIN")
+	require.False(t, slices.ContainsFunc(server.received(), func(command string) bool { return strings.HasPrefix(command, "LOGIN") }))
+}
+
+func TestIMAPLoopback(t *testing.T) {
+	require.True(t, imapLoopback("localhost"))
+	require.True(t, imapLoopback("127.0.0.1"))
+	require.True(t, imapLoopback("::1"))
+	require.False(t, imapLoopback("imap.example.com"))
+	require.False(t, imapLoopback("192.168.1.10"))
+}
+
+func TestIMAPEmailClient_SetSeen(t *testing.T) {
+	server := newFakeIMAPServer(t, map[string]map[uint32]*fakeIMAPMessage{
+		"INBOX": {
+			2: {raw: testEmail("first@test", "First", "Mon, 1 Jan 2024 10:00:00 +0000", "Hello.")},
+			3: {raw: testEmail("second@test", "Second", "Tue, 2 Jan 2024 10:00:00 +0000", "Hi there.")},
+		},
+	})
+	server.setupEnvVars(t)
+
+	emailClient, err := newEmailClient()
+	require.NoError(t, err)
+	writer := emailClient.(emailWriter)
+
+	messages, err := emailClient.getUnread()
+	require.NoError(t, err)
+	require.Len(t, messages, 2)
+
+	err = writer.setSeen([]emailRef{{Folder: "INBOX", UID: 2}}, true)
+	require.NoError(t, err)
+	require.Contains(t, server.received(), `UID STORE 2 +FLAGS.SILENT (\Seen)`)
+
+	// the unread list is fetched again after a change
+	messages, err = emailClient.getUnread()
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	require.Equal(t, "Second", messages[0].Subject)
+
+	err = writer.setSeen([]emailRef{{Folder: "INBOX", UID: 2}}, false)
+	require.NoError(t, err)
+	messages, err = emailClient.getUnread()
+	require.NoError(t, err)
+	require.Len(t, messages, 2)
+}
+
+func TestIMAPEmailClient_Move(t *testing.T) {
+	for _, noMove := range []bool{false, true} {
+		t.Run(fmt.Sprintf("noMove=%v", noMove), func(t *testing.T) {
+			server := newFakeIMAPServer(t, map[string]map[uint32]*fakeIMAPMessage{
+				"INBOX": {
+					2: {raw: testEmail("first@test", "First", "Mon, 1 Jan 2024 10:00:00 +0000", "Hello.")},
+					3: {raw: testEmail("second@test", "Second", "Tue, 2 Jan 2024 10:00:00 +0000", "Hi there.")},
+					// deleted in the mail client, but not expunged yet
+					4: {raw: testEmail("deleted@test", "Deleted", "Mon, 1 Jan 2024 09:00:00 +0000", "Gone."), flags: []string{`\Seen`, `\Deleted`}},
+				},
+				"Archive": {
+					5: {raw: testEmail("old@test", "Old", "Mon, 1 Jan 2024 08:00:00 +0000", "Old."), flags: []string{`\Seen`}},
+				},
+			})
+			server.mu.Lock()
+			server.noMove = noMove
+			server.mu.Unlock()
+			server.setupEnvVars(t)
+
+			emailClient, err := newEmailClient()
+			require.NoError(t, err)
+			writer := emailClient.(emailWriter)
+			require.Equal(t, "Archive", writer.archiveFolder())
+
+			moved, err := writer.move([]emailRef{{Folder: "INBOX", UID: 3, MessageID: "second@test"}}, "Archive")
+			require.NoError(t, err)
+			require.Equal(t, []emailRef{{Folder: "Archive", UID: 6, MessageID: "second@test"}}, moved)
+
+			messages, err := emailClient.getUnread()
+			require.NoError(t, err)
+			require.Len(t, messages, 1)
+			require.Equal(t, "First", messages[0].Subject)
+
+			// and back again
+			_, err = writer.move(moved, "INBOX")
+			require.NoError(t, err)
+			messages, err = emailClient.getUnread()
+			require.NoError(t, err)
+			require.Len(t, messages, 2)
+
+			// only the moved messages are expunged
+			server.mu.Lock()
+			_, ok := server.folders["INBOX"][4]
+			server.mu.Unlock()
+			require.True(t, ok)
+		})
+	}
+}
+
+func TestIMAPEmailClient_MoveUnsupported(t *testing.T) {
+	server := newFakeIMAPServer(t, map[string]map[uint32]*fakeIMAPMessage{
+		"INBOX":   {3: {raw: testEmail("second@test", "Second", "Tue, 2 Jan 2024 10:00:00 +0000", "Hi there.")}},
+		"Archive": {},
+	})
+	server.noMove = true
+	server.noUIDPlus = true
+	server.setupEnvVars(t)
+
+	emailClient, err := newEmailClient()
+	require.NoError(t, err)
+
+	// without MOVE or UIDPLUS the messages aren't copied, so nothing is left half-moved
+	_, err = emailClient.(emailWriter).move([]emailRef{{Folder: "INBOX", UID: 3, MessageID: "second@test"}}, "Archive")
+	require.ErrorContains(t, err, "neither MOVE nor UIDPLUS")
+	require.False(t, slices.ContainsFunc(server.received(), func(command string) bool { return strings.HasPrefix(command, "UID COPY") }))
+	require.Empty(t, server.folders["Archive"])
+}
+
+func TestIMAPEmailClient_ThreadAndSaveDraft(t *testing.T) {
+	reply := "Message-ID: <reply@test>\r\n" +
+		"From: Sam <sam@example.com>\r\n" +
+		"Subject: Re: Plans\r\n" +
+		"Date: Wed, 3 Jan 2024 10:00:00 +0000\r\n" +
+		"In-Reply-To: <mine@test>\r\n" +
+		"References: <start@test> <mine@test>\r\n" +
+		"\r\nSounds good, Friday?\r\n"
+	server := newFakeIMAPServer(t, map[string]map[uint32]*fakeIMAPMessage{
+		"INBOX": {
+			1: {raw: testEmail("start@test", "Plans", "Mon, 1 Jan 2024 10:00:00 +0000", "Want to meet up?"), flags: []string{`\Seen`}},
+			2: {raw: reply},
+		},
+		"Sent":   {4: {raw: testEmail("mine@test", "Re: Plans", "Tue, 2 Jan 2024 10:00:00 +0000", "Sure, when?"), flags: []string{`\Seen`}}},
+		"Drafts": {},
+	})
+	server.setupEnvVars(t)
+
+	emailClient, err := newEmailClient()
+	require.NoError(t, err)
+	drafter := emailClient.(emailDrafter)
+
+	messages, err := emailClient.getUnread()
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+
+	thread, err := drafter.thread(messages[0])
+	require.NoError(t, err)
+	require.Len(t, thread, 2)
+	require.Equal(t, "Want to meet up?", thread[0].Text)
+	require.Equal(t, "Sure, when?", thread[1].Text)
+	require.Equal(t, "Sent", thread[1].Folder)
+
+	draft := "Subject: Re: Plans\r\n\r\nFriday works.\r\n"
+	err = drafter.saveDraft([]byte(draft))
+	require.NoError(t, err)
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	require.Equal(t, draft, server.folders["Drafts"][1].raw)
+	require.Equal(t, []string{`\Draft`, `\Seen`}, server.folders["Drafts"][1].flags)
+}
+
+func TestNewEmailClient(t *testing.T) {
+	os.Unsetenv("EMAIL_PROVIDER")
+	emailClient, err := newEmailClient()
+	require.NoError(t, err)
+	messages, err := emailClient.getUnread()
+	require.NoError(t, err)
+	require.Empty(t, messages)
+
+	t.Setenv("EMAIL_PROVIDER", "imap")
+	t.Setenv("IMAP_HOST", "")
+	_, err = newEmailClient()
+	require.Error(t, err)
+
+	t.Setenv("EMAIL_PROVIDER", "pop3")
+	_, err = newEmailClient()
+	require.Error(t, err)
+
+	// limits must be positive
+	t.Setenv("EMAIL_PROVIDER", "imap")
+	t.Setenv("IMAP_HOST", "localhost")
+	t.Setenv("IMAP_USERNAME", "me")
+	t.Setenv("IMAP_PASSWORD", "secret")
+	for _, name := range []string{"EMAIL_MAX_MESSAGES", "EMAIL_MAX_CHARS"} {
+		t.Setenv(name, "-1")
+		_, err = newEmailClient()
+		require.ErrorContains(t, err, name+" must be greater than 0")
+		t.Setenv(name, "")
+	}
+}
+
+func TestIMAPLiteralSize(t *testing.T) {
+	size, ok := imapLiteralSize("* 1 FETCH (UID 2 BODY[] {42}")
+	require.True(t, ok)
+	require.Equal(t, 42, size)
+
+	_, ok = imapLiteralSize("* OK done")
+	require.False(t, ok)
+}
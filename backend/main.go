@@ -23,6 +23,17 @@ func main() {
 		log.Fatal(fmt.Errorf("can't create calendar client: %w", err))
 	}
 
+	// events can only be added to some providers
+	calendarWriter, ok := calendarClient.(calendarWriter)
+	if ok && !calendarWriter.writable() {
+		calendarWriter = nil
+	}
+
+	calendarClient, err = newWindowedCalendarClient(calendarClient)
+	if err != nil {
+		log.Fatal(fmt.Errorf("can't create calendar window: %w", err))
+	}
+
 	// local ai clients
 	openWebUIClient, err := newOpenWebUIClient()
 	if err != nil {
@@ -34,12 +45,126 @@ func main() {
 		log.Fatal(fmt.Errorf("can't create automaticSD API client: %w", err))
 	}
 
+	// news pipeline: collapse duplicate stories, rank them by interest using
+	// the local model, then fetch the full text of the top stories
+	newsClient, err = newClusteredNewsClient(newsClient)
+	if err != nil {
+		log.Fatal(fmt.Errorf("can't create news clustering: %w", err))
+	}
+
+	newsClient, err = newRankedNewsClient(newsClient, openWebUIClient)
+	if err != nil {
+		log.Fatal(fmt.Errorf("can't create news ranking: %w", err))
+	}
+
+	newsClient, err = newArticleNewsClient(newsClient)
+	if err != nil {
+		log.Fatal(fmt.Errorf("can't create news article extraction: %w", err))
+	}
+
+	scheduleSettings, err := newScheduleSettings()
+	if err != nil {
+		log.Fatal(fmt.Errorf("can't read schedule settings: %w", err))
+	}
+
+	emailClient, err := newEmailClient()
+	if err != nil {
+		log.Fatal(fmt.Errorf("can't create email client: %w", err))
+	}
+
+	// messages can only be changed by some providers
+	emailWriter, _ := emailClient.(emailWriter)
+	emailThreader, _ := emailClient.(emailThreader)
+	emailDrafter, _ := emailClient.(emailDrafter)
+	emailDraftSettings := newEmailDraftSettings()
+
+	emailActionLog, err := newEmailActionLog()
+	if err != nil {
+		log.Fatal(fmt.Errorf("can't create email audit log: %w", err))
+	}
+
+	emailClient, err = newRiskScoredEmailClient(emailClient, openWebUIClient)
+	if err != nil {
+		log.Fatal(fmt.Errorf("can't create email risk scoring: %w", err))
+	}
+
+	emailClient, err = newClassifiedEmailClient(emailClient, openWebUIClient)
+	if err != nil {
+		log.Fatal(fmt.Errorf("can't create email classification: %w", err))
+	}
+
+	emailClient, err = newExtractedEmailClient(emailClient, openWebUIClient, calendarWriter, scheduleSettings.calendar.location)
+	if err != nil {
+		log.Fatal(fmt.Errorf("can't create email extraction: %w", err))
+	}
+
+	// speech-to-text for the voice interface
+	whisperClient, err := newWhisperClient()
+	if err != nil {
+		log.Fatal(fmt.Errorf("can't create whisper client: %w", err))
+	}
+
+	// items summarized so far
+	seenStore, err := newSeenStore()
+	if err != nil {
+		log.Fatal(fmt.Errorf("can't create seen store: %w", err))
+	}
+
 	// set up web server
 	http.HandleFunc("/updates", func(w http.ResponseWriter, r *http.Request) {
-		err := getUpdates(w, r, openWebUIClient, automaticSDClient, weatherClient, newsClient, calendarClient)
+		err := getUpdates(w, r, openWebUIClient, automaticSDClient, weatherClient, newsClient, calendarClient, scheduleSettings, emailClient, emailThreader, seenStore)
 		writeHttpError(w, http.StatusInternalServerError, "cannot get updates", err)
 	})
 
+	http.HandleFunc("GET /calendar/free", func(w http.ResponseWriter, r *http.Request) {
+		err := getCalendarFree(w, r, calendarClient, scheduleSettings)
+		if err != nil {
+			writeHttpError(w, http.StatusInternalServerError, "cannot get free time", err)
+		}
+	})
+
+	http.HandleFunc("POST /calendar/events", func(w http.ResponseWriter, r *http.Request) {
+		err := postCalendarEvent(w, r, openWebUIClient, calendarWriter, scheduleSettings.calendar)
+		if err != nil {
+			writeHttpError(w, http.StatusInternalServerError, "cannot create event", err)
+		}
+	})
+
+	http.HandleFunc("POST /emails/actions", func(w http.ResponseWriter, r *http.Request) {
+		err := postEmailAction(w, r, emailClient, emailWriter, emailActionLog)
+		if err != nil {
+			writeHttpError(w, http.StatusInternalServerError, "cannot change emails", err)
+		}
+	})
+
+	http.HandleFunc("POST /emails/dismiss", func(w http.ResponseWriter, r *http.Request) {
+		err := postEmailDismiss(w, r, emailClient, emailWriter, emailActionLog)
+		if err != nil {
+			writeHttpError(w, http.StatusInternalServerError, "cannot dismiss emails", err)
+		}
+	})
+
+	http.HandleFunc("POST /emails/actions/{id}/undo", func(w http.ResponseWriter, r *http.Request) {
+		err := postEmailUndo(w, r, emailWriter, emailActionLog)
+		if err != nil {
+			writeHttpError(w, http.StatusInternalServerError, "cannot undo email action", err)
+		}
+	})
+
+	http.HandleFunc("POST /emails/drafts", func(w http.ResponseWriter, r *http.Request) {
+		err := postEmailDraft(w, r, openWebUIClient, emailClient, emailDrafter, emailDraftSettings, emailActionLog)
+		if err != nil {
+			writeHttpError(w, http.StatusInternalServerError, "cannot draft reply", err)
+		}
+	})
+
+	http.HandleFunc("POST /voice/transcribe", func(w http.ResponseWriter, r *http.Request) {
+		err := postVoiceTranscribe(w, r, whisperClient)
+		if err != nil {
+			writeHttpError(w, http.StatusInternalServerError, "cannot transcribe recording", err)
+		}
+	})
+
 	http.ListenAndServe(":8080", nil)
 }
 
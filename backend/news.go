@@ -4,8 +4,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"net/http"
 	"os"
+	"time"
 )
 
 type newsClient interface {
@@ -13,17 +13,44 @@ type newsClient interface {
 }
 
 type news struct {
-	apiKey string
-	baseURL string	
+	apiKey  string
+	baseURL string
+
+	cache *ttlCache[[]newsResult]
 }
 
 type newsResult struct {
-	Title string `json:"title"`
-	Description string `json:"description"`
-	URL string `json:"url"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	URL         string    `json:"url"`
+	Published   time.Time `json:"published"`
+	Source      string    `json:"source,omitempty"`
+	// ClusterSize is the number of stories covering the same event, when there are several
+	ClusterSize int      `json:"cluster_size,omitempty"`
+	Sources     []string `json:"sources,omitempty"`
+	// Content is the main text of the article, when it was extracted
+	Content string `json:"content,omitempty"`
 }
 
+const (
+	newsCacheDuration = 15 * time.Minute
+	newsCacheMaxStale = 2 * time.Hour
+)
+
+// newNewsClient creates the news client selected by NEWS_PROVIDER, either
+// "api" (the default) or "feeds" for RSS and Atom feeds
 func newNewsClient() (newsClient, error) {
+	switch os.Getenv("NEWS_PROVIDER") {
+	case "", "api":
+		return newNewsAPIClient()
+	case "feeds":
+		return newFeedNewsClient()
+	default:
+		return nil, fmt.Errorf("unknown NEWS_PROVIDER %q", os.Getenv("NEWS_PROVIDER"))
+	}
+}
+
+func newNewsAPIClient() (newsClient, error) {
 	if os.Getenv("NEWS_API_KEY") == "" {
 		return nil, fmt.Errorf("NEWS_API_KEY is not set")
 	}
@@ -32,16 +59,26 @@ func newNewsClient() (newsClient, error) {
 		return nil, fmt.Errorf("NEWS_BASE_URL is not set")
 	}
 
+	cache, err := newSourceCache[[]newsResult]("NEWS", newsCacheDuration, newsCacheMaxStale)
+	if err != nil {
+		return nil, err
+	}
+
 	return &news{
-		apiKey: os.Getenv("NEWS_API_KEY"),
+		apiKey:  os.Getenv("NEWS_API_KEY"),
 		baseURL: os.Getenv("NEWS_BASE_URL"),
+		cache:   cache,
 	}, nil
 }
 
 func (n *news) get() ([]newsResult, error) {
+	return n.cache.get("news", n.fetch)
+}
+
+func (n *news) fetch() ([]newsResult, error) {
 	url := fmt.Sprintf("%s/news?apiKey=%s", n.baseURL, n.apiKey)
 
-	resp, err := http.Get(url)
+	resp, err := sourceHTTPClient.Get(url)
 	if err != nil {
 		return nil, fmt.Errorf("cannot get news: %w", err)
 	}
@@ -52,7 +89,7 @@ func (n *news) get() ([]newsResult, error) {
 	if err != nil {
 		return nil, fmt.Errorf("cannot read news: %w", err)
 	}
-	
+
 	var results []newsResult
 	err = json.Unmarshal(body, &results)
 	if err != nil {
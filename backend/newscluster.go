@@ -0,0 +1,144 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// clusteredNews wraps a news source, collapsing stories that several outlets
+// cover into one representative with the size of its cluster
+type clusteredNews struct {
+	source    newsClient
+	threshold float64
+}
+
+const defaultNewsClusterThreshold = 0.5
+
+// newsStopWords are left out when comparing stories, so they don't look alike
+// just for sharing common words
+var newsStopWords = map[string]bool{
+	"the": true, "and": true, "for": true, "with": true, "that": true, "this": true,
+	"from": true, "are": true, "was": true, "were": true, "has": true, "have": true,
+	"will": true, "its": true, "after": true, "over": true, "into": true, "about": true,
+	"says": true, "said": true, "new": true, "not": true, "but": true, "than": true,
+}
+
+func newClusteredNewsClient(source newsClient) (newsClient, error) {
+	threshold, err := envFloat("NEWS_CLUSTER_THRESHOLD", defaultNewsClusterThreshold)
+	if err != nil {
+		return nil, err
+	}
+
+	return &clusteredNews{
+		source:    source,
+		threshold: threshold,
+	}, nil
+}
+
+func (c *clusteredNews) get() ([]newsResult, error) {
+	results, err := c.source.get()
+	if err != nil {
+		return nil, err
+	}
+	return clusterNews(results, c.threshold), nil
+}
+
+type newsCluster struct {
+	members     []newsResult
+	titleTokens []map[string]bool
+	allTokens   []map[string]bool
+}
+
+// clusterNews groups stories whose titles or text are similar enough, keeping
+// the order in which each cluster first appeared
+func clusterNews(results []newsResult, threshold float64) []newsResult {
+	var clusters []*newsCluster
+	for _, result := range results {
+		titleTokens := newsTokens(result.Title)
+		allTokens := newsTokens(result.Title + " " + result.Description)
+
+		var match *newsCluster
+		for _, cluster := range clusters {
+			if cluster.matches(titleTokens, allTokens, threshold) {
+				match = cluster
+				break
+			}
+		}
+		if match == nil {
+			match = &newsCluster{}
+			clusters = append(clusters, match)
+		}
+		match.members = append(match.members, result)
+		match.titleTokens = append(match.titleTokens, titleTokens)
+		match.allTokens = append(match.allTokens, allTokens)
+	}
+
+	clustered := make([]newsResult, 0, len(clusters))
+	for _, cluster := range clusters {
+		clustered = append(clustered, cluster.representative())
+	}
+	return clustered
+}
+
+// matches reports whether a story is similar to any story already in the cluster
+func (c *newsCluster) matches(titleTokens, allTokens map[string]bool, threshold float64) bool {
+	for i := range c.members {
+		if jaccardSimilarity(titleTokens, c.titleTokens[i]) >= threshold || jaccardSimilarity(allTokens, c.allTokens[i]) >= threshold {
+			return true
+		}
+	}
+	return false
+}
+
+// representative picks the story with the most detail, preferring the most
+// recent one, and annotates it with the cluster's size and sources
+func (c *newsCluster) representative() newsResult {
+	best := c.members[0]
+	for _, member := range c.members[1:] {
+		if len(member.Description) > len(best.Description) ||
+			(len(member.Description) == len(best.Description) && member.Published.After(best.Published)) {
+			best = member
+		}
+	}
+
+	if len(c.members) > 1 {
+		best.ClusterSize = len(c.members)
+		seen := map[string]bool{}
+		for _, member := range c.members {
+			if member.Source != "" && !seen[member.Source] {
+				seen[member.Source] = true
+				best.Sources = append(best.Sources, member.Source)
+			}
+		}
+	}
+	return best
+}
+
+// newsTokens splits text into the set of its lowercase words, minus stop words and very short words
+func newsTokens(text string) map[string]bool {
+	tokens := map[string]bool{}
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, word := range words {
+		if len(word) < 3 || newsStopWords[word] {
+			continue
+		}
+		tokens[word] = true
+	}
+	return tokens
+}
+
+func jaccardSimilarity(a, b map[string]bool) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+
+	intersection := 0
+	for token := range a {
+		if b[token] {
+			intersection++
+		}
+	}
+	return float64(intersection) / float64(len(a)+len(b)-intersection)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClusteredNews_CollapsesDuplicates(t *testing.T) {
+	now := time.Now()
+	source := &mockNewsClient{getReturns: []newsResult{
+		{Title: "Storm hits the coast overnight", Description: "Short", Source: "Outlet A", Published: now},
+		{Title: "Local team wins championship", Description: "Sports", Source: "Outlet A", Published: now},
+		{Title: "Storm hits coast overnight, thousands without power", Description: "Longer description of the storm", Source: "Outlet B", Published: now},
+		{Title: "Overnight storm hits the coast", Description: "Short", Source: "Outlet C", Published: now},
+	}}
+
+	client, err := newClusteredNewsClient(source)
+	require.NoError(t, err)
+
+	results, err := client.get()
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+
+	// the most detailed story represents the cluster
+	require.Equal(t, "Storm hits coast overnight, thousands without power", results[0].Title)
+	require.Equal(t, 3, results[0].ClusterSize)
+	require.Equal(t, []string{"Outlet A", "Outlet B", "Outlet C"}, results[0].Sources)
+
+	// unique stories are left alone
+	require.Equal(t, "Local team wins championship", results[1].Title)
+	require.Zero(t, results[1].ClusterSize)
+	require.Nil(t, results[1].Sources)
+}
+
+func TestNewsTokens(t *testing.T) {
+	require.Equal(t, map[string]bool{"storm": true, "hits": true, "coast": true}, newsTokens("The storm hits the coast!"))
+}
+
+func TestJaccardSimilarity(t *testing.T) {
+	a := map[string]bool{"storm": true, "coast": true}
+	b := map[string]bool{"storm": true, "city": true}
+	require.InDelta(t, 1.0/3.0, jaccardSimilarity(a, b), 0.0001)
+	require.Equal(t, 0.0, jaccardSimilarity(a, map[string]bool{}))
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rankedNews wraps a news source, dropping blocked topics and keeping only
+// the top N stories by relevance to the configured interests
+type rankedNews struct {
+	source   newsClient
+	embedder openWebUIClient
+
+	interests     []string
+	terms         []string
+	blockedTopics []string
+	sourceWeights map[string]float64
+	topN          int
+	halfLife      time.Duration
+	useEmbeddings bool
+
+	// embeddings of the interests and of stories seen so far, by embeddingKey
+	mu                sync.Mutex
+	interestEmbedding []float64
+	embeddings        map[string][]float64
+}
+
+const (
+	defaultNewsTopN            = 5
+	defaultNewsRecencyHalfLife = 12 * time.Hour
+)
+
+func newRankedNewsClient(source newsClient, embedder openWebUIClient) (newsClient, error) {
+	topN, err := envInt("NEWS_TOP_N", defaultNewsTopN)
+	if err != nil {
+		return nil, err
+	}
+	if topN <= 0 {
+		return nil, fmt.Errorf("NEWS_TOP_N must be greater than 0")
+	}
+
+	halfLife, err := envDuration("NEWS_RECENCY_HALF_LIFE", defaultNewsRecencyHalfLife)
+	if err != nil {
+		return nil, err
+	}
+	if halfLife <= 0 {
+		return nil, fmt.Errorf("NEWS_RECENCY_HALF_LIFE must be greater than 0")
+	}
+
+	sourceWeights, err := parseSourceWeights(os.Getenv("NEWS_SOURCE_WEIGHTS"))
+	if err != nil {
+		return nil, err
+	}
+
+	useEmbeddings := os.Getenv("NEWS_EMBEDDINGS") == "true"
+	if useEmbeddings && len(envList("NEWS_INTERESTS")) == 0 {
+		return nil, fmt.Errorf("NEWS_EMBEDDINGS needs NEWS_INTERESTS to be set")
+	}
+
+	// both keywords and interests are matched against the text of each story
+	interests := lowerAll(envList("NEWS_INTERESTS"))
+	terms := append(lowerAll(envList("NEWS_KEYWORDS")), interests...)
+
+	return &rankedNews{
+		source:        source,
+		embedder:      embedder,
+		interests:     interests,
+		terms:         terms,
+		blockedTopics: lowerAll(envList("NEWS_BLOCKED_TOPICS")),
+		sourceWeights: sourceWeights,
+		topN:          topN,
+		halfLife:      halfLife,
+		useEmbeddings: useEmbeddings,
+		embeddings:    map[string][]float64{},
+	}, nil
+}
+
+// parseSourceWeights parses "source=weight" pairs, e.g. "BBC News=1.5,Tabloid=0.5"
+func parseSourceWeights(value string) (map[string]float64, error) {
+	weights := map[string]float64{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, weight, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("NEWS_SOURCE_WEIGHTS entry %q is not in source=weight format", entry)
+		}
+		number, err := strconv.ParseFloat(strings.TrimSpace(weight), 64)
+		if err != nil {
+			return nil, fmt.Errorf("NEWS_SOURCE_WEIGHTS entry %q has an invalid weight: %w", entry, err)
+		}
+		weights[strings.ToLower(strings.TrimSpace(name))] = number
+	}
+	return weights, nil
+}
+
+func (r *rankedNews) get() ([]newsResult, error) {
+	results, err := r.source.get()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	scored, err := r.scoreAll(results, now, r.useEmbeddings)
+	if err != nil {
+		// a model hiccup shouldn't lose the news, rank it by keywords alone
+		fmt.Println(fmt.Errorf("cannot rank news by interests, using keywords: %w", err))
+		scored, _ = r.scoreAll(results, now, false)
+	}
+
+	// forget embeddings of stories that dropped out of the source
+	if r.useEmbeddings {
+		current := map[string]bool{}
+		for _, result := range results {
+			current[embeddingKey(result)] = true
+		}
+		r.mu.Lock()
+		for key := range r.embeddings {
+			if !current[key] {
+				delete(r.embeddings, key)
+			}
+		}
+		r.mu.Unlock()
+	}
+
+	sort.SliceStable(scored, func(i, j int) bool {
+		return scored[i].score > scored[j].score
+	})
+	if len(scored) > r.topN {
+		scored = scored[:r.topN]
+	}
+
+	ranked := make([]newsResult, len(scored))
+	for i, s := range scored {
+		ranked[i] = s.result
+	}
+	return ranked, nil
+}
+
+type scoredResult struct {
+	result newsResult
+	score  float64
+}
+
+// scoreAll scores the stories that aren't about blocked topics
+func (r *rankedNews) scoreAll(results []newsResult, now time.Time, useEmbeddings bool) ([]scoredResult, error) {
+	scored := make([]scoredResult, 0, len(results))
+	for _, result := range results {
+		text := strings.ToLower(result.Title + " " + result.Description)
+		if containsAny(text, r.blockedTopics) {
+			continue
+		}
+		score, err := r.score(result, now, useEmbeddings)
+		if err != nil {
+			return nil, err
+		}
+		scored = append(scored, scoredResult{result: result, score: score})
+	}
+	return scored, nil
+}
+
+// score rates a story by keyword matches, embedding similarity to the
+// interests, how widely it was reported and recency, scaled by the weight of its source
+func (r *rankedNews) score(result newsResult, now time.Time, useEmbeddings bool) (float64, error) {
+	title := strings.ToLower(result.Title)
+	description := strings.ToLower(result.Description)
+
+	// title matches count double
+	relevance := 0.0
+	for _, term := range r.terms {
+		if strings.Contains(title, term) {
+			relevance += 2
+		}
+		if strings.Contains(description, term) {
+			relevance++
+		}
+	}
+
+	// widely reported stories are more likely to matter
+	if result.ClusterSize > 1 {
+		relevance += math.Log2(float64(result.ClusterSize))
+	}
+
+	if useEmbeddings {
+		similarity, err := r.similarity(result)
+		if err != nil {
+			return 0, err
+		}
+		relevance += 2 * similarity
+	}
+
+	// undated stories count as half-decayed
+	recency := 0.5
+	if !result.Published.IsZero() {
+		recency = math.Pow(2, -now.Sub(result.Published).Hours()/r.halfLife.Hours())
+	}
+
+	weight := 1.0
+	if w, ok := r.sourceWeights[strings.ToLower(result.Source)]; ok {
+		weight = w
+	}
+
+	return weight * (relevance + recency), nil
+}
+
+// similarity is the cosine similarity between a story and the interests, using the local model's embeddings
+func (r *rankedNews) similarity(result newsResult) (float64, error) {
+	r.mu.Lock()
+	interestEmbedding := r.interestEmbedding
+	embedding, ok := r.embeddings[embeddingKey(result)]
+	r.mu.Unlock()
+
+	var err error
+	if interestEmbedding == nil {
+		interestEmbedding, err = r.embedder.embed(strings.Join(r.interests, ", "))
+		if err != nil {
+			return 0, fmt.Errorf("cannot embed interests: %w", err)
+		}
+	}
+	if !ok {
+		embedding, err = r.embedder.embed(result.Title + "\n" + result.Description)
+		if err != nil {
+			return 0, fmt.Errorf("cannot embed news: %w", err)
+		}
+	}
+
+	r.mu.Lock()
+	r.interestEmbedding = interestEmbedding
+	r.embeddings[embeddingKey(result)] = embedding
+	r.mu.Unlock()
+
+	return cosineSimilarity(interestEmbedding, embedding), nil
+}
+
+// embeddingKey identifies a story by its URL, or a hash of its title when it has none
+func embeddingKey(result newsResult) string {
+	if result.URL != "" {
+		return result.URL
+	}
+	sum := sha1.Sum([]byte(result.Title))
+	return "title:" + hex.EncodeToString(sum[:])
+}
+
+func cosineSimilarity(a, b []float64) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+
+	var dot, normA, normB float64
+	for i := range a {
+		dot += a[i] * b[i]
+		normA += a[i] * a[i]
+		normB += b[i] * b[i]
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
+func containsAny(text string, terms []string) bool {
+	for _, term := range terms {
+		if strings.Contains(text, term) {
+			return true
+		}
+	}
+	return false
+}
+
+func lowerAll(values []string) []string {
+	lowered := make([]string, len(values))
+	for i, value := range values {
+		lowered[i] = strings.ToLower(value)
+	}
+	return lowered
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func setupRankedNewsEnvVars() {
+	os.Setenv("NEWS_INTERESTS", "space")
+	os.Setenv("NEWS_KEYWORDS", "rust")
+	os.Setenv("NEWS_BLOCKED_TOPICS", "celebrity")
+	os.Setenv("NEWS_SOURCE_WEIGHTS", "Trusted=2")
+	os.Setenv("NEWS_TOP_N", "2")
+}
+
+func teardownRankedNewsEnvVars() {
+	for _, name := range []string{"NEWS_INTERESTS", "NEWS_KEYWORDS", "NEWS_BLOCKED_TOPICS", "NEWS_SOURCE_WEIGHTS", "NEWS_TOP_N", "NEWS_EMBEDDINGS"} {
+		os.Unsetenv(name)
+	}
+}
+
+func rankedNewsTestResults() []newsResult {
+	now := time.Now()
+	return []newsResult{
+		{Title: "Local bake sale", URL: "https://test.com/1", Published: now},
+		{Title: "Celebrity launches space brand", URL: "https://test.com/2", Published: now},
+		{Title: "New space telescope", URL: "https://test.com/3", Published: now.Add(-24 * time.Hour)},
+		{Title: "Rust 2.0 released", URL: "https://test.com/4", Published: now.Add(-48 * time.Hour), Source: "Trusted"},
+	}
+}
+
+func TestRankedNews_FiltersAndRanks(t *testing.T) {
+	setupRankedNewsEnvVars()
+	defer teardownRankedNewsEnvVars()
+
+	source := &mockNewsClient{getReturns: rankedNewsTestResults()}
+	client, err := newRankedNewsClient(source, &mockOpenWebUIClient{})
+	require.NoError(t, err)
+
+	results, err := client.get()
+	require.NoError(t, err)
+
+	// the blocked story is dropped, and keyword matches beat recency
+	require.Len(t, results, 2)
+	require.Equal(t, "Rust 2.0 released", results[0].Title)
+	require.Equal(t, "New space telescope", results[1].Title)
+}
+
+func TestRankedNews_EmbeddingSimilarity(t *testing.T) {
+	setupRankedNewsEnvVars()
+	defer teardownRankedNewsEnvVars()
+	os.Setenv("NEWS_KEYWORDS", "")
+	os.Setenv("NEWS_SOURCE_WEIGHTS", "")
+	os.Setenv("NEWS_TOP_N", "1")
+	os.Setenv("NEWS_EMBEDDINGS", "true")
+
+	source := &mockNewsClient{getReturns: rankedNewsTestResults()}
+	client, err := newRankedNewsClient(source, &mockOpenWebUIClient{})
+	require.NoError(t, err)
+
+	results, err := client.get()
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	require.Equal(t, "New space telescope", results[0].Title)
+	require.Len(t, client.(*rankedNews).embeddings, 3)
+}
+
+// failingEmbedder is a model that can't embed
+type failingEmbedder struct {
+	*mockOpenWebUIClient
+}
+
+func (failingEmbedder) embed(text string) ([]float64, error) {
+	return nil, errors.New("model is down")
+}
+
+func TestRankedNews_EmbeddingErrorFallsBackToKeywords(t *testing.T) {
+	setupRankedNewsEnvVars()
+	defer teardownRankedNewsEnvVars()
+	os.Setenv("NEWS_EMBEDDINGS", "true")
+
+	source := &mockNewsClient{getReturns: rankedNewsTestResults()}
+	client, err := newRankedNewsClient(source, failingEmbedder{&mockOpenWebUIClient{}})
+	require.NoError(t, err)
+
+	results, err := client.get()
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	require.Equal(t, "Rust 2.0 released", results[0].Title)
+}
+
+func TestRankedNews_EmbeddingsWithoutURLs(t *testing.T) {
+	setupRankedNewsEnvVars()
+	defer teardownRankedNewsEnvVars()
+	os.Setenv("NEWS_KEYWORDS", "")
+	os.Setenv("NEWS_SOURCE_WEIGHTS", "")
+	os.Setenv("NEWS_TOP_N", "1")
+	os.Setenv("NEWS_EMBEDDINGS", "true")
+
+	// stories without a URL don't share an embedding
+	now := time.Now()
+	source := &mockNewsClient{getReturns: []newsResult{
+		{Title: "Local bake sale", Published: now},
+		{Title: "New space telescope", Published: now.Add(-time.Hour)},
+	}}
+	client, err := newRankedNewsClient(source, &mockOpenWebUIClient{})
+	require.NoError(t, err)
+
+	results, err := client.get()
+	require.NoError(t, err)
+	require.Equal(t, "New space telescope", results[0].Title)
+	require.Len(t, client.(*rankedNews).embeddings, 2)
+}
+
+func TestNewRankedNewsClient_TopN(t *testing.T) {
+	for _, value := range []string{"0", "-1"} {
+		t.Setenv("NEWS_TOP_N", value)
+		_, err := newRankedNewsClient(&mockNewsClient{}, &mockOpenWebUIClient{})
+		require.ErrorContains(t, err, "NEWS_TOP_N must be greater than 0")
+	}
+}
+
+func TestNewRankedNewsClient_HalfLife(t *testing.T) {
+	for _, value := range []string{"0s", "-1h"} {
+		t.Setenv("NEWS_RECENCY_HALF_LIFE", value)
+		_, err := newRankedNewsClient(&mockNewsClient{}, &mockOpenWebUIClient{})
+		require.Error(t, err)
+	}
+}
+
+func TestParseSourceWeights_Malformed(t *testing.T) {
+	weights, err := parseSourceWeights("Trusted")
+	require.Error(t, err)
+	require.Nil(t, weights)
+}
+
+func TestCosineSimilarity(t *testing.T) {
+	require.InDelta(t, 1.0, cosineSimilarity([]float64{1, 2}, []float64{2, 4}), 0.0001)
+	require.InDelta(t, 0.0, cosineSimilarity([]float64{1, 0}, []float64{0, 1}), 0.0001)
+	require.Equal(t, 0.0, cosineSimilarity([]float64{1}, []float64{1, 2}))
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+type openMeteo struct {
+	baseURL           string
+	airQualityBaseURL string
+	locations         []weatherLocation
+	timezone          string
+
+	cache      *ttlCache[weatherResult]
+	thresholds weatherThresholds
+}
+
+const (
+	defaultOpenMeteoBaseURL           = "https://api.open-meteo.com"
+	defaultOpenMeteoAirQualityBaseURL = "https://air-quality-api.open-meteo.com"
+	openMeteoHourlyLimit              = 12
+	openMeteoTimeLayout               = "2006-01-02T15:04"
+)
+
+var (
+	openMeteoPollutants = []string{"pm10", "pm2_5", "carbon_monoxide", "nitrogen_dioxide", "sulphur_dioxide", "ozone"}
+	openMeteoPollen     = []string{"alder_pollen", "birch_pollen", "grass_pollen", "mugwort_pollen", "olive_pollen", "ragweed_pollen"}
+)
+
+// wmoWeatherCodes translates WMO weather interpretation codes to conditions
+var wmoWeatherCodes = map[int]string{
+	0:  "Clear sky",
+	1:  "Mainly clear",
+	2:  "Partly cloudy",
+	3:  "Overcast",
+	45: "Fog",
+	48: "Depositing rime fog",
+	51: "Light drizzle",
+	53: "Moderate drizzle",
+	55: "Dense drizzle",
+	56: "Light freezing drizzle",
+	57: "Dense freezing drizzle",
+	61: "Slight rain",
+	63: "Moderate rain",
+	65: "Heavy rain",
+	66: "Light freezing rain",
+	67: "Heavy freezing rain",
+	71: "Slight snow fall",
+	73: "Moderate snow fall",
+	75: "Heavy snow fall",
+	77: "Snow grains",
+	80: "Slight rain showers",
+	81: "Moderate rain showers",
+	82: "Violent rain showers",
+	85: "Slight snow showers",
+	86: "Heavy snow showers",
+	95: "Thunderstorm",
+	96: "Thunderstorm with slight hail",
+	99: "Thunderstorm with heavy hail",
+}
+
+func newOpenMeteoClient() (weatherClient, error) {
+	locations, err := parseWeatherLocations("OPENMETEO")
+	if err != nil {
+		return nil, err
+	}
+
+	baseURL := os.Getenv("OPENMETEO_BASE_URL")
+	if baseURL == "" {
+		baseURL = defaultOpenMeteoBaseURL
+	}
+
+	airQualityBaseURL := os.Getenv("OPENMETEO_AIR_QUALITY_BASE_URL")
+	if airQualityBaseURL == "" {
+		airQualityBaseURL = defaultOpenMeteoAirQualityBaseURL
+	}
+
+	timezone := os.Getenv("OPENMETEO_TIMEZONE")
+	if timezone == "" {
+		timezone = "auto"
+	}
+
+	cache, err := newSourceCache[weatherResult]("WEATHER", weatherCacheDuration, weatherCacheMaxStale)
+	if err != nil {
+		return nil, err
+	}
+
+	thresholds, err := newWeatherThresholds()
+	if err != nil {
+		return nil, err
+	}
+
+	return &openMeteo{
+		baseURL:           baseURL,
+		airQualityBaseURL: airQualityBaseURL,
+		locations:         locations,
+		timezone:          timezone,
+		cache:             cache,
+		thresholds:        thresholds,
+	}, nil
+}
+
+// get returns the weather at the primary location
+func (o *openMeteo) get() (*weatherResult, error) {
+	return o.getLocation(o.locations[0])
+}
+
+// getAll returns the weather at every configured location, primary first
+func (o *openMeteo) getAll() ([]weatherResult, error) {
+	results := make([]weatherResult, 0, len(o.locations))
+	for _, location := range o.locations {
+		result, err := o.getLocation(location)
+		if err != nil {
+			return nil, fmt.Errorf("cannot get weather for %s: %w", location.name, err)
+		}
+		results = append(results, *result)
+	}
+	return results, nil
+}
+
+func (o *openMeteo) getLocation(location weatherLocation) (*weatherResult, error) {
+	result, err := o.cache.get(location.name, func() (weatherResult, error) {
+		return o.fetch(location)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (o *openMeteo) fetch(location weatherLocation) (weatherResult, error) {
+	// get weather data from open-meteo forecast API
+	query := url.Values{}
+	query.Set("latitude", location.latitude)
+	query.Set("longitude", location.longitude)
+	query.Set("timezone", o.timezone)
+	query.Set("current", "temperature_2m,weather_code")
+	query.Set("hourly", "temperature_2m,weather_code")
+	query.Set("daily", "weather_code,temperature_2m_max,temperature_2m_min")
+	query.Set("forecast_days", "2")
+	resp, err := sourceHTTPClient.Get(o.baseURL + "/v1/forecast?" + query.Encode())
+	if err != nil {
+		return weatherResult{}, fmt.Errorf("cannot get weather: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	// validate response code
+	if resp.StatusCode != http.StatusOK {
+		return weatherResult{}, fmt.Errorf("unexpected response code: %d", resp.StatusCode)
+	}
+
+	// read response body
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return weatherResult{}, fmt.Errorf("cannot read weather: %w", err)
+	}
+
+	// parse weather data
+	var weatherData struct {
+		UTCOffsetSeconds int `json:"utc_offset_seconds"`
+		Current          struct {
+			Time        string  `json:"time"`
+			Temperature float64 `json:"temperature_2m"`
+			WeatherCode int     `json:"weather_code"`
+		} `json:"current"`
+		Hourly struct {
+			Time        []string  `json:"time"`
+			Temperature []float64 `json:"temperature_2m"`
+			WeatherCode []int     `json:"weather_code"`
+		} `json:"hourly"`
+		Daily struct {
+			Time           []string  `json:"time"`
+			WeatherCode    []int     `json:"weather_code"`
+			TemperatureMax []float64 `json:"temperature_2m_max"`
+			TemperatureMin []float64 `json:"temperature_2m_min"`
+		} `json:"daily"`
+	}
+
+	err = json.Unmarshal(body, &weatherData)
+	if err != nil {
+		return weatherResult{}, fmt.Errorf("cannot parse weather: %w", err)
+	}
+
+	weather := weatherResult{
+		Location: location.name,
+		Temp:     weatherData.Current.Temperature,
+		Weather:  wmoCondition(weatherData.Current.WeatherCode),
+	}
+
+	// today's high and low
+	if len(weatherData.Daily.TemperatureMax) > 0 && len(weatherData.Daily.TemperatureMin) > 0 {
+		weather.High = &weatherData.Daily.TemperatureMax[0]
+		weather.Low = &weatherData.Daily.TemperatureMin[0]
+	}
+
+	// upcoming hours, starting from the current hour; times are local to the
+	// requested timezone and carry no offset of their own
+	zone := time.FixedZone("", weatherData.UTCOffsetSeconds)
+	current, err := time.ParseInLocation(openMeteoTimeLayout, weatherData.Current.Time, zone)
+	if err != nil {
+		return weatherResult{}, fmt.Errorf("cannot parse current time: %w", err)
+	}
+	current = current.Truncate(time.Hour)
+	for i, value := range weatherData.Hourly.Time {
+		if len(weather.Hourly) == openMeteoHourlyLimit {
+			break
+		}
+		if i >= len(weatherData.Hourly.Temperature) || i >= len(weatherData.Hourly.WeatherCode) {
+			break
+		}
+		hour, err := time.ParseInLocation(openMeteoTimeLayout, value, zone)
+		if err != nil {
+			return weatherResult{}, fmt.Errorf("cannot parse hourly time: %w", err)
+		}
+		if hour.Before(current) {
+			continue
+		}
+		weather.Hourly = append(weather.Hourly, weatherHour{
+			Time:    hour,
+			Temp:    weatherData.Hourly.Temperature[i],
+			Weather: wmoCondition(weatherData.Hourly.WeatherCode[i]),
+		})
+	}
+
+	// air quality is supplementary, so the briefing goes on without it
+	weather.AirQuality, weather.UVIndex, err = o.fetchAirQuality(location)
+	if err != nil {
+		fmt.Println(fmt.Errorf("cannot get air quality for %s: %w", location.name, err))
+	}
+	weather.Notices = weatherNotices(weather, o.thresholds)
+
+	return weather, nil
+}
+
+// fetchAirQuality gets air quality, UV index and pollen from the open-meteo air quality API
+func (o *openMeteo) fetchAirQuality(location weatherLocation) (*airQuality, float64, error) {
+	query := url.Values{}
+	query.Set("latitude", location.latitude)
+	query.Set("longitude", location.longitude)
+	query.Set("timezone", o.timezone)
+	query.Set("current", strings.Join(append([]string{"european_aqi", "uv_index"}, append(openMeteoPollutants, openMeteoPollen...)...), ","))
+	resp, err := sourceHTTPClient.Get(o.airQualityBaseURL + "/v1/air-quality?" + query.Encode())
+	if err != nil {
+		return nil, 0, fmt.Errorf("cannot get air quality: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	// validate response code
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("unexpected response code: %d", resp.StatusCode)
+	}
+
+	// read response body
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, fmt.Errorf("cannot read air quality: %w", err)
+	}
+
+	// parse air quality data, values are null where the model has no coverage
+	var airQualityData struct {
+		Current map[string]any `json:"current"`
+	}
+	err = json.Unmarshal(body, &airQualityData)
+	if err != nil {
+		return nil, 0, fmt.Errorf("cannot parse air quality: %w", err)
+	}
+
+	result := &airQuality{
+		Components: map[string]float64{},
+		Pollen:     map[string]float64{},
+	}
+	if value, ok := airQualityData.Current["european_aqi"].(float64); ok {
+		result.AQI = europeanAQIToIndex(value)
+	}
+	for _, name := range openMeteoPollutants {
+		if value, ok := airQualityData.Current[name].(float64); ok {
+			result.Components[name] = value
+		}
+	}
+	for _, name := range openMeteoPollen {
+		if value, ok := airQualityData.Current[name].(float64); ok {
+			result.Pollen[strings.TrimSuffix(name, "_pollen")] = value
+		}
+	}
+	uvIndex, _ := airQualityData.Current["uv_index"].(float64)
+
+	return result, uvIndex, nil
+}
+
+// wmoCondition returns a human-readable condition for a WMO weather code
+func wmoCondition(code int) string {
+	if condition, ok := wmoWeatherCodes[code]; ok {
+		return condition
+	}
+	return "Unknown"
+}
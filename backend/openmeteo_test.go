@@ -0,0 +1,124 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const openMeteoSuccessResponse = `{
+	"utc_offset_seconds": -18000,
+	"current": {"time": "2024-01-01T10:15", "temperature_2m": 3.5, "weather_code": 61},
+	"hourly": {
+		"time": ["2024-01-01T09:00", "2024-01-01T10:00", "2024-01-01T11:00"],
+		"temperature_2m": [2.0, 3.0, 4.0],
+		"weather_code": [3, 61, 95]
+	},
+	"daily": {
+		"time": ["2024-01-01"],
+		"weather_code": [61],
+		"temperature_2m_max": [6.0],
+		"temperature_2m_min": [-1.0]
+	}
+}`
+
+const openMeteoAirQualityResponse = `{
+	"current": {
+		"european_aqi": 45,
+		"uv_index": 2.5,
+		"pm2_5": 12.0,
+		"grass_pollen": 80.0,
+		"birch_pollen": 3.0,
+		"olive_pollen": null
+	}
+}`
+
+func setupOpenMeteoClientEnvVars(serverURL string) {
+	os.Setenv("WEATHER_PROVIDER", "openmeteo")
+	os.Setenv("OPENMETEO_AIR_QUALITY_BASE_URL", serverURL)
+	os.Setenv("OPENMETEO_LATITUDE", "40.7128")
+	os.Setenv("OPENMETEO_LONGITUDE", "-74.0060")
+	os.Setenv("OPENMETEO_BASE_URL", serverURL)
+}
+
+func setupOpenMeteoServer() *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/air-quality" {
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(openMeteoAirQualityResponse))
+			return
+		}
+		if r.URL.Path != "/v1/forecast" || r.URL.Query().Get("latitude") != "40.7128" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(openMeteoSuccessResponse))
+	}))
+
+	setupOpenMeteoClientEnvVars(server.URL)
+
+	return server
+}
+
+func setupOpenMeteoServerWithInternalError() *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+
+	setupOpenMeteoClientEnvVars(server.URL)
+
+	return server
+}
+
+func TestOpenMeteoClient_GetSuccess(t *testing.T) {
+	server := setupOpenMeteoServer()
+	defer server.Close()
+	defer os.Unsetenv("WEATHER_PROVIDER")
+
+	weather, err := newWeatherClient()
+	require.NoError(t, err)
+	require.IsType(t, &openMeteo{}, weather)
+
+	result, err := weather.get()
+	require.NoError(t, err)
+	require.Equal(t, "home", result.Location)
+	require.Equal(t, 3.5, result.Temp)
+	require.Equal(t, "Slight rain", result.Weather)
+	require.Equal(t, 6.0, *result.High)
+	require.Equal(t, -1.0, *result.Low)
+
+	// hours before the current hour are skipped
+	require.Len(t, result.Hourly, 2)
+	require.Equal(t, "10:00", result.Hourly[0].Time.Format("15:04"))
+	require.Equal(t, "Thunderstorm", result.Hourly[1].Weather)
+
+	// air quality, UV and pollen
+	require.Equal(t, 2.5, result.UVIndex)
+	require.Equal(t, 3, result.AirQuality.AQI)
+	require.Equal(t, 12.0, result.AirQuality.Components["pm2_5"])
+	require.Equal(t, map[string]float64{"grass": 80.0, "birch": 3.0}, result.AirQuality.Pollen)
+	require.Equal(t, []string{"the air quality index is 3 of 5 (moderate)", "grass pollen is 80 grains/m³"}, result.Notices)
+}
+
+func TestOpenMeteoClient_GetInternalError(t *testing.T) {
+	server := setupOpenMeteoServerWithInternalError()
+	defer server.Close()
+	defer os.Unsetenv("WEATHER_PROVIDER")
+
+	weather, err := newWeatherClient()
+	require.NoError(t, err)
+
+	result, err := weather.get()
+	require.Error(t, err)
+	require.Nil(t, result)
+}
+
+func TestWMOCondition(t *testing.T) {
+	require.Equal(t, "Clear sky", wmoCondition(0))
+	require.Equal(t, "Heavy snow fall", wmoCondition(75))
+	require.Equal(t, "Unknown", wmoCondition(42))
+}
@@ -6,18 +6,27 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"time"
 )
 
 type openWebUIClient interface {
 	generate(prompt string) (string, error)
+	// generateJSON generates a JSON document that matches the given JSON schema
+	generateJSON(prompt string, schema map[string]any) (string, error)
+	embed(text string) ([]float64, error)
 }
 
 type openWebUI struct {
-	baseUrl   string
-	apiKey    string
-	modelName string
+	baseUrl            string
+	apiKey             string
+	modelName          string
+	embeddingModelName string
+	client             *http.Client
 }
 
+// defaultOpenWebUITimeout is generous, local models can take minutes to answer
+const defaultOpenWebUITimeout = 5 * time.Minute
+
 func newOpenWebUIClient() (openWebUIClient, error) {
 	if os.Getenv("OPENWEBUI_BASE_URL") == "" {
 		return nil, fmt.Errorf("OPENWEBUI_BASE_URL env var is not set")
@@ -28,27 +37,60 @@ func newOpenWebUIClient() (openWebUIClient, error) {
 	if os.Getenv("OPENWEBUI_MODEL_NAME") == "" {
 		return nil, fmt.Errorf("OPENWEBUI_MODEL_NAME env var is not set")
 	}
+	// embeddings use the chat model unless a dedicated one is set
+	embeddingModelName := os.Getenv("OPENWEBUI_EMBEDDING_MODEL_NAME")
+	if embeddingModelName == "" {
+		embeddingModelName = os.Getenv("OPENWEBUI_MODEL_NAME")
+	}
+	timeout, err := envDuration("OPENWEBUI_TIMEOUT", defaultOpenWebUITimeout)
+	if err != nil {
+		return nil, err
+	}
 	return &openWebUI{
-		baseUrl:   os.Getenv("OPENWEBUI_BASE_URL"),
-		apiKey:    os.Getenv("OPENWEBUI_API_KEY"),
-		modelName: os.Getenv("OPENWEBUI_MODEL_NAME"),
+		baseUrl:            os.Getenv("OPENWEBUI_BASE_URL"),
+		apiKey:             os.Getenv("OPENWEBUI_API_KEY"),
+		modelName:          os.Getenv("OPENWEBUI_MODEL_NAME"),
+		embeddingModelName: embeddingModelName,
+		client:             &http.Client{Timeout: timeout},
 	}, nil
 }
 
 func (o *openWebUI) generate(prompt string) (string, error) {
-	// payload for /api/generate endpoint
-	updatesPayload := []byte(`{
-		"model": "` + o.modelName + `",
-		"messages": [
-			{
-				"role": "user",
-				"content": "` + prompt + `"
-			}
-		]
-		}`)
+	return o.chatCompletion(map[string]any{
+		"model": o.modelName,
+		"messages": []map[string]string{
+			{"role": "user", "content": prompt},
+		},
+	})
+}
+
+func (o *openWebUI) generateJSON(prompt string, schema map[string]any) (string, error) {
+	// structured output, the model answers with a document that matches the schema
+	return o.chatCompletion(map[string]any{
+		"model": o.modelName,
+		"messages": []map[string]string{
+			{"role": "user", "content": prompt},
+		},
+		"response_format": map[string]any{
+			"type": "json_schema",
+			"json_schema": map[string]any{
+				"name":   "result",
+				"strict": true,
+				"schema": schema,
+			},
+		},
+	})
+}
+
+// chatCompletion sends a payload to the /api/chat/completions endpoint and returns the content of the first choice
+func (o *openWebUI) chatCompletion(payload map[string]any) (string, error) {
+	payloadJson, err := json.Marshal(payload)
+	if err != nil {
+		return "", fmt.Errorf("cannot marshal request: %w", err)
+	}
 
 	// create request
-	req, err := http.NewRequest("POST", o.baseUrl+"/api/chat/completions", bytes.NewBuffer(updatesPayload))
+	req, err := http.NewRequest("POST", o.baseUrl+"/api/chat/completions", bytes.NewBuffer(payloadJson))
 	if err != nil {
 		return "", fmt.Errorf("cannot create request: %w", err)
 	}
@@ -56,8 +98,7 @@ func (o *openWebUI) generate(prompt string) (string, error) {
 	req.Header.Set("Authorization", "Bearer "+o.apiKey)
 
 	// send request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := o.client.Do(req)
 	if err != nil {
 		return "", fmt.Errorf("cannot send request: %w", err)
 	}
@@ -68,19 +109,68 @@ func (o *openWebUI) generate(prompt string) (string, error) {
 		return "", fmt.Errorf("unexpected response code: %d", resp.StatusCode)
 	}
 
-	// read response
-	buf := new(bytes.Buffer)
-	buf.ReadFrom(resp.Body)
-	respBody := buf.String()
-
 	// parse response
 	var response struct {
-		Response string `json:"response"`
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
 	}
-	err = json.Unmarshal([]byte(respBody), &response)
+	err = json.NewDecoder(resp.Body).Decode(&response)
 	if err != nil {
 		return "", fmt.Errorf("cannot unmarshal response: %w", err)
 	}
+	if len(response.Choices) == 0 {
+		return "", fmt.Errorf("response has no choices")
+	}
+
+	return response.Choices[0].Message.Content, nil
+}
+
+func (o *openWebUI) embed(text string) ([]float64, error) {
+	// payload for /api/embeddings endpoint
+	payload, err := json.Marshal(map[string]string{
+		"model": o.embeddingModelName,
+		"input": text,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot marshal request: %w", err)
+	}
+
+	// create request
+	req, err := http.NewRequest("POST", o.baseUrl+"/api/embeddings", bytes.NewBuffer(payload))
+	if err != nil {
+		return nil, fmt.Errorf("cannot create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+o.apiKey)
+
+	// send request
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("cannot send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// validate response code
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected response code: %d", resp.StatusCode)
+	}
+
+	// parse response
+	var response struct {
+		Data []struct {
+			Embedding []float64 `json:"embedding"`
+		} `json:"data"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&response)
+	if err != nil {
+		return nil, fmt.Errorf("cannot unmarshal response: %w", err)
+	}
+	if len(response.Data) == 0 {
+		return nil, fmt.Errorf("response has no embeddings")
+	}
 
-	return response.Response, nil
+	return response.Data[0].Embedding, nil
 }
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -31,7 +32,7 @@ func setupOpenWebUIServer() *httptest.Server {
 	// set up mock server
 	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
-		w.Write([]byte(`{"response": "success"}`))
+		w.Write([]byte(`{"choices": [{"message": {"content": "success"}}]}`))
 	}))
 
 	setupOpenWebUIEnvVars(mockServer.URL)
@@ -75,8 +76,8 @@ func TestGenerateSuccess(t *testing.T) {
 	response, err := openWebUIClient.generate("What's the weather like today?")
 	require.NoError(t, err)
 
-	// check that the response is not empty
-	require.NotEmpty(t, response)
+	// check that the response is the content of the first choice
+	require.Equal(t, "success", response)
 	require.Equal(t, "success", response)
 }
 
@@ -109,3 +110,100 @@ func TestGenerateMalformedResponse(t *testing.T) {
 	require.Empty(t, response)
 	require.Contains(t, err.Error(), "cannot unmarshal response")
 }
+
+func setupOpenWebUIEmbeddingsServer() *httptest.Server {
+	// set up mock server
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/embeddings" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"data": [{"embedding": [0.1, 0.2, 0.3]}]}`))
+	}))
+
+	setupOpenWebUIEnvVars(mockServer.URL)
+
+	return mockServer
+}
+
+func TestEmbedSuccess(t *testing.T) {
+	// set up test environment
+	setupOpenWebUIEmbeddingsServer()
+
+	// create a new openWebUI client
+	openWebUIClient, err := newOpenWebUIClient()
+	require.NoError(t, err)
+
+	// embed some text
+	embedding, err := openWebUIClient.embed("space telescope")
+	require.NoError(t, err)
+	require.Equal(t, []float64{0.1, 0.2, 0.3}, embedding)
+}
+
+func TestEmbedInternalError(t *testing.T) {
+	// set up test environment
+	setupOpenWebUIServerWithInternalError()
+
+	// create a new openWebUI client
+	openWebUIClient, err := newOpenWebUIClient()
+	require.NoError(t, err)
+
+	// embed some text
+	embedding, err := openWebUIClient.embed("error")
+	require.Error(t, err)
+	require.Nil(t, embedding)
+}
+
+func setupOpenWebUIStructuredServer(t *testing.T) *httptest.Server {
+	// set up mock server that checks the schema is sent
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			ResponseFormat struct {
+				Type       string `json:"type"`
+				JSONSchema struct {
+					Schema map[string]any `json:"schema"`
+				} `json:"json_schema"`
+			} `json:"response_format"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&payload))
+		require.Equal(t, "json_schema", payload.ResponseFormat.Type)
+		require.Equal(t, "object", payload.ResponseFormat.JSONSchema.Schema["type"])
+
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"choices": [{"message": {"content": "{\"title\": \"Lunch\"}"}}]}`))
+	}))
+
+	setupOpenWebUIEnvVars(mockServer.URL)
+
+	return mockServer
+}
+
+func TestGenerateJSONSuccess(t *testing.T) {
+	// set up test environment
+	server := setupOpenWebUIStructuredServer(t)
+	defer server.Close()
+
+	// create a new openWebUI client
+	openWebUIClient, err := newOpenWebUIClient()
+	require.NoError(t, err)
+
+	// generate a document
+	response, err := openWebUIClient.generateJSON("Lunch with Sam \"tomorrow\"", map[string]any{"type": "object"})
+	require.NoError(t, err)
+	require.JSONEq(t, `{"title": "Lunch"}`, response)
+}
+
+func TestGenerateJSONInternalError(t *testing.T) {
+	// set up test environment
+	setupOpenWebUIServerWithInternalError()
+
+	// create a new openWebUI client
+	openWebUIClient, err := newOpenWebUIClient()
+	require.NoError(t, err)
+
+	// generate a document
+	response, err := openWebUIClient.generateJSON("error", map[string]any{"type": "object"})
+	require.Error(t, err)
+	require.Empty(t, response)
+}
@@ -0,0 +1,439 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// recurrenceRule is a parsed RRULE. BYSETPOS, the other BY parts that
+// aren't fields here and the sub-daily frequencies aren't supported.
+type recurrenceRule struct {
+	freq       string
+	interval   int
+	count      int
+	until      time.Time
+	byDay      []recurrenceWeekday
+	byMonthDay []int
+	byMonth    []time.Month
+	weekStart  time.Weekday
+}
+
+// recurrenceWeekday is a BYDAY entry such as MO, 2TU or -1FR; n is 0 for every such weekday
+type recurrenceWeekday struct {
+	n       int
+	weekday time.Weekday
+}
+
+var icsWeekdays = map[string]time.Weekday{
+	"SU": time.Sunday,
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+}
+
+// maxRecurrencePeriods bounds expansion of rules that never end
+const maxRecurrencePeriods = 10000
+
+// errUnsupportedRecurrence is returned for rules with parts that would change the occurrences
+// if they were ignored, like BYSETPOS, so only the first occurrence is shown
+var errUnsupportedRecurrence = errors.New("unsupported recurrence rule")
+
+func parseRecurrenceRule(value string, loc *time.Location) (recurrenceRule, error) {
+	rule := recurrenceRule{interval: 1, weekStart: time.Monday}
+	for _, part := range strings.Split(value, ";") {
+		name, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return recurrenceRule{}, fmt.Errorf("malformed rule part %q", part)
+		}
+
+		var err error
+		switch strings.ToUpper(name) {
+		case "FREQ":
+			rule.freq = strings.ToUpper(value)
+		case "INTERVAL":
+			rule.interval, err = strconv.Atoi(value)
+			if err == nil && rule.interval < 1 {
+				err = fmt.Errorf("interval must be positive")
+			}
+		case "COUNT":
+			rule.count, err = strconv.Atoi(value)
+		case "UNTIL":
+			var isDate bool
+			rule.until, isDate, err = parseICSTimeValue(value, false, loc)
+			// a date includes the occurrence on that day
+			if isDate {
+				rule.until = rule.until.AddDate(0, 0, 1).Add(-time.Nanosecond)
+			}
+		case "BYDAY":
+			for _, day := range strings.Split(value, ",") {
+				var weekday recurrenceWeekday
+				weekday, err = parseRecurrenceWeekday(day)
+				if err != nil {
+					break
+				}
+				rule.byDay = append(rule.byDay, weekday)
+			}
+		case "BYMONTHDAY":
+			for _, day := range strings.Split(value, ",") {
+				var n int
+				n, err = strconv.Atoi(day)
+				if err != nil {
+					break
+				}
+				rule.byMonthDay = append(rule.byMonthDay, n)
+			}
+		case "BYMONTH":
+			for _, month := range strings.Split(value, ",") {
+				var n int
+				n, err = strconv.Atoi(month)
+				if err != nil {
+					break
+				}
+				rule.byMonth = append(rule.byMonth, time.Month(n))
+			}
+		case "WKST":
+			weekday, ok := icsWeekdays[strings.ToUpper(value)]
+			if !ok {
+				err = fmt.Errorf("unknown weekday %q", value)
+			}
+			rule.weekStart = weekday
+		default:
+			return recurrenceRule{}, fmt.Errorf("%w: %s", errUnsupportedRecurrence, name)
+		}
+		if err != nil {
+			return recurrenceRule{}, fmt.Errorf("cannot parse %s: %w", name, err)
+		}
+	}
+
+	switch rule.freq {
+	case "DAILY", "WEEKLY", "MONTHLY", "YEARLY":
+	default:
+		return recurrenceRule{}, fmt.Errorf("unsupported frequency %q", rule.freq)
+	}
+	return rule, nil
+}
+
+func parseRecurrenceWeekday(value string) (recurrenceWeekday, error) {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	if len(value) < 2 {
+		return recurrenceWeekday{}, fmt.Errorf("malformed weekday %q", value)
+	}
+
+	weekday, ok := icsWeekdays[value[len(value)-2:]]
+	if !ok {
+		return recurrenceWeekday{}, fmt.Errorf("unknown weekday %q", value)
+	}
+
+	n := 0
+	if prefix := value[:len(value)-2]; prefix != "" {
+		var err error
+		n, err = strconv.Atoi(prefix)
+		if err != nil {
+			return recurrenceWeekday{}, fmt.Errorf("malformed weekday %q", value)
+		}
+	}
+	return recurrenceWeekday{n: n, weekday: weekday}, nil
+}
+
+// expandRecurrences replaces recurring events with their occurrences that
+// overlap from and to, applying RDATE, EXDATE and overridden instances.
+// Events whose rule can't be expanded are skipped.
+func expandRecurrences(events []icsEvent, from, to time.Time) []icsEvent {
+	// overridden instances, by the occurrence they replace
+	overrides := map[string]icsEvent{}
+	for _, event := range events {
+		if !event.recurrenceID.IsZero() {
+			overrides[occurrenceKey(event.uid, event.recurrenceID)] = event
+		}
+	}
+
+	var expanded []icsEvent
+	usedOverrides := map[string]bool{}
+	for _, event := range events {
+		if !event.recurrenceID.IsZero() {
+			continue
+		}
+		if event.rrule == "" && len(event.rdates) == 0 {
+			expanded = append(expanded, event)
+			continue
+		}
+
+		duration := event.end.Sub(event.start)
+		starts, err := event.occurrences(from.Add(-duration), to)
+		if err != nil {
+			fmt.Println(fmt.Errorf("cannot expand %q: %w", event.uid, err))
+			continue
+		}
+
+		for _, start := range starts {
+			key := occurrenceKey(event.uid, start)
+			if override, ok := overrides[key]; ok {
+				usedOverrides[key] = true
+				override.recurring = true
+				expanded = append(expanded, override)
+				continue
+			}
+
+			occurrence := event
+			occurrence.start = start
+			occurrence.end = start.Add(duration)
+			occurrence.recurring = true
+			expanded = append(expanded, occurrence)
+		}
+	}
+
+	// overrides whose recurring event wasn't in the document, e.g. an
+	// instance moved into a CalDAV time range the rest of the series is not in
+	for key, override := range overrides {
+		if !usedOverrides[key] {
+			override.recurring = true
+			expanded = append(expanded, override)
+		}
+	}
+
+	return expanded
+}
+
+func occurrenceKey(uid string, start time.Time) string {
+	return uid + "|" + strconv.FormatInt(start.Unix(), 10)
+}
+
+// occurrences lists the starts of an event's occurrences before to, and from
+// about from on: the first start, those generated by its rule and its RDATEs,
+// minus its EXDATEs. Rules with unsupported parts only give the first start.
+func (e icsEvent) occurrences(from, to time.Time) ([]time.Time, error) {
+	starts := []time.Time{e.start}
+	if e.rrule != "" {
+		rule, err := parseRecurrenceRule(e.rrule, e.start.Location())
+		if errors.Is(err, errUnsupportedRecurrence) {
+			fmt.Println(fmt.Errorf("only showing the first occurrence of %q: %w", e.uid, err))
+		} else if err != nil {
+			return nil, err
+		} else {
+			starts = rule.occurrences(e.start, from, to)
+		}
+	}
+	starts = append(starts, e.rdates...)
+
+	excluded := map[int64]bool{}
+	for _, exdate := range e.exdates {
+		excluded[exdate.Unix()] = true
+	}
+
+	seen := map[int64]bool{}
+	var occurrences []time.Time
+	for _, start := range starts {
+		if excluded[start.Unix()] || seen[start.Unix()] || !start.Before(to) {
+			continue
+		}
+		seen[start.Unix()] = true
+		occurrences = append(occurrences, start)
+	}
+
+	sort.Slice(occurrences, func(i, j int) bool {
+		return occurrences[i].Before(occurrences[j])
+	})
+	return occurrences, nil
+}
+
+// occurrences generates the starts of the rule from dtstart, which is always
+// the first occurrence, until to, the rule's UNTIL or its COUNT. Rules without
+// a COUNT skip the periods before from, so old series aren't cut short.
+func (r recurrenceRule) occurrences(dtstart, from, to time.Time) []time.Time {
+	occurrences := []time.Time{dtstart}
+	first := 0
+	if r.count == 0 {
+		first = r.periodsBefore(dtstart, from)
+	}
+	for period := first; period < first+maxRecurrencePeriods; period++ {
+		candidates := r.periodCandidates(dtstart, period)
+		if len(candidates) == 0 && r.periodStart(dtstart, period).After(to) {
+			break
+		}
+
+		for _, candidate := range candidates {
+			if !candidate.After(dtstart) {
+				continue
+			}
+			if !r.until.IsZero() && candidate.After(r.until) {
+				return occurrences
+			}
+			if r.count > 0 && len(occurrences) >= r.count {
+				return occurrences
+			}
+			if !candidate.Before(to) {
+				return occurrences
+			}
+			occurrences = append(occurrences, candidate)
+		}
+	}
+	return occurrences
+}
+
+// periodsBefore is the number of whole periods between dtstart and from, less
+// one in case from falls within a period's occurrences
+func (r recurrenceRule) periodsBefore(dtstart, from time.Time) int {
+	if !from.After(dtstart) {
+		return 0
+	}
+
+	var periods int
+	switch r.freq {
+	case "DAILY":
+		periods = int(from.Sub(dtstart).Hours()/24) / r.interval
+	case "WEEKLY":
+		periods = int(from.Sub(dtstart).Hours()/24/7) / r.interval
+	case "MONTHLY":
+		periods = ((from.Year()-dtstart.Year())*12 + int(from.Month()-dtstart.Month())) / r.interval
+	case "YEARLY":
+		periods = (from.Year() - dtstart.Year()) / r.interval
+	}
+	return max(periods-1, 0)
+}
+
+// periodStart is the first day of the given period after dtstart's period
+func (r recurrenceRule) periodStart(dtstart time.Time, period int) time.Time {
+	year, month, day := dtstart.Date()
+	switch r.freq {
+	case "DAILY":
+		return time.Date(year, month, day+period*r.interval, 0, 0, 0, 0, dtstart.Location())
+	case "WEEKLY":
+		offset := (int(dtstart.Weekday()) - int(r.weekStart) + 7) % 7
+		return time.Date(year, month, day-offset+7*period*r.interval, 0, 0, 0, 0, dtstart.Location())
+	case "MONTHLY":
+		return time.Date(year, month+time.Month(period*r.interval), 1, 0, 0, 0, 0, dtstart.Location())
+	}
+	return time.Date(year+period*r.interval, time.January, 1, 0, 0, 0, 0, dtstart.Location())
+}
+
+// periodCandidates lists the occurrences within a period, in order, at dtstart's time of day
+func (r recurrenceRule) periodCandidates(dtstart time.Time, period int) []time.Time {
+	start := r.periodStart(dtstart, period)
+	var days []time.Time
+
+	switch r.freq {
+	case "DAILY":
+		days = []time.Time{start}
+	case "WEEKLY":
+		if len(r.byDay) == 0 {
+			days = []time.Time{start.AddDate(0, 0, (int(dtstart.Weekday())-int(r.weekStart)+7)%7)}
+			break
+		}
+		for i := 0; i < 7; i++ {
+			day := start.AddDate(0, 0, i)
+			if r.matchesWeekday(day) {
+				days = append(days, day)
+			}
+		}
+	case "MONTHLY":
+		days = r.monthDays(dtstart, start)
+	case "YEARLY":
+		months := r.byMonth
+		if len(months) == 0 {
+			months = []time.Month{dtstart.Month()}
+		}
+		for _, month := range months {
+			days = append(days, r.monthDays(dtstart, time.Date(start.Year(), month, 1, 0, 0, 0, 0, start.Location()))...)
+		}
+	}
+
+	hour, minute, second := dtstart.Clock()
+	var candidates []time.Time
+	for _, day := range days {
+		if len(r.byMonth) > 0 && !containsMonth(r.byMonth, day.Month()) {
+			continue
+		}
+		if r.freq == "DAILY" && len(r.byDay) > 0 && !r.matchesWeekday(day) {
+			continue
+		}
+		if r.freq == "DAILY" && len(r.byMonthDay) > 0 && !r.matchesMonthDay(day) {
+			continue
+		}
+		candidates = append(candidates, time.Date(day.Year(), day.Month(), day.Day(), hour, minute, second, 0, dtstart.Location()))
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].Before(candidates[j])
+	})
+	return candidates
+}
+
+// monthDays lists the days of the month starting at first that match BYMONTHDAY and BYDAY,
+// or dtstart's day of the month when the rule has neither
+func (r recurrenceRule) monthDays(dtstart, first time.Time) []time.Time {
+	last := first.AddDate(0, 1, -1)
+
+	if len(r.byMonthDay) == 0 && len(r.byDay) == 0 {
+		if dtstart.Day() > last.Day() {
+			// e.g. the 31st in a 30 day month is skipped
+			return nil
+		}
+		return []time.Time{first.AddDate(0, 0, dtstart.Day()-1)}
+	}
+
+	var days []time.Time
+	for day := first; !day.After(last); day = day.AddDate(0, 0, 1) {
+		if len(r.byMonthDay) > 0 && !r.matchesMonthDay(day) {
+			continue
+		}
+		if len(r.byDay) > 0 && !r.matchesMonthWeekday(day, last) {
+			continue
+		}
+		days = append(days, day)
+	}
+	return days
+}
+
+func (r recurrenceRule) matchesWeekday(day time.Time) bool {
+	for _, weekday := range r.byDay {
+		if weekday.weekday == day.Weekday() {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesMonthWeekday checks BYDAY within a month, where 2TU is the second Tuesday and -1FR the last Friday
+func (r recurrenceRule) matchesMonthWeekday(day, last time.Time) bool {
+	for _, weekday := range r.byDay {
+		if weekday.weekday != day.Weekday() {
+			continue
+		}
+		switch {
+		case weekday.n == 0:
+			return true
+		case weekday.n > 0 && (day.Day()-1)/7+1 == weekday.n:
+			return true
+		case weekday.n < 0 && (last.Day()-day.Day())/7+1 == -weekday.n:
+			return true
+		}
+	}
+	return false
+}
+
+// matchesMonthDay checks BYMONTHDAY, where -1 is the last day of the month
+func (r recurrenceRule) matchesMonthDay(day time.Time) bool {
+	last := time.Date(day.Year(), day.Month()+1, 0, 0, 0, 0, 0, day.Location()).Day()
+	for _, n := range r.byMonthDay {
+		if n == day.Day() || (n < 0 && last+n+1 == day.Day()) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsMonth(months []time.Month, month time.Month) bool {
+	for _, m := range months {
+		if m == month {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func ruleOccurrences(t *testing.T, rule string, dtstart, to time.Time) []string {
+	t.Helper()
+	parsed, err := parseRecurrenceRule(rule, dtstart.Location())
+	require.NoError(t, err)
+
+	var starts []string
+	for _, start := range parsed.occurrences(dtstart, dtstart, to) {
+		starts = append(starts, start.Format(time.RFC3339))
+	}
+	return starts
+}
+
+func TestRecurrenceRule_Daily(t *testing.T) {
+	dtstart := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	to := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+
+	require.Equal(t, []string{
+		"2024-01-01T09:00:00Z",
+		"2024-01-03T09:00:00Z",
+		"2024-01-05T09:00:00Z",
+	}, ruleOccurrences(t, "FREQ=DAILY;INTERVAL=2;COUNT=3", dtstart, to))
+
+	require.Equal(t, []string{
+		"2024-01-01T09:00:00Z",
+		"2024-01-02T09:00:00Z",
+		"2024-01-03T09:00:00Z",
+	}, ruleOccurrences(t, "FREQ=DAILY;UNTIL=20240103T090000Z", dtstart, to))
+
+	// a date includes the occurrence on that day
+	require.Equal(t, []string{
+		"2024-01-01T09:00:00Z",
+		"2024-01-02T09:00:00Z",
+		"2024-01-03T09:00:00Z",
+	}, ruleOccurrences(t, "FREQ=DAILY;UNTIL=20240103", dtstart, to))
+}
+
+func TestRecurrenceRule_SkipsToWindow(t *testing.T) {
+	dtstart := time.Date(1990, 1, 1, 9, 0, 0, 0, time.UTC)
+	from := time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC)
+	to := time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC)
+
+	rule, err := parseRecurrenceRule("FREQ=DAILY", time.UTC)
+	require.NoError(t, err)
+	starts := rule.occurrences(dtstart, from, to)
+	require.Equal(t, dtstart, starts[0])
+	require.Equal(t, []time.Time{
+		time.Date(2024, 1, 3, 9, 0, 0, 0, time.UTC),
+		time.Date(2024, 1, 4, 9, 0, 0, 0, time.UTC),
+	}, starts[len(starts)-2:])
+
+	rule, err = parseRecurrenceRule("FREQ=MONTHLY;INTERVAL=4;BYMONTHDAY=3", time.UTC)
+	require.NoError(t, err)
+	starts = rule.occurrences(dtstart, from, to)
+	require.Equal(t, time.Date(2024, 1, 3, 9, 0, 0, 0, time.UTC), starts[len(starts)-1])
+}
+
+func TestRecurrenceRule_Weekly(t *testing.T) {
+	// a Monday
+	dtstart := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	to := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+
+	require.Equal(t, []string{
+		"2024-01-01T09:00:00Z",
+		"2024-01-03T09:00:00Z",
+		"2024-01-05T09:00:00Z",
+		"2024-01-08T09:00:00Z",
+		"2024-01-10T09:00:00Z",
+		"2024-01-12T09:00:00Z",
+	}, ruleOccurrences(t, "FREQ=WEEKLY;BYDAY=MO,WE,FR", dtstart, to))
+
+	require.Equal(t, []string{
+		"2024-01-01T09:00:00Z",
+	}, ruleOccurrences(t, "FREQ=WEEKLY;INTERVAL=2", dtstart, to))
+}
+
+func TestRecurrenceRule_Monthly(t *testing.T) {
+	dtstart := time.Date(2024, 1, 9, 10, 0, 0, 0, time.UTC)
+	to := time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)
+
+	require.Equal(t, []string{
+		"2024-01-09T10:00:00Z",
+		"2024-02-13T10:00:00Z",
+		"2024-03-12T10:00:00Z",
+	}, ruleOccurrences(t, "FREQ=MONTHLY;BYDAY=2TU", dtstart, to))
+
+	require.Equal(t, []string{
+		"2024-01-09T10:00:00Z",
+		"2024-01-31T10:00:00Z",
+		"2024-02-29T10:00:00Z",
+		"2024-03-31T10:00:00Z",
+	}, ruleOccurrences(t, "FREQ=MONTHLY;BYMONTHDAY=-1", dtstart, to))
+
+	// months without the 31st are skipped
+	dtstart = time.Date(2024, 1, 31, 10, 0, 0, 0, time.UTC)
+	to = time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	require.Equal(t, []string{
+		"2024-01-31T10:00:00Z",
+		"2024-03-31T10:00:00Z",
+		"2024-05-31T10:00:00Z",
+	}, ruleOccurrences(t, "FREQ=MONTHLY", dtstart, to))
+}
+
+func TestRecurrenceRule_Yearly(t *testing.T) {
+	dtstart := time.Date(2023, 11, 23, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	require.Equal(t, []string{
+		"2023-11-23T00:00:00Z",
+		"2024-11-28T00:00:00Z",
+		"2025-11-27T00:00:00Z",
+	}, ruleOccurrences(t, "FREQ=YEARLY;BYMONTH=11;BYDAY=4TH", dtstart, to))
+}
+
+func TestRecurrenceRule_KeepsWallClockAcrossDST(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+
+	dtstart := time.Date(2024, 3, 8, 9, 0, 0, 0, newYork)
+	starts := ruleOccurrences(t, "FREQ=DAILY;COUNT=3", dtstart, dtstart.AddDate(0, 1, 0))
+	require.Equal(t, []string{
+		"2024-03-08T09:00:00-05:00",
+		"2024-03-09T09:00:00-05:00",
+		"2024-03-10T09:00:00-04:00",
+	}, starts)
+}
+
+func TestParseRecurrenceRule_Invalid(t *testing.T) {
+	for _, rule := range []string{"FREQ=HOURLY", "FREQ=MONTHLY;BYDAY=MO;BYSETPOS=1", "FREQ=YEARLY;BYWEEKNO=20", "FREQ=DAILY;INTERVAL=0", "FREQ=WEEKLY;BYDAY=XX", "FREQ"} {
+		_, err := parseRecurrenceRule(rule, time.UTC)
+		require.Error(t, err, rule)
+	}
+}
+
+func TestICSEventsBetween_Recurring(t *testing.T) {
+	calendar := "BEGIN:VCALENDAR\r\n" +
+		"BEGIN:VEVENT\r\n" +
+		"UID:sync@test\r\n" +
+		"SUMMARY:Sync\r\n" +
+		"DTSTART:20240101T090000Z\r\n" +
+		"DTEND:20240101T093000Z\r\n" +
+		"RRULE:FREQ=DAILY\r\n" +
+		"EXDATE:20240103T090000Z\r\n" +
+		"RDATE:20240103T170000Z\r\n" +
+		"END:VEVENT\r\n" +
+		"BEGIN:VEVENT\r\n" +
+		"UID:sync@test\r\n" +
+		"RECURRENCE-ID:20240104T090000Z\r\n" +
+		"SUMMARY:Sync (moved)\r\n" +
+		"DTSTART:20240104T110000Z\r\n" +
+		"DTEND:20240104T113000Z\r\n" +
+		"END:VEVENT\r\n" +
+		"END:VCALENDAR\r\n"
+
+	events, err := parseICS(calendar, time.UTC)
+	require.NoError(t, err)
+
+	calendarEvents := icsEventsBetween(events, time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC), time.Date(2024, 1, 5, 12, 0, 0, 0, time.UTC), time.UTC)
+
+	var starts []string
+	for _, event := range calendarEvents {
+		require.True(t, event.Recurring)
+		starts = append(starts, event.Title+" "+event.Start.Format(time.RFC3339)+" "+event.End.Format(time.RFC3339))
+	}
+	require.Equal(t, []string{
+		"Sync 2024-01-03T17:00:00Z 2024-01-03T17:30:00Z",
+		"Sync (moved) 2024-01-04T11:00:00Z 2024-01-04T11:30:00Z",
+		"Sync 2024-01-05T09:00:00Z 2024-01-05T09:30:00Z",
+	}, starts)
+}
+
+func TestICSEventsBetween_UnsupportedRules(t *testing.T) {
+	calendar := "BEGIN:VCALENDAR\r\n" +
+		"BEGIN:VEVENT\r\n" +
+		"UID:standup@test\r\n" +
+		"SUMMARY:Standup\r\n" +
+		"DTSTART:20240101T090000Z\r\n" +
+		"DTEND:20240101T091500Z\r\n" +
+		"RRULE:FREQ=HOURLY\r\n" +
+		"END:VEVENT\r\n" +
+		"BEGIN:VEVENT\r\n" +
+		"UID:review@test\r\n" +
+		"SUMMARY:Review\r\n" +
+		"DTSTART:20240102T100000Z\r\n" +
+		"DTEND:20240102T110000Z\r\n" +
+		"RRULE:FREQ=MONTHLY;BYDAY=TU;BYSETPOS=1\r\n" +
+		"END:VEVENT\r\n" +
+		"BEGIN:VEVENT\r\n" +
+		"UID:lunch@test\r\n" +
+		"SUMMARY:Lunch\r\n" +
+		"DTSTART:20240103T120000Z\r\n" +
+		"DTEND:20240103T130000Z\r\n" +
+		"END:VEVENT\r\n" +
+		"END:VCALENDAR\r\n"
+
+	events, err := parseICS(calendar, time.UTC)
+	require.NoError(t, err)
+
+	// the event with an unsupported frequency is skipped, the one with
+	// unsupported parts only keeps its first occurrence
+	calendarEvents := icsEventsBetween(events, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), time.UTC)
+	var titles []string
+	for _, event := range calendarEvents {
+		titles = append(titles, event.Title+" "+event.Start.Format(time.RFC3339))
+	}
+	require.Equal(t, []string{
+		"Review 2024-01-02T10:00:00Z",
+		"Lunch 2024-01-03T12:00:00Z",
+	}, titles)
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	defaultWorkHours = "09:00-17:00"
+	defaultMinBreak  = 5 * time.Minute
+)
+
+// scheduleSettings describe the working day that free time is looked for in
+type scheduleSettings struct {
+	calendar calendarSettings
+	// workStart and workEnd are offsets from midnight
+	workStart time.Duration
+	workEnd   time.Duration
+	workDays  map[time.Weekday]bool
+	// minBreak is the shortest gap between meetings that isn't back-to-back
+	minBreak time.Duration
+}
+
+// scheduleOverlap is a pair of timed events, either overlapping or back-to-back
+type scheduleOverlap struct {
+	First  calendarEvent `json:"first"`
+	Second calendarEvent `json:"second"`
+}
+
+// freeSlot is a free period within working hours
+type freeSlot struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// scheduleReport is the result of analyzing the events in the calendar window
+type scheduleReport struct {
+	Conflicts  []scheduleOverlap `json:"conflicts"`
+	BackToBack []scheduleOverlap `json:"back_to_back"`
+	Free       []freeSlot        `json:"free"`
+}
+
+// newScheduleSettings reads CALENDAR_WORK_HOURS like "09:00-17:00", CALENDAR_WORK_DAYS like "Mon,Tue"
+// (Monday to Friday by default) and CALENDAR_MIN_BREAK
+func newScheduleSettings() (scheduleSettings, error) {
+	calendar, err := newCalendarSettings()
+	if err != nil {
+		return scheduleSettings{}, err
+	}
+
+	workHours := os.Getenv("CALENDAR_WORK_HOURS")
+	if workHours == "" {
+		workHours = defaultWorkHours
+	}
+	from, to, ok := strings.Cut(workHours, "-")
+	if !ok {
+		return scheduleSettings{}, fmt.Errorf("CALENDAR_WORK_HOURS is not a range like %q", defaultWorkHours)
+	}
+	workStart, err := parseClockTime(from)
+	if err != nil {
+		return scheduleSettings{}, fmt.Errorf("CALENDAR_WORK_HOURS is not valid: %w", err)
+	}
+	workEnd, err := parseClockTime(to)
+	if err != nil {
+		return scheduleSettings{}, fmt.Errorf("CALENDAR_WORK_HOURS is not valid: %w", err)
+	}
+	if workEnd <= workStart {
+		return scheduleSettings{}, fmt.Errorf("CALENDAR_WORK_HOURS must end after it starts")
+	}
+
+	workDays := map[time.Weekday]bool{}
+	days := envList("CALENDAR_WORK_DAYS")
+	if len(days) == 0 {
+		days = []string{"MO", "TU", "WE", "TH", "FR"}
+	}
+	for _, day := range days {
+		weekday, ok := icsWeekdays[strings.ToUpper(day[:min(2, len(day))])]
+		if !ok {
+			return scheduleSettings{}, fmt.Errorf("CALENDAR_WORK_DAYS has unknown day %q", day)
+		}
+		workDays[weekday] = true
+	}
+
+	minBreak, err := envDuration("CALENDAR_MIN_BREAK", defaultMinBreak)
+	if err != nil {
+		return scheduleSettings{}, err
+	}
+
+	return scheduleSettings{
+		calendar:  calendar,
+		workStart: workStart,
+		workEnd:   workEnd,
+		workDays:  workDays,
+		minBreak:  minBreak,
+	}, nil
+}
+
+// parseClockTime parses a time of day like "09:30" as the time since midnight
+func parseClockTime(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a time like 09:30", value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// analyzeSchedule finds the conflicting and back-to-back events, and the free working time,
+// from now until the end of the calendar window. All-day events aren't meetings, so they are ignored.
+func analyzeSchedule(events []calendarEvent, settings scheduleSettings, now time.Time) scheduleReport {
+	var timed []calendarEvent
+	for _, event := range events {
+		if !event.AllDay {
+			timed = append(timed, event)
+		}
+	}
+	sort.SliceStable(timed, func(i, j int) bool {
+		return timed[i].Start.Before(timed[j].Start)
+	})
+
+	report := scheduleReport{
+		Conflicts:  []scheduleOverlap{},
+		BackToBack: []scheduleOverlap{},
+	}
+	for i, event := range timed {
+		for _, next := range timed[i+1:] {
+			if next.Start.Before(event.End) {
+				report.Conflicts = append(report.Conflicts, scheduleOverlap{First: event, Second: next})
+			}
+		}
+		if i+1 < len(timed) {
+			gap := timed[i+1].Start.Sub(event.End)
+			if gap >= 0 && gap < settings.minBreak {
+				report.BackToBack = append(report.BackToBack, scheduleOverlap{First: event, Second: timed[i+1]})
+			}
+		}
+	}
+
+	report.Free = freeSlots(timed, settings, now, windowEnd(settings.calendar.window, now))
+	return report
+}
+
+// freeSlots lists the working time between from and to that no event is scheduled in
+func freeSlots(events []calendarEvent, settings scheduleSettings, from, to time.Time) []freeSlot {
+	slots := []freeSlot{}
+	for day := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, from.Location()); day.Before(to); day = day.AddDate(0, 0, 1) {
+		if !settings.workDays[day.Weekday()] {
+			continue
+		}
+
+		start, end := atClockTime(day, settings.workStart), atClockTime(day, settings.workEnd)
+		if start.Before(from) {
+			start = from
+		}
+		if end.After(to) {
+			end = to
+		}
+
+		for _, event := range events {
+			if !start.Before(end) {
+				break
+			}
+			if !event.End.After(start) || !event.Start.Before(end) {
+				continue
+			}
+			if event.Start.After(start) {
+				slots = append(slots, freeSlot{Start: start, End: event.Start})
+			}
+			start = event.End
+		}
+		if start.Before(end) {
+			slots = append(slots, freeSlot{Start: start, End: end})
+		}
+	}
+	return slots
+}
+
+// atClockTime is the given time of day on day, which keeps working hours right on DST changes
+func atClockTime(day time.Time, offset time.Duration) time.Time {
+	return time.Date(day.Year(), day.Month(), day.Day(), int(offset.Hours()), int(offset.Minutes())%60, 0, 0, day.Location())
+}
+
+// describeSchedule formats a schedule report for prompts
+func describeSchedule(report scheduleReport, now time.Time) string {
+	var parts []string
+	for _, conflict := range report.Conflicts {
+		parts = append(parts, fmt.Sprintf("%q overlaps with %q %s", conflict.First.Title, conflict.Second.Title, relativeTime(conflict.Second, now)))
+	}
+	for _, pair := range report.BackToBack {
+		parts = append(parts, fmt.Sprintf("%q is right after %q with no break, %s", pair.Second.Title, pair.First.Title, relativeTime(pair.Second, now)))
+	}
+
+	var free []string
+	for _, slot := range report.Free {
+		if calendarDaysBetween(now, slot.Start) > 0 {
+			break
+		}
+		free = append(free, fmt.Sprintf("%s - %s", slot.Start.In(now.Location()).Format("3:04 PM"), slot.End.In(now.Location()).Format("3:04 PM")))
+	}
+	if len(free) > 0 {
+		parts = append(parts, "free working time today: "+strings.Join(free, ", "))
+	} else {
+		parts = append(parts, "no free working time left today")
+	}
+
+	return strings.Join(parts, "; ")
+}
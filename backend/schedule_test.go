@@ -0,0 +1,84 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testScheduleSettings is a 9 to 5 working week in UTC
+func testScheduleSettings() scheduleSettings {
+	return scheduleSettings{
+		calendar:  calendarSettings{lookahead: defaultCalendarLookahead, location: time.UTC, window: calendarWindowWeek},
+		workStart: 9 * time.Hour,
+		workEnd:   17 * time.Hour,
+		workDays: map[time.Weekday]bool{
+			time.Monday: true, time.Tuesday: true, time.Wednesday: true, time.Thursday: true, time.Friday: true,
+		},
+		minBreak: defaultMinBreak,
+	}
+}
+
+func TestNewScheduleSettings(t *testing.T) {
+	t.Setenv("CALENDAR_WORK_HOURS", "08:30-16:00")
+	t.Setenv("CALENDAR_WORK_DAYS", "Sun,Mon")
+
+	settings, err := newScheduleSettings()
+	require.NoError(t, err)
+	require.Equal(t, 8*time.Hour+30*time.Minute, settings.workStart)
+	require.Equal(t, 16*time.Hour, settings.workEnd)
+	require.Equal(t, map[time.Weekday]bool{time.Sunday: true, time.Monday: true}, settings.workDays)
+	require.Equal(t, defaultMinBreak, settings.minBreak)
+
+	for _, hours := range []string{"9-5", "17:00-09:00", "09:00"} {
+		t.Setenv("CALENDAR_WORK_HOURS", hours)
+		_, err = newScheduleSettings()
+		require.Error(t, err, hours)
+	}
+}
+
+func TestAnalyzeSchedule(t *testing.T) {
+	// Thursday morning, the week ends with Friday
+	now := time.Date(2024, 1, 4, 8, 0, 0, 0, time.UTC)
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2024, 1, day, hour, minute, 0, 0, time.UTC)
+	}
+	events := []calendarEvent{
+		{Title: "Review", Start: at(4, 10, 0), End: at(4, 11, 0)},
+		{Title: "Standup", Start: at(4, 9, 0), End: at(4, 9, 15)},
+		{Title: "Planning", Start: at(4, 10, 30), End: at(4, 11, 30)},
+		{Title: "1:1", Start: at(4, 11, 30), End: at(4, 12, 0)},
+		{Title: "Holiday", Start: at(5, 0, 0), End: at(6, 0, 0), AllDay: true},
+		{Title: "Lunch", Start: at(5, 12, 0), End: at(5, 13, 0)},
+	}
+
+	report := analyzeSchedule(events, testScheduleSettings(), now)
+
+	require.Len(t, report.Conflicts, 1)
+	require.Equal(t, "Review", report.Conflicts[0].First.Title)
+	require.Equal(t, "Planning", report.Conflicts[0].Second.Title)
+
+	require.Len(t, report.BackToBack, 1)
+	require.Equal(t, "Planning", report.BackToBack[0].First.Title)
+	require.Equal(t, "1:1", report.BackToBack[0].Second.Title)
+
+	require.Equal(t, []freeSlot{
+		{Start: at(4, 9, 15), End: at(4, 10, 0)},
+		{Start: at(4, 12, 0), End: at(4, 17, 0)},
+		{Start: at(5, 9, 0), End: at(5, 12, 0)},
+		{Start: at(5, 13, 0), End: at(5, 17, 0)},
+	}, report.Free)
+
+	require.Equal(t, `"Review" overlaps with "Planning" in 3 hours; "1:1" is right after "Planning" with no break, in 4 hours; free working time today: 9:15 AM - 10:00 AM, 12:00 PM - 5:00 PM`, describeSchedule(report, now))
+}
+
+func TestFreeSlots_StartsNow(t *testing.T) {
+	// Saturday isn't a working day
+	now := time.Date(2024, 1, 5, 15, 20, 0, 0, time.UTC)
+	slots := freeSlots(nil, testScheduleSettings(), now, time.Date(2024, 1, 8, 10, 0, 0, 0, time.UTC))
+	require.Equal(t, []freeSlot{
+		{Start: now, End: time.Date(2024, 1, 5, 17, 0, 0, 0, time.UTC)},
+		{Start: time.Date(2024, 1, 8, 9, 0, 0, 0, time.UTC), End: time.Date(2024, 1, 8, 10, 0, 0, 0, time.UTC)},
+	}, slots)
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// seenStore remembers which items have already been summarized, and their
+// summaries, so they are not re-summarized on every refresh
+type seenStore interface {
+	lookup(key string) (seenItem, bool)
+	mark(key string, summary string)
+	// latest returns when the most recent item with the given key prefix was first seen
+	latest(prefix string) time.Time
+	save() error
+}
+
+type seenItem struct {
+	Summary string    `json:"summary"`
+	SeenAt  time.Time `json:"seen_at"`
+}
+
+// fileSeenStore is a seenStore persisted as a JSON file
+type fileSeenStore struct {
+	path      string
+	retention time.Duration
+
+	mu    sync.Mutex
+	items map[string]seenItem
+}
+
+const (
+	defaultSeenStorePath = "seen.json"
+	defaultSeenRetention = 7 * 24 * time.Hour
+)
+
+func newSeenStore() (seenStore, error) {
+	path := os.Getenv("SEEN_STORE_PATH")
+	if path == "" {
+		path = defaultSeenStorePath
+	}
+
+	retention, err := envDuration("SEEN_RETENTION", defaultSeenRetention)
+	if err != nil {
+		return nil, err
+	}
+
+	store := &fileSeenStore{
+		path:      path,
+		retention: retention,
+		items:     map[string]seenItem{},
+	}
+
+	// a missing file just means nothing has been seen yet
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read seen store: %w", err)
+	}
+
+	err = json.Unmarshal(data, &store.items)
+	if err != nil {
+		return nil, fmt.Errorf("cannot unmarshal seen store: %w", err)
+	}
+	return store, nil
+}
+
+func (s *fileSeenStore) lookup(key string) (seenItem, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item, ok := s.items[key]
+	return item, ok
+}
+
+func (s *fileSeenStore) mark(key string, summary string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.items[key] = seenItem{Summary: summary, SeenAt: time.Now()}
+}
+
+func (s *fileSeenStore) latest(prefix string) time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var latest time.Time
+	for key, item := range s.items {
+		if strings.HasPrefix(key, prefix) && item.SeenAt.After(latest) {
+			latest = item.SeenAt
+		}
+	}
+	return latest
+}
+
+// save forgets items older than the retention period and writes the rest to disk
+func (s *fileSeenStore) save() error {
+	s.mu.Lock()
+	for key, item := range s.items {
+		if time.Since(item.SeenAt) > s.retention {
+			delete(s.items, key)
+		}
+	}
+	data, err := json.Marshal(s.items)
+	s.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("cannot marshal seen store: %w", err)
+	}
+
+	err = writeFileAtomic(s.path, data)
+	if err != nil {
+		return fmt.Errorf("cannot write seen store: %w", err)
+	}
+	return nil
+}
+
+// writeFileAtomic writes to a temporary file first so a crash can't leave a
+// partial file behind, creating the directory if needed. The file keeps its
+// mode, new files get the usual 0644.
+func writeFileAtomic(path string, data []byte) error {
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return fmt.Errorf("cannot create directory: %w", err)
+	}
+
+	mode := os.FileMode(0o644)
+	info, err := os.Stat(path)
+	if err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	// temporary files are only readable by their owner
+	err = tmp.Chmod(mode)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+
+	_, err = tmp.Write(data)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// newsSeenKey identifies a story by its URL, or its title when it has none
+func newsSeenKey(result newsResult) string {
+	if result.URL != "" {
+		return "news:" + result.URL
+	}
+	return "news:" + result.Title
+}
+
+// emailSeenKey identifies an email by its Message-ID, or where it is stored if it has none
+func emailSeenKey(message emailMessage) string {
+	if message.MessageID != "" {
+		return "email:" + message.MessageID
+	}
+	return fmt.Sprintf("email:%s/%d", message.Folder, message.UID)
+}
+
+// emailRiskSeenKey identifies an email that was withheld as phishing, apart from the
+// summaries of emails, so it isn't mistaken for a message that was summarized
+func emailRiskSeenKey(message emailMessage) string {
+	return "email-risk:" + strings.TrimPrefix(emailSeenKey(message), "email:")
+}
+
+// calendarSeenKey identifies an event by its title and start, and how soon it is, so a summary
+// saying it is "in 45 minutes" isn't shown again the next day
+func calendarSeenKey(event calendarEvent, now time.Time) string {
+	return "calendar:" + event.Title + "|" + event.Start.Format(time.RFC3339) + "|" + relativeBucket(event.Start, now)
+}
+
+// emailItemSeenKey identifies a flight, hotel stay or parcel across emails, and how soon it is
+func emailItemSeenKey(item emailItem, now time.Time) string {
+	key := "email-item:" + item.key()
+	switch {
+	case item.Start != nil:
+		key += "|" + relativeBucket(*item.Start, now)
+	case item.ExpectedDelivery != nil:
+		key += "|" + relativeBucket(*item.ExpectedDelivery, now)
+	}
+	return key
+}
+
+// relativeBucket says roughly how far from now start is: started, in quarter hours within
+// the hour, in hours within the day, then tomorrow or in days
+func relativeBucket(start, now time.Time) string {
+	until := start.Sub(now)
+	switch {
+	case until <= 0:
+		return "started"
+	case until < time.Hour:
+		return fmt.Sprintf("in %d minutes", (int(until.Minutes())+14)/15*15)
+	case until < 24*time.Hour:
+		return fmt.Sprintf("in %d hours", int(until.Hours()))
+	}
+
+	start = start.In(now.Location())
+	startDay := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, time.UTC)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	days := int(startDay.Sub(today).Hours() / 24)
+	if days == 1 {
+		return "tomorrow"
+	}
+	return fmt.Sprintf("in %d days", days)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSeenStore_PersistsAcrossRestarts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data", "seen.json")
+	os.Setenv("SEEN_STORE_PATH", path)
+	defer os.Unsetenv("SEEN_STORE_PATH")
+
+	store, err := newSeenStore()
+	require.NoError(t, err)
+
+	_, ok := store.lookup("news:https://test.com")
+	require.False(t, ok)
+	require.True(t, store.latest("news:").IsZero())
+
+	store.mark("news:https://test.com", "A summary.")
+	require.NoError(t, store.save())
+
+	// a new store picks up what was saved
+	reloaded, err := newSeenStore()
+	require.NoError(t, err)
+	item, ok := reloaded.lookup("news:https://test.com")
+	require.True(t, ok)
+	require.Equal(t, "A summary.", item.Summary)
+	require.WithinDuration(t, time.Now(), reloaded.latest("news:"), time.Minute)
+	require.True(t, reloaded.latest("calendar:").IsZero())
+}
+
+func TestSeenStore_ForgetsOldItems(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "seen.json")
+	os.Setenv("SEEN_STORE_PATH", path)
+	defer os.Unsetenv("SEEN_STORE_PATH")
+
+	store, err := newSeenStore()
+	require.NoError(t, err)
+
+	store.mark("news:https://old.test", "Old.")
+	store.(*fileSeenStore).items["news:https://old.test"] = seenItem{Summary: "Old.", SeenAt: time.Now().Add(-30 * 24 * time.Hour)}
+	store.mark("news:https://new.test", "New.")
+	require.NoError(t, store.save())
+
+	_, ok := store.lookup("news:https://old.test")
+	require.False(t, ok)
+	_, ok = store.lookup("news:https://new.test")
+	require.True(t, ok)
+}
+
+func TestSeenStore_Malformed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "seen.json")
+	require.NoError(t, os.WriteFile(path, []byte("not json"), 0o600))
+	os.Setenv("SEEN_STORE_PATH", path)
+	defer os.Unsetenv("SEEN_STORE_PATH")
+
+	store, err := newSeenStore()
+	require.Error(t, err)
+	require.Nil(t, store)
+}
+
+func TestCalendarSeenKey(t *testing.T) {
+	now := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	event := calendarEvent{Title: "Standup", Start: time.Date(2024, 1, 1, 9, 45, 0, 0, time.UTC)}
+	require.Equal(t, "calendar:Standup|2024-01-01T09:45:00Z|in 45 minutes", calendarSeenKey(event, now))
+
+	// the summary is written again once it no longer says how soon the event is
+	require.Equal(t, calendarSeenKey(event, now), calendarSeenKey(event, now.Add(5*time.Minute)))
+	require.NotEqual(t, calendarSeenKey(event, now), calendarSeenKey(event, now.Add(20*time.Minute)))
+	require.NotEqual(t, calendarSeenKey(event, now), calendarSeenKey(event, now.AddDate(0, 0, -1)))
+
+	require.Equal(t, "in 3 hours", relativeBucket(now.Add(3*time.Hour+time.Minute), now))
+	require.Equal(t, "tomorrow", relativeBucket(time.Date(2024, 1, 2, 18, 0, 0, 0, time.UTC), now))
+	require.Equal(t, "in 3 days", relativeBucket(time.Date(2024, 1, 4, 8, 0, 0, 0, time.UTC), now))
+	require.Equal(t, "started", relativeBucket(now, now))
+}
+
+func TestWriteFileAtomic_KeepsMode(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "calendar.ics")
+	require.NoError(t, writeFileAtomic(path, []byte("first")))
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o644), info.Mode().Perm())
+
+	require.NoError(t, os.Chmod(path, 0o664))
+	require.NoError(t, writeFileAtomic(path, []byte("second")))
+	info, err = os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o664), info.Mode().Perm())
+}
@@ -2,30 +2,84 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"slices"
+	"strings"
 	"sync"
+	"time"
 )
 
 type prompt struct {
 	key           string
 	prompt        string
 	generateImage bool
+	priority      string
+	alert         *weatherAlert
+	articles      []newsResult
+	emails        []emailMessage
+	item          *emailItem
+	// seenKey identifies the source item, so it is only summarized the first time it is seen
+	seenKey string
+	new     bool
 }
 
 // PromptResult is the response value for a given prompt
 type PromptResult struct {
-	Key      string `json:"key"`
-	Response string `json:"response"`
-	ImageURL string `json:"image_url,omitempty"`
+	Key      string        `json:"key"`
+	Response string        `json:"response"`
+	ImageURL string        `json:"image_url,omitempty"`
+	Priority string        `json:"priority,omitempty"`
+	Alert    *weatherAlert `json:"alert,omitempty"`
+	Items    []PromptItem  `json:"items,omitempty"`
+	Emails   []Email       `json:"emails,omitempty"`
+	// Details are the flight, hotel stay or parcel a card is about
+	Details *emailItem `json:"details,omitempty"`
+	// New is set when the card covers items that weren't shown before
+	New bool `json:"new,omitempty"`
 }
 
-func getUpdates(w http.ResponseWriter, _ *http.Request, o openWebUIClient, a automaticSDClient, weather weatherClient, news newsClient, calendar calendarClient) error {
-	// get source data: weather
-	weatherResult, err := weather.get()
+// PromptItem is a summary of a single source item, such as a news article, linked to the original
+type PromptItem struct {
+	Title   string `json:"title"`
+	Summary string `json:"summary"`
+	URL     string `json:"url"`
+	Source  string `json:"source,omitempty"`
+}
+
+// Email is a summary of an unread message or thread, in the shape the frontend's email carousel expects
+type Email struct {
+	Image        string `json:"image"`
+	Title        string `json:"title"`
+	Description  string `json:"description"`
+	NeedResponse bool   `json:"needResponse"`
+	// Category, Confidence and Reasoning explain NeedResponse
+	Category   string  `json:"category,omitempty"`
+	Confidence float64 `json:"confidence,omitempty"`
+	Reasoning  string  `json:"reasoning,omitempty"`
+	// Messages is the number of unread messages when the summary covers a thread
+	Messages      int      `json:"messages,omitempty"`
+	Decisions     []string `json:"decisions,omitempty"`
+	OpenQuestions []string `json:"openQuestions,omitempty"`
+	WaitingOnUs   []string `json:"waitingOnUs,omitempty"`
+	// Risky emails look like phishing, and only the reasons are shown
+	Risky       bool     `json:"risky,omitempty"`
+	RiskReasons []string `json:"riskReasons,omitempty"`
+}
+
+const (
+	priorityHigh       = "high"
+	maxCalendarPrompts = 3
+)
+
+func getUpdates(w http.ResponseWriter, _ *http.Request, o openWebUIClient, a automaticSDClient, weather weatherClient, news newsClient, calendar calendarClient, schedule scheduleSettings, email emailClient, threader emailThreader, seen seenStore) error {
+	// get source data: weather, the first location is the primary one
+	weatherResults, err := weather.getAll()
 	if err != nil {
 		return fmt.Errorf("cannot get weather: %w", err)
 	}
+	weatherResult := weatherResults[0]
 
 	// get source data: news
 	newsResults, err := news.get()
@@ -39,67 +93,452 @@ func getUpdates(w http.ResponseWriter, _ *http.Request, o openWebUIClient, a aut
 		return fmt.Errorf("cannot get calendar events: %w", err)
 	}
 
+	// get source data: unread emails, the other cards are still good without them
+	unreadEmails, err := email.getUnread()
+	if err != nil {
+		fmt.Println(fmt.Errorf("cannot get emails: %w", err))
+		unreadEmails = nil
+	}
+
+	// only stories that weren't summarized before are news
+	var freshNews []newsResult
+	for _, result := range newsResults {
+		if _, ok := seen.lookup(newsSeenKey(result)); !ok {
+			freshNews = append(freshNews, result)
+		}
+	}
+	newsPrompt := prompt{
+		key:           "news",
+		prompt:        fmt.Sprintf("You are a news assistant. The latest news are below:\n%s\n \n Write a very short digest of the news, mentioning when a story was widely reported.", formatNews(freshNews)),
+		generateImage: true,
+		articles:      freshNews,
+		new:           true,
+	}
+	if len(freshNews) == 0 && len(newsResults) > 0 {
+		newsPrompt = prompt{
+			key:           "news",
+			prompt:        fmt.Sprintf("You are a news assistant. There is no new news since %s. Write a very short comment saying there is nothing new.", seen.latest("news:").Format("Monday 3:04 PM")),
+			generateImage: false,
+		}
+	}
+
 	prompts := []prompt{
 		{
 			key:           "weather",
-			prompt:        fmt.Sprintf("You are a weather assistant. The current temperature is %f°C and the weather is %s. Write a very short comment on the weather.", weatherResult.Temp, weatherResult.Weather),
+			prompt:        fmt.Sprintf("You are a weather assistant. The current temperature is %f°C and the weather is %s.%s Write a very short comment on the weather.", weatherResult.Temp, weatherResult.Weather, weatherDetails(weatherResult)),
 			generateImage: false,
 		},
-		{
-			key:           "news",
-			prompt:        fmt.Sprintf("You are a news assistant. The latest news are below: %v.\n \n Write a very short comment on the news.", newsResults),
-			generateImage: true,
-		},
-		{
-			key:           "calendar1",
-			prompt:        fmt.Sprintf("You are a calendar assistant. The calendar event is below: %v.\n \n Write a very short comment on the calendar event.", calendarEvents[0]),
+		newsPrompt,
+	}
+
+	// one card for each of the next few calendar events
+	for i, event := range calendarEvents {
+		if i == maxCalendarPrompts {
+			break
+		}
+		now := time.Now().In(event.Start.Location())
+		prompts = append(prompts, prompt{
+			key:           fmt.Sprintf("calendar%d", i+1),
+			prompt:        fmt.Sprintf("You are a calendar assistant. It is %s. The calendar event is below: %s.\n \n %s", now.Format("Monday 3:04 PM"), describeCalendarEvent(event, now), calendarInstruction(event)),
 			generateImage: false,
-		},
-		{
-			key:           "calendar2",
-			prompt:        fmt.Sprintf("You are a calendar assistant. The calendar event is below: %v.\n \n Write a very short comment on the calendar event.", calendarEvents[1]),
+			seenKey:       calendarSeenKey(event, now),
+		})
+	}
+
+	// point out conflicts, meetings without breaks and free time
+	if len(calendarEvents) > 0 {
+		now := time.Now().In(schedule.calendar.location)
+		report := analyzeSchedule(calendarEvents, schedule, now)
+		healthPrompt := prompt{
+			key:           "schedule_health",
+			prompt:        fmt.Sprintf("You are a calendar assistant. It is %s. Here is an overview of the schedule: %s.\n \n Write a very short comment on how busy the schedule is, pointing out any conflicts to resolve.", now.Format("Monday 3:04 PM"), describeSchedule(report, now)),
 			generateImage: false,
-		},
-		{
-			key:           "calendar3",
-			prompt:        fmt.Sprintf("You are a calendar assistant. The calendar event is below: %v.\n \n Write a very short comment on the calendar event.", calendarEvents[2]),
+		}
+		if len(report.Conflicts) > 0 {
+			healthPrompt.priority = priorityHigh
+		}
+		prompts = append(prompts, healthPrompt)
+	}
+
+	// one card for the unread emails, with a summary of each thread
+	if len(unreadEmails) > 0 {
+		prompts = append(prompts, prompt{
+			key:           "emails",
+			prompt:        fmt.Sprintf("You are an email assistant. The unread emails are below:\n%s\n \n Write a very short overview of the unread emails, mentioning the ones that need a response.", formatEmails(unreadEmails)),
 			generateImage: false,
-		},
+			emails:        unreadEmails,
+		})
+	}
+
+	// one card for each upcoming flight, hotel stay and parcel found in the emails
+	prompts = append(prompts, emailItemPrompts(unreadEmails, time.Now().In(schedule.calendar.location))...)
+
+	// compare the weather at every other saved location to the primary one
+	for _, other := range weatherResults[1:] {
+		prompts = append(prompts, prompt{
+			key:           "weather_" + other.Location,
+			prompt:        fmt.Sprintf("You are a weather assistant. At %s the current temperature is %f°C and the weather is %s. At %s the current temperature is %f°C and the weather is %s. Write a very short comment on how the weather at %s differs from %s.", weatherResult.Location, weatherResult.Temp, weatherResult.Weather, other.Location, other.Temp, other.Weather, other.Location, weatherResult.Location),
+			generateImage: false,
+		})
+	}
+
+	// weather alerts go first as high-priority cards, an alert covering
+	// several locations is only shown once
+	var alertPrompts []prompt
+	seenAlerts := map[string]bool{}
+	for _, result := range weatherResults {
+		for i := range result.Alerts {
+			alert := result.Alerts[i]
+			if seenAlerts[alert.ID] {
+				continue
+			}
+			seenAlerts[alert.ID] = true
+			alertPrompts = append(alertPrompts, prompt{
+				key:           "weather_alert_" + alert.ID,
+				prompt:        fmt.Sprintf("You are a weather assistant. The following government weather alert is in effect: %s (severity: %s) from %s until %s, issued by %s. The official description is below: %s.\n \n Explain in plain language what this alert means and what to do about it, in a few short sentences.", alert.Event, alert.Severity, alert.Start.Format(time.RFC1123), alert.End.Format(time.RFC1123), alert.Sender, alert.Description),
+				generateImage: false,
+				priority:      priorityHigh,
+				alert:         &alert,
+			})
+		}
 	}
+	prompts = append(alertPrompts, prompts...)
 
-	// concurrently generate updates for each prompt
+	// concurrently generate updates for each prompt, errors are collected so the
+	// response is decided once everything is done
 	updates := make([]PromptResult, len(prompts))
+	errs := make([]error, len(prompts))
 	var wg sync.WaitGroup
 	for i, promptValue := range prompts {
 		wg.Add(1)
 		go func(i int, promptValue prompt) {
 			defer wg.Done()
+			updates[i].Key = promptValue.key
+			updates[i].Priority = promptValue.priority
+			updates[i].Alert = promptValue.alert
+			updates[i].New = promptValue.new
+			updates[i].Details = promptValue.item
+
+			// reuse the summary of an item that was seen before
+			if promptValue.seenKey != "" {
+				if item, ok := seen.lookup(promptValue.seenKey); ok {
+					updates[i].Response = item.Summary
+					return
+				}
+				updates[i].New = true
+			}
+
 			promptResult, err := o.generate(promptValue.prompt)
 			if err != nil {
-				writeHttpError(w, http.StatusInternalServerError, "cannot get updates", err)
+				errs[i] = fmt.Errorf("cannot generate %s: %w", promptValue.key, err)
+				return
 			}
 
 			if promptValue.generateImage {
 				imageURL, err := a.txt2img(promptResult)
 				if err != nil {
-					writeHttpError(w, http.StatusInternalServerError, "cannot get updates", err)
+					errs[i] = fmt.Errorf("cannot generate %s image: %w", promptValue.key, err)
+					return
 				}
 				updates[i].ImageURL = imageURL
 			}
 
 			updates[i].Response = promptResult
-			updates[i].Key = promptValue.key
+			if promptValue.seenKey != "" {
+				seen.mark(promptValue.seenKey, promptResult)
+			}
+
+			if len(promptValue.articles) > 0 {
+				var err error
+				updates[i].Items, err = summarizeArticles(o, seen, promptValue.articles)
+				// the card is still good with the description of the stories that couldn't be summarized
+				if err != nil {
+					fmt.Println(fmt.Errorf("cannot summarize news: %w", err))
+				}
+			}
+			if len(promptValue.emails) > 0 {
+				var err error
+				updates[i].Emails, updates[i].New, err = summarizeEmails(o, seen, threader, promptValue.emails)
+				// emails that couldn't be summarized show the start of their text instead
+				if err != nil {
+					fmt.Println(fmt.Errorf("cannot summarize emails: %w", err))
+				}
+			}
 		}(i, promptValue)
 	}
 	wg.Wait()
+	err = errors.Join(errs...)
+	if err != nil {
+		return fmt.Errorf("cannot get updates: %w", err)
+	}
+
+	// remembering what was seen is best effort, the updates are still good without it
+	err = seen.save()
+	if err != nil {
+		fmt.Println(fmt.Errorf("cannot save seen items: %w", err))
+	}
 
 	// return updates as json
 	updatesJson, err := json.Marshal(updates)
 	if err != nil {
-		writeHttpError(w, http.StatusInternalServerError, "cannot marshal updates to json", err)
+		return fmt.Errorf("cannot marshal updates to json: %w", err)
 	}
 	w.WriteHeader(http.StatusOK)
 	w.Write(updatesJson)
 
 	return nil
 }
+
+// summarizeArticles concurrently writes a short summary of each article, linked
+// to the original story, and marks the article as seen. Articles that can't be
+// summarized keep their description, and the errors are returned together.
+func summarizeArticles(o openWebUIClient, seen seenStore, articles []newsResult) ([]PromptItem, error) {
+	items := make([]PromptItem, len(articles))
+	errs := make([]error, len(articles))
+	var wg sync.WaitGroup
+	for i, article := range articles {
+		wg.Add(1)
+		go func(i int, article newsResult) {
+			defer wg.Done()
+			text := article.Description
+			if article.Content != "" {
+				text = article.Content
+			}
+			summary, err := o.generate(fmt.Sprintf("You are a news assistant. The article is below:\n%s\n%s\n \n Summarize the article in one or two sentences.", article.Title, text))
+			if err != nil {
+				errs[i] = fmt.Errorf("cannot summarize %q: %w", article.Title, err)
+				summary = article.Description
+			} else {
+				seen.mark(newsSeenKey(article), summary)
+			}
+
+			items[i] = PromptItem{
+				Title:   article.Title,
+				Summary: summary,
+				URL:     article.URL,
+				Source:  article.Source,
+			}
+		}(i, article)
+	}
+	wg.Wait()
+
+	return items, errors.Join(errs...)
+}
+
+// emailItemPrompts makes a card for each flight, hotel stay and parcel in the emails that hasn't passed,
+// once even when several emails mention it. A flight leaving within a day is high priority.
+func emailItemPrompts(messages []emailMessage, now time.Time) []prompt {
+	var prompts []prompt
+	seenItems := map[string]bool{}
+	for _, message := range messages {
+		for i := range message.Items {
+			item := message.Items[i]
+			key := item.key()
+			if seenItems[key] || !item.current(now) {
+				continue
+			}
+			seenItems[key] = true
+
+			assistant, instruction := "travel", "Write a very short comment on the trip, mentioning anything to prepare."
+			if item.Kind == emailItemParcel {
+				assistant, instruction = "delivery", "Write a very short comment on when the parcel arrives."
+			}
+			itemPrompt := prompt{
+				key:           "email_item_" + key,
+				prompt:        fmt.Sprintf("You are a %s assistant. It is %s. The details below are from an email:\n%s.\n \n %s", assistant, now.Format("Monday, January 2 3:04 PM"), describeEmailItem(item, now), instruction),
+				generateImage: false,
+				item:          &item,
+				seenKey:       emailItemSeenKey(item, now),
+			}
+			if item.Kind == emailItemFlight && item.Start != nil && item.Start.Before(now.Add(24*time.Hour)) {
+				itemPrompt.priority = priorityHigh
+			}
+			prompts = append(prompts, itemPrompt)
+		}
+	}
+	return prompts
+}
+
+// calendarInstruction asks for a comment in the tone that suits the kind of calendar the event is from
+func calendarInstruction(event calendarEvent) string {
+	switch event.Kind {
+	case calendarKindWork:
+		return "This is a work event. Write a very short, matter-of-fact comment on the calendar event, mentioning anything worth preparing."
+	case calendarKindPersonal:
+		return "This is a personal event. Write a very short, friendly comment on the calendar event."
+	}
+	return "Write a very short comment on the calendar event."
+}
+
+// summarizeEmails groups the emails into threads and concurrently summarizes each one, a single message
+// in one sentence and a conversation with its decisions, open questions and who is waiting on us.
+// Summaries of threads that were seen before are reused, and it reports whether any thread is new.
+// Emails that look like phishing are flagged, with their content withheld. Messages that can't be
+// summarized show the start of their text, and the errors are returned together.
+func summarizeEmails(o openWebUIClient, seen seenStore, threader emailThreader, messages []emailMessage) ([]Email, bool, error) {
+	// risky messages are listed without their content, and kept out of the threads
+	var safe, withheld []emailMessage
+	for _, message := range messages {
+		if message.Risk != nil && message.Risk.Risky {
+			withheld = append(withheld, message)
+		} else {
+			safe = append(safe, message)
+		}
+	}
+
+	threads := groupEmailThreads(safe)
+	emails := make([]Email, len(threads))
+	fresh := make([]bool, len(threads))
+	errs := make([]error, len(threads))
+	connections := make(chan struct{}, emailThreadConnections)
+	var wg sync.WaitGroup
+	for i, thread := range threads {
+		wg.Add(1)
+		go func(i int, thread emailThread) {
+			defer wg.Done()
+			latest := thread.latest()
+			emails[i].Title = latest.Subject
+			if len(thread.Unread) > 1 {
+				emails[i].Title = thread.Subject
+				emails[i].Messages = len(thread.Unread)
+			}
+			classified, ok := thread.needsResponse()
+			if !ok {
+				classified = latest
+			}
+			if classified.Classification != nil {
+				emails[i].NeedResponse = classified.Classification.NeedsResponse
+				emails[i].Category = classified.Classification.Category
+				emails[i].Confidence = classified.Classification.Confidence
+				emails[i].Reasoning = classified.Classification.Reasoning
+			}
+
+			// a message on its own is summarized in one sentence
+			if len(thread.Unread) == 1 && len(emailMessageAncestors(latest)) == 0 {
+				if item, ok := seen.lookup(emailSeenKey(latest)); ok {
+					emails[i].Description = item.Summary
+					return
+				}
+				fresh[i] = true
+
+				summary, err := o.generate(fmt.Sprintf("You are an email assistant. The email is below:\nFrom: %s\nSubject: %s\n%s\n \n Summarize the email in one sentence.", latest.From, latest.Subject, latest.Text))
+				if err != nil {
+					errs[i] = fmt.Errorf("cannot summarize %q: %w", latest.Subject, err)
+					summary = emailPreview(latest)
+				} else {
+					seen.mark(emailSeenKey(latest), summary)
+				}
+				emails[i].Description = summary
+				return
+			}
+
+			var summary emailThreadSummary
+			item, ok := seen.lookup(thread.seenKey())
+			if ok && json.Unmarshal([]byte(item.Summary), &summary) == nil {
+				emails[i].setThreadSummary(summary)
+				return
+			}
+			fresh[i] = true
+
+			connections <- struct{}{}
+			thread = thread.withContext(threader)
+			<-connections
+
+			summary, err := summarizeEmailThread(o, thread)
+			if err != nil {
+				errs[i] = fmt.Errorf("cannot summarize thread %q: %w", thread.Subject, err)
+				emails[i].Description = emailPreview(latest)
+				return
+			}
+			summaryJson, err := json.Marshal(summary)
+			if err == nil {
+				seen.mark(thread.seenKey(), string(summaryJson))
+			}
+			emails[i].setThreadSummary(summary)
+		}(i, thread)
+	}
+	wg.Wait()
+
+	isNew := slices.Contains(fresh, true)
+	for _, message := range withheld {
+		emails = append(emails, Email{
+			Title:       "Possible phishing from " + message.FromAddress,
+			Description: "The content of this email is withheld because it looks like phishing.",
+			Risky:       true,
+			RiskReasons: message.Risk.Reasons,
+		})
+		if _, ok := seen.lookup(emailRiskSeenKey(message)); !ok {
+			seen.mark(emailRiskSeenKey(message), "withheld")
+			isNew = true
+		}
+	}
+	return emails, isNew, errors.Join(errs...)
+}
+
+func (e *Email) setThreadSummary(summary emailThreadSummary) {
+	e.Description = summary.Summary
+	e.Decisions = summary.Decisions
+	e.OpenQuestions = summary.OpenQuestions
+	e.WaitingOnUs = summary.WaitingOnUs
+}
+
+// maxEmailPreviewChars is how much of an email is shown when it can't be summarized
+const maxEmailPreviewChars = 200
+
+// emailPreview is the start of the text of an email, on one line
+func emailPreview(message emailMessage) string {
+	return truncateText(strings.Join(strings.Fields(message.Text), " "), maxEmailPreviewChars)
+}
+
+// formatEmails lists the sender and subject of each email for prompts
+func formatEmails(messages []emailMessage) string {
+	lines := make([]string, len(messages))
+	for i, message := range messages {
+		if message.Risk != nil && message.Risk.Risky {
+			lines[i] = fmt.Sprintf("- possible phishing from %s (content withheld)", message.FromAddress)
+			continue
+		}
+		lines[i] = fmt.Sprintf("- %s: %s", message.From, message.Subject)
+		if message.Classification != nil && message.Classification.NeedsResponse {
+			lines[i] += " (needs a response)"
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// weatherDetails describes today's high/low and the next few hours, when the
+// provider has them, and any air quality, UV or pollen readings worth a comment
+func weatherDetails(result weatherResult) string {
+	summary := ""
+	if result.High != nil && result.Low != nil {
+		summary += fmt.Sprintf(" Today's high is %f°C and the low is %f°C.", *result.High, *result.Low)
+	}
+	if len(result.Hourly) > 0 {
+		hours := make([]string, 0, len(result.Hourly))
+		for _, hour := range result.Hourly {
+			hours = append(hours, fmt.Sprintf("%s: %f°C, %s", hour.Time.Format("15:04"), hour.Temp, hour.Weather))
+		}
+		summary += fmt.Sprintf(" The forecast for the next hours is: %s.", strings.Join(hours, "; "))
+	}
+	if len(result.Notices) > 0 {
+		summary += fmt.Sprintf(" Also, %s; comment on these as well.", strings.Join(result.Notices, ", "))
+	}
+	return summary
+}
+
+// formatNews lists stories one per line for a prompt
+func formatNews(results []newsResult) string {
+	lines := make([]string, 0, len(results))
+	for _, result := range results {
+		text := result.Description
+		if result.Content != "" {
+			text = result.Content
+		}
+		line := fmt.Sprintf("- %s: %s", result.Title, text)
+		if result.ClusterSize > 1 {
+			line += fmt.Sprintf(" (widely reported, by %d outlets)", result.ClusterSize)
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
@@ -2,10 +2,15 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"slices"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -15,21 +20,36 @@ const (
 	weatherPrompt  = "You are a weather assistant."
 	newsPrompt     = "You are a news assistant."
 	calendarPrompt = "You are a calendar assistant."
+	emailPrompt    = "You are an email assistant."
 )
 
 type mockOpenWebUIClient struct {
+	mu             sync.Mutex
 	generateCalls  int
 	generateArgs   []string
 	generateErrors []error
+	// generateReturns is the response to prompts that aren't for updates
+	generateReturns string
+	// generateFailsOn fails prompts that contain it, for calls made concurrently
+	generateFailsOn string
+
+	generateJSONArgs    []string
+	generateJSONReturns string
+	generateJSONErrors  []error
 }
 
 func (m *mockOpenWebUIClient) generate(prompt string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	m.generateCalls++
 	m.generateArgs = append(m.generateArgs, prompt)
 	if len(m.generateErrors) > 0 {
 		return "", m.generateErrors[m.generateCalls-1]
 	}
-	response := ""
+	if m.generateFailsOn != "" && strings.Contains(prompt, m.generateFailsOn) {
+		return "", errors.New("model is down")
+	}
+	response := m.generateReturns
 	// return a response based on whether the prompt matches the beginning of the prompt
 	if strings.HasPrefix(prompt, weatherPrompt) {
 		response = "The weather is clear and sunny."
@@ -37,17 +57,40 @@ func (m *mockOpenWebUIClient) generate(prompt string) (string, error) {
 		response = "The latest news is that the weather is clear and sunny."
 	} else if strings.HasPrefix(prompt, calendarPrompt) {
 		response = "The latest calendar event is that the weather is clear and sunny."
+	} else if strings.HasPrefix(prompt, emailPrompt) {
+		response = "An email about the weather."
 	}
 	return response, nil
 }
 
+func (m *mockOpenWebUIClient) generateJSON(prompt string, schema map[string]any) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.generateJSONArgs = append(m.generateJSONArgs, prompt)
+	if len(m.generateJSONErrors) > 0 {
+		return "", m.generateJSONErrors[len(m.generateJSONArgs)-1]
+	}
+	return m.generateJSONReturns, nil
+}
+
+func (m *mockOpenWebUIClient) embed(text string) ([]float64, error) {
+	// a single dimension is enough to tell topics apart
+	if strings.Contains(strings.ToLower(text), "space") {
+		return []float64{1, 0}, nil
+	}
+	return []float64{0, 1}, nil
+}
+
 type mockAutomaticSDClient struct {
+	mu            sync.Mutex
 	txt2imgCalls  int
 	txt2imgArgs   []string
 	txt2imgErrors []error
 }
 
 func (m *mockAutomaticSDClient) txt2img(prompt string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	m.txt2imgCalls++
 	m.txt2imgArgs = append(m.txt2imgArgs, prompt)
 	if len(m.txt2imgErrors) > 0 {
@@ -57,9 +100,10 @@ func (m *mockAutomaticSDClient) txt2img(prompt string) (string, error) {
 }
 
 type mockWeatherClient struct {
-	getCalls   int
-	getReturns *weatherResult
-	getErrors  []error
+	getCalls      int
+	getReturns    *weatherResult
+	getAllReturns []weatherResult
+	getErrors     []error
 }
 
 func (m *mockWeatherClient) get() (*weatherResult, error) {
@@ -70,6 +114,17 @@ func (m *mockWeatherClient) get() (*weatherResult, error) {
 	return m.getReturns, nil
 }
 
+func (m *mockWeatherClient) getAll() ([]weatherResult, error) {
+	if m.getAllReturns != nil {
+		return m.getAllReturns, nil
+	}
+	result, err := m.get()
+	if err != nil {
+		return nil, err
+	}
+	return []weatherResult{*result}, nil
+}
+
 type mockNewsClient struct {
 	getCalls   int
 	getReturns []newsResult
@@ -84,6 +139,20 @@ func (m *mockNewsClient) get() ([]newsResult, error) {
 	return m.getReturns, nil
 }
 
+type mockEmailClient struct {
+	getUnreadReturns []emailMessage
+	getUnreadErrors  []error
+	getUnreadCalls   int
+}
+
+func (m *mockEmailClient) getUnread() ([]emailMessage, error) {
+	m.getUnreadCalls++
+	if len(m.getUnreadErrors) > 0 {
+		return nil, m.getUnreadErrors[m.getUnreadCalls-1]
+	}
+	return m.getUnreadReturns, nil
+}
+
 type mockCalendarClient struct {
 	getEventsCalls   int
 	getEventsReturns []calendarEvent
@@ -98,6 +167,46 @@ func (m *mockCalendarClient) getEvents() ([]calendarEvent, error) {
 	return m.getEventsReturns, nil
 }
 
+type mockSeenStore struct {
+	mu        sync.Mutex
+	items     map[string]seenItem
+	saveCalls int
+}
+
+func newMockSeenStore() *mockSeenStore {
+	return &mockSeenStore{items: map[string]seenItem{}}
+}
+
+func (m *mockSeenStore) lookup(key string) (seenItem, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	item, ok := m.items[key]
+	return item, ok
+}
+
+func (m *mockSeenStore) mark(key string, summary string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.items[key] = seenItem{Summary: summary, SeenAt: time.Date(2024, 1, 1, 9, 30, 0, 0, time.UTC)}
+}
+
+func (m *mockSeenStore) latest(prefix string) time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var latest time.Time
+	for key, item := range m.items {
+		if strings.HasPrefix(key, prefix) && item.SeenAt.After(latest) {
+			latest = item.SeenAt
+		}
+	}
+	return latest
+}
+
+func (m *mockSeenStore) save() error {
+	m.saveCalls++
+	return nil
+}
+
 func TestGetUpdatesSuccess(t *testing.T) {
 	// set up test environment
 	mockOpenWebUIClient := &mockOpenWebUIClient{
@@ -129,8 +238,9 @@ func TestGetUpdatesSuccess(t *testing.T) {
 	calendarEventValue := calendarEvent{
 		Title:       "Test Calendar Event",
 		Description: "Test Description",
-		Start:       "2021-01-01",
-		End:         "2021-01-01",
+		Start:       time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+		End:         time.Date(2021, 1, 2, 0, 0, 0, 0, time.UTC),
+		AllDay:      true,
 	}
 	mockCalendarClient.getEventsReturns = []calendarEvent{calendarEventValue, calendarEventValue, calendarEventValue}
 	mockCalendarClient.getEventsErrors = []error{}
@@ -139,7 +249,7 @@ func TestGetUpdatesSuccess(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// handle GET request for /updates
 		if r.Method == "GET" {
-			getUpdates(w, r, mockOpenWebUIClient, mockAutomaticSDClient, mockWeatherClient, mockNewsClient, mockCalendarClient)
+			getUpdates(w, r, mockOpenWebUIClient, mockAutomaticSDClient, mockWeatherClient, mockNewsClient, mockCalendarClient, testScheduleSettings(), &mockEmailClient{}, nil, newMockSeenStore())
 			return
 		}
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -167,7 +277,7 @@ func TestGetUpdatesSuccess(t *testing.T) {
 	require.NoError(t, err)
 
 	// check number of updates
-	require.Len(t, response, 5)
+	require.Len(t, response, 6)
 
 	// check weather update
 	require.Equal(t, "weather", response[0].Key)
@@ -177,8 +287,395 @@ func TestGetUpdatesSuccess(t *testing.T) {
 	require.Equal(t, "news", response[1].Key)
 	require.Equal(t, "The latest news is that the weather is clear and sunny.", response[1].Response)
 	require.Equal(t, "test.com/image.jpg", response[1].ImageURL)
+	require.Len(t, response[1].Items, 1)
+	require.Equal(t, "Test News", response[1].Items[0].Title)
+	require.Equal(t, "https://test.com", response[1].Items[0].URL)
+	require.Equal(t, "The latest news is that the weather is clear and sunny.", response[1].Items[0].Summary)
 
 	// check calendar update
 	require.Equal(t, "calendar1", response[2].Key)
 	require.Equal(t, "The latest calendar event is that the weather is clear and sunny.", response[2].Response)
+
+	// check schedule health update
+	require.Equal(t, "schedule_health", response[5].Key)
+}
+
+func TestGetUpdatesWeatherAlert(t *testing.T) {
+	// set up test environment
+	mockOpenWebUIClient := &mockOpenWebUIClient{}
+	mockAutomaticSDClient := &mockAutomaticSDClient{}
+	calendarEventValue := calendarEvent{Title: "Test Calendar Event"}
+	mockWeatherClient := &mockWeatherClient{
+		getReturns: &weatherResult{
+			Temp:    30.0,
+			Weather: "Thunderstorm",
+			Alerts: []weatherAlert{
+				{ID: "abc123", Event: "Severe Thunderstorm Warning", Severity: "severe"},
+			},
+		},
+	}
+	mockNewsClient := &mockNewsClient{}
+	mockCalendarClient := &mockCalendarClient{
+		getEventsReturns: []calendarEvent{calendarEventValue, calendarEventValue, calendarEventValue},
+	}
+
+	// call handler directly
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/updates", nil)
+	err := getUpdates(recorder, req, mockOpenWebUIClient, mockAutomaticSDClient, mockWeatherClient, mockNewsClient, mockCalendarClient, testScheduleSettings(), &mockEmailClient{}, nil, newMockSeenStore())
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, recorder.Code)
+
+	var response []PromptResult
+	err = json.NewDecoder(recorder.Body).Decode(&response)
+	require.NoError(t, err)
+
+	// alert card comes first with high priority
+	require.Len(t, response, 7)
+	require.Equal(t, "weather_alert_abc123", response[0].Key)
+	require.Equal(t, priorityHigh, response[0].Priority)
+	require.Equal(t, "severe", response[0].Alert.Severity)
+	require.Equal(t, "The weather is clear and sunny.", response[0].Response)
+	require.Equal(t, "weather", response[1].Key)
+}
+
+func TestGetUpdatesMultipleLocations(t *testing.T) {
+	// set up test environment, the same alert is reported for both locations
+	alert := weatherAlert{ID: "abc123", Event: "Heat Advisory", Severity: "minor"}
+	mockWeatherClient := &mockWeatherClient{
+		getAllReturns: []weatherResult{
+			{Location: "home", Temp: 20.0, Weather: "Clear", Alerts: []weatherAlert{alert}},
+			{Location: "office", Temp: 14.0, Weather: "Rain", Alerts: []weatherAlert{alert}},
+		},
+	}
+	calendarEventValue := calendarEvent{Title: "Test Calendar Event"}
+	mockCalendarClient := &mockCalendarClient{
+		getEventsReturns: []calendarEvent{calendarEventValue, calendarEventValue, calendarEventValue},
+	}
+	mockOpenWebUIClient := &mockOpenWebUIClient{}
+
+	// call handler directly
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/updates", nil)
+	err := getUpdates(recorder, req, mockOpenWebUIClient, &mockAutomaticSDClient{}, mockWeatherClient, &mockNewsClient{}, mockCalendarClient, testScheduleSettings(), &mockEmailClient{}, nil, newMockSeenStore())
+	require.NoError(t, err)
+
+	var response []PromptResult
+	err = json.NewDecoder(recorder.Body).Decode(&response)
+	require.NoError(t, err)
+
+	// one alert card, the six standard cards, and one comparison card
+	require.Len(t, response, 8)
+	require.Equal(t, "weather_alert_abc123", response[0].Key)
+	require.Equal(t, "weather", response[1].Key)
+	require.Equal(t, "weather_office", response[7].Key)
+	require.Contains(t, mockOpenWebUIClient.generateArgs, "You are a weather assistant. At home the current temperature is 20.000000°C and the weather is Clear. At office the current temperature is 14.000000°C and the weather is Rain. Write a very short comment on how the weather at office differs from home.")
+}
+
+func TestGetUpdatesSeenItems(t *testing.T) {
+	// set up test environment, everything was already summarized this morning
+	newsValue := newsResult{Title: "Test News", URL: "https://test.com"}
+	calendarEventValue := calendarEvent{Title: "Test Calendar Event", Start: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)}
+	seen := newMockSeenStore()
+	seen.mark(newsSeenKey(newsValue), "Old news summary.")
+	seen.mark(calendarSeenKey(calendarEventValue, time.Now()), "Old calendar summary.")
+
+	mockOpenWebUIClient := &mockOpenWebUIClient{}
+	mockAutomaticSDClient := &mockAutomaticSDClient{}
+	mockWeatherClient := &mockWeatherClient{getReturns: &weatherResult{Temp: 20.0, Weather: "Clear"}}
+	mockNewsClient := &mockNewsClient{getReturns: []newsResult{newsValue}}
+	mockCalendarClient := &mockCalendarClient{
+		getEventsReturns: []calendarEvent{calendarEventValue, calendarEventValue, calendarEventValue},
+	}
+
+	// call handler directly
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/updates", nil)
+	err := getUpdates(recorder, req, mockOpenWebUIClient, mockAutomaticSDClient, mockWeatherClient, mockNewsClient, mockCalendarClient, testScheduleSettings(), &mockEmailClient{}, nil, seen)
+	require.NoError(t, err)
+
+	var response []PromptResult
+	err = json.NewDecoder(recorder.Body).Decode(&response)
+	require.NoError(t, err)
+	require.Len(t, response, 6)
+
+	// news says nothing is new, without re-summarizing or generating an image
+	require.Equal(t, "news", response[1].Key)
+	require.False(t, response[1].New)
+	require.Empty(t, response[1].Items)
+	require.Empty(t, response[1].ImageURL)
+	require.Contains(t, mockOpenWebUIClient.generateArgs, "You are a news assistant. There is no new news since Monday 9:30 AM. Write a very short comment saying there is nothing new.")
+	require.Equal(t, 0, mockAutomaticSDClient.txt2imgCalls)
+
+	// calendar events reuse their summaries
+	require.Equal(t, "Old calendar summary.", response[2].Response)
+	require.False(t, response[2].New)
+
+	// only weather, the news comment and the schedule overview were generated
+	require.Equal(t, 3, mockOpenWebUIClient.generateCalls)
+	require.Equal(t, 1, seen.saveCalls)
+}
+
+func TestGetUpdatesArticleSummaryFails(t *testing.T) {
+	articles := []newsResult{
+		{Title: "Good story", Description: "Good description", URL: "https://test.com/good"},
+		{Title: "Broken story", Description: "Broken description", URL: "https://test.com/broken"},
+	}
+	mockOpenWebUIClient := &mockOpenWebUIClient{generateFailsOn: "The article is below:\nBroken story"}
+	seen := newMockSeenStore()
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/updates", nil)
+	err := getUpdates(recorder, req, mockOpenWebUIClient, &mockAutomaticSDClient{}, &mockWeatherClient{getReturns: &weatherResult{Temp: 20.0, Weather: "Clear"}}, &mockNewsClient{getReturns: articles}, &mockCalendarClient{}, testScheduleSettings(), &mockEmailClient{}, nil, seen)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, recorder.Code)
+
+	// the story that couldn't be summarized keeps its description, and isn't marked as seen
+	var response []PromptResult
+	err = json.NewDecoder(recorder.Body).Decode(&response)
+	require.NoError(t, err)
+	require.Equal(t, "news", response[1].Key)
+	require.Equal(t, []PromptItem{
+		{Title: "Good story", Summary: "The latest news is that the weather is clear and sunny.", URL: "https://test.com/good"},
+		{Title: "Broken story", Summary: "Broken description", URL: "https://test.com/broken"},
+	}, response[1].Items)
+	_, ok := seen.lookup(newsSeenKey(articles[1]))
+	require.False(t, ok)
+}
+
+func TestGetUpdatesGenerateFails(t *testing.T) {
+	mockOpenWebUIClient := &mockOpenWebUIClient{generateFailsOn: "weather assistant"}
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/updates", nil)
+	err := getUpdates(recorder, req, mockOpenWebUIClient, &mockAutomaticSDClient{}, &mockWeatherClient{getReturns: &weatherResult{Temp: 20.0, Weather: "Clear"}}, &mockNewsClient{}, &mockCalendarClient{}, testScheduleSettings(), &mockEmailClient{}, nil, newMockSeenStore())
+	require.ErrorContains(t, err, "cannot generate weather")
+	// nothing was written, so the caller can answer with the error
+	require.Empty(t, recorder.Body.String())
+}
+
+func TestGetUpdatesScheduleConflicts(t *testing.T) {
+	// set up test environment, two meetings overlap
+	start := time.Now().Add(time.Hour)
+	mockCalendarClient := &mockCalendarClient{
+		getEventsReturns: []calendarEvent{
+			{Title: "Design review", Start: start, End: start.Add(time.Hour)},
+			{Title: "Dentist", Start: start.Add(30 * time.Minute), End: start.Add(90 * time.Minute)},
+		},
+	}
+	mockOpenWebUIClient := &mockOpenWebUIClient{}
+
+	// call handler directly
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/updates", nil)
+	err := getUpdates(recorder, req, mockOpenWebUIClient, &mockAutomaticSDClient{}, &mockWeatherClient{getReturns: &weatherResult{Temp: 20.0, Weather: "Clear"}}, &mockNewsClient{}, mockCalendarClient, testScheduleSettings(), &mockEmailClient{}, nil, newMockSeenStore())
+	require.NoError(t, err)
+
+	var response []PromptResult
+	err = json.NewDecoder(recorder.Body).Decode(&response)
+	require.NoError(t, err)
+
+	require.Len(t, response, 5)
+	require.Equal(t, "schedule_health", response[4].Key)
+	require.Equal(t, priorityHigh, response[4].Priority)
+
+	found := false
+	for _, arg := range mockOpenWebUIClient.generateArgs {
+		if strings.Contains(arg, `"Design review" overlaps with "Dentist"`) {
+			found = true
+		}
+	}
+	require.True(t, found)
+}
+
+func TestCalendarInstruction(t *testing.T) {
+	require.Contains(t, calendarInstruction(calendarEvent{Kind: calendarKindWork}), "work event")
+	require.Contains(t, calendarInstruction(calendarEvent{Kind: calendarKindPersonal}), "personal event")
+	require.Equal(t, "Write a very short comment on the calendar event.", calendarInstruction(calendarEvent{}))
+}
+
+func TestWeatherDetails(t *testing.T) {
+	require.Empty(t, weatherDetails(weatherResult{}))
+
+	// a forecast of exactly 0°C is still a forecast
+	zero := 0.0
+	require.Equal(t, " Today's high is 0.000000°C and the low is 0.000000°C.", weatherDetails(weatherResult{High: &zero, Low: &zero}))
+}
+
+func TestGetUpdatesEmails(t *testing.T) {
+	// set up test environment, one of the emails was summarized before
+	messages := []emailMessage{
+		{UID: 2, Folder: "INBOX", MessageID: "new@test", From: "Sam <sam@example.com>", Subject: "Closing documents", Text: "Please send the documents.", Classification: &emailClassification{Category: emailCategoryNeedsReply, NeedsResponse: true, Confidence: 0.9, Reasoning: "Sam asks for documents."}},
+		{UID: 1, Folder: "INBOX", MessageID: "old@test", From: "Bank <bank@example.com>", Subject: "Statement", Text: "Your statement is ready."},
+	}
+	seen := newMockSeenStore()
+	seen.mark(emailSeenKey(messages[1]), "Your statement is ready.")
+	mockOpenWebUIClient := &mockOpenWebUIClient{}
+
+	// call handler directly
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/updates", nil)
+	err := getUpdates(recorder, req, mockOpenWebUIClient, &mockAutomaticSDClient{}, &mockWeatherClient{getReturns: &weatherResult{Temp: 20.0, Weather: "Clear"}}, &mockNewsClient{}, &mockCalendarClient{}, testScheduleSettings(), &mockEmailClient{getUnreadReturns: messages}, nil, seen)
+	require.NoError(t, err)
+
+	var response []PromptResult
+	err = json.NewDecoder(recorder.Body).Decode(&response)
+	require.NoError(t, err)
+
+	require.Len(t, response, 3)
+	require.Equal(t, "emails", response[2].Key)
+	require.True(t, response[2].New)
+	require.Equal(t, []Email{
+		{Title: "Closing documents", Description: "An email about the weather.", NeedResponse: true, Category: emailCategoryNeedsReply, Confidence: 0.9, Reasoning: "Sam asks for documents."},
+		{Title: "Statement", Description: "Your statement is ready."},
+	}, response[2].Emails)
+	require.Contains(t, mockOpenWebUIClient.generateArgs, "You are an email assistant. The email is below:\nFrom: Sam <sam@example.com>\nSubject: Closing documents\nPlease send the documents.\n \n Summarize the email in one sentence.")
+
+	// the new email is remembered
+	_, ok := seen.lookup(emailSeenKey(messages[0]))
+	require.True(t, ok)
+
+	// the frontend reads needResponse
+	data, err := json.Marshal(response[2].Emails[0])
+	require.NoError(t, err)
+	require.Contains(t, string(data), `"needResponse":true`)
+	require.Contains(t, strings.Join(mockOpenWebUIClient.generateArgs, "\n"), "- Sam <sam@example.com>: Closing documents (needs a response)")
+}
+
+func TestGetUpdatesEmailSummaryFails(t *testing.T) {
+	messages := []emailMessage{
+		{UID: 1, Folder: "INBOX", MessageID: "broken@test", From: "Sam <sam@example.com>", Subject: "Closing documents", Text: "Please send\nthe documents."},
+	}
+	seen := newMockSeenStore()
+	mockOpenWebUIClient := &mockOpenWebUIClient{generateFailsOn: "The email is below:"}
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/updates", nil)
+	err := getUpdates(recorder, req, mockOpenWebUIClient, &mockAutomaticSDClient{}, &mockWeatherClient{getReturns: &weatherResult{Temp: 20.0, Weather: "Clear"}}, &mockNewsClient{}, &mockCalendarClient{}, testScheduleSettings(), &mockEmailClient{getUnreadReturns: messages}, nil, seen)
+	require.NoError(t, err)
+
+	var response []PromptResult
+	err = json.NewDecoder(recorder.Body).Decode(&response)
+	require.NoError(t, err)
+
+	// the email shows the start of its text, and is summarized again next time
+	require.Equal(t, "emails", response[2].Key)
+	require.Equal(t, []Email{{Title: "Closing documents", Description: "Please send the documents."}}, response[2].Emails)
+	_, ok := seen.lookup(emailSeenKey(messages[0]))
+	require.False(t, ok)
+}
+
+func TestGetUpdatesEmailsUnavailable(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/updates", nil)
+	err := getUpdates(recorder, req, &mockOpenWebUIClient{}, &mockAutomaticSDClient{}, &mockWeatherClient{getReturns: &weatherResult{Temp: 20.0, Weather: "Clear"}}, &mockNewsClient{}, &mockCalendarClient{}, testScheduleSettings(), &mockEmailClient{getUnreadErrors: []error{errors.New("mail server is down")}}, nil, newMockSeenStore())
+	require.NoError(t, err)
+
+	// the other cards are still shown, without the email card
+	var response []PromptResult
+	err = json.NewDecoder(recorder.Body).Decode(&response)
+	require.NoError(t, err)
+	require.Len(t, response, 2)
+	require.Equal(t, "weather", response[0].Key)
+	require.Equal(t, "news", response[1].Key)
+}
+
+func TestGetUpdatesEmailThreads(t *testing.T) {
+	messages := []emailMessage{
+		{UID: 3, Folder: "INBOX", MessageID: "reply@test", From: "Sam <sam@example.com>", Subject: "Re: Offsite", Text: "March works, which venue?", InReplyTo: "start@test", References: []string{"start@test"}, Classification: &emailClassification{Category: emailCategoryNeedsReply, NeedsResponse: true}},
+		{UID: 4, Folder: "INBOX", MessageID: "other@test", From: "Alex <alex@example.com>", Subject: "Re: Offsite", Text: "Agreed.", InReplyTo: "start@test", References: []string{"start@test"}},
+	}
+	threader := &mockEmailThreader{threadReturns: []emailMessage{{MessageID: "start@test", From: "Me <me@example.com>", Subject: "Offsite", Text: "Offsite in March?", FromMe: true}}}
+	mockOpenWebUIClient := &mockOpenWebUIClient{generateJSONReturns: `{"summary":"Planning the offsite.","decisions":["March"],"open_questions":["Which venue?"],"waiting_on_us":["Sam"]}`}
+	seen := newMockSeenStore()
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/updates", nil)
+	err := getUpdates(recorder, req, mockOpenWebUIClient, &mockAutomaticSDClient{}, &mockWeatherClient{getReturns: &weatherResult{Temp: 20.0, Weather: "Clear"}}, &mockNewsClient{}, &mockCalendarClient{}, testScheduleSettings(), &mockEmailClient{getUnreadReturns: messages}, threader, seen)
+	require.NoError(t, err)
+
+	var response []PromptResult
+	err = json.NewDecoder(recorder.Body).Decode(&response)
+	require.NoError(t, err)
+	require.Equal(t, "emails", response[2].Key)
+
+	// both replies are summarized together, with the message that started the thread
+	require.Equal(t, []Email{{
+		Title:         "Offsite",
+		Description:   "Planning the offsite.",
+		NeedResponse:  true,
+		Category:      emailCategoryNeedsReply,
+		Messages:      2,
+		Decisions:     []string{"March"},
+		OpenQuestions: []string{"Which venue?"},
+		WaitingOnUs:   []string{"Sam"},
+	}}, response[2].Emails)
+	require.Len(t, mockOpenWebUIClient.generateJSONArgs, 1)
+	require.Contains(t, mockOpenWebUIClient.generateJSONArgs[0], "Offsite in March?")
+
+	// the thread summary is reused until another reply arrives
+	recorder = httptest.NewRecorder()
+	err = getUpdates(recorder, req, mockOpenWebUIClient, &mockAutomaticSDClient{}, &mockWeatherClient{getReturns: &weatherResult{Temp: 20.0, Weather: "Clear"}}, &mockNewsClient{}, &mockCalendarClient{}, testScheduleSettings(), &mockEmailClient{getUnreadReturns: messages}, threader, seen)
+	require.NoError(t, err)
+	require.Len(t, mockOpenWebUIClient.generateJSONArgs, 1)
+	require.Len(t, threader.threadArgs, 1)
+}
+
+func TestSummarizeEmailsThreadContext(t *testing.T) {
+	var messages []emailMessage
+	for i := range 8 {
+		messages = append(messages, emailMessage{UID: uint32(i + 1), Folder: "INBOX", MessageID: fmt.Sprintf("reply%d@test", i), Subject: fmt.Sprintf("Re: Topic %d", i), Text: "Sounds good.", InReplyTo: fmt.Sprintf("start%d@test", i)})
+	}
+	threader := &mockEmailThreader{delay: 20 * time.Millisecond}
+	mockOpenWebUIClient := &mockOpenWebUIClient{generateJSONErrors: slices.Repeat([]error{errors.New("model is down")}, len(messages))}
+
+	emails, isNew, err := summarizeEmails(mockOpenWebUIClient, newMockSeenStore(), threader, messages)
+	require.ErrorContains(t, err, "cannot summarize thread")
+	require.True(t, isNew)
+
+	// only a few threads open a connection at once
+	require.Len(t, threader.threadArgs, len(messages))
+	require.LessOrEqual(t, threader.maxActive, emailThreadConnections)
+	// threads that couldn't be summarized show the start of their latest message
+	require.Len(t, emails, len(messages))
+	require.Equal(t, "Sounds good.", emails[0].Description)
+}
+
+func TestGetUpdatesEmailsWithholdsRisky(t *testing.T) {
+	messages := []emailMessage{
+		{UID: 2, Folder: "INBOX", MessageID: "phish@test", From: "PayPal <service@paypa1.com>", FromAddress: "service@paypa1.com", Subject: "Account locked", Text: "Log in at once.", Risk: &emailRisk{Score: 0.9, Risky: true, Reasons: []string{"the sender domain paypa1.com looks like paypal.com"}}},
+		{UID: 1, Folder: "INBOX", MessageID: "fine@test", From: "Sam <sam@example.com>", Subject: "Dinner", Text: "Friday?", Risk: &emailRisk{Score: 0.1}},
+	}
+	mockOpenWebUIClient := &mockOpenWebUIClient{}
+	seen := newMockSeenStore()
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/updates", nil)
+	err := getUpdates(recorder, req, mockOpenWebUIClient, &mockAutomaticSDClient{}, &mockWeatherClient{getReturns: &weatherResult{Temp: 20.0, Weather: "Clear"}}, &mockNewsClient{}, &mockCalendarClient{}, testScheduleSettings(), &mockEmailClient{getUnreadReturns: messages}, nil, seen)
+	require.NoError(t, err)
+
+	var response []PromptResult
+	err = json.NewDecoder(recorder.Body).Decode(&response)
+	require.NoError(t, err)
+	require.Equal(t, "emails", response[2].Key)
+	require.True(t, response[2].New)
+	require.Len(t, response[2].Emails, 2)
+	require.Equal(t, "Dinner", response[2].Emails[0].Title)
+	require.Equal(t, Email{
+		Title:       "Possible phishing from service@paypa1.com",
+		Description: "The content of this email is withheld because it looks like phishing.",
+		Risky:       true,
+		RiskReasons: []string{"the sender domain paypa1.com looks like paypal.com"},
+	}, response[2].Emails[1])
+
+	// nothing from the risky email reaches the model or the display
+	prompts := strings.Join(mockOpenWebUIClient.generateArgs, "\n")
+	require.NotContains(t, prompts, "Account locked")
+	require.NotContains(t, prompts, "Log in at once.")
+	require.Contains(t, prompts, "- possible phishing from service@paypa1.com (content withheld)")
+	require.NotContains(t, recorder.Body.String(), "Account locked")
+
+	// the withheld email is remembered apart from summarized ones
+	require.Contains(t, seen.items, "email-risk:phish@test")
+	require.NotContains(t, seen.items, "email:phish@test")
 }
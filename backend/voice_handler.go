@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// maxVoiceUploadSize is the largest recording accepted, the limit of the OpenAI API
+const maxVoiceUploadSize = 25 << 20
+
+// voiceAudioTypes maps the content types of the supported recordings to a file extension,
+// browsers record WebM or Ogg and other clients usually WAV
+var voiceAudioTypes = map[string]string{
+	"audio/wav":       ".wav",
+	"audio/x-wav":     ".wav",
+	"audio/wave":      ".wav",
+	"audio/vnd.wave":  ".wav",
+	"audio/webm":      ".webm",
+	"video/webm":      ".webm",
+	"audio/ogg":       ".ogg",
+	"audio/opus":      ".ogg",
+	"application/ogg": ".ogg",
+}
+
+// postVoiceTranscribe transcribes a recording, uploaded as the "file" field of a multipart
+// form or as the request body, into text that can be sent on as a chat message
+func postVoiceTranscribe(w http.ResponseWriter, r *http.Request, whisper whisperClient) error {
+	if whisper == nil {
+		writeHttpError(w, http.StatusNotImplemented, "speech-to-text is not configured", fmt.Errorf("WHISPER_BASE_URL env var is not set"))
+		return nil
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxVoiceUploadSize)
+
+	audio, filename, contentType, err := voiceUpload(r)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeHttpError(w, http.StatusRequestEntityTooLarge, "recording is too large", err)
+		return nil
+	}
+	if err != nil {
+		writeHttpError(w, http.StatusBadRequest, "cannot read recording", err)
+		return nil
+	}
+	defer audio.Close()
+
+	extension, audioType, ok := voiceAudioType(contentType, filename)
+	if !ok {
+		writeHttpError(w, http.StatusUnsupportedMediaType, "recording must be WAV, WebM or Ogg", fmt.Errorf("unsupported content type %q", contentType))
+		return nil
+	}
+	if filepath.Ext(filename) != extension {
+		filename = "recording" + extension
+	}
+
+	result, err := whisper.transcribe(audio, filename, audioType)
+	if errors.As(err, &tooLarge) {
+		writeHttpError(w, http.StatusRequestEntityTooLarge, "recording is too large", err)
+		return nil
+	}
+	if err != nil {
+		writeHttpError(w, http.StatusBadGateway, "cannot transcribe recording", err)
+		return nil
+	}
+
+	resultJson, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("cannot marshal transcription to json: %w", err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(resultJson)
+
+	return nil
+}
+
+// voiceUpload returns the recording of a request with its file name and content type
+func voiceUpload(r *http.Request) (io.ReadCloser, string, string, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		return r.Body, "", r.Header.Get("Content-Type"), nil
+	}
+
+	// the form is streamed rather than parsed, so the recording isn't buffered twice
+	form, err := r.MultipartReader()
+	if err != nil {
+		return nil, "", "", fmt.Errorf("cannot read form: %w", err)
+	}
+	for {
+		part, err := form.NextPart()
+		if err == io.EOF {
+			return nil, "", "", fmt.Errorf("file is missing")
+		}
+		if err != nil {
+			return nil, "", "", fmt.Errorf("cannot read form: %w", err)
+		}
+		if part.FormName() == "file" {
+			return part, part.FileName(), part.Header.Get("Content-Type"), nil
+		}
+	}
+}
+
+// voiceAudioType returns the file extension and content type of a supported recording, from
+// its content type or, when that is generic, from its file name
+func voiceAudioType(contentType, filename string) (string, string, bool) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if extension, ok := voiceAudioTypes[mediaType]; ok {
+		return extension, contentType, true
+	}
+	if mediaType == "" || mediaType == "application/octet-stream" {
+		switch extension := strings.ToLower(filepath.Ext(filename)); extension {
+		case ".wav":
+			return extension, "audio/wav", true
+		case ".webm":
+			return extension, "audio/webm", true
+		case ".ogg", ".oga", ".opus":
+			return extension, "audio/ogg", true
+		}
+	}
+	return "", "", false
+}
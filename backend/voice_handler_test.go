@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type mockWhisperClient struct {
+	transcribeArgs   []string
+	transcribeAudio  []string
+	transcribeErrors []error
+}
+
+func (m *mockWhisperClient) transcribe(audio io.Reader, filename, contentType string) (transcription, error) {
+	data, err := io.ReadAll(audio)
+	if err != nil {
+		return transcription{}, err
+	}
+	m.transcribeArgs = append(m.transcribeArgs, filename+" "+contentType)
+	m.transcribeAudio = append(m.transcribeAudio, string(data))
+	if len(m.transcribeErrors) > 0 {
+		return transcription{}, m.transcribeErrors[len(m.transcribeArgs)-1]
+	}
+	return transcription{Text: "Hello", Language: "english", Segments: []transcriptionSegment{{End: 1, Text: "Hello"}}}, nil
+}
+
+func newTestVoiceUpload(t *testing.T, filename, contentType, audio string) *http.Request {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	err := form.WriteField("note", "ignored")
+	require.NoError(t, err)
+	part, err := form.CreatePart(map[string][]string{
+		"Content-Disposition": {`form-data; name="file"; filename="` + filename + `"`},
+		"Content-Type":        {contentType},
+	})
+	require.NoError(t, err)
+	part.Write([]byte(audio))
+	require.NoError(t, form.Close())
+
+	req := httptest.NewRequest("POST", "/voice/transcribe", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	return req
+}
+
+func TestPostVoiceTranscribe_Multipart(t *testing.T) {
+	whisper := &mockWhisperClient{}
+
+	recorder := httptest.NewRecorder()
+	err := postVoiceTranscribe(recorder, newTestVoiceUpload(t, "voice.webm", "audio/webm;codecs=opus", "test-audio"), whisper)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, recorder.Code)
+
+	var result transcription
+	err = json.NewDecoder(recorder.Body).Decode(&result)
+	require.NoError(t, err)
+	require.Equal(t, "Hello", result.Text)
+	require.Equal(t, "english", result.Language)
+	require.Len(t, result.Segments, 1)
+	require.Equal(t, []string{"voice.webm audio/webm;codecs=opus"}, whisper.transcribeArgs)
+	require.Equal(t, []string{"test-audio"}, whisper.transcribeAudio)
+
+	// a generic content type is read from the file name
+	recorder = httptest.NewRecorder()
+	err = postVoiceTranscribe(recorder, newTestVoiceUpload(t, "voice.ogg", "application/octet-stream", "test-audio"), whisper)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, recorder.Code)
+	require.Equal(t, "voice.ogg audio/ogg", whisper.transcribeArgs[1])
+}
+
+func TestPostVoiceTranscribe_RawBody(t *testing.T) {
+	whisper := &mockWhisperClient{}
+
+	req := httptest.NewRequest("POST", "/voice/transcribe", strings.NewReader("test-audio"))
+	req.Header.Set("Content-Type", "audio/wav")
+	recorder := httptest.NewRecorder()
+	err := postVoiceTranscribe(recorder, req, whisper)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, recorder.Code)
+	// the server is given a file name that matches the audio
+	require.Equal(t, []string{"recording.wav audio/wav"}, whisper.transcribeArgs)
+}
+
+func TestPostVoiceTranscribe_Errors(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	err := postVoiceTranscribe(recorder, newTestVoiceUpload(t, "voice.webm", "audio/webm", "test-audio"), nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNotImplemented, recorder.Code)
+
+	whisper := &mockWhisperClient{}
+	recorder = httptest.NewRecorder()
+	err = postVoiceTranscribe(recorder, newTestVoiceUpload(t, "voice.mp3", "audio/mpeg", "test-audio"), whisper)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusUnsupportedMediaType, recorder.Code)
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("note", "no file")
+	form.Close()
+	req := httptest.NewRequest("POST", "/voice/transcribe", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	recorder = httptest.NewRecorder()
+	err = postVoiceTranscribe(recorder, req, whisper)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, recorder.Code)
+
+	req = httptest.NewRequest("POST", "/voice/transcribe", bytes.NewReader(make([]byte, maxVoiceUploadSize+1)))
+	req.Header.Set("Content-Type", "audio/wav")
+	recorder = httptest.NewRecorder()
+	err = postVoiceTranscribe(recorder, req, &mockWhisperClient{transcribeErrors: []error{nil}})
+	require.NoError(t, err)
+	require.Equal(t, http.StatusRequestEntityTooLarge, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	err = postVoiceTranscribe(recorder, newTestVoiceUpload(t, "voice.webm", "audio/webm", "test-audio"), &mockWhisperClient{transcribeErrors: []error{errors.New("whisper is down")}})
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadGateway, recorder.Code)
+}
@@ -1,49 +1,105 @@
 package main
 
 import (
+	"crypto/sha1"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
-	"net/http"
 	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 )
 
 type weatherClient interface {
 	get() (*weatherResult, error)
+	getAll() ([]weatherResult, error)
 }
 
 type weather struct {
 	apiKey    string
-	latitude  string
-	longitude string
+	locations []weatherLocation
 	timezone  string
 	baseURL   string
 
-	value       weatherResult
-	lastUpdated time.Time
+	cache      *ttlCache[weatherResult]
+	thresholds weatherThresholds
+
+	// alerts from the last refresh, per location
+	alertsMu sync.Mutex
+	alerts   map[string]map[string]weatherAlert
+}
+
+// weatherLocation is a named place to fetch weather for, e.g. home or office
+type weatherLocation struct {
+	name      string
+	latitude  string
+	longitude string
 }
 
 type weatherResult struct {
-	Temp    float64 `json:"temp"`
-	Weather string  `json:"weather"`
+	Location string         `json:"location,omitempty"`
+	Temp     float64        `json:"temp"`
+	Weather  string         `json:"weather"`
+	High     *float64       `json:"high,omitempty"` // nil when the provider has no daily forecast
+	Low      *float64       `json:"low,omitempty"`
+	Hourly   []weatherHour  `json:"hourly,omitempty"`
+	Alerts   []weatherAlert `json:"alerts,omitempty"`
+
+	UVIndex    float64     `json:"uv_index"`
+	AirQuality *airQuality `json:"air_quality,omitempty"`
+	// Notices are the readings that crossed the configured thresholds
+	Notices []string `json:"notices,omitempty"`
+}
+
+// weatherHour is a single hour of forecast
+type weatherHour struct {
+	Time    time.Time `json:"time"`
+	Temp    float64   `json:"temp"`
+	Weather string    `json:"weather"`
+}
+
+// weatherAlert is a government weather alert, deduplicated by ID
+type weatherAlert struct {
+	ID          string    `json:"id"`
+	Event       string    `json:"event"`
+	Sender      string    `json:"sender"`
+	Severity    string    `json:"severity"`
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	Description string    `json:"description"`
 }
 
 const (
 	weatherCacheDuration = 10 * time.Minute
+	weatherCacheMaxStale = time.Hour
+	defaultLocationName  = "home"
 )
 
+// newWeatherClient creates the weather client selected by WEATHER_PROVIDER,
+// either "openweather" (the default) or "openmeteo", which needs no API key
 func newWeatherClient() (weatherClient, error) {
-	if os.Getenv("OPENWEATHER_API_KEY") == "" {
-		return nil, fmt.Errorf("OPENWEATHER_API_KEY is not set")
+	switch os.Getenv("WEATHER_PROVIDER") {
+	case "", "openweather":
+		return newOpenWeatherClient()
+	case "openmeteo":
+		return newOpenMeteoClient()
+	default:
+		return nil, fmt.Errorf("unknown WEATHER_PROVIDER %q", os.Getenv("WEATHER_PROVIDER"))
 	}
+}
 
-	if os.Getenv("OPENWEATHER_LATITUDE") == "" {
-		return nil, fmt.Errorf("OPENWEATHER_LATITUDE is not set")
+func newOpenWeatherClient() (weatherClient, error) {
+	if os.Getenv("OPENWEATHER_API_KEY") == "" {
+		return nil, fmt.Errorf("OPENWEATHER_API_KEY is not set")
 	}
 
-	if os.Getenv("OPENWEATHER_LONGITUDE") == "" {
-		return nil, fmt.Errorf("OPENWEATHER_LONGITUDE is not set")
+	locations, err := parseWeatherLocations("OPENWEATHER")
+	if err != nil {
+		return nil, err
 	}
 
 	if os.Getenv("OPENWEATHER_TIMEZONE") == "" {
@@ -54,29 +110,112 @@ func newWeatherClient() (weatherClient, error) {
 		return nil, fmt.Errorf("OPENWEATHER_BASE_URL is not set")
 	}
 
+	cache, err := newSourceCache[weatherResult]("WEATHER", weatherCacheDuration, weatherCacheMaxStale)
+	if err != nil {
+		return nil, err
+	}
+
+	thresholds, err := newWeatherThresholds()
+	if err != nil {
+		return nil, err
+	}
+
 	return &weather{
-		apiKey:    os.Getenv("OPENWEATHER_API_KEY"),
-		latitude:  os.Getenv("OPENWEATHER_LATITUDE"),
-		longitude: os.Getenv("OPENWEATHER_LONGITUDE"),
-		timezone:  os.Getenv("OPENWEATHER_TIMEZONE"),
-		baseURL:   os.Getenv("OPENWEATHER_BASE_URL"),
+		apiKey:     os.Getenv("OPENWEATHER_API_KEY"),
+		locations:  locations,
+		timezone:   os.Getenv("OPENWEATHER_TIMEZONE"),
+		baseURL:    os.Getenv("OPENWEATHER_BASE_URL"),
+		cache:      cache,
+		thresholds: thresholds,
+		alerts:     map[string]map[string]weatherAlert{},
 	}, nil
 }
 
+// parseWeatherLocations reads <prefix>_LOCATIONS as a list of "name=lat,lon" entries
+// separated by semicolons, falling back to a single "home" location from
+// <prefix>_LATITUDE and <prefix>_LONGITUDE. The first location is the primary one, and names
+// must be unique as locations are cached by name.
+func parseWeatherLocations(prefix string) ([]weatherLocation, error) {
+	if value := os.Getenv(prefix + "_LOCATIONS"); value != "" {
+		var locations []weatherLocation
+		for _, entry := range strings.Split(value, ";") {
+			entry = strings.TrimSpace(entry)
+			if entry == "" {
+				continue
+			}
+			name, coordinates, ok := strings.Cut(entry, "=")
+			if !ok {
+				return nil, fmt.Errorf("%s_LOCATIONS entry %q is not in name=lat,lon format", prefix, entry)
+			}
+			latitude, longitude, ok := strings.Cut(coordinates, ",")
+			if !ok {
+				return nil, fmt.Errorf("%s_LOCATIONS entry %q is not in name=lat,lon format", prefix, entry)
+			}
+			name = strings.TrimSpace(name)
+			if slices.ContainsFunc(locations, func(location weatherLocation) bool { return location.name == name }) {
+				return nil, fmt.Errorf("%s_LOCATIONS has more than one location named %q", prefix, name)
+			}
+			locations = append(locations, weatherLocation{
+				name:      name,
+				latitude:  strings.TrimSpace(latitude),
+				longitude: strings.TrimSpace(longitude),
+			})
+		}
+		if len(locations) == 0 {
+			return nil, fmt.Errorf("%s_LOCATIONS has no locations", prefix)
+		}
+		return locations, nil
+	}
+
+	if os.Getenv(prefix+"_LATITUDE") == "" {
+		return nil, fmt.Errorf("%s_LATITUDE is not set", prefix)
+	}
+
+	if os.Getenv(prefix+"_LONGITUDE") == "" {
+		return nil, fmt.Errorf("%s_LONGITUDE is not set", prefix)
+	}
+
+	return []weatherLocation{{
+		name:      defaultLocationName,
+		latitude:  os.Getenv(prefix + "_LATITUDE"),
+		longitude: os.Getenv(prefix + "_LONGITUDE"),
+	}}, nil
+}
+
+// get returns the weather at the primary location
 func (w *weather) get() (*weatherResult, error) {
-	// if cache is fresh, return cached value
-	if time.Since(w.lastUpdated) < weatherCacheDuration {
-		return &weatherResult{
-			Temp:    w.value.Temp,
-			Weather: w.value.Weather,
-		}, nil
+	return w.getLocation(w.locations[0])
+}
+
+// getAll returns the weather at every configured location, primary first
+func (w *weather) getAll() ([]weatherResult, error) {
+	results := make([]weatherResult, 0, len(w.locations))
+	for _, location := range w.locations {
+		result, err := w.getLocation(location)
+		if err != nil {
+			return nil, fmt.Errorf("cannot get weather for %s: %w", location.name, err)
+		}
+		results = append(results, *result)
+	}
+	return results, nil
+}
+
+func (w *weather) getLocation(location weatherLocation) (*weatherResult, error) {
+	result, err := w.cache.get(location.name, func() (weatherResult, error) {
+		return w.fetch(location)
+	})
+	if err != nil {
+		return nil, err
 	}
+	return &result, nil
+}
 
-	// get weather data from openweathermap API
-	url := fmt.Sprintf("%s/data/2.5/weather?lat=%s&lon=%s&appid=%s", w.baseURL, w.latitude, w.longitude, w.apiKey)
-	resp, err := http.Get(url)
+func (w *weather) fetch(location weatherLocation) (weatherResult, error) {
+	// get the current weather and alerts from the openweathermap One Call API
+	url := fmt.Sprintf("%s/data/3.0/onecall?lat=%s&lon=%s&exclude=minutely,hourly,daily&appid=%s", w.baseURL, location.latitude, location.longitude, w.apiKey)
+	resp, err := sourceHTTPClient.Get(url)
 	if err != nil {
-		return nil, fmt.Errorf("cannot get weather: %w", err)
+		return weatherResult{}, fmt.Errorf("cannot get weather: %w", err)
 	}
 
 	defer resp.Body.Close()
@@ -84,29 +223,150 @@ func (w *weather) get() (*weatherResult, error) {
 	// read response body
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("cannot read weather: %w", err)
+		return weatherResult{}, fmt.Errorf("cannot read weather: %w", err)
 	}
 
 	// parse weather data
 	var weatherData struct {
 		Current struct {
 			Temperature float64 `json:"temp"`
+			UVI         float64 `json:"uvi"`
 			Weather     []struct {
 				Main string `json:"main"`
 			} `json:"weather"`
 		} `json:"current"`
+		Alerts []struct {
+			SenderName  string   `json:"sender_name"`
+			Event       string   `json:"event"`
+			Start       int64    `json:"start"`
+			End         int64    `json:"end"`
+			Description string   `json:"description"`
+			Tags        []string `json:"tags"`
+		} `json:"alerts"`
 	}
 
 	err = json.Unmarshal(body, &weatherData)
 	if err != nil {
-		return nil, fmt.Errorf("cannot parse weather: %w", err)
+		return weatherResult{}, fmt.Errorf("cannot parse weather: %w", err)
+	}
+
+	alerts := map[string]weatherAlert{}
+	for _, a := range weatherData.Alerts {
+		alert := weatherAlert{
+			Event:       a.Event,
+			Sender:      a.SenderName,
+			Severity:    alertSeverity(a.Event, a.Tags),
+			Start:       time.Unix(a.Start, 0).UTC(),
+			End:         time.Unix(a.End, 0).UTC(),
+			Description: a.Description,
+		}
+		alert.ID = alertID(alert)
+		alerts[alert.ID] = alert
 	}
+	active := activeAlerts(alerts, time.Now())
+
+	// the alerts in the response replace the last ones, so alerts that were cancelled go away
+	w.alertsMu.Lock()
+	w.alerts[location.name] = alerts
+	w.alertsMu.Unlock()
+
 	weather := weatherResult{
-		Temp:    weatherData.Current.Temperature,
-		Weather: weatherData.Current.Weather[0].Main,
+		Location: location.name,
+		Temp:     weatherData.Current.Temperature,
+		Weather:  weatherData.Current.Weather[0].Main,
+		Alerts:   active,
+		UVIndex:  weatherData.Current.UVI,
+	}
+
+	// air quality is supplementary, so the briefing goes on without it
+	weather.AirQuality, err = w.fetchAirQuality(location)
+	if err != nil {
+		fmt.Println(fmt.Errorf("cannot get air quality for %s: %w", location.name, err))
+	}
+	weather.Notices = weatherNotices(weather, w.thresholds)
+
+	return weather, nil
+}
+
+// fetchAirQuality gets the air quality index and pollutant components from the air pollution API
+func (w *weather) fetchAirQuality(location weatherLocation) (*airQuality, error) {
+	url := fmt.Sprintf("%s/data/2.5/air_pollution?lat=%s&lon=%s&appid=%s", w.baseURL, location.latitude, location.longitude, w.apiKey)
+	resp, err := sourceHTTPClient.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get air quality: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	// read response body
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read air quality: %w", err)
+	}
+
+	// parse air quality data
+	var airQualityData struct {
+		List []struct {
+			Main struct {
+				AQI int `json:"aqi"`
+			} `json:"main"`
+			Components map[string]float64 `json:"components"`
+		} `json:"list"`
+	}
+
+	err = json.Unmarshal(body, &airQualityData)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse air quality: %w", err)
+	}
+	if len(airQualityData.List) == 0 {
+		return nil, nil
+	}
+
+	return &airQuality{
+		AQI:        airQualityData.List[0].Main.AQI,
+		Components: airQualityData.List[0].Components,
+	}, nil
+}
+
+// alertID derives a stable ID for an alert, since the One Call API doesn't provide one
+func alertID(a weatherAlert) string {
+	sum := sha1.Sum([]byte(a.Sender + "|" + a.Event + "|" + a.Start.Format(time.RFC3339)))
+	return hex.EncodeToString(sum[:8])
+}
+
+// alertSeverity maps an alert's event name and tags to a severity level
+func alertSeverity(event string, tags []string) string {
+	for _, tag := range tags {
+		if strings.HasPrefix(strings.ToLower(tag), "extreme") {
+			return "extreme"
+		}
+	}
+
+	event = strings.ToLower(event)
+	switch {
+	case strings.Contains(event, "warning"), strings.Contains(event, "emergency"):
+		return "severe"
+	case strings.Contains(event, "watch"):
+		return "moderate"
+	case strings.Contains(event, "advisory"), strings.Contains(event, "statement"):
+		return "minor"
+	}
+	return "unknown"
+}
+
+// activeAlerts drops expired alerts from the set and returns the rest ordered by start time
+func activeAlerts(alerts map[string]weatherAlert, now time.Time) []weatherAlert {
+	var active []weatherAlert
+	for id, alert := range alerts {
+		if !alert.End.IsZero() && alert.End.Before(now) {
+			delete(alerts, id)
+			continue
+		}
+		active = append(active, alert)
 	}
 
-	w.value = weather
-	w.lastUpdated = time.Now()
-	return &weather, nil
+	sort.Slice(active, func(i, j int) bool {
+		return active[i].Start.Before(active[j].Start)
+	})
+	return active
 }
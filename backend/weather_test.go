@@ -1,25 +1,83 @@
 package main
 
 import (
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
 
 const weatherSuccessResponse = `{
+	"lat": 40.7128,
+	"lon": -74.006,
+	"timezone": "America/New_York",
+	"timezone_offset": -14400,
 	"current": {
+		"dt": 1718550000,
+		"sunrise": 1718529360,
+		"sunset": 1718583900,
 		"temp": 20,
+		"feels_like": 19.6,
+		"pressure": 1015,
+		"humidity": 64,
+		"dew_point": 12.9,
+		"uvi": 3.2,
+		"clouds": 0,
+		"visibility": 10000,
+		"wind_speed": 3.6,
+		"wind_deg": 220,
 		"weather": [
 			{
-				"main": "Clear"
+				"id": 800,
+				"main": "Clear",
+				"description": "clear sky",
+				"icon": "01d"
 			}
 		]
 	}
 }`
 
+// oneCallResponse is a One Call response with the given current weather and alerts
+func oneCallResponse(temp float64, uvi float64, main string, alerts string) string {
+	return fmt.Sprintf(`{
+		"lat": 40.7128,
+		"lon": -74.006,
+		"timezone": "America/New_York",
+		"timezone_offset": -14400,
+		"current": {
+			"dt": 1718550000,
+			"sunrise": 1718529360,
+			"sunset": 1718583900,
+			"temp": %g,
+			"feels_like": %g,
+			"pressure": 1012,
+			"humidity": 58,
+			"dew_point": 14.1,
+			"uvi": %g,
+			"clouds": 20,
+			"visibility": 10000,
+			"wind_speed": 4.1,
+			"wind_deg": 200,
+			"weather": [{"id": 800, "main": %q, "description": "", "icon": "01d"}]
+		}%s
+	}`, temp, temp, uvi, main, alerts)
+}
+
+// serveOneCall answers One Call requests with response, and anything else with 404
+func serveOneCall(w http.ResponseWriter, r *http.Request, response string) {
+	if r.URL.Path != "/data/3.0/onecall" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(response))
+}
+
 func setupWeatherClientEnvVars(serverURL string) {
 	os.Setenv("OPENWEATHER_API_KEY", "test")
 	os.Setenv("OPENWEATHER_LATITUDE", "40.7128")
@@ -28,10 +86,61 @@ func setupWeatherClientEnvVars(serverURL string) {
 	os.Setenv("OPENWEATHER_BASE_URL", serverURL)
 }
 
+func setupWeatherServerByLocation() *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		temp := 20.0
+		if r.URL.Query().Get("lat") == "40.7580" {
+			temp = 15
+		}
+		serveOneCall(w, r, oneCallResponse(temp, 3, "Clear", ""))
+	}))
+
+	setupWeatherClientEnvVars(server.URL)
+
+	return server
+}
+
 func setupWeatherServer() *httptest.Server {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte(weatherSuccessResponse))
+		serveOneCall(w, r, weatherSuccessResponse)
+	}))
+
+	setupWeatherClientEnvVars(server.URL)
+
+	return server
+}
+
+func setupWeatherServerWithAlerts() *httptest.Server {
+	start := time.Now().Add(-time.Hour).Unix()
+	end := time.Now().Add(time.Hour).Unix()
+	alerts := fmt.Sprintf(`,
+		"alerts": [
+			{"sender_name": "NWS Upton NY", "event": "Severe Thunderstorm Warning", "start": %d, "end": %d, "description": "Large hail expected.", "tags": ["Thunderstorm"]},
+			{"sender_name": "NWS Upton NY", "event": "Heat Advisory", "start": %d, "end": %d, "description": "Hot.", "tags": ["Extreme temperature value"]}
+		]`, start, end, start-60, start)
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the alerts are cancelled after two refreshes
+		if r.URL.Path == "/data/3.0/onecall" && requests.Add(1) > 2 {
+			serveOneCall(w, r, oneCallResponse(30, 5, "Thunderstorm", ""))
+			return
+		}
+		serveOneCall(w, r, oneCallResponse(30, 5, "Thunderstorm", alerts))
+	}))
+
+	setupWeatherClientEnvVars(server.URL)
+
+	return server
+}
+
+func setupWeatherServerWithAirQuality() *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/data/2.5/air_pollution" {
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(`{"coord": {"lon": -74.006, "lat": 40.7128}, "list": [{"main": {"aqi": 4}, "components": {"co": 230.31, "no": 0.2, "no2": 9.1, "o3": 120, "so2": 1.3, "pm2_5": 40.5, "pm10": 45.2, "nh3": 0.5}, "dt": 1718550000}]}`))
+			return
+		}
+		serveOneCall(w, r, oneCallResponse(25, 8.1, "Clear", ""))
 	}))
 
 	setupWeatherClientEnvVars(server.URL)
@@ -73,3 +182,85 @@ func TestWeatherClient_GetInternalError(t *testing.T) {
 	require.Error(t, err)
 	require.Nil(t, result)
 }
+
+func TestWeatherClient_GetAlertsDeduplicated(t *testing.T) {
+	server := setupWeatherServerWithAlerts()
+	defer server.Close()
+
+	client, err := newWeatherClient()
+	require.NoError(t, err)
+
+	result, err := client.get()
+	require.NoError(t, err)
+
+	// expire the cache and refresh, the same alert should not be duplicated
+	client.(*weather).cache.entries[defaultLocationName].fetchedAt = time.Time{}
+	result, err = client.get()
+	require.NoError(t, err)
+
+	// the expired advisory is dropped
+	require.Len(t, result.Alerts, 1)
+	require.Equal(t, "Severe Thunderstorm Warning", result.Alerts[0].Event)
+	require.Equal(t, "severe", result.Alerts[0].Severity)
+	require.NotEmpty(t, result.Alerts[0].ID)
+
+	// once the provider cancels the warning it goes away before it would have ended
+	client.(*weather).cache.entries[defaultLocationName].fetchedAt = time.Time{}
+	result, err = client.get()
+	require.NoError(t, err)
+	require.Empty(t, result.Alerts)
+}
+
+func TestWeatherClient_GetAllLocations(t *testing.T) {
+	server := setupWeatherServerByLocation()
+	defer server.Close()
+	os.Setenv("OPENWEATHER_LOCATIONS", "home=40.7128,-74.0060; office=40.7580,-73.9855")
+	defer os.Unsetenv("OPENWEATHER_LOCATIONS")
+
+	client, err := newWeatherClient()
+	require.NoError(t, err)
+
+	results, err := client.getAll()
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	require.Equal(t, "home", results[0].Location)
+	require.Equal(t, 20.0, results[0].Temp)
+	require.Equal(t, "office", results[1].Location)
+	require.Equal(t, 15.0, results[1].Temp)
+
+	// each location has its own cache entry
+	require.Len(t, client.(*weather).cache.entries, 2)
+}
+
+func TestParseWeatherLocations_Malformed(t *testing.T) {
+	os.Setenv("OPENWEATHER_LOCATIONS", "home=40.7128")
+	defer os.Unsetenv("OPENWEATHER_LOCATIONS")
+
+	locations, err := parseWeatherLocations("OPENWEATHER")
+	require.Error(t, err)
+	require.Nil(t, locations)
+}
+
+func TestParseWeatherLocations_DuplicateNames(t *testing.T) {
+	os.Setenv("OPENWEATHER_LOCATIONS", "home=40.7128,-74.0060; home =40.7580,-73.9855")
+	defer os.Unsetenv("OPENWEATHER_LOCATIONS")
+
+	locations, err := parseWeatherLocations("OPENWEATHER")
+	require.ErrorContains(t, err, `more than one location named "home"`)
+	require.Nil(t, locations)
+}
+
+func TestWeatherClient_GetAirQuality(t *testing.T) {
+	server := setupWeatherServerWithAirQuality()
+	defer server.Close()
+
+	client, err := newWeatherClient()
+	require.NoError(t, err)
+
+	result, err := client.get()
+	require.NoError(t, err)
+	require.Equal(t, 8.1, result.UVIndex)
+	require.Equal(t, 4, result.AirQuality.AQI)
+	require.Equal(t, 40.5, result.AirQuality.Components["pm2_5"])
+	require.Equal(t, []string{"the air quality index is 4 of 5 (poor)", "the UV index is 8.1"}, result.Notices)
+}
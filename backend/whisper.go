@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+	"strings"
+	"time"
+)
+
+type whisperClient interface {
+	// transcribe turns the speech in an audio file into text
+	transcribe(audio io.Reader, filename, contentType string) (transcription, error)
+}
+
+// whisper is a Whisper-compatible speech-to-text server, with the OpenAI /v1/audio/transcriptions API
+type whisper struct {
+	baseUrl   string
+	apiKey    string
+	modelName string
+	// language is the expected language of the speech, the server detects it when empty
+	language string
+	client   *http.Client
+}
+
+// transcription is the text of a recording, with when each part of it was said
+type transcription struct {
+	Text     string                 `json:"text"`
+	Language string                 `json:"language,omitempty"`
+	Duration float64                `json:"duration,omitempty"`
+	Segments []transcriptionSegment `json:"segments"`
+}
+
+// transcriptionSegment is a part of a recording, with its start and end in seconds
+type transcriptionSegment struct {
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+	Text  string  `json:"text"`
+}
+
+const (
+	defaultWhisperModelName = "whisper-1"
+	defaultWhisperTimeout   = 2 * time.Minute
+)
+
+// newWhisperClient returns nil when WHISPER_BASE_URL isn't set, voice input is optional.
+// WHISPER_API_KEY is only needed by servers that check it.
+func newWhisperClient() (whisperClient, error) {
+	if os.Getenv("WHISPER_BASE_URL") == "" {
+		return nil, nil
+	}
+	timeout, err := envDuration("WHISPER_TIMEOUT", defaultWhisperTimeout)
+	if err != nil {
+		return nil, err
+	}
+	modelName := os.Getenv("WHISPER_MODEL_NAME")
+	if modelName == "" {
+		modelName = defaultWhisperModelName
+	}
+	return &whisper{
+		baseUrl:   strings.TrimSuffix(os.Getenv("WHISPER_BASE_URL"), "/"),
+		apiKey:    os.Getenv("WHISPER_API_KEY"),
+		modelName: modelName,
+		language:  os.Getenv("WHISPER_LANGUAGE"),
+		client:    &http.Client{Timeout: timeout},
+	}, nil
+}
+
+func (c *whisper) transcribe(audio io.Reader, filename, contentType string) (transcription, error) {
+	// multipart payload for /v1/audio/transcriptions, verbose_json has the segments and language
+	var payload bytes.Buffer
+	form := multipart.NewWriter(&payload)
+	fields := [][2]string{{"model", c.modelName}, {"response_format", "verbose_json"}}
+	if c.language != "" {
+		fields = append(fields, [2]string{"language", c.language})
+	}
+	for _, field := range fields {
+		err := form.WriteField(field[0], field[1])
+		if err != nil {
+			return transcription{}, fmt.Errorf("cannot write request: %w", err)
+		}
+	}
+
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename=%q`, filename))
+	header.Set("Content-Type", contentType)
+	part, err := form.CreatePart(header)
+	if err != nil {
+		return transcription{}, fmt.Errorf("cannot write request: %w", err)
+	}
+	_, err = io.Copy(part, audio)
+	if err != nil {
+		return transcription{}, fmt.Errorf("cannot read audio: %w", err)
+	}
+	err = form.Close()
+	if err != nil {
+		return transcription{}, fmt.Errorf("cannot write request: %w", err)
+	}
+
+	// create request
+	req, err := http.NewRequest("POST", c.baseUrl+"/v1/audio/transcriptions", &payload)
+	if err != nil {
+		return transcription{}, fmt.Errorf("cannot create request: %w", err)
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+
+	// send request
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return transcription{}, fmt.Errorf("cannot send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// validate response code
+	if resp.StatusCode != http.StatusOK {
+		return transcription{}, fmt.Errorf("unexpected response code: %d", resp.StatusCode)
+	}
+
+	// parse response, servers that ignore verbose_json only give the text
+	var result transcription
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return transcription{}, fmt.Errorf("cannot unmarshal response: %w", err)
+	}
+
+	result.Text = strings.TrimSpace(result.Text)
+	for i := range result.Segments {
+		result.Segments[i].Text = strings.TrimSpace(result.Segments[i].Text)
+	}
+	if result.Segments == nil {
+		result.Segments = []transcriptionSegment{}
+	}
+	return result, nil
+}
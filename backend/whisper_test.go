@@ -0,0 +1,86 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWhisperClient_Transcribe(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/v1/audio/transcriptions", r.URL.Path)
+		require.Equal(t, "Bearer test-key", r.Header.Get("Authorization"))
+
+		file, header, err := r.FormFile("file")
+		require.NoError(t, err)
+		audio, err := io.ReadAll(file)
+		require.NoError(t, err)
+		require.Equal(t, "test-audio", string(audio))
+		require.Equal(t, "recording.webm", header.Filename)
+		require.Equal(t, "audio/webm", header.Header.Get("Content-Type"))
+		require.Equal(t, "whisper-1", r.FormValue("model"))
+		require.Equal(t, "verbose_json", r.FormValue("response_format"))
+		require.Equal(t, "en", r.FormValue("language"))
+
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"task": "transcribe", "language": "english", "duration": 2.5, "text": " What's on my calendar today?",
+			"segments": [{"id": 0, "start": 0.0, "end": 2.5, "text": " What's on my calendar today?", "avg_logprob": -0.2}]}`))
+	}))
+	defer server.Close()
+	t.Setenv("WHISPER_BASE_URL", server.URL+"/")
+	t.Setenv("WHISPER_API_KEY", "test-key")
+	t.Setenv("WHISPER_LANGUAGE", "en")
+
+	client, err := newWhisperClient()
+	require.NoError(t, err)
+
+	result, err := client.transcribe(strings.NewReader("test-audio"), "recording.webm", "audio/webm")
+	require.NoError(t, err)
+	require.Equal(t, transcription{
+		Text:     "What's on my calendar today?",
+		Language: "english",
+		Duration: 2.5,
+		Segments: []transcriptionSegment{{Start: 0, End: 2.5, Text: "What's on my calendar today?"}},
+	}, result)
+}
+
+func TestWhisperClient_TextOnlyAndErrors(t *testing.T) {
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// no key is sent when none is set
+		require.Empty(t, r.Header.Get("Authorization"))
+		w.WriteHeader(status)
+		w.Write([]byte(`{"text": "Hello"}`))
+	}))
+	defer server.Close()
+	t.Setenv("WHISPER_BASE_URL", server.URL)
+	t.Setenv("WHISPER_API_KEY", "")
+
+	client, err := newWhisperClient()
+	require.NoError(t, err)
+
+	// servers that only give the text still return segments as a list
+	result, err := client.transcribe(strings.NewReader("test-audio"), "recording.wav", "audio/wav")
+	require.NoError(t, err)
+	require.Equal(t, transcription{Text: "Hello", Segments: []transcriptionSegment{}}, result)
+
+	status = http.StatusInternalServerError
+	_, err = client.transcribe(strings.NewReader("test-audio"), "recording.wav", "audio/wav")
+	require.Error(t, err)
+}
+
+func TestNewWhisperClient_NotConfigured(t *testing.T) {
+	t.Setenv("WHISPER_BASE_URL", "")
+	client, err := newWhisperClient()
+	require.NoError(t, err)
+	require.Nil(t, client)
+
+	t.Setenv("WHISPER_BASE_URL", "http://whisper")
+	t.Setenv("WHISPER_TIMEOUT", "soon")
+	_, err = newWhisperClient()
+	require.Error(t, err)
+}